@@ -0,0 +1,478 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// setting is a single git config key and value.
+type setting struct {
+    Key   string
+    Value string
+}
+
+// profileSettings returns the local git config entries `gist set` writes
+// for a profile.
+func profileSettings(p *Profile) []setting {
+    settings := []setting{
+        {"user.name", gitName(p)},
+        {"user.email", p.Email},
+    }
+    if p.SigningKey != "" {
+        settings = append(settings, setting{"user.signingkey", p.SigningKey})
+    }
+    if key, _ := signingPublicKey(p.SigningKey); key != "" {
+        settings = append(settings, setting{"gpg.ssh.allowedSignersFile", allowedSignersPath()})
+    }
+    settings = append(settings, signingFormatSettings(p)...)
+    if p.SSHKey != "" {
+        settings = append(settings, setting{"core.sshCommand", sshCommand(profilePath(p, p.SSHKey))})
+    }
+    if p.AWSProfile != "" {
+        settings = append(settings, codeCommitHelper(p.AWSProfile)...)
+    }
+    if p.CommitTemplate != "" {
+        settings = append(settings, setting{"commit.template", profilePath(p, p.CommitTemplate)})
+    }
+    if p.SignOff {
+        settings = append(settings, setting{"format.signOff", "true"})
+    }
+    if p.HTTPProxy != "" {
+        settings = append(settings, setting{"http.proxy", p.HTTPProxy})
+    }
+    if p.SSLCAInfo != "" {
+        settings = append(settings, setting{"http.sslCAInfo", profilePath(p, p.SSLCAInfo)})
+    }
+    if p.HTTPExtraHeader != "" {
+        settings = append(settings, setting{"http.extraHeader", p.HTTPExtraHeader})
+    }
+    if p.LFSURL != "" {
+        settings = append(settings, setting{"lfs.url", p.LFSURL})
+        if p.LFSAccess != "" {
+            settings = append(settings, setting{"lfs." + p.LFSURL + ".access", p.LFSAccess})
+        }
+    }
+    if p.PartialCloneFilter != "" {
+        settings = append(settings,
+            setting{"remote.origin.promisor", "true"},
+            setting{"remote.origin.partialclonefilter", p.PartialCloneFilter})
+    }
+    for _, key := range sortedKeys(p.Maintenance) {
+        if maintenanceKey(key) {
+            settings = append(settings, setting{key, p.Maintenance[key]})
+        }
+    }
+    return settings
+}
+
+// maintenanceKey reports whether a profile's maintenance map may set the
+// git config key: maintenance.*, gc.* and fetch.prune only, so the map
+// can't smuggle in other settings.
+func maintenanceKey(key string) bool {
+    key = strings.ToLower(key)
+    return strings.HasPrefix(key, "maintenance.") || strings.HasPrefix(key, "gc.") || key == "fetch.prune"
+}
+
+// configTransaction writes settings to the current repository's local
+// config and can undo them: before it first changes a key it records the
+// key's local values.
+type configTransaction struct {
+    prior []priorSetting
+    seen  map[string]bool
+}
+
+// priorSetting is a key's local values before a transaction changed it.
+type priorSetting struct {
+    Key    string
+    Values []string
+}
+
+// record remembers key's local values, once.
+func (tx *configTransaction) record(key string) {
+    if tx.seen == nil {
+        tx.seen = map[string]bool{}
+    }
+    if tx.seen[key] {
+        return
+    }
+    tx.seen[key] = true
+    var values []string
+    if out, err := runGit("config", "--local", "--get-all", key); err == nil {
+        values = strings.Split(out, "\n")
+    }
+    tx.prior = append(tx.prior, priorSetting{key, values})
+}
+
+// set writes a setting.
+func (tx *configTransaction) set(key, value string) error {
+    tx.record(key)
+    if out, err := runGit("config", "--local", key, value); err != nil {
+        if out == "" {
+            out = err.Error()
+        }
+        return fmt.Errorf("failed to set %s: %s", key, out)
+    }
+    return nil
+}
+
+// unset removes every local value of key; a key that isn't set is no error.
+func (tx *configTransaction) unset(key string) error {
+    tx.record(key)
+    out, err := runGit("config", "--local", "--unset-all", key)
+    var exit *exec.ExitError
+    // Exit status 5: the key isn't set.
+    if err == nil || errors.As(err, &exit) && exit.ExitCode() == 5 {
+        return nil
+    }
+    if out == "" {
+        out = err.Error()
+    }
+    return fmt.Errorf("failed to unset %s: %s", key, out)
+}
+
+// fail rolls the transaction back after err, saying whether that worked.
+func (tx *configTransaction) fail(err error) error {
+    if rerr := tx.rollback(); rerr != nil {
+        return fmt.Errorf("%w; restoring the previous settings failed too: %v", err, rerr)
+    }
+    return fmt.Errorf("%w; the previous settings were restored", err)
+}
+
+// rollback restores the recorded keys, latest first.
+func (tx *configTransaction) rollback() error {
+    var failed []string
+    for i := len(tx.prior) - 1; i >= 0; i-- {
+        p := tx.prior[i]
+        if out, err := runGit("config", "--local", "--get-all", p.Key); err == nil && out == strings.Join(p.Values, "\n") {
+            continue
+        }
+        // A single value is written back in place, keeping its position.
+        if len(p.Values) == 1 {
+            if _, err := runGit("config", "--local", p.Key, p.Values[0]); err == nil {
+                continue
+            }
+        }
+        // Exit status 5 only means the key was never set.
+        runGit("config", "--local", "--unset-all", p.Key)
+        for _, v := range p.Values {
+            if _, err := runGit("config", "--local", "--add", p.Key, v); err != nil {
+                failed = append(failed, p.Key)
+                break
+            }
+        }
+    }
+    if len(failed) > 0 {
+        return fmt.Errorf("cannot restore %s", strings.Join(failed, ", "))
+    }
+    return nil
+}
+
+// canonicalKey returns a config key as git lists it: section and name
+// lower-cased, the subsection as written.
+func canonicalKey(key string) string {
+    first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+    if first < 0 || first == last {
+        return strings.ToLower(key)
+    }
+    return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+// staleSettings returns the local values of the keys gist wrote in the
+// current repository (see repoRecord.Keys) that the wanted settings leave
+// out, so that switching profiles doesn't carry the previous one's proxy,
+// extra header or SSH command over to the next one's remotes. Keys the user
+// set themselves are never among them.
+func staleSettings(wanted []setting) []setting {
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        return nil
+    }
+    rec, err := loadRepoRecord(root)
+    if err != nil {
+        return nil
+    }
+    want := map[string]bool{}
+    for _, s := range wanted {
+        want[canonicalKey(s.Key)] = true
+    }
+    var stale []setting
+    for _, key := range rec.Keys {
+        if want[canonicalKey(key)] {
+            continue
+        }
+        if out, err := runGit("config", "--local", "--get-all", key); err == nil {
+            stale = append(stale, setting{key, out})
+        }
+    }
+    return stale
+}
+
+// settingKeys returns the keys of settings.
+func settingKeys(settings []setting) []string {
+    keys := make([]string, len(settings))
+    for i, s := range settings {
+        keys[i] = s.Key
+    }
+    return keys
+}
+
+// settingChange is a setting whose local value differs from the wanted one,
+// or, with Remove, a stale one to unset.
+type settingChange struct {
+    setting
+    Old    string
+    Exists bool
+    Remove bool
+}
+
+// repoSettings returns the local config p gives the current repository:
+// its own settings and those for the hosting services of the remotes.
+func repoSettings(p *Profile) []setting {
+    return append(profileSettings(p), hostedSettings(listRemotes())...)
+}
+
+// removedSettings returns the local entries switching the current
+// repository to p removes: those gist wrote for a previous profile that the
+// settings leave out, and gitsign's.
+func removedSettings(p *Profile, settings []setting) []setting {
+    removed := staleSettings(settings)
+    seen := map[string]bool{}
+    for _, s := range removed {
+        seen[canonicalKey(s.Key)] = true
+    }
+    for _, s := range staleGitsign(p) {
+        if !seen[canonicalKey(s.Key)] {
+            removed = append(removed, s)
+        }
+    }
+    return removed
+}
+
+// pendingChanges compares the current repository's local config against
+// the settings and returns the entries that need to be written or, left
+// over from another profile, removed.
+func pendingChanges(p *Profile, settings []setting) []settingChange {
+    var changes []settingChange
+    for _, s := range settings {
+        old, err := runGit("config", "--local", "--get", s.Key)
+        if err == nil && old == s.Value {
+            continue
+        }
+        changes = append(changes, settingChange{setting: s, Old: old, Exists: err == nil})
+    }
+    for _, s := range removedSettings(p, settings) {
+        changes = append(changes, settingChange{setting: setting{Key: s.Key}, Old: s.Value, Exists: true, Remove: true})
+    }
+    return changes
+}
+
+// setProfile gives the repository at root the profile, as set, ensure and
+// apply do: unless its CA file is missing, it writes the changes
+// (pendingChanges of settings) to the local config between the pre_set and
+// on_set hooks, then updates what follows the profile – allowed signers, the
+// ticket hook, the state record and other sessions.
+func setProfile(cfg Config, p *Profile, root string, settings []setting, changes []settingChange) error {
+    if err := checkCAFile(p); err != nil {
+        return fmt.Errorf("profile %s: %w", p.Name, err)
+    }
+    if err := runEvent(cfg, eventPreSet, p, root); err != nil {
+        return err
+    }
+    // All or none: a repository left with the new email but the old
+    // signing key or SSH command is worse than one left as it was, and a
+    // failed switch restores what it removed too.
+    var tx configTransaction
+    for _, c := range changes {
+        var err error
+        if c.Remove {
+            err = tx.unset(c.Key)
+        } else {
+            err = tx.set(c.Key, c.Value)
+        }
+        if err != nil {
+            return tx.fail(err)
+        }
+    }
+    if key, _ := signingPublicKey(p.SigningKey); key != "" {
+        if err := syncAllowedSigners(cfg); err != nil {
+            fmt.Fprintf(os.Stderr, "warning: failed to update %s: %v\n", allowedSignersPath(), err)
+        }
+    }
+    if err := syncTicketHook(p); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: failed to update prepare-commit-msg hook: %v\n", err)
+    }
+    if err := rememberRepo(root, p.Name, settingKeys(settings)); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot record %s in %s: %v\n", root, statePath(), err)
+    }
+    broadcastChange(root, "set", p.Name)
+    notifyEvent(cfg, eventOnSet, p, root)
+    return nil
+}
+
+// printChanges prints changes in a terraform-like diff format.
+func printChanges(changes []settingChange) {
+    for _, c := range changes {
+        switch {
+        case c.Remove:
+            fmt.Printf("    - %s: %s\n", c.Key, displayValue(c.Key, c.Old))
+        case c.Exists:
+            fmt.Printf("    ~ %s: %q → %q\n", c.Key, c.Old, c.Value)
+        default:
+            fmt.Printf("    + %s: %q\n", c.Key, c.Value)
+        }
+    }
+}
+
+// PlanEntry maps repositories matching Path (a directory or glob) to a
+// profile.
+type PlanEntry struct {
+    Path    string `yaml:"path"`
+    Profile string `yaml:"profile"`
+}
+
+// loadPlan reads a plan file: a "repos:" list of path/profile entries.
+func loadPlan(path string) ([]PlanEntry, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var plan []PlanEntry
+    for _, line := range strings.Split(string(data), "\n") {
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "repos:" {
+            continue
+        }
+        key, value, ok := parseKeyValue(line)
+        if !ok {
+            continue
+        }
+        if strings.HasPrefix(trimmed, "-") || len(plan) == 0 {
+            plan = append(plan, PlanEntry{})
+        }
+        entry := &plan[len(plan)-1]
+        switch key {
+        case "path":
+            entry.Path = value
+        case "profile":
+            entry.Profile = value
+        }
+    }
+    for i, e := range plan {
+        if e.Path == "" || e.Profile == "" {
+            return nil, fmt.Errorf("plan entry %d needs both path and profile", i+1)
+        }
+    }
+    return plan, nil
+}
+
+// commandApply applies a plan to every repository it names. With dryRun it
+// only prints the changes it would make.
+func commandApply(cfg Config, planPath string, dryRun bool) error {
+    plan, err := loadPlan(planPath)
+    if err != nil {
+        return err
+    }
+    saved := repoDir
+    defer func() { repoDir = saved }()
+    changed, unchanged, failed := 0, 0, 0
+    for _, e := range plan {
+        p := findProfile(&cfg, e.Profile)
+        if p == nil {
+            return fmt.Errorf("plan references unknown profile %s", e.Profile)
+        }
+        if err := profileGitRequirements(p); err != nil {
+            return err
+        }
+        dirs, err := filepath.Glob(expandHome(e.Path))
+        if err != nil {
+            return fmt.Errorf("invalid path pattern %s: %w", e.Path, err)
+        }
+        if len(dirs) == 0 {
+            fmt.Printf("! %s: no matching directories\n", e.Path)
+        }
+        for _, dir := range dirs {
+            if excludedPath(cfg, dir) {
+                fmt.Printf("! %s: excluded by scan.exclude, skipped\n", dir)
+                continue
+            }
+            repoDir = dir
+            inRepo, root := isGitRepo()
+            if !inRepo {
+                fmt.Printf("! %s: not a git repository, skipped\n", dir)
+                continue
+            }
+            if pin := pinnedProfile(root); pin != "" && pin != p.Name {
+                fmt.Printf("! %s: pinned to %s, skipped\n", root, pin)
+                continue
+            }
+            settings := repoSettings(p)
+            changes := pendingChanges(p, settings)
+            if len(changes) == 0 {
+                unchanged++
+                continue
+            }
+            fmt.Printf("~ %s (%s)\n", root, p.Name)
+            printChanges(changes)
+            if dryRun {
+                changed++
+                continue
+            }
+            if err := setProfile(cfg, p, root, settings, changes); err != nil {
+                fmt.Fprintf(os.Stderr, "%s: %v\n", root, err)
+                failed++
+                continue
+            }
+            changed++
+        }
+    }
+    verb := "changed"
+    if dryRun {
+        verb = "to change"
+    }
+    fmt.Printf("Plan: %d %s, %d unchanged", changed, verb, unchanged)
+    if failed > 0 {
+        fmt.Printf(", %d failed\n", failed)
+        return errors.New("some repositories could not be updated")
+    }
+    fmt.Println(".")
+    return nil
+}
+
+// errDrift is returned by `ensure --check` when the repository differs from
+// the requested profile.
+var errDrift = errors.New("repository config drifted from profile")
+
+// commandEnsure makes the current repository match a profile, changing only
+// what differs and printing nothing when it already matches. With check it
+// reports drift instead of fixing it.
+func commandEnsure(cfg Config, profileName string, check bool) error {
+    p := findProfile(&cfg, profileName)
+    if p == nil {
+        return fmt.Errorf("profile %s not found", profileName)
+    }
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    if err := checkPin(root, p.Name); err != nil {
+        return err
+    }
+    if err := profileGitRequirements(p); err != nil {
+        return err
+    }
+    settings := repoSettings(p)
+    changes := pendingChanges(p, settings)
+    if len(changes) == 0 {
+        return nil
+    }
+    fmt.Printf("~ %s (%s)\n", root, p.Name)
+    printChanges(changes)
+    if check {
+        return errDrift
+    }
+    return setProfile(cfg, p, root, settings, changes)
+}
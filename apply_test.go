@@ -0,0 +1,57 @@
+package main
+
+import (
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// TestStaleSettings checks that switching profiles removes only the local
+// keys gist recorded writing, never the user's own.
+func TestStaleSettings(t *testing.T) {
+    dir := t.TempDir()
+    t.Setenv("GIST_CONFIG_PATH", filepath.Join(t.TempDir(), "config.yaml"))
+    defer func(dir string) { repoDir = dir }(repoDir)
+    repoDir = dir
+    for _, args := range [][]string{
+        {"init", "-q", dir},
+        {"-C", dir, "config", "user.email", "old@corp.com"},
+        {"-C", dir, "config", "http.proxy", "http://proxy.corp:3128"},
+        {"-C", dir, "config", "http.extraHeader", "X-Team: a"},
+        {"-C", dir, "config", "--add", "http.extraHeader", "X-Team: b"},
+        {"-C", dir, "config", "commit.gpgsign", "true"},
+    } {
+        if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+            t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+        }
+    }
+    _, root := isGitRepo()
+
+    if got := staleSettings([]setting{{"user.email", "new@corp.com"}}); got != nil {
+        t.Errorf("without a record: %q", got)
+    }
+    written := []string{"user.email", "http.proxy", "http.extraHeader", "core.sshCommand"}
+    if err := rememberRepo(root, "old", written); err != nil {
+        t.Fatal(err)
+    }
+    for _, tc := range []struct {
+        name   string
+        wanted []setting
+        want   []setting
+    }{
+        {"switch", []setting{{"user.email", "new@corp.com"}}, []setting{
+            {"http.proxy", "http://proxy.corp:3128"},
+            {"http.extraHeader", "X-Team: a\nX-Team: b"},
+        }},
+        {"key case", []setting{{"HTTP.proxy", "http://other:8080"}, {"http.extraheader", "X-Team: c"}}, []setting{
+            {"user.email", "old@corp.com"},
+        }},
+        {"all wanted", []setting{{"user.email", "a"}, {"http.proxy", "b"}, {"http.extraHeader", "c"}}, nil},
+    } {
+        if got := staleSettings(tc.wanted); !reflect.DeepEqual(got, tc.want) {
+            t.Errorf("%s: stale %q, want %q", tc.name, got, tc.want)
+        }
+    }
+}
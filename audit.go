@@ -0,0 +1,185 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// auditFinding is a commit made with another profile's identity than the
+// one the repository should use.
+type auditFinding struct {
+    Commit string
+    // Name and Email are the identity the commit was made with, Profile the
+    // profile it belongs to.
+    Name    string
+    Email   string
+    Profile string
+    Pushed  bool
+}
+
+// profileByEmail returns the profile with the email or also_email,
+// ignoring case.
+func profileByEmail(cfg *Config, email string) *Profile {
+    for i := range cfg.Profiles {
+        if ownsEmail(&cfg.Profiles[i], email) {
+            return &cfg.Profiles[i]
+        }
+    }
+    return nil
+}
+
+// auditCommits finds the commits in revs authored or committed with the
+// email of a profile other than want. Other people's commits are not
+// findings: only identities of configured profiles are.
+func auditCommits(cfg Config, want *Profile, revs string) ([]auditFinding, error) {
+    // The identities as .mailmap maps them: remediated commits are fine.
+    out, err := runGit("log", "--format=%H%x00%aN%x00%aE%x00%cN%x00%cE", revs)
+    if err != nil {
+        return nil, fmt.Errorf("cannot read commits %s: %s", revs, out)
+    }
+    local := map[string]bool{}
+    if unpushed, err := runGit("rev-list", "HEAD", "--not", "--remotes"); err == nil {
+        for _, c := range strings.Fields(unpushed) {
+            local[c] = true
+        }
+    }
+    var findings []auditFinding
+    for _, line := range strings.Split(out, "\n") {
+        f := strings.Split(line, "\x00")
+        if len(f) != 5 {
+            continue
+        }
+        for _, who := range [][2]string{{f[1], f[2]}, {f[3], f[4]}} {
+            if ownsEmail(want, who[1]) {
+                continue
+            }
+            if p := profileByEmail(&cfg, who[1]); p != nil {
+                findings = append(findings, auditFinding{Commit: f[0], Name: who[0], Email: who[1], Profile: p.Name, Pushed: !local[f[0]]})
+                break
+            }
+        }
+    }
+    return findings, nil
+}
+
+// mailmapLines returns the .mailmap entries mapping the findings' identities
+// to the profile's, leaving out those the file already has.
+func mailmapLines(existing string, p *Profile, findings []auditFinding) []string {
+    have := map[string]bool{}
+    for _, line := range strings.Split(existing, "\n") {
+        have[strings.TrimSpace(line)] = true
+    }
+    var lines []string
+    for _, f := range findings {
+        line := fmt.Sprintf("%s <%s> %s <%s>", gitName(p), p.Email, f.Name, f.Email)
+        if !have[line] {
+            have[line] = true
+            lines = append(lines, line)
+        }
+    }
+    return lines
+}
+
+// remediate fixes pushed mistakes forward instead of rewriting history: it
+// appends the identity mappings to .mailmap, optionally attaches a git note
+// to each commit, and commits .mailmap with a prepared message, opening the
+// editor on a terminal.
+func remediate(p *Profile, repoRoot string, findings []auditFinding, note bool) error {
+    path := filepath.Join(repoRoot, ".mailmap")
+    data, err := os.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    lines := mailmapLines(string(data), p, findings)
+    if len(lines) > 0 {
+        content := string(data)
+        if content != "" && !strings.HasSuffix(content, "\n") {
+            content += "\n"
+        }
+        content += strings.Join(lines, "\n") + "\n"
+        if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+            return err
+        }
+        fmt.Printf("Added %d mapping(s) to %s.\n", len(lines), path)
+    }
+    if note {
+        for _, f := range findings {
+            msg := fmt.Sprintf("gist: made as %s <%s> (profile %s) by mistake; the intended identity is %s <%s>, mapped in .mailmap.", f.Name, f.Email, f.Profile, gitName(p), p.Email)
+            if out, err := runGit("notes", "append", "-m", msg, f.Commit); err != nil {
+                return fmt.Errorf("cannot add a note to %s: %s", f.Commit[:7], out)
+            }
+        }
+        fmt.Printf("Added notes to %d commit(s); publish them with `git push origin refs/notes/commits`.\n", len(findings))
+    }
+    if len(lines) == 0 {
+        fmt.Println(".mailmap already maps these identities.")
+        return nil
+    }
+    if out, err := runGit("add", "--", path); err != nil {
+        return fmt.Errorf("cannot stage .mailmap: %s", out)
+    }
+    var short []string
+    for _, f := range findings {
+        short = append(short, f.Commit[:7])
+    }
+    msg := fmt.Sprintf("Map mistaken identities to %s <%s>\n\nMade with the wrong identity: %s.\nMapped in .mailmap instead of rewriting published history.", gitName(p), p.Email, strings.Join(short, ", "))
+    args := append([]string{"-C", repoRoot}, identityOverrides(p)...)
+    args = append(args, "commit", "-m", msg)
+    if isInteractive() {
+        args = append(args, "--edit")
+    }
+    return interactive(getGitPath(), append(args, "--", path)...)
+}
+
+// commandAudit reports the commits in revs made with the identity of a
+// profile other than the one the repository should use, and with
+// remediate fixes them forward through .mailmap. It fails when it finds
+// any and doesn't remediate them.
+func commandAudit(cfg Config, revs string, remediateFlag, note bool) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    name, err := resolveAutoProfile(cfg, false)
+    if err != nil {
+        active, activeErr := activeProfile(&cfg)
+        if activeErr != nil {
+            return fmt.Errorf("%v; nothing to audit against", err)
+        }
+        name = active.Name
+    }
+    p := findProfile(&cfg, name)
+    if p == nil {
+        return fmt.Errorf("profile %s not found", name)
+    }
+    findings, err := auditCommits(cfg, p, revs)
+    if err != nil {
+        return err
+    }
+    if len(findings) == 0 {
+        fmt.Printf("✔ every commit in %s by one of your profiles uses profile %s\n", revs, p.Name)
+        return nil
+    }
+    local := 0
+    for _, f := range findings {
+        state := "pushed"
+        if !f.Pushed {
+            state = "local"
+            local++
+        }
+        fmt.Printf("  ✘ %s made as %s <%s> (profile %s, %s)\n", f.Commit[:7], f.Name, f.Email, f.Profile, state)
+    }
+    if !remediateFlag {
+        if local > 0 {
+            fmt.Println("  → local commits can still be re-authored with `gist fix-last-commit`")
+        }
+        if local < len(findings) {
+            fmt.Println("  → fix pushed commits forward with `gist audit --remediate`")
+        }
+        return fmt.Errorf("%d commit(s) not made as profile %s", len(findings), p.Name)
+    }
+    return remediate(p, repoRoot, findings, note)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// maxBackups is how many previous versions of the config are kept.
+const maxBackups = 10
+
+// backupTimeFormat names backup files so they sort chronologically.
+const backupTimeFormat = "20060102-150405.000"
+
+// backupDir returns the directory holding config backups.
+func backupDir(path string) string {
+    return filepath.Join(filepath.Dir(path), "backups")
+}
+
+// listBackups returns backup file paths, newest first.
+func listBackups(path string) []string {
+    matches, _ := filepath.Glob(filepath.Join(backupDir(path), "config-*.yaml"))
+    sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+    return matches
+}
+
+// backupConfig copies the current config file into the backups directory
+// and prunes all but the newest maxBackups copies. A missing config file is
+// not an error: there is nothing to back up yet.
+func backupConfig(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return err
+    }
+    dir := backupDir(path)
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return err
+    }
+    name := "config-" + time.Now().Format(backupTimeFormat) + ".yaml"
+    if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+        return err
+    }
+    if backups := listBackups(path); len(backups) > maxBackups {
+        for _, old := range backups[maxBackups:] {
+            os.Remove(old)
+        }
+    }
+    return nil
+}
+
+// backupTime extracts the timestamp from a backup file name.
+func backupTime(file string) string {
+    stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "config-"), ".yaml")
+    t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+    if err != nil {
+        return stamp
+    }
+    return t.Format("2006-01-02 15:04:05")
+}
+
+// restoreBackup replaces the config with backup number n (1 is newest). The
+// current config is backed up first, so a restore can itself be undone.
+func restoreBackup(path, arg string) error {
+    backups := listBackups(path)
+    n, err := strconv.Atoi(arg)
+    if err != nil || n < 1 || n > len(backups) {
+        return fmt.Errorf("no backup %s (see gist config backups list)", arg)
+    }
+    data, err := os.ReadFile(backups[n-1])
+    if err != nil {
+        return err
+    }
+    if err := backupConfig(path); err != nil {
+        return fmt.Errorf("failed to back up current config: %w", err)
+    }
+    before, _ := os.ReadFile(path)
+    if err := os.WriteFile(path, data, 0o644); err != nil {
+        return err
+    }
+    logConfigSave(before, data)
+    fmt.Printf("✔️  Restored config from backup %d (%s)\n", n, backupTime(backups[n-1]))
+    return nil
+}
+
+// commandConfig runs the `config` subcommands.
+func commandConfig(path string, args []string) error {
+    usage := errors.New("usage: gist config edit | gist config backups list | gist config restore <n>")
+    if len(args) == 0 {
+        return usage
+    }
+    // "config backups restore <n>" and "config restore <n>" are the same.
+    if args[0] == "backups" {
+        args = args[1:]
+        if len(args) == 0 {
+            args = []string{"list"}
+        }
+    }
+    switch args[0] {
+    case "edit":
+        if len(args) > 1 {
+            return usage
+        }
+        return commandConfigEdit(path)
+    case "list":
+        backups := listBackups(path)
+        if len(backups) == 0 {
+            fmt.Println("no config backups")
+            return nil
+        }
+        fmt.Println("config backups (newest first):")
+        for i, b := range backups {
+            fmt.Printf("  %d) %s\t%s\n", i+1, backupTime(b), b)
+        }
+        return nil
+    case "restore":
+        if len(args) < 2 {
+            return usage
+        }
+        return restoreBackup(path, args[1])
+    default:
+        return usage
+    }
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// devcontainerTarget is where the bootstrap directory is mounted inside the
+// container; the generated gitconfig refers to its files there.
+const devcontainerTarget = "/etc/gist"
+
+// devcontainerSettings returns the minimal gitconfig a profile needs in a
+// container: the identity and, for SSH signing, the public key as a literal
+// so the forwarded SSH agent signs without any key file. GPG and x509 keys
+// stay on the host; the returned notes say what was left out.
+func devcontainerSettings(p *Profile) ([]setting, []string, error) {
+    settings := []setting{
+        {"user.name", gitName(p)},
+        {"user.email", p.Email},
+    }
+    var notes []string
+    signs := false
+    switch key, err := signingPublicKey(p.SigningKey); {
+    case err != nil:
+        return nil, nil, err
+    case key != "":
+        settings = append(settings,
+            setting{"user.signingkey", "key::" + key},
+            setting{"gpg.format", "ssh"},
+            setting{"gpg.ssh.allowedSignersFile", devcontainerTarget + "/allowed_signers"},
+        )
+        signs = true
+    case p.SigningFormat == formatGitsign:
+        settings = append(settings, signingFormatSettings(p)...)
+        notes = append(notes, "gitsign must be installed in the container")
+    case p.SigningKey != "":
+        notes = append(notes, fmt.Sprintf("signing key %s is not an SSH key and is not carried into the container; commits there are unsigned", p.SigningKey))
+    }
+    if signs {
+        // Sign in the container exactly when the host does.
+        for _, key := range []string{"commit.gpgsign", "tag.gpgsign"} {
+            if on, _ := runGit("config", "--type=bool", key); on == "true" {
+                settings = append(settings, setting{key, "true"})
+            }
+        }
+    }
+    if p.SignOff {
+        settings = append(settings, setting{"format.signOff", "true"})
+    }
+    return settings, notes, nil
+}
+
+// devcontainerSnippet is the devcontainer.json fragment that mounts dir and
+// makes its gitconfig the container's global config.
+func devcontainerSnippet(dir string) string {
+    return fmt.Sprintf(`"mounts": [
+    "source=${localWorkspaceFolder}/%s,target=%s,type=bind,readonly"
+],
+"containerEnv": {
+    "GIT_CONFIG_GLOBAL": "%s/gitconfig"
+}
+`, filepath.ToSlash(dir), devcontainerTarget, devcontainerTarget)
+}
+
+// bootstrapDevcontainer writes the profile's container gitconfig and
+// allowed_signers file into dir (relative to the repository root) and
+// prints how to wire them into devcontainer.json.
+func bootstrapDevcontainer(cfg Config, profileName, dir string, force bool) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    if profileName == "" {
+        name, err := resolveAutoProfile(cfg, false)
+        if err != nil {
+            active, activeErr := activeProfile(&cfg)
+            if activeErr != nil {
+                return fmt.Errorf("%v; name a profile with --profile", err)
+            }
+            name = active.Name
+        }
+        profileName = name
+    }
+    p := findProfile(&cfg, profileName)
+    if p == nil {
+        return fmt.Errorf("profile %s not found", profileName)
+    }
+    settings, notes, err := devcontainerSettings(p)
+    if err != nil {
+        return err
+    }
+    out := dir
+    if !filepath.IsAbs(out) {
+        out = filepath.Join(repoRoot, out)
+    }
+    gitconfig := filepath.Join(out, "gitconfig")
+    if data, err := os.ReadFile(gitconfig); err == nil && !strings.HasPrefix(string(data), "# gist bootstrap") && !force {
+        return fmt.Errorf("refusing to overwrite %s; use --force", gitconfig)
+    }
+    if err := os.MkdirAll(out, 0o755); err != nil {
+        return err
+    }
+    content := fmt.Sprintf("# gist bootstrap: profile %s for devcontainers\n", p.Name) + renderSettings(settings)
+    files := map[string]string{
+        "gitconfig": content,
+        // The directory holds a personal identity; keep it out of commits.
+        ".gitignore": "*\n",
+    }
+    if key, _ := signingPublicKey(p.SigningKey); key != "" {
+        files["allowed_signers"] = p.Email + " " + key + "\n"
+    } else if err := os.Remove(filepath.Join(out, "allowed_signers")); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    for name, data := range files {
+        if err := os.WriteFile(filepath.Join(out, name), []byte(data), 0o644); err != nil {
+            return err
+        }
+    }
+    fmt.Printf("Wrote the gitconfig for profile %s to %s.\n", p.Name, out)
+    for _, note := range notes {
+        fmt.Printf("⚠ %s\n", note)
+    }
+    rel, err := filepath.Rel(repoRoot, out)
+    if err != nil || strings.HasPrefix(rel, "..") {
+        fmt.Printf("Mount %s at %s in the container and set GIT_CONFIG_GLOBAL=%s/gitconfig.\n", out, devcontainerTarget, devcontainerTarget)
+        return nil
+    }
+    fmt.Println("Add to .devcontainer/devcontainer.json:")
+    fmt.Print(devcontainerSnippet(rel))
+    if _, ok := files["allowed_signers"]; ok {
+        fmt.Println("Commits are signed through the SSH agent, which VS Code and Codespaces forward into the container.")
+    }
+    return nil
+}
+
+// commandBootstrap sets up a container's git identity to match the host.
+func commandBootstrap(cfg Config, args []string) error {
+    usage := errors.New("usage: gist bootstrap --devcontainer [--profile <p>] [--dir <dir>] [--force]")
+    devcontainer, force := false, false
+    profileName, dir := "", filepath.Join(".devcontainer", "gist")
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--devcontainer":
+            devcontainer = true
+        case args[i] == "--force":
+            force = true
+        case args[i] == "--profile" && i+1 < len(args):
+            profileName = args[i+1]
+            i++
+        case args[i] == "--dir" && i+1 < len(args):
+            dir = expandHome(args[i+1])
+            i++
+        default:
+            return usage
+        }
+    }
+    if !devcontainer {
+        return usage
+    }
+    return bootstrapDevcontainer(cfg, profileName, dir, force)
+}
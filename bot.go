@@ -0,0 +1,70 @@
+package main
+
+import (
+    "os"
+    "strings"
+)
+
+// A profile with `bot: true` is a machine identity, such as the account CI
+// runners commit generated changes with:
+//
+//   gist exec ci-bot -- sh -c 'git commit -am "Regenerate" && git push'
+//
+// Under `gist exec` git gets the bot's credential_token through gist as its
+// only credential helper, and nothing may prompt: not git, not ssh, not a
+// credential manager and not gist itself.
+
+// botEnv returns the variables that keep everything under a bot profile
+// from prompting.
+func botEnv(p *Profile) []string {
+    env := []string{
+        "GIT_TERMINAL_PROMPT=0",
+        "GCM_INTERACTIVE=never",
+        "SSH_ASKPASS_REQUIRE=never",
+        "GIST_NONINTERACTIVE=1",
+    }
+    // ssh must fail instead of asking for a passphrase or a host key.
+    switch {
+    case p.SSHKey != "":
+        env = append(env, "GIT_SSH_COMMAND="+sshCommand(profilePath(p, p.SSHKey))+" -o BatchMode=yes")
+    case os.Getenv("GIT_SSH_COMMAND") == "":
+        env = append(env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+    }
+    return env
+}
+
+// botCredentialSettings makes gist the only credential helper: the empty
+// value clears the helpers configured elsewhere.
+func botCredentialSettings() []setting {
+    return []setting{
+        {"credential.helper", ""},
+        {"credential.helper", "!" + gistExecutable() + " credential"},
+    }
+}
+
+// execBot returns the bot profile `gist exec` runs git under, if its token
+// may answer for the URL: when the config selects the bot for it, or the
+// URL's host is one of the current repository's remotes'. A bot's token
+// never goes to other hosts.
+func execBot(cfg Config, u string, selected *Profile) *Profile {
+    p := findProfile(&cfg, os.Getenv("GIST_PROFILE"))
+    if p == nil || !p.Bot {
+        return nil
+    }
+    if selected != nil && selected.Name == p.Name {
+        return p
+    }
+    host := strings.ToLower(remoteHost(u))
+    for _, rm := range listRemotes() {
+        if strings.ToLower(remoteHost(rm.URL)) == host {
+            return p
+        }
+    }
+    return nil
+}
+
+// nonInteractive reports whether gist runs under a bot profile, where it
+// must not prompt.
+func nonInteractive() bool {
+    return os.Getenv("GIST_NONINTERACTIVE") != ""
+}
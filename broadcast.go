@@ -0,0 +1,97 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// When gist changes a repository's identity it broadcasts the change, so
+// open shell prompts and editors refresh right away instead of on the next
+// cd: it rewrites the state directory's changed file, whose modification
+// time a prompt can compare, and tells a running `gist serve`, which passes
+// it on to the clients that subscribed.
+
+// broadcastTimeout bounds telling the server; a server that doesn't answer
+// in time misses the change.
+const broadcastTimeout = 500 * time.Millisecond
+
+// identityChange is what a broadcast says.
+type identityChange struct {
+    Time    time.Time `json:"time"`
+    Root    string    `json:"root"`
+    Action  string    `json:"action"`
+    Profile string    `json:"profile,omitempty"`
+}
+
+// changedPath returns the file rewritten on every change.
+func changedPath() string {
+    return filepath.Join(statePath(), "changed")
+}
+
+// serveSocketRecord returns the file naming the socket of the running
+// server.
+func serveSocketRecord() string {
+    return filepath.Join(statePath(), "serve")
+}
+
+// runningServer returns the socket the running server listens on, or "".
+func runningServer() string {
+    data, err := os.ReadFile(serveSocketRecord())
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(data))
+}
+
+// broadcastChange announces a change of the identity of the repository at
+// root. Failing to is only worth a warning: the next poll catches up.
+func broadcastChange(root, action, profile string) {
+    change := identityChange{Time: time.Now().UTC(), Root: root, Action: action, Profile: profile}
+    data, err := json.Marshal(change)
+    if err != nil {
+        return
+    }
+    if err := writeFileAtomic(changedPath(), append(data, '\n')); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot update %s: %v\n", changedPath(), err)
+    }
+    socket := runningServer()
+    if socket == "" {
+        return
+    }
+    conn, err := net.DialTimeout("unix", socket, broadcastTimeout)
+    if err != nil {
+        // The server is gone; don't try it again.
+        os.Remove(serveSocketRecord())
+        return
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(broadcastTimeout))
+    json.NewEncoder(conn).Encode(rpcNotification{JSONRPC: "2.0", Method: "changed", Params: change})
+}
+
+// writeFileAtomic replaces path with data, so readers never see half of it.
+func writeFileAtomic(path string, data []byte) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return err
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+    if err != nil {
+        return err
+    }
+    _, err = tmp.Write(data)
+    if cerr := tmp.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(tmp.Name(), path)
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+    return err
+}
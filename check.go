@@ -0,0 +1,199 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/mail"
+    "os"
+    "os/exec"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// getGPGPath returns the gpg executable path.
+func getGPGPath() string {
+    if env := os.Getenv("GIST_GPG_PATH"); env != "" {
+        return env
+    }
+    return "gpg"
+}
+
+// checkEmail reports whether email is a bare, syntactically valid address.
+func checkEmail(email string) error {
+    if email == "" {
+        return errors.New("email is empty")
+    }
+    addr, err := mail.ParseAddress(email)
+    if err != nil || addr.Address != email {
+        return fmt.Errorf("email %q is not a valid address", email)
+    }
+    return nil
+}
+
+// isSSHSigningKey reports whether a signingkey value refers to an SSH key
+// (a literal public key or a key file) rather than a GPG key id.
+func isSSHSigningKey(key string) bool {
+    if strings.HasPrefix(key, "key::") || strings.HasPrefix(key, "ssh-") {
+        return true
+    }
+    return strings.ContainsAny(key, `/\`) || strings.HasSuffix(key, ".pub")
+}
+
+// checkGPGKey verifies that a GPG key exists and is neither expired nor revoked.
+func checkGPGKey(key string) error {
+    out, err := exec.Command(getGPGPath(), "--list-keys", "--with-colons", key).Output()
+    if err != nil {
+        return fmt.Errorf("signing key %s not found in gpg keyring", key)
+    }
+    for _, line := range strings.Split(string(out), "\n") {
+        fields := strings.Split(line, ":")
+        if len(fields) < 7 || fields[0] != "pub" {
+            continue
+        }
+        switch fields[1] {
+        case "e":
+            return fmt.Errorf("signing key %s is expired", key)
+        case "r":
+            return fmt.Errorf("signing key %s is revoked", key)
+        }
+        if fields[6] != "" {
+            expires, err := strconv.ParseInt(fields[6], 10, 64)
+            if err == nil && time.Unix(expires, 0).Before(time.Now()) {
+                return fmt.Errorf("signing key %s is expired", key)
+            }
+        }
+        return nil
+    }
+    return fmt.Errorf("signing key %s not found in gpg keyring", key)
+}
+
+// checkKeyFile verifies that a key file exists and, for private keys, that it
+// is not readable by other users.
+func checkKeyFile(path string, private bool) error {
+    info, err := os.Stat(path)
+    if err != nil {
+        return fmt.Errorf("key file %s does not exist", path)
+    }
+    if info.IsDir() {
+        return fmt.Errorf("key file %s is a directory", path)
+    }
+    // Windows has no meaningful unix permission bits.
+    if private && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+        return fmt.Errorf("key file %s has permissions %04o, expected 0600", path, info.Mode().Perm())
+    }
+    return nil
+}
+
+// checkProfile returns every problem found with a profile.
+func checkProfile(p Profile) []error {
+    var problems []error
+    if err := checkEmail(p.Email); err != nil {
+        problems = append(problems, err)
+    }
+    if p.SigningKey != "" {
+        var err error
+        if isSSHSigningKey(p.SigningKey) {
+            if !strings.HasPrefix(p.SigningKey, "key::") && !strings.HasPrefix(p.SigningKey, "ssh-") {
+                err = checkKeyFile(p.SigningKey, false)
+            }
+        } else {
+            err = checkGPGKey(p.SigningKey)
+        }
+        if err != nil {
+            problems = append(problems, err)
+        }
+    }
+    if err := checkSigningFormat(p); err != nil {
+        problems = append(problems, err)
+    }
+    if p.SSHKey != "" {
+        if err := checkKeyFile(profilePath(&p, p.SSHKey), true); err != nil {
+            problems = append(problems, err)
+        }
+    }
+    if p.Username != nfc(p.Username) {
+        problems = append(problems, fmt.Errorf("username %q is not in NFC; gist writes it to git composed", p.Username))
+    }
+    if p.Bot && p.CredentialToken == "" && p.GitHubAppID == "" {
+        problems = append(problems, errors.New("bot profiles need a credential_token or a GitHub App"))
+    }
+    problems = append(problems, githubAppProblems(&p)...)
+    for _, key := range sortedKeys(p.Maintenance) {
+        if !maintenanceKey(key) {
+            problems = append(problems, fmt.Errorf("maintenance: %s is not a maintenance.*, gc.* or fetch.prune setting", key))
+        }
+    }
+    for _, host := range sortedKeys(p.KnownHosts) {
+        if err := checkHostPin(host, p.KnownHosts[host]); err != nil {
+            problems = append(problems, err)
+        }
+    }
+    if p.Transliterate != "" && p.Transliterate != "ascii" {
+        problems = append(problems, fmt.Errorf("transliterate %q must be ascii", p.Transliterate))
+    }
+    if p.RemoteProtocol != "" && p.RemoteProtocol != "ssh" && p.RemoteProtocol != "https" {
+        problems = append(problems, fmt.Errorf("remote_protocol %q must be ssh or https", p.RemoteProtocol))
+    }
+    if p.LFSAccess != "" && p.LFSURL == "" {
+        problems = append(problems, errors.New("lfs_access needs lfs_url"))
+    }
+    if err := checkCAFile(&p); err != nil {
+        problems = append(problems, err)
+    }
+    return problems
+}
+
+// checkCAFile reports a ssl_ca_info that names no file: as http.sslCAInfo
+// it would fail every HTTPS request of the repository.
+func checkCAFile(p *Profile) error {
+    if p.SSLCAInfo == "" {
+        return nil
+    }
+    if info, err := os.Stat(profilePath(p, p.SSLCAInfo)); err != nil || info.IsDir() {
+        return fmt.Errorf("CA file %s does not exist", p.SSLCAInfo)
+    }
+    return nil
+}
+
+// commandCheck validates all profiles and prints a per-profile summary.
+// It returns false if any profile has problems.
+func commandCheck(cfg Config) bool {
+    healthy := true
+    fmt.Println("checking profiles:")
+    for _, p := range cfg.Profiles {
+        problems := checkProfile(p)
+        if len(problems) == 0 {
+            fmt.Printf("  ✔ %s\n", p.Name)
+            continue
+        }
+        healthy = false
+        if p.Source != "" {
+            fmt.Printf("  ✘ %s (from %s)\n", p.Name, p.Source)
+        } else {
+            fmt.Printf("  ✘ %s\n", p.Name)
+        }
+        for _, err := range problems {
+            fmt.Printf("      %v\n", err)
+        }
+    }
+    // Indistinguishable profiles work, just not as intended: warn only.
+    for _, c := range hostConflicts(cfg) {
+        fmt.Printf("  ⚠ %s\n", c)
+        fmt.Printf("      → %s\n", c.Fix)
+    }
+    if _, err := parseTimeouts(cfg.Timeouts); err != nil {
+        healthy = false
+        fmt.Printf("  ✘ %v\n", err)
+    }
+    if cfg.SortProfiles != "" && cfg.SortProfiles != "insertion" && cfg.SortProfiles != "alphabetical" {
+        healthy = false
+        fmt.Printf("  ✘ sort_profiles %q must be insertion or alphabetical\n", cfg.SortProfiles)
+    }
+    if cfg.DefaultProfile != "" && findProfile(&cfg, cfg.DefaultProfile) == nil {
+        healthy = false
+        fmt.Printf("  ✘ default_profile %s does not exist\n", cfg.DefaultProfile)
+    }
+    return healthy
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+)
+
+// commandNames lists the commands offered by shell completion.
+var commandNames = []string{
+    "init", "init-repo", "config", "log", "list", "grep", "info", "stats",
+    "keys", "signers", "forge", "trust", "verify-signatures", "set", "diff",
+    "detect", "rules", "remotes", "policy", "unset", "which", "explain",
+    "profile", "whoami", "pin", "unpin", "fix-last-commit", "guard", "shim",
+    "privacy", "verify", "audit", "server-hook", "exec", "shell", "ssh",
+    "ssh-select", "credential", "serve", "lsp-lite", "bootstrap", "scan",
+    "export", "metrics", "watch", "service", "tidy", "apply", "ensure",
+    "render", "includes", "template", "add", "remove", "restore", "trash",
+    "doctor", "selftest", "completion", "release",
+}
+
+// subcommandNames lists the words completed after a command.
+var subcommandNames = map[string][]string{
+    "init-repo":   {"--install-template"},
+    "config":      {"edit", "backups", "restore"},
+    "log":         {"show", "-n", "--target", "--json"},
+    "list":        {"--check", "--tree", "--porcelain"},
+    "info":        {"--commits", "--porcelain"},
+    "which":       {"--porcelain"},
+    "explain":     {"--json"},
+    "profile":     {"show"},
+    "stats":       {"keys", "usage"},
+    "keys":        {"rotate"},
+    "signers":     {"list", "add", "remove"},
+    "forge":       {"check", "noreply", "cache", "--use"},
+    "trust":       {"sync", "--from"},
+    "set":         {"--auto"},
+    "rules":       {"list", "add", "remove", "test", "lint"},
+    "policy":      {"install", "show"},
+    "remotes":     {"fix", "audit", "--dry-run", "--yes", "--json", "--jobs"},
+    "guard":       {"install", "--block", "--privacy", "--stamp"},
+    "privacy":     {"--block"},
+    "verify":      {"--range", "--quick"},
+    "shim":        {"install", "uninstall", "--dir", "--force"},
+    "audit":       {"--range", "--remediate", "--note"},
+    "server-hook": {"generate"},
+    "ssh":         {"test", "setup"},
+    "ssh-select":  {"--match"},
+    "credential":  {"get", "store", "erase"},
+    "serve":       {"--socket"},
+    "lsp-lite":    {"--interval"},
+    "bootstrap":   {"--devcontainer", "--profile", "--dir", "--force"},
+    "scan":        {"--fix", "--resume", "--verify", "--jobs"},
+    "export":      {"--repos", "--format", "-o", "--jobs"},
+    "metrics":     {"-o", "--jobs"},
+    "watch":       {"--notify", "--interval", "--once", "--jobs"},
+    "service":     {"install", "uninstall", "status", "--interval"},
+    "tidy":        {"--yes"},
+    "apply":       {"-f", "--dry-run"},
+    "ensure":      {"--profile", "--repo", "--check"},
+    "render":      {"--scope"},
+    "includes":    {"sync", "clear", "--dry-run"},
+    "template":    {"edit"},
+    "remove":      {"--force"},
+    "doctor":      {"--fix", "--yes"},
+    "selftest":    {"--keep"},
+    "completion":  {"bash", "zsh", "powershell", "--install"},
+    "release":     {"manifest"},
+    "add":         {"--from"},
+}
+
+// profileCommands take a profile name as their argument.
+var profileCommands = map[string]bool{
+    "set": true, "remove": true, "render": true, "exec": true, "shell": true,
+    "fix-last-commit": true, "pin": true, "diff": true,
+}
+
+// completions returns the candidates for the word following words, the
+// arguments already typed after "gist". Shells filter them by prefix.
+func completions(cfg Config, words []string) []string {
+    // Global options come before the command.
+    args := words[globalArgs(words):]
+    if len(args) == 0 {
+        return append(commandNames, "-C", "--path", "--help", "--version")
+    }
+    var profiles []string
+    for _, p := range cfg.Profiles {
+        profiles = append(profiles, p.Name)
+    }
+    cmd, prev := args[0], args[len(args)-1]
+    switch {
+    case prev == "--profile":
+        return profiles
+    case prev == "--scope":
+        return []string{"local", "global", "include"}
+    case cmd == "restore":
+        var names []string
+        for _, t := range cfg.Trash {
+            names = append(names, t.Name)
+        }
+        return names
+    case (cmd == "template" || cmd == "keys" || cmd == "forge" || cmd == "profile") && len(args) == 2:
+        return profiles
+    case profileCommands[cmd]:
+        return append(profiles, subcommandNames[cmd]...)
+    case len(args) == 1:
+        return subcommandNames[cmd]
+    }
+    return nil
+}
+
+// bashCompletion is sourced by bash (and zsh through bashcompinit).
+const bashCompletion = `# gist completion for bash; zsh users: autoload -U +X bashcompinit && bashcompinit
+_gist() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local IFS=$'\n'
+    COMPREPLY=($(compgen -W "$(gist __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null)" -- "$cur"))
+}
+complete -F _gist gist
+`
+
+// powershellCompletion registers a native argument completer; load it from
+// $PROFILE so every PowerShell (and Windows Terminal) session has it.
+const powershellCompletion = `# gist completion for PowerShell
+Register-ArgumentCompleter -Native -CommandName gist, gist.exe -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })
+    if ($wordToComplete -ne '') { $words = @($words | Select-Object -SkipLast 1) }
+    & gist __complete @words 2>$null | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`
+
+// zshCompletion is a native completion function, for a directory in fpath.
+const zshCompletion = `#compdef gist
+# gist completion for zsh
+local -a candidates
+candidates=(${(f)"$(gist __complete "${(@)words[2,CURRENT-1]}" 2>/dev/null)"})
+compadd -a candidates
+`
+
+// bashCompletionScripts are where bash-completion is commonly installed; it
+// loads completions from the user's data directory by itself.
+var bashCompletionScripts = []string{
+    "/usr/share/bash-completion/bash_completion",
+    "/etc/bash_completion",
+    "/usr/local/share/bash-completion/bash_completion",
+    "/opt/homebrew/etc/profile.d/bash_completion.sh",
+    "/usr/local/etc/profile.d/bash_completion.sh",
+}
+
+// completionInstall is where a shell's completion is installed: the script
+// and the file for it, and the line the rc file needs to load it, if any.
+type completionInstall struct {
+    File   string
+    Script string
+    RC     string
+    RCLine string
+}
+
+// dataHome returns $XDG_DATA_HOME, by default ~/.local/share.
+func dataHome() string {
+    if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+        return dir
+    }
+    return expandHome("~/.local/share")
+}
+
+// detectShell returns the user's shell: PowerShell on Windows, else the
+// one $SHELL names.
+func detectShell() string {
+    if runtime.GOOS == "windows" {
+        return "powershell"
+    }
+    return filepath.Base(os.Getenv("SHELL"))
+}
+
+// completionInstallFor returns where to install the completion for shell.
+func completionInstallFor(shell string) (completionInstall, error) {
+    switch shell {
+    case "bash":
+        in := completionInstall{
+            File:   filepath.Join(dataHome(), "bash-completion", "completions", "gist"),
+            Script: bashCompletion,
+        }
+        for _, script := range bashCompletionScripts {
+            if _, err := os.Stat(script); err == nil {
+                return in, nil
+            }
+        }
+        // Without bash-completion, ~/.bashrc sources the file.
+        in.RC = expandHome("~/.bashrc")
+        in.RCLine = fmt.Sprintf("[ -f %q ] && . %q", in.File, in.File)
+        return in, nil
+    case "zsh":
+        dir := filepath.Join(dataHome(), "zsh", "site-functions")
+        rc := expandHome("~/.zshrc")
+        if zdotdir := os.Getenv("ZDOTDIR"); zdotdir != "" {
+            rc = filepath.Join(zdotdir, ".zshrc")
+        }
+        return completionInstall{
+            File:   filepath.Join(dir, "_gist"),
+            Script: zshCompletion,
+            RC:     rc,
+            RCLine: fmt.Sprintf("fpath=(%q $fpath) && autoload -Uz compinit && compinit", dir),
+        }, nil
+    case "powershell", "pwsh":
+        profile := expandHome("~/.config/powershell/Microsoft.PowerShell_profile.ps1")
+        if runtime.GOOS == "windows" {
+            profile = filepath.Join(expandHome("~"), "Documents", "PowerShell", "Microsoft.PowerShell_profile.ps1")
+        }
+        file := filepath.Join(filepath.Dir(getConfigPath()), "completion.ps1")
+        return completionInstall{
+            File:   file,
+            Script: powershellCompletion,
+            RC:     profile,
+            RCLine: fmt.Sprintf(". '%s'", strings.ReplaceAll(file, "'", "''")),
+        }, nil
+    }
+    return completionInstall{}, fmt.Errorf("unsupported shell %q (bash, zsh or powershell)", shell)
+}
+
+// installCompletion writes the completion for shell (detected when empty)
+// and makes the shell load it. Running it again changes nothing unless the
+// script changed.
+func installCompletion(shell string) error {
+    if shell == "" {
+        shell = detectShell()
+        if shell == "" || shell == "." {
+            return fmt.Errorf("cannot detect your shell; name it: gist completion --install bash|zsh|powershell")
+        }
+    }
+    in, err := completionInstallFor(shell)
+    if err != nil {
+        return err
+    }
+    if data, err := os.ReadFile(in.File); err == nil && string(data) == in.Script {
+        fmt.Printf("%s is up to date.\n", in.File)
+    } else {
+        if err := os.MkdirAll(filepath.Dir(in.File), 0o755); err != nil {
+            return err
+        }
+        if err := os.WriteFile(in.File, []byte(in.Script), 0o644); err != nil {
+            return err
+        }
+        fmt.Printf("Wrote %s.\n", in.File)
+    }
+    if in.RC == "" {
+        fmt.Println("bash-completion loads it in new shells.")
+        return nil
+    }
+    data, err := os.ReadFile(in.RC)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    if strings.Contains(string(data), in.RCLine) {
+        fmt.Printf("%s already loads it.\n", in.RC)
+        return nil
+    }
+    if err := os.MkdirAll(filepath.Dir(in.RC), 0o755); err != nil {
+        return err
+    }
+    f, err := os.OpenFile(in.RC, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    block := "# gist completion\n" + in.RCLine + "\n"
+    if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+        block = "\n" + block
+    }
+    _, err = f.WriteString(block)
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Added it to %s; open a new shell to use it.\n", in.RC)
+    return nil
+}
+
+// commandCompletion prints the completion script for a shell.
+func commandCompletion(shell string) error {
+    switch shell {
+    case "bash":
+        fmt.Print(bashCompletion)
+    case "zsh":
+        fmt.Print("autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion)
+    case "powershell", "pwsh":
+        fmt.Print(powershellCompletion)
+    default:
+        return fmt.Errorf("unsupported shell %q (bash, zsh or powershell)", shell)
+    }
+    return nil
+}
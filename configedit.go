@@ -0,0 +1,271 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+)
+
+// yamlKeys returns the yaml keys of a struct type's fields.
+func yamlKeys(v any) map[string]bool {
+    keys := map[string]bool{}
+    t := reflect.TypeOf(v)
+    for i := 0; i < t.NumField(); i++ {
+        name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+        if name != "" && name != "-" {
+            keys[name] = true
+        }
+    }
+    return keys
+}
+
+// lintConfigLines finds the lines parseConfig would silently misread: keys
+// it doesn't know, and profile keys outside a profile, which are dropped or
+// land in the profile before.
+func lintConfigLines(data []byte) []string {
+    topKeys, profileKeys, ruleKeys := yamlKeys(Config{}), yamlKeys(Profile{}), yamlKeys(Rule{})
+    var problems []string
+    section, inProfile, mapIndent := "profiles", false, -1
+    for n, line := range strings.Split(string(data), "\n") {
+        line = stripComment(line)
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+            continue
+        }
+        at := fmt.Sprintf("line %d: ", n+1)
+        indent := len(line) - len(strings.TrimLeft(line, " \t"))
+        item := strings.HasPrefix(trimmed, "-")
+        if indent == 0 && !item {
+            key, value, ok := parseKeyValue(trimmed)
+            switch {
+            case !ok:
+                problems = append(problems, at+"expected key: value")
+            case !topKeys[key]:
+                problems = append(problems, fmt.Sprintf("%sunknown top-level key %q", at, key))
+            case value == "":
+                section, inProfile = key, false
+            }
+            mapIndent = -1
+            continue
+        }
+        // Entries of a profile's env, known_hosts and maintenance maps and
+        // also_emails list.
+        if mapIndent >= 0 && indent > mapIndent {
+            continue
+        }
+        mapIndent = -1
+        key, value, ok := parseKeyValue(trimmed)
+        if section != "profiles" && section != "rules" {
+            // Other sections also hold lists of bare values.
+            if !ok && !item {
+                problems = append(problems, at+"expected key: value")
+            }
+            continue
+        }
+        if !ok {
+            problems = append(problems, at+"expected key: value")
+            continue
+        }
+        if section == "rules" {
+            if !ruleKeys[key] {
+                problems = append(problems, fmt.Sprintf("%sunknown rule key %q", at, key))
+            }
+            continue
+        }
+        switch {
+        case item && key != "name":
+            problems = append(problems, fmt.Sprintf("%sprofile starts with %s instead of name", at, key))
+        case !item && !inProfile && key != "name":
+            problems = append(problems, fmt.Sprintf("%s%s belongs to no profile (a profile starts with - name:)", at, key))
+        case !profileKeys[key]:
+            problems = append(problems, fmt.Sprintf("%sunknown profile key %q", at, key))
+        case value == "" && (key == "env" || key == "also_emails" || key == "known_hosts" || key == "maintenance"):
+            mapIndent = indent
+        }
+        if key == "name" {
+            inProfile = true
+        } else if item {
+            inProfile = false
+        }
+    }
+    return problems
+}
+
+// danglingReferences returns the rules, hosts entries and default_profile
+// naming a profile that doesn't exist.
+func danglingReferences(cfg Config) []string {
+    var problems []string
+    for i, r := range cfg.Rules {
+        if findProfile(&cfg, r.Profile) == nil {
+            problems = append(problems, fmt.Sprintf("rule %d: profile %s does not exist", i+1, r.Profile))
+        }
+    }
+    for _, host := range sortedKeys(cfg.Hosts) {
+        if findProfile(&cfg, cfg.Hosts[host]) == nil {
+            problems = append(problems, fmt.Sprintf("host %s: profile %s does not exist", host, cfg.Hosts[host]))
+        }
+    }
+    if cfg.DefaultProfile != "" && findProfile(&cfg, cfg.DefaultProfile) == nil {
+        problems = append(problems, fmt.Sprintf("default_profile %s does not exist", cfg.DefaultProfile))
+    }
+    return problems
+}
+
+// configProblems validates the config text data as if it were saved at
+// path: the lines gist would misread, then the config it loads to. Unlike
+// loadConfig it doesn't migrate an older version; that happens on the next
+// load.
+func configProblems(path string, data []byte) (Config, []string) {
+    problems := lintConfigLines(data)
+    var cfg Config
+    parseConfig(&cfg, data)
+    if cfg.Version > configVersion {
+        problems = append(problems, fmt.Sprintf("version %d is newer than this gist supports (%d)", cfg.Version, configVersion))
+    }
+    if err := loadIncludes(path, cfg.Include, &cfg, map[string]bool{}); err != nil {
+        problems = append(problems, err.Error())
+    }
+    if cfg.StrictEnv && len(cfg.undefined) > 0 {
+        problems = append(problems, "undefined environment variables (strict_env): "+strings.Join(cfg.undefined, ", "))
+    }
+    problems = append(problems, profileNameProblems(cfg)...)
+    for _, p := range cfg.Profiles {
+        if p.Source != "" {
+            continue
+        }
+        if err := checkEmail(p.Email); err != nil {
+            problems = append(problems, fmt.Sprintf("%s: %v", profileLabel(&p), err))
+        }
+    }
+    return cfg, append(problems, danglingReferences(cfg)...)
+}
+
+// profileNameProblems reports the profiles of the file cfg was parsed from
+// that have no name or the name of an earlier one, which findProfile never
+// returns. Included profiles are loadIncludes' business.
+func profileNameProblems(cfg Config) []string {
+    var problems []string
+    seen := map[string]bool{}
+    for _, p := range cfg.Profiles {
+        if p.Source != cfg.source {
+            continue
+        }
+        switch {
+        case p.Name == "":
+            problems = append(problems, "a profile has an empty name")
+        case seen[p.Name]:
+            problems = append(problems, fmt.Sprintf("profile %s is defined twice", p.Name))
+        }
+        seen[p.Name] = true
+    }
+    return problems
+}
+
+// strictConfig is set by the global --strict flag, which works like
+// strict: true in the config.
+var strictConfig bool
+
+// strictCheck fails, in strict mode, when the config text data read from
+// path has lines gist would misread or profiles without a unique name.
+func strictCheck(cfg *Config, path string, data []byte) error {
+    if !cfg.Strict && !strictConfig {
+        return nil
+    }
+    problems := append(lintConfigLines(data), profileNameProblems(*cfg)...)
+    if len(problems) == 0 {
+        return nil
+    }
+    return fmt.Errorf("%s (strict):\n  %s", path, strings.Join(problems, "\n  "))
+}
+
+// runEditor opens path in the user's editor and waits for it to exit.
+func runEditor(path string) error {
+    // The editor setting may carry arguments, e.g. "code --wait".
+    editor := strings.Fields(editorCommand())
+    if err := interactive(editor[0], append(editor[1:], path)...); err != nil {
+        return fmt.Errorf("editor failed: %w", err)
+    }
+    return nil
+}
+
+// commandConfigEdit opens a copy of the config in the editor and saves it
+// only once it validates; when it doesn't, the user can edit it again or
+// discard the changes. The copy lives next to the config, so relative
+// include paths resolve the same.
+func commandConfigEdit(path string) error {
+    original, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return fmt.Errorf("no config at %s; run `gist init` first", path)
+    } else if err != nil {
+        return err
+    }
+    before, _ := configProblems(path, original)
+    tmp, err := os.CreateTemp(filepath.Dir(path), ".config-edit-*.yaml")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    _, err = tmp.Write(original)
+    if cerr := tmp.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        return err
+    }
+    var data []byte
+    var cfg Config
+    for {
+        if err := runEditor(tmp.Name()); err != nil {
+            return err
+        }
+        if data, err = os.ReadFile(tmp.Name()); err != nil {
+            return err
+        }
+        if bytes.Equal(data, original) {
+            fmt.Println("Config unchanged.")
+            return nil
+        }
+        var problems []string
+        cfg, problems = configProblems(path, data)
+        if len(problems) == 0 {
+            break
+        }
+        fmt.Println("✘ The edited config has problems:")
+        for _, problem := range problems {
+            fmt.Printf("  %s\n", problem)
+        }
+        if !isInteractive() {
+            return errors.New("changes discarded; the config is unchanged")
+        }
+        fmt.Print("Edit again or discard the changes? [E/d] ")
+        answer, err := stdin.ReadString('\n')
+        if a := strings.ToLower(strings.TrimSpace(answer)); err != nil || a == "d" || a == "discard" {
+            fmt.Println("Changes discarded; the config is unchanged.")
+            return nil
+        }
+    }
+    if err := backupConfig(path); err != nil {
+        return fmt.Errorf("failed to back up config: %w", err)
+    }
+    if err := os.WriteFile(path, data, 0o644); err != nil {
+        return err
+    }
+    logConfigSave(original, data)
+    fmt.Printf("✔️  Saved the config (%d profiles)\n", len(cfg.Profiles))
+    var removed []string
+    for _, p := range before.Profiles {
+        if findProfile(&cfg, p.Name) == nil {
+            removed = append(removed, p.Name)
+        }
+    }
+    if len(removed) > 0 {
+        sort.Strings(removed)
+        fmt.Printf("Removed profile(s): %s (undo with `gist config restore 1`)\n", strings.Join(removed, ", "))
+    }
+    return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// gist is a git credential helper: with
+//
+//   git config --global credential.helper "!gist credential"
+//
+// (or credential.helper = gist, with gist linked as git-credential-gist on
+// PATH) git asks gist for the username and token of HTTPS remotes, and gist
+// answers with those of the profile the URL selects.
+
+// credentialHelperName is the executable name git runs for
+// credential.helper = gist.
+const credentialHelperName = "git-credential-gist"
+
+// readCredential parses the key=value lines git sends a credential helper,
+// up to a blank line.
+func readCredential(r io.Reader) (map[string]string, error) {
+    data, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    attrs := map[string]string{}
+    for _, line := range strings.Split(string(data), "\n") {
+        line = strings.TrimSuffix(line, "\r")
+        if line == "" {
+            break
+        }
+        key, value, ok := strings.Cut(line, "=")
+        if !ok {
+            return nil, fmt.Errorf("malformed credential line %q", line)
+        }
+        attrs[key] = value
+    }
+    // Newer gits may send the whole URL instead of its parts.
+    if raw, ok := attrs["url"]; ok {
+        u, err := url.Parse(raw)
+        if err != nil {
+            return nil, err
+        }
+        attrs["protocol"], attrs["host"] = u.Scheme, u.Host
+        attrs["path"] = strings.TrimPrefix(u.Path, "/")
+        if u.User != nil && attrs["username"] == "" {
+            attrs["username"] = u.User.Username()
+        }
+    }
+    return attrs, nil
+}
+
+// credentialProfile returns the profile a URL selects: the highest ranked
+// rule with a matching url (whose dir and branch conditions hold in the
+// current repository, if any), else the hosts map. Rules without a url and
+// the default profile are not considered, so a token only ever goes to a
+// host the config names for it.
+func credentialProfile(cfg Config, u string) *Profile {
+    inRepo, repoRoot := isGitRepo()
+    for _, i := range rankedRules(cfg) {
+        r := cfg.Rules[i]
+        if r.URL == "" || !r.matchesURL(u) {
+            continue
+        }
+        if (r.Dir != "" || r.Branch != "") && (!inRepo || !r.matchesDir(repoRoot) || !r.matchesBranch(currentBranch())) {
+            continue
+        }
+        return findProfile(&cfg, r.Profile)
+    }
+    if name, _ := hostProfile(cfg, []remoteInfo{{Name: "origin", URL: u}}); name != "" {
+        return findProfile(&cfg, name)
+    }
+    return nil
+}
+
+// credentialUsername returns the username to send with the profile's
+// token: credential_username, or what the forge expects with a token.
+func credentialUsername(cfg Config, p *Profile, host string) string {
+    if p.CredentialUsername != "" {
+        return p.CredentialUsername
+    }
+    switch forgeKind(cfg, host) {
+    case forgeGitHub:
+        return "x-access-token"
+    case forgeGitLab:
+        return "oauth2"
+    case forgeBitbucket:
+        return forgeCredential(p, "BITBUCKET_USERNAME")
+    }
+    return ""
+}
+
+// credentialToken resolves the profile's token, which is loaded unresolved
+// so that a password manager is only asked when git needs the token.
+func credentialToken(p *Profile) (string, error) {
+    token := p.CredentialToken
+    switch {
+    case strings.HasPrefix(token, "!"):
+        return resolveValue(token)
+    case strings.Contains(token, "${"):
+        return interpolate(token), nil
+    }
+    return token, nil
+}
+
+// remotePath returns the path of the current repository's HTTPS remote on
+// host, origin first, or "" when it has none. git only sends a path with
+// credential.useHttpPath, and without one URL rules that tell accounts on
+// the same host apart can't match.
+func remotePath(host string) string {
+    remotes := listRemotes()
+    sort.SliceStable(remotes, func(i, j int) bool { return remotes[i].Name == "origin" && remotes[j].Name != "origin" })
+    for _, rm := range remotes {
+        if !strings.HasPrefix(strings.ToLower(rm.URL), "https://") {
+            continue
+        }
+        if _, h, path, ok := remoteParts(rm.URL); ok && strings.EqualFold(h, host) {
+            return strings.Trim(path, "/")
+        }
+    }
+    return ""
+}
+
+// credentialGet answers a "get" request. It writes nothing when gist has no
+// token for the URL, so git tries its next helper or prompts.
+func credentialGet(cfg Config, attrs map[string]string, w io.Writer) error {
+    if attrs["protocol"] != "https" || attrs["host"] == "" {
+        return nil
+    }
+    if attrs["path"] == "" {
+        attrs["path"] = remotePath(attrs["host"])
+    }
+    u := "https://" + attrs["host"] + "/" + attrs["path"]
+    p := credentialProfile(cfg, u)
+    if bot := execBot(cfg, u, p); bot != nil {
+        p = bot
+    }
+    if p == nil || (p.CredentialToken == "" && !usesGitHubApp(p)) {
+        return nil
+    }
+    username := attrs["username"]
+    switch {
+    case username == "":
+        username = credentialUsername(cfg, p, remoteHost(u))
+        if username == "" && p.Bot {
+            // git would prompt for it. Where the forge doesn't tell, the
+            // token alone usually authenticates; name the account with
+            // credential_username where it doesn't.
+            username = "token"
+        }
+    case p.CredentialUsername != "" && p.CredentialUsername != username:
+        // The URL names another account.
+        return nil
+    }
+    var token string
+    var err error
+    if usesGitHubApp(p) && forgeKind(cfg, attrs["host"]) == forgeGitHub {
+        repo := strings.TrimSuffix(strings.Trim(attrs["path"], "/"), ".git")
+        if repo == "" {
+            _, repo = repoGitHubRemote(cfg)
+        }
+        if token, err = githubAppToken(p, attrs["host"], repo); err != nil {
+            return fmt.Errorf("%s: cannot mint a GitHub App token: %w", profileLabel(p), err)
+        }
+    } else if token, err = credentialToken(p); err != nil {
+        return fmt.Errorf("%s: cannot resolve credential_token: %w", profileLabel(p), err)
+    }
+    if token == "" {
+        return nil
+    }
+    if username != "" {
+        fmt.Fprintf(w, "username=%s\n", username)
+    }
+    fmt.Fprintf(w, "password=%s\n", token)
+    return nil
+}
+
+// commandCredential implements the git credential helper protocol. Tokens
+// live in the config, so store and erase requests are ignored.
+func commandCredential(cfg Config, args []string) error {
+    if len(args) != 1 {
+        return errors.New("usage: gist credential get|store|erase (run by git as a credential helper)")
+    }
+    attrs, err := readCredential(stdin)
+    if err != nil {
+        return err
+    }
+    switch args[0] {
+    case "get":
+        return credentialGet(cfg, attrs, os.Stdout)
+    case "store", "erase":
+        return nil
+    }
+    return fmt.Errorf("unknown credential operation %q", args[0])
+}
+
+// invokedAsCredentialHelper reports whether gist runs as
+// git-credential-gist.
+func invokedAsCredentialHelper(argv0 string) bool {
+    return strings.TrimSuffix(filepath.Base(argv0), ".exe") == credentialHelperName
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+    "os/exec"
+    "strings"
+    "testing"
+)
+
+// TestCredentialGetWithoutPath checks that a request without a path – what
+// git sends unless credential.useHttpPath is set – is answered by the URL
+// rule the repository's remote matches, not by the hosts map.
+func TestCredentialGetWithoutPath(t *testing.T) {
+    dir := t.TempDir()
+    for _, args := range [][]string{
+        {"init", "-q", dir},
+        {"-C", dir, "remote", "add", "origin", "https://github.com/acme/widgets.git"},
+    } {
+        if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+            t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+        }
+    }
+    t.Setenv("GIST_PROFILE", "")
+    defer func(dir string) { repoDir = dir }(repoDir)
+    repoDir = dir
+
+    cfg := Config{
+        Profiles: []Profile{
+            {Name: "work", CredentialUsername: "work-bot", CredentialToken: "work-token"},
+            {Name: "personal", CredentialUsername: "me", CredentialToken: "personal-token"},
+        },
+        Rules: []Rule{{Profile: "work", URL: "github.com/acme"}},
+        Hosts: map[string]string{"github.com": "personal"},
+    }
+    for _, tc := range []struct {
+        name  string
+        attrs map[string]string
+        want  string
+    }{
+        {"no path", map[string]string{"protocol": "https", "host": "github.com"}, "password=work-token"},
+        {"rule path", map[string]string{"protocol": "https", "host": "github.com", "path": "acme/widgets.git"}, "password=work-token"},
+        {"other path", map[string]string{"protocol": "https", "host": "github.com", "path": "someone/else.git"}, "password=personal-token"},
+    } {
+        var out strings.Builder
+        if err := credentialGet(cfg, tc.attrs, &out); err != nil {
+            t.Fatalf("%s: %v", tc.name, err)
+        }
+        if !strings.Contains(out.String(), tc.want+"\n") {
+            t.Errorf("%s: got %q, want %s", tc.name, out.String(), tc.want)
+        }
+    }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// detection is the evidence collected for one profile.
+type detection struct {
+    Profile *Profile
+    Score   int
+    Reasons []string
+}
+
+// emailDomainParts returns the labels of an email's domain that identify an
+// organisation, e.g. "jane@mail.acme.co.uk" gives [mail acme].
+func emailDomainParts(email string) []string {
+    _, domain, ok := strings.Cut(strings.ToLower(email), "@")
+    if !ok {
+        return nil
+    }
+    labels := strings.Split(domain, ".")
+    var parts []string
+    for _, l := range labels[:max(len(labels)-1, 0)] {
+        // Skip generic second-level labels such as the "co" in co.uk.
+        if len(l) > 2 {
+            parts = append(parts, l)
+        }
+    }
+    return parts
+}
+
+// historyEmails counts author emails in the repository's recent history.
+func historyEmails() (map[string]int, int) {
+    out, err := runGit("log", "--max-count=200", "--format=%ae")
+    if err != nil || out == "" {
+        return nil, 0
+    }
+    counts := map[string]int{}
+    lines := strings.Split(out, "\n")
+    for _, e := range lines {
+        counts[strings.ToLower(e)]++
+    }
+    return counts, len(lines)
+}
+
+// detectProfiles scores every profile against the repository's remotes,
+// history and location, best first.
+func detectProfiles(cfg Config, repoRoot string) []detection {
+    remotes := listRemotes()
+    history, total := historyEmails()
+    dirParts := strings.Split(strings.ToLower(filepath.ToSlash(repoRoot)), "/")
+    var results []detection
+    for i := range cfg.Profiles {
+        p := &cfg.Profiles[i]
+        d := detection{Profile: p}
+        orgs := emailDomainParts(p.Email)
+        for _, rm := range remotes {
+            url := normalizeRemoteURL(rm.URL)
+            for _, org := range orgs {
+                if strings.Contains(url, org) {
+                    d.Score += 3
+                    d.Reasons = append(d.Reasons, fmt.Sprintf("remote %s mentions %q from the email domain", rm.Name, org))
+                    break
+                }
+            }
+        }
+        if n := history[strings.ToLower(p.Email)]; n > 0 {
+            d.Score += 1 + 4*n/total
+            d.Reasons = append(d.Reasons, fmt.Sprintf("%d of the last %d commits are by %s", n, total, p.Email))
+        }
+        for _, part := range dirParts {
+            if part == strings.ToLower(p.Name) {
+                d.Score += 2
+                d.Reasons = append(d.Reasons, fmt.Sprintf("path contains %q", part))
+                break
+            }
+        }
+        if d.Score > 0 {
+            results = append(results, d)
+        }
+    }
+    sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+    return results
+}
+
+// confidence turns the winner's lead into a rough label.
+func confidence(results []detection) string {
+    lead := results[0].Score
+    if len(results) > 1 {
+        lead -= results[1].Score
+    }
+    switch {
+    case lead >= 4:
+        return "high"
+    case lead >= 2:
+        return "medium"
+    default:
+        return "low"
+    }
+}
+
+// commandDetect suggests a profile for a repository no rule covers, and
+// applies it after confirmation (or straight away with assumeYes).
+func commandDetect(cfg Config, assumeYes bool) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    if idx := resolveRule(cfg, repoRoot, listRemotes()); idx != -1 {
+        fmt.Printf("rule %d already selects %s; use gist set --auto\n", idx+1, cfg.Rules[idx].Profile)
+        return nil
+    }
+    results := detectProfiles(cfg, repoRoot)
+    if len(results) == 0 {
+        fmt.Println("no evidence points to any profile")
+        return nil
+    }
+    best := results[0]
+    fmt.Printf("suggested profile: %s (confidence: %s)\n", best.Profile.Name, confidence(results))
+    for _, r := range best.Reasons {
+        fmt.Printf("  • %s\n", r)
+    }
+    for _, other := range results[1:] {
+        fmt.Printf("  also possible: %s (%s)\n", other.Profile.Name, strings.Join(other.Reasons, "; "))
+    }
+    if !assumeYes && !confirm(fmt.Sprintf("Apply profile %s?", best.Profile.Name)) {
+        return nil
+    }
+    return commandSet(cfg, best.Profile.Name)
+}
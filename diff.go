@@ -0,0 +1,85 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// ANSI colours used by diff output.
+const (
+    colorRed    = "\x1b[31m"
+    colorGreen  = "\x1b[32m"
+    colorDim    = "\x1b[2m"
+    colorReset  = "\x1b[0m"
+)
+
+// useColor reports whether stdout is a terminal and NO_COLOR is unset.
+func useColor() bool {
+    if os.Getenv("NO_COLOR") != "" {
+        return false
+    }
+    info, err := os.Stdout.Stat()
+    return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// displayValue quotes a config value for diff output, hiding credentials
+// in http.extraHeader the way info does.
+func displayValue(key, value string) string {
+    if strings.EqualFold(key, "http.extraHeader") {
+        if name, _, ok := strings.Cut(value, ":"); ok {
+            value = name + ": ***"
+        }
+    }
+    return fmt.Sprintf("%q", value)
+}
+
+// commandDiff compares the repository's effective config with what `set`
+// would write for a profile, field by field.
+func commandDiff(cfg Config, profileName string) error {
+    p := findProfile(&cfg, profileName)
+    if p == nil {
+        return fmt.Errorf("profile %s not found", profileName)
+    }
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    color := useColor()
+    paint := func(c, s string) string {
+        if !color {
+            return s
+        }
+        return c + s + colorReset
+    }
+    fmt.Printf("%s → profile %s\n", root, p.Name)
+    changes := 0
+    settings := repoSettings(p)
+    for _, s := range settings {
+        src, err := lookupConfig(s.Key)
+        switch {
+        case err != nil:
+            changes++
+            fmt.Println(paint(colorGreen, fmt.Sprintf("  + %s: %s", s.Key, displayValue(s.Key, s.Value))))
+        case src.Value == s.Value:
+            fmt.Println(paint(colorDim, fmt.Sprintf("  = %s: %s", s.Key, displayValue(s.Key, s.Value))))
+        default:
+            changes++
+            fmt.Println(paint(colorRed, fmt.Sprintf("  - %s: %s (%s)", s.Key, displayValue(s.Key, src.Value), src.scopeLabel())))
+            fmt.Println(paint(colorGreen, fmt.Sprintf("  + %s: %s", s.Key, displayValue(s.Key, s.Value))))
+        }
+    }
+    // set removes the local entries gist wrote that the profile doesn't
+    // define.
+    for _, s := range removedSettings(p, settings) {
+        changes++
+        fmt.Println(paint(colorRed, fmt.Sprintf("  - %s: %s (not in profile)", s.Key, displayValue(s.Key, s.Value))))
+    }
+    if changes == 0 {
+        fmt.Println("No changes: the repository already uses this profile.")
+    } else {
+        fmt.Printf("%d setting(s) would change.\n", changes)
+    }
+    return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "runtime"
+    "strings"
+)
+
+// diagnosis is a problem found by doctor, with an optional remedy.
+type diagnosis struct {
+    Problem string
+    // Fix describes the remedy; empty when doctor cannot fix the problem.
+    Fix   string
+    apply func() error
+}
+
+// hookExecutable finds the absolute gist path a hook script invokes,
+// single-quoted as gistExecutable writes it or double-quoted as earlier
+// versions did.
+var hookExecutable = regexp.MustCompile(`'((?:[A-Za-z]:\\|/)(?:[^']|'\\'')*)'|"((?:[A-Za-z]:\\|/)[^"]*)"`)
+
+// unquoteHookExecutable returns the path of a hookExecutable match.
+func unquoteHookExecutable(m []string) string {
+    return strings.ReplaceAll(m[1], `'\''`, "'") + m[2]
+}
+
+// diagnoseConfig checks that the config directory and file exist.
+func diagnoseConfig(configPath string) []diagnosis {
+    dir := filepath.Dir(configPath)
+    if _, err := os.Stat(dir); os.IsNotExist(err) {
+        return []diagnosis{{
+            Problem: fmt.Sprintf("config directory %s does not exist", dir),
+            Fix:     "create it with an example config",
+            apply: func() error {
+                if err := os.MkdirAll(dir, 0o755); err != nil {
+                    return err
+                }
+                return initConfig(configPath)
+            },
+        }}
+    }
+    if _, err := os.Stat(configPath); os.IsNotExist(err) {
+        return []diagnosis{{
+            Problem: fmt.Sprintf("config file %s does not exist", configPath),
+            Fix:     "create an example config",
+            apply:   func() error { return initConfig(configPath) },
+        }}
+    }
+    return nil
+}
+
+// diagnoseKeys checks that private SSH keys are only readable by the owner.
+func diagnoseKeys(cfg Config) []diagnosis {
+    // Windows has no meaningful unix permission bits.
+    if runtime.GOOS == "windows" {
+        return nil
+    }
+    var found []diagnosis
+    seen := map[string]bool{}
+    for _, p := range cfg.Profiles {
+        path := profilePath(&p, p.SSHKey)
+        if path == "" || seen[path] {
+            continue
+        }
+        seen[path] = true
+        info, err := os.Stat(path)
+        if err != nil || info.IsDir() || info.Mode().Perm()&0o077 == 0 {
+            continue
+        }
+        found = append(found, diagnosis{
+            Problem: fmt.Sprintf("key file %s has permissions %04o, expected 0600", path, info.Mode().Perm()),
+            Fix:     "chmod 0600 " + path,
+            apply:   func() error { return os.Chmod(path, 0o600) },
+        })
+    }
+    return found
+}
+
+// diagnoseHooks checks the gist hooks in dir: they must be executable and
+// point at an existing gist binary.
+func diagnoseHooks(dir string) []diagnosis {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil
+    }
+    var found []diagnosis
+    for _, e := range entries {
+        path := filepath.Join(dir, e.Name())
+        data, err := os.ReadFile(path)
+        if err != nil || !strings.Contains(string(data), "Installed by gist") {
+            continue
+        }
+        script := string(data)
+        var problems []string
+        for _, m := range hookExecutable.FindAllStringSubmatch(script, -1) {
+            exe := unquoteHookExecutable(m)
+            if _, err := os.Stat(exe); err != nil {
+                problems = append(problems, "runs missing "+exe)
+                script = strings.ReplaceAll(script, m[0], gistExecutable())
+            }
+        }
+        if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+            problems = append(problems, "is not executable")
+        }
+        if len(problems) == 0 {
+            continue
+        }
+        found = append(found, diagnosis{
+            Problem: fmt.Sprintf("hook %s %s", path, strings.Join(problems, " and ")),
+            Fix:     "re-install it for " + gistExecutable(),
+            apply: func() error {
+                if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+                    return err
+                }
+                return os.Chmod(path, 0o755)
+            },
+        })
+    }
+    return found
+}
+
+// includeFragments returns the files included from the global gitconfig.
+func includeFragments() []string {
+    out, err := runGit("config", "--global", "--get-regexp", `^include(if\..*)?\.path$`)
+    if err != nil {
+        return nil
+    }
+    var paths []string
+    seen := map[string]bool{}
+    for _, line := range strings.Split(out, "\n") {
+        _, path, ok := strings.Cut(line, " ")
+        path = expandHome(strings.TrimSpace(path))
+        if ok && !seen[path] {
+            seen[path] = true
+            paths = append(paths, path)
+        }
+    }
+    return paths
+}
+
+// diagnoseIncludes checks fragments written with `gist render --scope
+// include` against what their profile renders today.
+func diagnoseIncludes(cfg Config) []diagnosis {
+    var found []diagnosis
+    for _, path := range includeFragments() {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            continue
+        }
+        header, _, _ := strings.Cut(string(data), "\n")
+        name, ok := strings.CutPrefix(header, "# gist profile ")
+        if !ok {
+            continue
+        }
+        name, _, _ = strings.Cut(name, ",")
+        p := findProfile(&cfg, name)
+        if p == nil {
+            found = append(found, diagnosis{Problem: fmt.Sprintf("include %s is for missing profile %s", path, name)})
+            continue
+        }
+        want, err := renderProfile(cfg, p, "include")
+        if err != nil || want == string(data) {
+            continue
+        }
+        found = append(found, diagnosis{
+            Problem: fmt.Sprintf("include %s is stale for profile %s", path, name),
+            Fix:     "regenerate it",
+            apply:   func() error { return os.WriteFile(path, []byte(want), 0o644) },
+        })
+    }
+    return found
+}
+
+// diagnoseGitVersion checks that the installed git can apply every profile.
+func diagnoseGitVersion(cfg Config) []diagnosis {
+    var found []diagnosis
+    for i := range cfg.Profiles {
+        if err := profileGitRequirements(&cfg.Profiles[i]); err != nil {
+            found = append(found, diagnosis{Problem: err.Error()})
+        }
+    }
+    return found
+}
+
+// diagnose runs every doctor check.
+func diagnose(cfg Config, configPath string) []diagnosis {
+    found := diagnoseConfig(configPath)
+    found = append(found, diagnoseKeys(cfg)...)
+    found = append(found, diagnoseGitVersion(cfg)...)
+    if hooks, err := hooksDir(); err == nil {
+        found = append(found, diagnoseHooks(hooks)...)
+    }
+    dir, _ := templateDir()
+    found = append(found, diagnoseHooks(filepath.Join(dir, "hooks"))...)
+    found = append(found, diagnoseGitsign(cfg)...)
+    found = append(found, diagnoseHostConflicts(cfg)...)
+    return append(found, diagnoseIncludes(cfg)...)
+}
+
+// commandDoctor reports problems with the gist setup and, with fix, offers
+// to remedy each one, asking first unless assumeYes is set. It returns false
+// when problems remain.
+func commandDoctor(cfg Config, configPath string, fix, assumeYes bool) bool {
+    found := diagnose(cfg, configPath)
+    if len(found) == 0 {
+        fmt.Println("✔ no problems found")
+        return true
+    }
+    remaining, fixable := 0, false
+    for _, d := range found {
+        fmt.Printf("✘ %s\n", d.Problem)
+        if d.apply == nil {
+            remaining++
+            continue
+        }
+        if !fix {
+            fmt.Printf("    fix: %s\n", d.Fix)
+            remaining++
+            fixable = true
+            continue
+        }
+        if !assumeYes && !confirm("    Fix: "+d.Fix+"?") {
+            remaining++
+            continue
+        }
+        if err := d.apply(); err != nil {
+            fmt.Printf("    ✘ fix failed: %v\n", err)
+            remaining++
+            continue
+        }
+        fmt.Println("    ✔ fixed")
+    }
+    if fixable {
+        fmt.Println("Run `gist doctor --fix` to apply the fixes.")
+    }
+    return remaining == 0
+}
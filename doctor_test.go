@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestHookExecutable(t *testing.T) {
+    for _, tc := range []struct{ line, want string }{
+        {shellQuote("/opt/gist $HOME/`id`/gist") + " guard\n", "/opt/gist $HOME/`id`/gist"},
+        {shellQuote("/home/o'brien/bin/gist") + " set --auto >&2 || exit 0\n", "/home/o'brien/bin/gist"},
+        {`"/usr/local/bin/gist" guard` + "\n", "/usr/local/bin/gist"},
+        {shellQuote(`C:\Tools\gist.exe`) + " guard\n", `C:\Tools\gist.exe`},
+    } {
+        m := hookExecutable.FindStringSubmatch(tc.line)
+        if m == nil {
+            t.Errorf("no executable found in %q", tc.line)
+            continue
+        }
+        if got := unquoteHookExecutable(m); got != tc.want {
+            t.Errorf("executable of %q = %q, want %q", tc.line, got, tc.want)
+        }
+    }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "strconv"
+    "strings"
+)
+
+// profileEnv returns the environment a command runs with under a profile:
+// the current environment plus git identity variables, the profile's git
+// settings passed as GIT_CONFIG_* entries, and the profile's own env.
+func profileEnv(p *Profile) []string {
+    env := os.Environ()
+    env = append(env,
+        "GIST_PROFILE="+p.Name,
+        "GIT_AUTHOR_NAME="+gitName(p),
+        "GIT_AUTHOR_EMAIL="+p.Email,
+        "GIT_COMMITTER_NAME="+gitName(p),
+        "GIT_COMMITTER_EMAIL="+p.Email,
+    )
+    if p.SSHKey != "" {
+        env = append(env, "GIT_SSH_COMMAND="+sshCommand(profilePath(p, p.SSHKey)))
+    }
+    if p.AWSProfile != "" {
+        // git-remote-codecommit (codecommit:// remotes) reads AWS_PROFILE.
+        env = append(env, "AWS_PROFILE="+p.AWSProfile)
+    }
+    // Continue numbering after any GIT_CONFIG_* entries already present.
+    count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+    settings := profileSettings(p)
+    if p.Bot {
+        settings = append(settings, botCredentialSettings()...)
+    }
+    for _, s := range settings {
+        env = append(env,
+            fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", count, s.Key),
+            fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", count, s.Value))
+        count++
+    }
+    env = append(env, "GIT_CONFIG_COUNT="+strconv.Itoa(count))
+    if p.Bot {
+        env = append(env, botEnv(p)...)
+    }
+    for k, v := range p.Env {
+        env = append(env, k+"="+os.ExpandEnv(v))
+    }
+    return env
+}
+
+// commandExec runs argv under the profile's environment and returns its
+// exit code.
+func commandExec(cfg Config, profileName string, argv []string) (int, error) {
+    p := findProfile(&cfg, profileName)
+    if p == nil {
+        return 0, fmt.Errorf("profile %s not found", profileName)
+    }
+    if len(argv) == 0 {
+        return 0, errors.New("no command given")
+    }
+    cmd := exec.Command(argv[0], argv[1:]...)
+    cmd.Dir = repoDir
+    cmd.Env = profileEnv(p)
+    if usesGitHubApp(p) {
+        // Mint up front, so a bad key fails before the command runs, and
+        // hand the token to gh and other API clients too.
+        host, repo := repoGitHubRemote(cfg)
+        token, err := githubAppToken(p, host, repo)
+        if err != nil {
+            return 0, fmt.Errorf("%s: cannot mint a GitHub App token: %w", profileLabel(p), err)
+        }
+        cmd.Env = append(cmd.Env, "GH_TOKEN="+token, "GITHUB_TOKEN="+token)
+    }
+    cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+    if err := cmd.Run(); err != nil {
+        var ee *exec.ExitError
+        if errors.As(err, &ee) {
+            return ee.ExitCode(), nil
+        }
+        return 0, fmt.Errorf("cannot run %s: %w", strings.Join(argv, " "), err)
+    }
+    return 0, nil
+}
+
+// bashPromptRC is a bash rcfile that loads the user's own configuration and
+// then marks the prompt with the active profile.
+const bashPromptRC = `[ -f ~/.bashrc ] && . ~/.bashrc
+PS1="(gist:$GIST_PROFILE) $PS1"
+`
+
+// commandShell starts an interactive subshell running under the profile's
+// environment. Everything reverts when the shell exits.
+func commandShell(cfg Config, profileName string) (int, error) {
+    p := findProfile(&cfg, profileName)
+    if p == nil {
+        return 0, fmt.Errorf("profile %s not found", profileName)
+    }
+    if p.Bot {
+        return 0, fmt.Errorf("profile %s is a bot, which never prompts; use gist exec", p.Name)
+    }
+    if current := os.Getenv("GIST_PROFILE"); current != "" {
+        fmt.Fprintf(os.Stderr, "warning: already inside a gist shell for %s\n", current)
+    }
+    shell := os.Getenv("SHELL")
+    if shell == "" {
+        shell = "/bin/sh"
+    }
+    env := profileEnv(p)
+    var args []string
+    if strings.HasSuffix(shell, "bash") {
+        // bash rebuilds PS1 from ~/.bashrc, so prefix it after that runs.
+        rc, err := os.CreateTemp("", "gist-shell-*.bashrc")
+        if err != nil {
+            return 0, err
+        }
+        defer os.Remove(rc.Name())
+        if _, err := rc.WriteString(bashPromptRC); err != nil {
+            return 0, err
+        }
+        rc.Close()
+        args = append(args, "--rcfile", rc.Name(), "-i")
+    } else {
+        env = append(env, fmt.Sprintf("PS1=(gist:%s) %s", p.Name, os.Getenv("PS1")))
+    }
+    fmt.Printf("Entering a shell as %s <%s> (profile %s); exit to return.\n", gitName(p), p.Email, p.Name)
+    cmd := exec.Command(shell, args...)
+    cmd.Dir = repoDir
+    cmd.Env = env
+    cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+    err := cmd.Run()
+    fmt.Printf("Left profile %s shell.\n", p.Name)
+    var ee *exec.ExitError
+    if errors.As(err, &ee) {
+        return ee.ExitCode(), nil
+    }
+    return 0, err
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+)
+
+// Lifecycle events users can attach scripts to in the config's hooks
+// section. pre_set runs before a profile is applied and aborts it on
+// failure; the others run afterwards and only warn.
+const (
+    eventPreSet       = "pre_set"
+    eventOnSet        = "on_set"
+    eventOnUnset      = "on_unset"
+    eventOnVerifyFail = "on_verify_fail"
+)
+
+// runEvent runs the script configured for event, if any, through sh with
+// the profile's details in GIST_* environment variables.
+func runEvent(cfg Config, event string, p *Profile, repoRoot string) error {
+    script := cfg.Hooks[event]
+    if script == "" {
+        return nil
+    }
+    cmd := exec.Command("sh", "-c", expandHome(script))
+    cmd.Dir = repoDir
+    cmd.Env = append(os.Environ(),
+        "GIST_EVENT="+event,
+        "GIST_REPO="+repoRoot,
+    )
+    if p != nil {
+        cmd.Env = append(cmd.Env,
+            "GIST_PROFILE="+p.Name,
+            "GIST_PROFILE_USERNAME="+p.Username,
+            "GIST_PROFILE_EMAIL="+p.Email,
+            "GIST_PROFILE_SIGNINGKEY="+p.SigningKey,
+            "GIST_PROFILE_SSH_KEY="+profilePath(p, p.SSHKey),
+        )
+    }
+    cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+    if err := cmd.Run(); err != nil {
+        return fmt.Errorf("%s hook failed: %w", event, err)
+    }
+    return nil
+}
+
+// notifyEvent runs a post-event hook, reporting failures as warnings.
+func notifyEvent(cfg Config, event string, p *Profile, repoRoot string) {
+    if err := runEvent(cfg, event, p, repoRoot); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+    }
+}
+
+// unsetKeys are the local config keys gist may have written for a profile.
+var unsetKeys = []string{
+    "user.name", "user.email", "user.signingkey", "gpg.ssh.allowedSignersFile",
+    "core.sshCommand", "commit.template", "format.signOff", "http.proxy",
+    "http.sslCAInfo", "http.extraHeader", "gpg.format", "gpg.x509.program",
+    "commit.gpgsign", "tag.gpgsign",
+    "credential.https://git-codecommit.*.amazonaws.com.helper",
+    "credential.https://git-codecommit.*.amazonaws.com.useHttpPath",
+    "lfs.url", "remote.origin.partialclonefilter",
+}
+
+// commandUnset removes the identity gist applied to the current repository
+// so it falls back to inherited config.
+func commandUnset(cfg Config) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    // Remember who we were for the hook before the keys disappear.
+    p, _ := activeProfile(&cfg)
+    removed := 0
+    for _, key := range unsetKeys {
+        if _, err := runGit("config", "--local", "--unset-all", key); err == nil {
+            removed++
+        }
+    }
+    // lfs.<url>.access is keyed on the profile's LFS URL. remote.origin.promisor
+    // stays: a partial clone lacks objects it must be able to fetch later.
+    if out, err := runGit("config", "--local", "--name-only", "--get-regexp", `^lfs\..*\.access$`); err == nil {
+        for _, key := range strings.Fields(out) {
+            if _, err := runGit("config", "--local", "--unset-all", key); err == nil {
+                removed++
+            }
+        }
+    }
+    // Nothing gist wrote is left to remove on the next set.
+    if err := updateRepoRecord(repoRoot, func(rec *repoRecord) { rec.Keys = nil }); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot record %s in %s: %v\n", repoRoot, statePath(), err)
+    }
+    if removed == 0 {
+        fmt.Printf("No local identity set for repository %s\n", repoRoot)
+        return nil
+    }
+    fmt.Printf("✔️  Removed local identity from repository %s\n", repoRoot)
+    broadcastChange(repoRoot, "unset", "")
+    notifyEvent(cfg, eventOnUnset, p, repoRoot)
+    return nil
+}
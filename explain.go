@@ -0,0 +1,327 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// `gist explain` traces how a repository's identity came to be, in the
+// order it is decided: the values git reads from each config file, the
+// include directives that pulled files in, the environment overriding both,
+// and gist's own choice – the pin, every rule with why it matched or not,
+// the hosts map and default_profile – ending with whether git's identity
+// is the one gist selected.
+
+// explainKeys are the config keys the trace follows through git's files.
+var explainKeys = []string{"user.name", "user.email", "user.signingkey", "gpg.format", "core.sshCommand"}
+
+// explainStep is one line of the trace. Layer is config, include, env, pin,
+// rule, host or default.
+type explainStep struct {
+    Layer   string `json:"layer"`
+    Key     string `json:"key,omitempty"`
+    Scope   string `json:"scope,omitempty"`
+    File    string `json:"file,omitempty"`
+    Pattern string `json:"pattern,omitempty"`
+    Value   string `json:"value,omitempty"`
+    // Applies is set for the values that take effect, the includes git
+    // followed and the rules that match.
+    Applies bool   `json:"applies"`
+    Note    string `json:"note,omitempty"`
+}
+
+// explanation is the whole trace.
+type explanation struct {
+    Repository string        `json:"repository"`
+    Branch     string        `json:"branch,omitempty"`
+    Steps      []explainStep `json:"steps"`
+    // Selected is the profile gist selects, Effective the one git's
+    // identity belongs to.
+    Selected  string `json:"selected"`
+    Effective string `json:"effective"`
+    Name      string `json:"name"`
+    Email     string `json:"email"`
+    OK        bool   `json:"ok"`
+}
+
+// configOrigin is a value git config printed with --show-origin.
+type configOrigin struct {
+    Scope, File, Value string
+}
+
+// configOrigins runs git config with --show-origin (and --show-scope when
+// git has it) and --null, returning the values in the order git read them.
+func configOrigins(args ...string) []configOrigin {
+    flags := append(configOriginFlags(), "--null")
+    out, err := runGit(append(append([]string{"config"}, flags...), args...)...)
+    if err != nil || out == "" {
+        return nil
+    }
+    fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+    per := len(configOriginFlags()) + 1
+    var found []configOrigin
+    for i := 0; i+per <= len(fields); i += per {
+        o := configOrigin{Value: fields[i+per-1]}
+        origin := fields[i+per-2]
+        if per == 3 {
+            o.Scope = fields[i]
+        } else {
+            o.Scope = originScope(origin)
+        }
+        o.File = strings.Trim(strings.TrimPrefix(origin, "file:"), "\"")
+        found = append(found, o)
+    }
+    return found
+}
+
+// explainConfig traces the explained keys through git's config files.
+func explainConfig() []explainStep {
+    var steps []explainStep
+    for _, key := range explainKeys {
+        values := configOrigins("--get-all", key)
+        for i, o := range values {
+            s := explainStep{Layer: "config", Key: key, Scope: o.Scope, File: o.File, Value: o.Value}
+            if i == len(values)-1 {
+                s.Applies = true
+            } else {
+                s.Note = "overridden by a later file"
+            }
+            steps = append(steps, s)
+        }
+    }
+    return steps
+}
+
+// explainIncludes lists the include directives and whether git followed
+// them, which it did when it read anything from the included file.
+func explainIncludes() []explainStep {
+    read := map[string]bool{}
+    for _, o := range configOrigins("--list") {
+        read[filepath.Clean(o.File)] = true
+    }
+    var steps []explainStep
+    for _, o := range configOrigins("--get-regexp", `^include(if\..*)?\.path$`) {
+        key, path, _ := strings.Cut(o.Value, "\n")
+        condition := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(key, "includeif."), "include."), "path")
+        condition = strings.TrimSuffix(condition, ".")
+        target := expandHome(path)
+        if !filepath.IsAbs(target) {
+            target = filepath.Join(filepath.Dir(o.File), target)
+        }
+        s := explainStep{Layer: "include", Scope: o.Scope, File: o.File, Pattern: condition, Value: target}
+        s.Applies = read[filepath.Clean(target)]
+        switch {
+        case s.Applies:
+        case condition == "":
+            s.Note = "file missing or empty"
+        default:
+            s.Note = "condition not met"
+        }
+        steps = append(steps, s)
+    }
+    return steps
+}
+
+// explainRules traces gist's choice: the pin, each rule in resolution
+// order, then the hosts map and default_profile. It returns the steps and
+// the selected profile.
+func explainRules(cfg Config, configPath, root string) ([]explainStep, string) {
+    var steps []explainStep
+    pin := pinnedProfile(root)
+    pinStep := explainStep{Layer: "pin", File: repoRecordPath(root), Value: pin, Applies: pin != ""}
+    if pin == "" {
+        pinStep.Note = "not pinned"
+    } else {
+        pinStep.Note = "rules are ignored"
+    }
+    steps = append(steps, pinStep)
+    remotes := listRemotes()
+    branch := currentBranch()
+    selected := pin
+    for _, i := range rankedRules(cfg) {
+        r := cfg.Rules[i]
+        s := explainStep{Layer: "rule", Key: fmt.Sprintf("rule %d", i+1), File: configPath, Pattern: r.describe(), Value: r.Profile}
+        if r.Source != "" {
+            s.File = strings.TrimPrefix(r.Source, "include ")
+        }
+        var reasons []string
+        if !r.matchesDir(root) {
+            reasons = append(reasons, "not under "+r.Dir)
+        }
+        if r.Branch != "" && !r.matchesBranch(branch) {
+            reasons = append(reasons, fmt.Sprintf("branch %q doesn't match", branch))
+        }
+        if r.URL != "" {
+            matched := ""
+            for _, rm := range r.candidateRemotes(remotes) {
+                if r.matchesURL(rm.URL) {
+                    matched = rm.Name + " " + rm.URL
+                    break
+                }
+            }
+            if matched == "" {
+                reasons = append(reasons, "no remote matches")
+            } else {
+                s.Note = "remote " + matched
+            }
+        }
+        switch {
+        case len(reasons) > 0:
+            s.Note = strings.Join(reasons, "; ")
+        case selected == "":
+            s.Applies = true
+            selected = r.Profile
+        case pin != "":
+            s.Applies = true
+            s.Note = strings.TrimPrefix(s.Note+"; ignored, the repository is pinned", "; ")
+        default:
+            s.Applies = true
+            s.Note = strings.TrimPrefix(s.Note+"; a higher ranked rule won", "; ")
+        }
+        steps = append(steps, s)
+    }
+    if name, host := hostProfile(cfg, remotes); host != "" {
+        s := explainStep{Layer: "host", File: configPath, Pattern: host, Value: name, Applies: true}
+        if selected == "" {
+            selected = name
+        } else {
+            s.Note = "a pin or rule came first"
+        }
+        steps = append(steps, s)
+    }
+    if cfg.DefaultProfile != "" {
+        s := explainStep{Layer: "default", File: configPath, Value: cfg.DefaultProfile}
+        if selected == "" {
+            selected, s.Applies = cfg.DefaultProfile, true
+        } else {
+            s.Note = "not needed"
+        }
+        steps = append(steps, s)
+    }
+    return steps, selected
+}
+
+// explain builds the trace for the current repository.
+func explain(cfg Config, configPath string) (explanation, error) {
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        return explanation{}, errors.New(tr("repo.not_inside"))
+    }
+    ex := explanation{Repository: root, Branch: currentBranch()}
+    ex.Steps = append(explainConfig(), explainIncludes()...)
+    for _, w := range identityOverrideWarnings() {
+        ex.Steps = append(ex.Steps, explainStep{Layer: "env", Note: w, Applies: true})
+    }
+    rules, selected := explainRules(cfg, configPath, root)
+    ex.Steps = append(ex.Steps, rules...)
+    ex.Selected = selected
+    id := currentIdentity(&cfg)
+    ex.Name, ex.Email = id.Name.Value, id.Email.Value
+    if id.Profile != nil {
+        ex.Effective = id.Profile.Name
+    }
+    ex.OK = ex.Selected != "" && ex.Effective == ex.Selected
+    return ex, nil
+}
+
+// printExplanation writes the trace as text, one section per stage.
+func printExplanation(ex explanation) {
+    fmt.Printf("repository: %s", ex.Repository)
+    if ex.Branch != "" {
+        fmt.Printf(" (branch %s)", ex.Branch)
+    }
+    fmt.Println()
+    headings := map[string]string{
+        "config":  "git config, in the order git reads it:",
+        "include": "includes:",
+        "env":     "environment:",
+        "pin":     "gist:",
+    }
+    mark := func(s explainStep) string {
+        if s.Applies {
+            return "✔"
+        }
+        return "·"
+    }
+    note := func(s explainStep) string {
+        if s.Note == "" {
+            return ""
+        }
+        return " (" + s.Note + ")"
+    }
+    last := ""
+    for _, s := range ex.Steps {
+        if h, ok := headings[s.Layer]; ok && s.Layer != last {
+            fmt.Println(h)
+        }
+        last = s.Layer
+        switch s.Layer {
+        case "config":
+            fmt.Printf("  %s %-16s %-8s %s = %s%s\n", mark(s), s.Key, s.Scope, s.File, s.Value, note(s))
+        case "include":
+            condition := "always"
+            if s.Pattern != "" {
+                condition = s.Pattern
+            }
+            fmt.Printf("  %s %s %s: %s → %s%s\n", mark(s), s.Scope, s.File, condition, s.Value, note(s))
+        case "env":
+            fmt.Printf("  ⚠ %s\n", s.Note)
+        case "pin":
+            if s.Applies {
+                fmt.Printf("  %s pinned to %s in %s%s\n", mark(s), s.Value, s.File, note(s))
+            } else {
+                fmt.Printf("  %s %s (%s)\n", mark(s), s.Note, s.File)
+            }
+        case "rule":
+            fmt.Printf("  %s %s (%s) → %s%s\n", mark(s), s.Key, s.Pattern, s.Value, note(s))
+        case "host":
+            fmt.Printf("  %s hosts: %s → %s%s\n", mark(s), s.Pattern, s.Value, note(s))
+        case "default":
+            fmt.Printf("  %s default_profile → %s%s\n", mark(s), s.Value, note(s))
+        }
+    }
+    selected := ex.Selected
+    if selected == "" {
+        selected = "(none)"
+    }
+    effective := ex.Effective
+    if effective == "" {
+        effective = "no profile"
+    }
+    fmt.Printf("selected profile: %s\n", selected)
+    if ex.OK {
+        fmt.Printf("✔ git uses %s <%s>, profile %s\n", ex.Name, ex.Email, effective)
+    } else {
+        fmt.Printf("✘ git uses %s <%s>, %s\n", ex.Name, ex.Email, effective)
+    }
+}
+
+// commandExplain explains the identity of the repository at dir (the
+// current one when empty), as text or JSON.
+func commandExplain(cfg Config, configPath, dir string, asJSON bool) error {
+    if dir != "" {
+        abs, err := filepath.Abs(expandHome(dir))
+        if err != nil {
+            return err
+        }
+        if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+            return fmt.Errorf("cannot explain %s: not a directory", dir)
+        }
+        repoDir = abs
+    }
+    ex, err := explain(cfg, configPath)
+    if err != nil {
+        return err
+    }
+    if asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        return enc.Encode(ex)
+    }
+    printExplanation(ex)
+    return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// writeInventoryCSV writes one row per repository.
+func writeInventoryCSV(w io.Writer, repos []scanResult) error {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"path", "remotes", "profile", "expected_profile", "email",
+        "signing_enabled", "signing_format", "signing_key", "problem"})
+    for _, r := range repos {
+        var remotes []string
+        for _, rm := range r.Remotes {
+            remotes = append(remotes, rm.Name+"="+rm.URL)
+        }
+        cw.Write([]string{r.Root, strings.Join(remotes, " "), r.Current, r.Want, r.Email,
+            strconv.FormatBool(r.Signing.Enabled), r.Signing.Format, r.Signing.Key, r.Problem})
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+// commandExportRepos writes an inventory of the repositories under roots –
+// path, remotes, active and expected profile, signing – as JSON or CSV, for
+// compliance reporting.
+func commandExportRepos(cfg Config, roots []string, format, output string, jobs int) error {
+    if format != "json" && format != "csv" {
+        return fmt.Errorf("unknown format %q (want json or csv)", format)
+    }
+    roots, err := absRoots(roots)
+    if err != nil {
+        return err
+    }
+    var repos []scanResult
+    if err := scanParallel(cfg, roots, jobs, nil, func(dir string, res scanResult) {
+        repos = append(repos, res)
+    }); err != nil {
+        return err
+    }
+    sort.Slice(repos, func(i, j int) bool { return repos[i].Root < repos[j].Root })
+    w := io.Writer(os.Stdout)
+    if output != "" && output != "-" {
+        f, err := os.Create(output)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        w = f
+    }
+    if format == "csv" {
+        return writeInventoryCSV(w, repos)
+    }
+    if repos == nil {
+        repos = []scanResult{}
+    }
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(repos)
+}
+
+// commandExport runs `export --repos`.
+func commandExport(cfg Config, args []string) error {
+    usage := errors.New("usage: gist export --repos [--format json|csv] [-o <file>] [--jobs <n>] [dir...]")
+    repos, format, output, jobs := false, "json", "", 0
+    var roots []string
+    for i := 0; i < len(args); i++ {
+        switch args[i] {
+        case "--repos":
+            repos = true
+        case "--format", "-o", "--output", "--jobs", "-j":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s requires a value", args[i])
+            }
+            switch args[i] {
+            case "--format":
+                format = args[i+1]
+            case "--jobs", "-j":
+                jobs, _ = strconv.Atoi(args[i+1])
+            default:
+                output = args[i+1]
+            }
+            i++
+        default:
+            roots = append(roots, args[i])
+        }
+    }
+    if !repos {
+        return usage
+    }
+    return commandExportRepos(cfg, roots, format, output, jobs)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// identityOverrides returns git -c options that make git use the profile's
+// identity for a single invocation without touching the repository config.
+func identityOverrides(p *Profile) []string {
+    args := []string{"-c", "user.name=" + gitName(p), "-c", "user.email=" + p.Email}
+    if p.SigningKey != "" {
+        args = append(args, "-c", "user.signingkey="+p.SigningKey)
+    }
+    return args
+}
+
+// unpushedCommits returns the last n commits on HEAD, failing if any of them
+// is already reachable from a remote-tracking branch.
+func unpushedCommits(n int) ([]string, error) {
+    out, err := runGit("rev-list", "--max-count="+strconv.Itoa(n), "HEAD")
+    if err != nil {
+        return nil, errors.New("repository has no commits")
+    }
+    commits := strings.Fields(out)
+    if len(commits) < n {
+        return nil, fmt.Errorf("HEAD has only %d commits", len(commits))
+    }
+    out, _ = runGit("rev-list", "HEAD", "--not", "--remotes")
+    local := map[string]bool{}
+    for _, c := range strings.Fields(out) {
+        local[c] = true
+    }
+    for _, c := range commits {
+        if !local[c] {
+            return nil, fmt.Errorf("commit %s is already pushed; rewriting it would diverge from the remote", c[:7])
+        }
+    }
+    return commits, nil
+}
+
+// commandFixLastCommit re-authors the last n commits with the given profile,
+// or with the currently active identity when profileName is empty.
+func commandFixLastCommit(cfg Config, profileName string, n int) error {
+    inRepo, _ := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    if n < 1 {
+        return errors.New("commit count must be at least 1")
+    }
+    var overrides []string
+    if profileName != "" {
+        p := findProfile(&cfg, profileName)
+        if p == nil {
+            return fmt.Errorf("profile %s not found", profileName)
+        }
+        overrides = identityOverrides(p)
+    }
+    commits, err := unpushedCommits(n)
+    if err != nil {
+        return err
+    }
+    amend := "git commit --amend --no-edit --no-verify --allow-empty --reset-author"
+    var args []string
+    if n == 1 {
+        args = append(overrides, strings.Fields(amend)[1:]...)
+    } else {
+        base := commits[n-1] + "^"
+        if _, err := runGit("rev-parse", "--verify", "--quiet", base); err != nil {
+            base = "--root"
+        }
+        args = append(overrides, "rebase", "--exec", amend, base)
+    }
+    if out, err := runGit(args...); err != nil {
+        if n > 1 {
+            // Leave the branch as it was rather than mid-rebase.
+            runGit("rebase", "--abort")
+        }
+        return fmt.Errorf("failed to re-author commits: %s", out)
+    }
+    who, _ := runGit("log", "-1", "--format=%an <%ae>")
+    fmt.Printf("✔️  Re-authored %d commit(s) as %s\n", n, who)
+    return nil
+}
+
+// printRecentCommits lists the last n commits' author and committer,
+// flagging emails other than the profile's (or its also_emails), and suggests fix-last-commit
+// when the flagged commits are not pushed yet. Without a profile nothing is
+// flagged.
+func printRecentCommits(p *Profile, n int) {
+    fmt.Println(tr("info.commits", n))
+    out, err := runGit("log", "--max-count="+strconv.Itoa(n), "--format=%H%x00%an <%ae>%x00%ae%x00%cn <%ce>%x00%ce")
+    if err != nil || out == "" {
+        fmt.Println("  (no commits)")
+        return
+    }
+    local := map[string]bool{}
+    if unpushed, err := runGit("rev-list", "HEAD", "--not", "--remotes"); err == nil {
+        for _, c := range strings.Fields(unpushed) {
+            local[c] = true
+        }
+    }
+    // Only the commits above the first pushed one can be re-authored.
+    fixable, rewritable, pushedBad := 0, true, 0
+    for i, line := range strings.Split(out, "\n") {
+        f := strings.Split(line, "\x00")
+        if len(f) != 5 {
+            continue
+        }
+        rewritable = rewritable && local[f[0]]
+        bad := p != nil && (!ownsEmail(p, f[2]) || !ownsEmail(p, f[4]))
+        mark := "✔"
+        if bad {
+            mark = "✘"
+            if rewritable {
+                fixable = i + 1
+            } else {
+                pushedBad++
+            }
+        }
+        text := fmt.Sprintf("  %s %s author %s", mark, f[0][:7], f[1])
+        if f[3] != f[1] {
+            text += ", committer " + f[3]
+        }
+        if !local[f[0]] {
+            text += " (pushed)"
+        }
+        fmt.Println(text)
+    }
+    if fixable > 0 {
+        fmt.Printf("  → re-author the local ones with `gist fix-last-commit -n %d`\n", fixable)
+    }
+    if pushedBad > 0 {
+        fmt.Printf("  → %d flagged commit(s) are already pushed and can't be re-authored safely\n", pushedBad)
+    }
+}
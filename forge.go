@@ -0,0 +1,641 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "os/exec"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Forge kinds, detected from the host name or set in the forges map for
+// self-hosted instances.
+const (
+    forgeGitHub    = "github"
+    forgeGitLab    = "gitlab"
+    forgeGitea     = "gitea"
+    forgeBitbucket = "bitbucket"
+)
+
+// forgeEmail is an email address registered with a forge account.
+type forgeEmail struct {
+    Email    string
+    Verified bool
+    Primary  bool
+}
+
+// forge is a code hosting service gist can publish keys to and read the
+// account's emails from. Credentials come from the profile's env, then the
+// environment: GitHub and GitLab use the gh and glab CLIs, Gitea/Forgejo
+// GITEA_TOKEN, Bitbucket BITBUCKET_USERNAME and BITBUCKET_APP_PASSWORD.
+type forge interface {
+    Kind() string
+    // UploadKey adds a public signing key: an SSH key file when ssh is set,
+    // otherwise a GPG key id.
+    UploadKey(p *Profile, key, title string, ssh bool) error
+    Emails(p *Profile) ([]forgeEmail, error)
+    // Noreply returns the account's private commit email address.
+    Noreply(p *Profile) (string, error)
+    // Account returns the login of the account the credentials belong to.
+    Account(p *Profile) (string, error)
+    // FullName returns the account's display name, "" when it has none.
+    FullName(p *Profile) (string, error)
+}
+
+// forgeKind returns the kind of forge at host: the forges map wins, then the
+// host name decides. It returns "" for unknown hosts.
+func forgeKind(cfg Config, host string) string {
+    if kind, ok := cfg.Forges[host]; ok {
+        if kind == "forgejo" || kind == "codeberg" {
+            return forgeGitea
+        }
+        return kind
+    }
+    switch {
+    case strings.Contains(host, "github"):
+        return forgeGitHub
+    case strings.Contains(host, "gitlab"):
+        return forgeGitLab
+    case host == "codeberg.org" || strings.Contains(host, "gitea") || strings.Contains(host, "forgejo"):
+        return forgeGitea
+    case strings.Contains(host, "bitbucket"):
+        return forgeBitbucket
+    }
+    return ""
+}
+
+// forgeFor returns the forge at host, or nil when gist doesn't know it.
+func forgeFor(cfg Config, host string) forge {
+    switch forgeKind(cfg, host) {
+    case forgeGitHub:
+        return githubForge{host}
+    case forgeGitLab:
+        return gitlabForge{host}
+    case forgeGitea:
+        return giteaForge{host}
+    case forgeBitbucket:
+        return bitbucketForge{host}
+    }
+    return nil
+}
+
+// profileHosts returns the hosts the hosts map assigns to a profile.
+func profileHosts(cfg Config, p *Profile) []string {
+    var hosts []string
+    for _, host := range sortedKeys(cfg.Hosts) {
+        if cfg.Hosts[host] == p.Name {
+            hosts = append(hosts, host)
+        }
+    }
+    return hosts
+}
+
+// forgeCredential looks a credential up in the profile's env, then the
+// environment.
+func forgeCredential(p *Profile, name string) string {
+    if v, ok := p.Env[name]; ok {
+        return os.ExpandEnv(v)
+    }
+    return os.Getenv(name)
+}
+
+// forgeCLI runs gh or glab against host with the profile's env, returning
+// its standard output.
+func forgeCLI(p *Profile, hostVar, host string, stdin []byte, name string, args ...string) ([]byte, error) {
+    if err := requireOnline(name + " " + strings.Join(args, " ")); err != nil {
+        return nil, err
+    }
+    cmd := exec.Command(name, args...)
+    cmd.Env = append(os.Environ(), hostVar+"="+host)
+    for k, v := range p.Env {
+        cmd.Env = append(cmd.Env, k+"="+os.ExpandEnv(v))
+    }
+    if stdin != nil {
+        cmd.Stdin = bytes.NewReader(stdin)
+    }
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        if msg := strings.TrimSpace(stderr.String()); msg != "" {
+            return nil, fmt.Errorf("%s: %v: %s", name, err, msg)
+        }
+        return nil, fmt.Errorf("%s: %v", name, err)
+    }
+    return out, nil
+}
+
+// forgeRequest sends a JSON API request through forgeAPI, which caches GET
+// answers and waits out rate limits; body and out may be nil.
+func forgeRequest(method, url string, auth func(*http.Request), body, out any) error {
+    return forgeAPI.do(method, url, auth, body, out)
+}
+
+// armoredKey exports a GPG public key in ASCII armor.
+func armoredKey(key string) ([]byte, error) {
+    out, err := exec.Command(getGPGPath(), "--armor", "--export", key).Output()
+    if err != nil || len(out) == 0 {
+        return nil, fmt.Errorf("cannot export %s", key)
+    }
+    return out, nil
+}
+
+// githubForge talks to GitHub (or GitHub Enterprise) through gh.
+type githubForge struct{ host string }
+
+func (f githubForge) Kind() string { return forgeGitHub }
+
+func (f githubForge) UploadKey(p *Profile, key, title string, ssh bool) error {
+    if ssh {
+        _, err := forgeCLI(p, "GH_HOST", f.host, nil, "gh", "ssh-key", "add", expandHome(key), "--type", "signing", "--title", title)
+        return err
+    }
+    armored, err := armoredKey(key)
+    if err != nil {
+        return err
+    }
+    _, err = forgeCLI(p, "GH_HOST", f.host, armored, "gh", "gpg-key", "add", "--title", title)
+    return err
+}
+
+func (f githubForge) Emails(p *Profile) ([]forgeEmail, error) {
+    out, err := forgeCLIRead(p, "GH_HOST", f.host, "gh", "api", "user/emails")
+    if err != nil {
+        return nil, err
+    }
+    var emails []forgeEmail
+    return emails, json.Unmarshal(out, &emails)
+}
+
+func (f githubForge) Noreply(p *Profile) (string, error) {
+    out, err := forgeCLIRead(p, "GH_HOST", f.host, "gh", "api", "user")
+    if err != nil {
+        return "", err
+    }
+    var user struct {
+        ID    int64
+        Login string
+    }
+    if err := json.Unmarshal(out, &user); err != nil {
+        return "", err
+    }
+    domain := "users.noreply.github.com"
+    if f.host != "github.com" {
+        domain = "users.noreply." + f.host
+    }
+    return fmt.Sprintf("%d+%s@%s", user.ID, user.Login, domain), nil
+}
+
+func (f githubForge) Account(p *Profile) (string, error) {
+    out, err := forgeCLIRead(p, "GH_HOST", f.host, "gh", "api", "user", "--jq", ".login")
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSpace(string(out)), nil
+}
+
+func (f githubForge) FullName(p *Profile) (string, error) {
+    out, err := forgeCLIRead(p, "GH_HOST", f.host, "gh", "api", "user", "--jq", ".name // empty")
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimSpace(string(out)), nil
+}
+
+// gitlabForge talks to GitLab through glab.
+type gitlabForge struct{ host string }
+
+func (f gitlabForge) Kind() string { return forgeGitLab }
+
+func (f gitlabForge) UploadKey(p *Profile, key, title string, ssh bool) error {
+    if ssh {
+        _, err := forgeCLI(p, "GITLAB_HOST", f.host, nil, "glab", "ssh-key", "add", expandHome(key), "--title", title, "--usage-type", "signing")
+        return err
+    }
+    armored, err := armoredKey(key)
+    if err != nil {
+        return err
+    }
+    _, err = forgeCLI(p, "GITLAB_HOST", f.host, nil, "glab", "api", "--method", "POST", "user/gpg_keys", "-f", "key="+string(armored))
+    return err
+}
+
+// gitlabUser is the part of GitLab's /user response gist uses.
+type gitlabUser struct {
+    ID       int64  `json:"id"`
+    Username string `json:"username"`
+    Name     string `json:"name"`
+    Email    string `json:"email"`
+}
+
+func (f gitlabForge) user(p *Profile) (gitlabUser, error) {
+    var user gitlabUser
+    out, err := forgeCLIRead(p, "GITLAB_HOST", f.host, "glab", "api", "user")
+    if err != nil {
+        return user, err
+    }
+    return user, json.Unmarshal(out, &user)
+}
+
+func (f gitlabForge) Emails(p *Profile) ([]forgeEmail, error) {
+    user, err := f.user(p)
+    if err != nil {
+        return nil, err
+    }
+    // The primary email is always confirmed; user/emails lists the others.
+    emails := []forgeEmail{{Email: user.Email, Verified: true, Primary: true}}
+    out, err := forgeCLIRead(p, "GITLAB_HOST", f.host, "glab", "api", "user/emails")
+    if err != nil {
+        return nil, err
+    }
+    var secondary []struct {
+        Email       string  `json:"email"`
+        ConfirmedAt *string `json:"confirmed_at"`
+    }
+    if err := json.Unmarshal(out, &secondary); err != nil {
+        return nil, err
+    }
+    for _, e := range secondary {
+        emails = append(emails, forgeEmail{Email: e.Email, Verified: e.ConfirmedAt != nil})
+    }
+    return emails, nil
+}
+
+func (f gitlabForge) Noreply(p *Profile) (string, error) {
+    user, err := f.user(p)
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf("%d-%s@users.noreply.%s", user.ID, user.Username, f.host), nil
+}
+
+func (f gitlabForge) Account(p *Profile) (string, error) {
+    user, err := f.user(p)
+    return user.Username, err
+}
+
+func (f gitlabForge) FullName(p *Profile) (string, error) {
+    user, err := f.user(p)
+    return user.Name, err
+}
+
+// giteaForge talks to the REST API of Gitea and Forgejo (e.g. Codeberg).
+type giteaForge struct{ host string }
+
+func (f giteaForge) Kind() string { return forgeGitea }
+
+func (f giteaForge) request(p *Profile, method, path string, body, out any) error {
+    token := forgeCredential(p, "GITEA_TOKEN")
+    if token == "" {
+        return fmt.Errorf("set GITEA_TOKEN (in the profile's env or the environment) to use %s", f.host)
+    }
+    return forgeRequest(method, "https://"+f.host+"/api/v1"+path, func(req *http.Request) {
+        req.Header.Set("Authorization", "token "+token)
+    }, body, out)
+}
+
+func (f giteaForge) UploadKey(p *Profile, key, title string, ssh bool) error {
+    // Gitea verifies SSH commit signatures against the account's SSH keys.
+    if ssh {
+        pub, err := sshPublicKey(key)
+        if err != nil {
+            return err
+        }
+        return f.request(p, http.MethodPost, "/user/keys", map[string]string{"title": title, "key": pub}, nil)
+    }
+    armored, err := armoredKey(key)
+    if err != nil {
+        return err
+    }
+    return f.request(p, http.MethodPost, "/user/gpg_keys", map[string]string{"armored_public_key": string(armored)}, nil)
+}
+
+func (f giteaForge) Emails(p *Profile) ([]forgeEmail, error) {
+    var emails []forgeEmail
+    return emails, f.request(p, http.MethodGet, "/user/emails", nil, &emails)
+}
+
+func (f giteaForge) Noreply(p *Profile) (string, error) {
+    var user struct{ Login string }
+    if err := f.request(p, http.MethodGet, "/user", nil, &user); err != nil {
+        return "", err
+    }
+    return user.Login + "@noreply." + f.host, nil
+}
+
+func (f giteaForge) Account(p *Profile) (string, error) {
+    var user struct{ Login string }
+    if err := f.request(p, http.MethodGet, "/user", nil, &user); err != nil {
+        return "", err
+    }
+    return user.Login, nil
+}
+
+func (f giteaForge) FullName(p *Profile) (string, error) {
+    var user struct {
+        FullName string `json:"full_name"`
+    }
+    if err := f.request(p, http.MethodGet, "/user", nil, &user); err != nil {
+        return "", err
+    }
+    return user.FullName, nil
+}
+
+// bitbucketForge talks to the Bitbucket Cloud REST API.
+type bitbucketForge struct{ host string }
+
+func (f bitbucketForge) Kind() string { return forgeBitbucket }
+
+func (f bitbucketForge) request(p *Profile, method, path string, body, out any) error {
+    user, password := forgeCredential(p, "BITBUCKET_USERNAME"), forgeCredential(p, "BITBUCKET_APP_PASSWORD")
+    if user == "" || password == "" {
+        return errors.New("set BITBUCKET_USERNAME and BITBUCKET_APP_PASSWORD (in the profile's env or the environment) to use Bitbucket")
+    }
+    return forgeRequest(method, "https://api.bitbucket.org/2.0"+path, func(req *http.Request) {
+        req.SetBasicAuth(user, password)
+    }, body, out)
+}
+
+func (f bitbucketForge) UploadKey(p *Profile, key, title string, ssh bool) error {
+    user := forgeCredential(p, "BITBUCKET_USERNAME")
+    if ssh {
+        pub, err := sshPublicKey(key)
+        if err != nil {
+            return err
+        }
+        return f.request(p, http.MethodPost, "/users/"+user+"/ssh-keys", map[string]string{"label": title, "key": pub}, nil)
+    }
+    armored, err := armoredKey(key)
+    if err != nil {
+        return err
+    }
+    return f.request(p, http.MethodPost, "/users/"+user+"/gpg-keys", map[string]string{"key": string(armored)}, nil)
+}
+
+func (f bitbucketForge) Emails(p *Profile) ([]forgeEmail, error) {
+    var page struct {
+        Values []struct {
+            Email       string `json:"email"`
+            IsConfirmed bool   `json:"is_confirmed"`
+            IsPrimary   bool   `json:"is_primary"`
+        } `json:"values"`
+    }
+    if err := f.request(p, http.MethodGet, "/user/emails", nil, &page); err != nil {
+        return nil, err
+    }
+    var emails []forgeEmail
+    for _, e := range page.Values {
+        emails = append(emails, forgeEmail{Email: e.Email, Verified: e.IsConfirmed, Primary: e.IsPrimary})
+    }
+    return emails, nil
+}
+
+func (f bitbucketForge) Noreply(p *Profile) (string, error) {
+    return "", errors.New("Bitbucket has no noreply addresses")
+}
+
+func (f bitbucketForge) Account(p *Profile) (string, error) {
+    var user struct {
+        Username string `json:"username"`
+        Nickname string `json:"nickname"`
+    }
+    if err := f.request(p, http.MethodGet, "/user", nil, &user); err != nil {
+        return "", err
+    }
+    if user.Username == "" {
+        return user.Nickname, nil
+    }
+    return user.Username, nil
+}
+
+func (f bitbucketForge) FullName(p *Profile) (string, error) {
+    var user struct {
+        DisplayName string `json:"display_name"`
+    }
+    if err := f.request(p, http.MethodGet, "/user", nil, &user); err != nil {
+        return "", err
+    }
+    return user.DisplayName, nil
+}
+
+// commandForgeCheck checks, for every host the hosts map assigns to a
+// profile, that the profile's email is verified on the account there, and
+// shows the account's noreply address.
+func commandForgeCheck(cfg Config, profileName string) error {
+    bad := 0
+    checked := 0
+    for i := range cfg.Profiles {
+        p := &cfg.Profiles[i]
+        if profileName != "" && p.Name != profileName {
+            continue
+        }
+        for _, host := range profileHosts(cfg, p) {
+            checked++
+            f := forgeFor(cfg, host)
+            if f == nil {
+                fmt.Printf("  ? %s (%s): unknown forge; set its kind under forges:\n", p.Name, host)
+                continue
+            }
+            emails, err := f.Emails(p)
+            if err != nil {
+                bad++
+                fmt.Printf("  ✘ %s (%s): %v\n", p.Name, host, err)
+                continue
+            }
+            verified, registered := false, false
+            for _, e := range emails {
+                if strings.EqualFold(e.Email, p.Email) {
+                    registered, verified = true, e.Verified
+                }
+            }
+            switch {
+            case verified:
+                fmt.Printf("  ✔ %s (%s): %s is verified\n", p.Name, host, p.Email)
+            case registered:
+                bad++
+                fmt.Printf("  ✘ %s (%s): %s is not verified yet\n", p.Name, host, p.Email)
+            default:
+                noreply, _ := f.Noreply(p)
+                if strings.EqualFold(noreply, p.Email) {
+                    fmt.Printf("  ✔ %s (%s): %s is the account's noreply address\n", p.Name, host, p.Email)
+                    continue
+                }
+                bad++
+                fmt.Printf("  ✘ %s (%s): %s is not an email of this account\n", p.Name, host, p.Email)
+            }
+        }
+    }
+    if profileName != "" && findProfile(&cfg, profileName) == nil {
+        return fmt.Errorf("profile %s not found", profileName)
+    }
+    if checked == 0 {
+        return errors.New("no host in the hosts map is assigned to a profile")
+    }
+    if bad > 0 {
+        return fmt.Errorf("%d problem(s) found", bad)
+    }
+    return nil
+}
+
+// commandForgeNoreply prints the noreply address of the profile's account on
+// its forge and, with use, makes it the profile's email. It reports whether
+// the config changed.
+func commandForgeNoreply(cfg *Config, profileName string, use, force bool) (bool, error) {
+    p := findProfile(cfg, profileName)
+    if p == nil {
+        return false, fmt.Errorf("profile %s not found", profileName)
+    }
+    hosts := profileHosts(*cfg, p)
+    if len(hosts) == 0 {
+        return false, fmt.Errorf("no host in the hosts map is assigned to profile %s", p.Name)
+    }
+    f := forgeFor(*cfg, hosts[0])
+    if f == nil {
+        return false, fmt.Errorf("%s is an unknown forge; set its kind under forges:", hosts[0])
+    }
+    noreply, err := f.Noreply(p)
+    if err != nil {
+        return false, err
+    }
+    fmt.Println(noreply)
+    if !use || noreply == p.Email {
+        return false, nil
+    }
+    if err := checkUnlocked(p, force); err != nil {
+        return false, err
+    }
+    if _, ok := p.raw["email"]; ok {
+        return false, fmt.Errorf("the email of profile %s comes from %s; change it at the source", p.Name, p.raw["email"])
+    }
+    p.Email = noreply
+    fmt.Printf("Profile %s now uses %s.\n", p.Name, noreply)
+    return true, nil
+}
+
+// forgeHosts are the public instances `add --from` accepts by forge name.
+var forgeHosts = map[string]string{
+    "github":    "github.com",
+    "gitlab":    "gitlab.com",
+    "codeberg":  "codeberg.org",
+    "bitbucket": "bitbucket.org",
+}
+
+// forgeProfile starts a profile from the forge account the environment's
+// credentials belong to: from is a forge name or a host. The user picks
+// the email among the account's verified ones and its noreply address.
+func forgeProfile(cfg Config, from string) (Profile, string, error) {
+    host := from
+    if h, ok := forgeHosts[strings.ToLower(from)]; ok {
+        host = h
+    }
+    f := forgeFor(cfg, host)
+    if f == nil {
+        return Profile{}, "", fmt.Errorf("%s is an unknown forge; use github, gitlab, codeberg, bitbucket or a host set under forges:", from)
+    }
+    creds := &Profile{}
+    login, err := f.Account(creds)
+    if err != nil {
+        return Profile{}, "", err
+    }
+    name, err := f.FullName(creds)
+    if err != nil {
+        return Profile{}, "", err
+    }
+    emails, err := f.Emails(creds)
+    if err != nil {
+        return Profile{}, "", err
+    }
+    sort.SliceStable(emails, func(i, j int) bool { return emails[i].Primary && !emails[j].Primary })
+    var choices, labels []string
+    unverified := 0
+    for _, e := range emails {
+        if !e.Verified {
+            unverified++
+            continue
+        }
+        label := e.Email
+        if e.Primary {
+            label += " (primary)"
+        }
+        choices, labels = append(choices, e.Email), append(labels, label)
+    }
+    if noreply, err := f.Noreply(creds); err == nil {
+        choices, labels = append(choices, noreply), append(labels, noreply+" (noreply, keeps your address private)")
+    }
+    if len(choices) == 0 {
+        return Profile{}, "", fmt.Errorf("account %s on %s has no verified email", login, host)
+    }
+    fmt.Printf("Account %s on %s:\n", login, host)
+    for i, label := range labels {
+        fmt.Printf("  %d) %s\n", i+1, label)
+    }
+    if unverified > 0 {
+        fmt.Printf("  (%d unverified address(es) left out; verify them on %s to use them)\n", unverified, host)
+    }
+    fmt.Print("Choose an email [1]: ")
+    email := choices[0]
+    answer, _ := stdin.ReadString('\n')
+    if answer = strings.TrimSpace(answer); answer != "" {
+        n, err := strconv.Atoi(answer)
+        if err != nil || n < 1 || n > len(choices) {
+            return Profile{}, "", fmt.Errorf("no email %q; choose 1 to %d", answer, len(choices))
+        }
+        email = choices[n-1]
+    }
+    if name == "" {
+        name = login
+    }
+    return Profile{Name: login, Username: name, Email: email}, host, nil
+}
+
+// commandForge runs the `forge` subcommands.
+func commandForge(cfg *Config, args []string) (bool, error) {
+    usage := errors.New("usage: gist forge check [<profile>] | forge noreply [--use] [--force] <profile> | forge cache clear")
+    if len(args) == 0 {
+        return false, usage
+    }
+    switch args[0] {
+    case "check":
+        if len(args) > 2 {
+            return false, usage
+        }
+        name := ""
+        if len(args) == 2 {
+            name = args[1]
+        }
+        return false, commandForgeCheck(*cfg, name)
+    case "noreply":
+        use, force := false, false
+        var rest []string
+        for _, a := range args[1:] {
+            switch a {
+            case "--use":
+                use = true
+            case "--force":
+                force = true
+            default:
+                rest = append(rest, a)
+            }
+        }
+        if len(rest) != 1 {
+            return false, usage
+        }
+        return commandForgeNoreply(cfg, rest[0], use, force)
+    case "cache":
+        if len(args) != 2 || args[1] != "clear" {
+            return false, usage
+        }
+        removed, err := clearForgeCache()
+        if err != nil {
+            return false, err
+        }
+        fmt.Printf("Removed %d cached forge answer(s) from %s\n", removed, forgeCacheDir())
+        return false, nil
+    }
+    return false, usage
+}
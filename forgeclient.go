@@ -0,0 +1,344 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Every forge API call goes through forgeAPI, which keeps the answers to
+// reads on disk so that checking many profiles, or the same ones again,
+// doesn't spend the API quota: a cached answer is used as is for the cache
+// TTL, then revalidated with its ETag, which forges answer with a 304 that
+// costs no quota. Rate-limited requests are retried once the limit resets,
+// if that is soon enough, and server errors after a backoff.
+
+const (
+    // defaultForgeCacheTTL is how long a cached answer is used without
+    // asking the forge; GIST_FORGE_CACHE_TTL overrides it, 0 turning the
+    // cache off.
+    defaultForgeCacheTTL = 10 * time.Minute
+    // forgeCacheMaxAge is when unused cache entries are removed.
+    forgeCacheMaxAge = 7 * 24 * time.Hour
+    // forgeMaxWait bounds how long a request waits for a rate limit to
+    // reset before failing instead.
+    forgeMaxWait = time.Minute
+    // forgeRetries is how often a rate-limited or failed request is retried.
+    forgeRetries = 3
+)
+
+// forgeCacheDir returns the directory of the cached answers.
+func forgeCacheDir() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "forge-cache")
+}
+
+// forgeCacheTTL returns the cache TTL, from GIST_FORGE_CACHE_TTL if set.
+func forgeCacheTTL() time.Duration {
+    if v := os.Getenv("GIST_FORGE_CACHE_TTL"); v != "" {
+        if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+            return d
+        }
+    }
+    return defaultForgeCacheTTL
+}
+
+// forgeCacheEntry is a cached answer. The key it is stored under hashes the
+// request and its credentials, so accounts never see each other's answers.
+type forgeCacheEntry struct {
+    Request      string    `json:"request"`
+    ETag         string    `json:"etag,omitempty"`
+    LastModified string    `json:"last_modified,omitempty"`
+    Fetched      time.Time `json:"fetched"`
+    Body         string    `json:"body"`
+}
+
+// forgeCacheKey returns the file name of the entry for the parts.
+func forgeCacheKey(parts ...string) string {
+    sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+    return hex.EncodeToString(sum[:16]) + ".json"
+}
+
+func loadForgeCache(key string) (forgeCacheEntry, bool) {
+    var e forgeCacheEntry
+    data, err := os.ReadFile(filepath.Join(forgeCacheDir(), key))
+    if err != nil || json.Unmarshal(data, &e) != nil {
+        return e, false
+    }
+    return e, true
+}
+
+// storeForgeCache saves an entry, readable by the user only since answers
+// list the account's emails, and removes entries unused for
+// forgeCacheMaxAge.
+func storeForgeCache(key string, e forgeCacheEntry) {
+    data, err := json.Marshal(e)
+    if err != nil {
+        return
+    }
+    if err := writeFileAtomic(filepath.Join(forgeCacheDir(), key), data); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot cache the forge's answer: %v\n", err)
+        return
+    }
+    entries, _ := os.ReadDir(forgeCacheDir())
+    for _, entry := range entries {
+        if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > forgeCacheMaxAge {
+            os.Remove(filepath.Join(forgeCacheDir(), entry.Name()))
+        }
+    }
+}
+
+// clearForgeCache removes every cached answer.
+func clearForgeCache() (int, error) {
+    entries, err := os.ReadDir(forgeCacheDir())
+    if os.IsNotExist(err) {
+        return 0, nil
+    }
+    if err != nil {
+        return 0, err
+    }
+    removed := 0
+    for _, entry := range entries {
+        if err := os.Remove(filepath.Join(forgeCacheDir(), entry.Name())); err != nil {
+            return removed, err
+        }
+        removed++
+    }
+    return removed, nil
+}
+
+// forgeClient sends forge API requests.
+type forgeClient struct {
+    http *http.Client
+    mu   sync.Mutex
+    // exhausted holds, by host, when a used-up rate limit resets.
+    exhausted map[string]time.Time
+}
+
+var forgeAPI = &forgeClient{
+    http:      &http.Client{Timeout: 30 * time.Second},
+    exhausted: map[string]time.Time{},
+}
+
+// rateLimitReset returns when the rate limit a response reports resets:
+// Retry-After, then GitHub's and Gitea's X-RateLimit-Reset or GitLab's
+// RateLimit-Reset, both Unix times. ok is false when it names none.
+func rateLimitReset(resp *http.Response, now time.Time) (time.Time, bool) {
+    if v := resp.Header.Get("Retry-After"); v != "" {
+        if secs, err := strconv.Atoi(v); err == nil {
+            return now.Add(time.Duration(secs) * time.Second), true
+        }
+        if t, err := http.ParseTime(v); err == nil {
+            return t, true
+        }
+    }
+    for _, name := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+        if epoch, err := strconv.ParseInt(resp.Header.Get(name), 10, 64); err == nil {
+            return time.Unix(epoch, 0), true
+        }
+    }
+    return time.Time{}, false
+}
+
+// rateLimited reports whether a response refuses the request for the rate
+// limit: 429, or GitHub's 403 with no requests remaining.
+func rateLimited(resp *http.Response) bool {
+    if resp.StatusCode == http.StatusTooManyRequests {
+        return true
+    }
+    remaining := resp.Header.Get("X-RateLimit-Remaining")
+    if remaining == "" {
+        remaining = resp.Header.Get("RateLimit-Remaining")
+    }
+    return resp.StatusCode == http.StatusForbidden && remaining == "0"
+}
+
+// note remembers a used-up rate limit, so later requests to the host wait
+// for it instead of being refused.
+func (c *forgeClient) note(host string, resp *http.Response) {
+    remaining := resp.Header.Get("X-RateLimit-Remaining")
+    if remaining == "" {
+        remaining = resp.Header.Get("RateLimit-Remaining")
+    }
+    if remaining != "0" && !rateLimited(resp) {
+        return
+    }
+    if reset, ok := rateLimitReset(resp, time.Now()); ok {
+        c.mu.Lock()
+        c.exhausted[host] = reset
+        c.mu.Unlock()
+    }
+}
+
+// wait waits out the host's used-up rate limit, failing when it resets
+// later than forgeMaxWait.
+func (c *forgeClient) wait(host string) error {
+    c.mu.Lock()
+    reset := c.exhausted[host]
+    c.mu.Unlock()
+    d := time.Until(reset)
+    if d <= 0 {
+        return nil
+    }
+    if d > forgeMaxWait {
+        return fmt.Errorf("the API rate limit of %s is used up until %s", host, reset.Local().Format("15:04:05"))
+    }
+    fmt.Fprintf(os.Stderr, "gist: waiting %s for the API rate limit of %s\n", d.Round(time.Second), host)
+    time.Sleep(d)
+    return nil
+}
+
+// do sends a JSON API request; body and out may be nil. GET answers are
+// cached.
+func (c *forgeClient) do(method, url string, auth func(*http.Request), body, out any) error {
+    return c.send(method, url, auth, body, out, true)
+}
+
+// send is do with the GET cache optional: answers to a credential that
+// changes with each request would only pile up in it.
+func (c *forgeClient) send(method, url string, auth func(*http.Request), body, out any, cache bool) error {
+    var payload []byte
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return err
+        }
+        payload = data
+    }
+    newRequest := func() (*http.Request, error) {
+        req, err := http.NewRequest(method, url, bytes.NewReader(payload))
+        if err != nil {
+            return nil, err
+        }
+        req.Header.Set("Accept", "application/json")
+        if body != nil {
+            req.Header.Set("Content-Type", "application/json")
+        }
+        auth(req)
+        return req, nil
+    }
+    decode := func(data []byte) error {
+        if out == nil {
+            return nil
+        }
+        return json.Unmarshal(data, out)
+    }
+    req, err := newRequest()
+    if err != nil {
+        return err
+    }
+    key, cached, haveCache := "", forgeCacheEntry{}, false
+    ttl := forgeCacheTTL()
+    if offlineMode {
+        if method != http.MethodGet || !cache {
+            return requireOnline(method + " " + url)
+        }
+        data, err := offlineAnswer(forgeCacheKey(method, url, req.Header.Get("Authorization"), req.Header.Get("Private-Token")), method+" "+url)
+        if err != nil {
+            return err
+        }
+        return decode(data)
+    }
+    if method == http.MethodGet && cache && ttl > 0 {
+        key = forgeCacheKey(method, url, req.Header.Get("Authorization"), req.Header.Get("Private-Token"))
+        if cached, haveCache = loadForgeCache(key); haveCache && time.Since(cached.Fetched) < ttl {
+            return decode([]byte(cached.Body))
+        }
+    }
+    host := req.URL.Host
+    for attempt := 0; ; attempt++ {
+        if attempt > 0 {
+            if req, err = newRequest(); err != nil {
+                return err
+            }
+        }
+        if haveCache && cached.ETag != "" {
+            req.Header.Set("If-None-Match", cached.ETag)
+        } else if haveCache && cached.LastModified != "" {
+            req.Header.Set("If-Modified-Since", cached.LastModified)
+        }
+        if err := c.wait(host); err != nil {
+            return err
+        }
+        resp, err := c.http.Do(req)
+        if err != nil {
+            if attempt < forgeRetries {
+                time.Sleep(time.Duration(1<<attempt) * time.Second)
+                continue
+            }
+            return err
+        }
+        data, err := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            return err
+        }
+        c.note(host, resp)
+        switch {
+        case resp.StatusCode == http.StatusNotModified && haveCache:
+            cached.Fetched = time.Now()
+            storeForgeCache(key, cached)
+            return decode([]byte(cached.Body))
+        case resp.StatusCode/100 == 2:
+            if key != "" {
+                storeForgeCache(key, forgeCacheEntry{
+                    Request:      method + " " + url,
+                    ETag:         resp.Header.Get("ETag"),
+                    LastModified: resp.Header.Get("Last-Modified"),
+                    Fetched:      time.Now(),
+                    Body:         string(data),
+                })
+            }
+            return decode(data)
+        case rateLimited(resp) && attempt < forgeRetries:
+            // wait sleeps until the reset note recorded, or fails.
+            if _, ok := rateLimitReset(resp, time.Now()); !ok {
+                time.Sleep(time.Duration(1<<attempt) * time.Second)
+            }
+            continue
+        case resp.StatusCode/100 == 5 && attempt < forgeRetries:
+            time.Sleep(time.Duration(1<<attempt) * time.Second)
+            continue
+        case rateLimited(resp):
+            return fmt.Errorf("%s %s: %s (API rate limit)", method, url, resp.Status)
+        }
+        return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+    }
+}
+
+// forgeCLIRead runs a reading gh or glab api call like forgeCLI, caching
+// its output for the cache TTL under the command, host and profile env.
+// The CLIs keep the credentials, so the answers of two accounts only stay
+// apart when their profiles set different env.
+func forgeCLIRead(p *Profile, hostVar, host, name string, args ...string) ([]byte, error) {
+    parts := append([]string{name, host}, args...)
+    for _, k := range sortedKeys(p.Env) {
+        parts = append(parts, k+"="+os.ExpandEnv(p.Env[k]))
+    }
+    key := forgeCacheKey(parts...)
+    if offlineMode {
+        return offlineAnswer(key, name+" "+strings.Join(args, " "))
+    }
+    ttl := forgeCacheTTL()
+    if ttl <= 0 {
+        return forgeCLI(p, hostVar, host, nil, name, args...)
+    }
+    if e, ok := loadForgeCache(key); ok && time.Since(e.Fetched) < ttl {
+        return []byte(e.Body), nil
+    }
+    out, err := forgeCLI(p, hostVar, host, nil, name, args...)
+    if err != nil {
+        return nil, err
+    }
+    storeForgeCache(key, forgeCacheEntry{Request: name + " " + strings.Join(args, " "), Fetched: time.Now(), Body: string(out)})
+    return out, nil
+}
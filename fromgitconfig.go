@@ -0,0 +1,208 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// gitconfigEntry is one setting of a gitconfig file; keys are as git
+// lists them, section and name lower-cased.
+type gitconfigEntry struct {
+    Key   string
+    Value string
+}
+
+// readGitconfig lists the settings of a gitconfig file, its includes
+// followed, relative to the file.
+func readGitconfig(path string) ([]gitconfigEntry, error) {
+    path, err := filepath.Abs(expandHome(path))
+    if err != nil {
+        return nil, err
+    }
+    if _, err := os.Stat(path); err != nil {
+        return nil, err
+    }
+    cmd := exec.Command(getGitPath(), "config", "--file", path, "--includes", "--list", "--null")
+    cmd.Dir = filepath.Dir(path)
+    out, err := cmd.Output()
+    if err != nil {
+        return nil, fmt.Errorf("cannot read %s as a gitconfig file: %v", path, err)
+    }
+    var entries []gitconfigEntry
+    for _, item := range strings.Split(string(out), "\x00") {
+        if item == "" {
+            continue
+        }
+        // A key without a value ("[section] flag") is true.
+        key, value, ok := strings.Cut(item, "\n")
+        if !ok {
+            value = "true"
+        }
+        entries = append(entries, gitconfigEntry{Key: key, Value: value})
+    }
+    return entries, nil
+}
+
+// shellWords splits a command line the way sh would, minus expansions.
+func shellWords(s string) []string {
+    var words []string
+    var word strings.Builder
+    inWord := false
+    var quote rune
+    for i := 0; i < len(s); i++ {
+        c := rune(s[i])
+        switch {
+        case quote != 0 && c == quote:
+            quote = 0
+        case quote == '\'':
+            word.WriteRune(c)
+        case quote == '"' && c == '\\' && i+1 < len(s) && strings.ContainsRune(`"\$`+"`", rune(s[i+1])):
+            i++
+            word.WriteByte(s[i])
+        case quote != 0:
+            word.WriteRune(c)
+        case c == '\'' || c == '"':
+            quote, inWord = c, true
+        case c == '\\' && i+1 < len(s):
+            i++
+            word.WriteByte(s[i])
+            inWord = true
+        case c == ' ' || c == '\t':
+            if inWord {
+                words = append(words, word.String())
+                word.Reset()
+                inWord = false
+            }
+        default:
+            word.WriteRune(c)
+            inWord = true
+        }
+    }
+    if inWord {
+        words = append(words, word.String())
+    }
+    return words
+}
+
+// sshCommandKey returns the key an ssh command forces with -i.
+func sshCommandKey(command string) string {
+    words := shellWords(command)
+    for i, w := range words {
+        switch {
+        case w == "-i" && i+1 < len(words):
+            return words[i+1]
+        case strings.HasPrefix(w, "-i") && len(w) > 2:
+            return w[2:]
+        }
+    }
+    return ""
+}
+
+// gitconfigImport is what a gitconfig file maps to: the profile fields,
+// rules for the URLs it rewrites, and the settings nothing maps.
+type gitconfigImport struct {
+    Profile Profile
+    Rules   []Rule
+    Ignored []string
+}
+
+// gitconfigProfile maps a gitconfig file, e.g. one exported from a work
+// machine, to a profile: user.*, the signing setup, core.sshCommand's key,
+// the http and lfs settings gist manages, and url.<base>.insteadOf
+// rewrites, which become remote_protocol, ssh_host_alias and url rules.
+func gitconfigProfile(path string) (gitconfigImport, error) {
+    entries, err := readGitconfig(path)
+    if err != nil {
+        return gitconfigImport{}, err
+    }
+    var in gitconfigImport
+    p := &in.Profile
+    ignore := func(e gitconfigEntry) {
+        in.Ignored = append(in.Ignored, e.Key)
+    }
+    for _, e := range entries {
+        switch e.Key {
+        case "user.name":
+            p.Username = e.Value
+        case "user.email":
+            p.Email = e.Value
+        case "user.signingkey":
+            p.SigningKey = e.Value
+        case "gpg.format":
+            if p.SigningFormat != formatGitsign {
+                p.SigningFormat = e.Value
+            }
+        case "gpg.x509.program":
+            if strings.HasSuffix(strings.TrimSuffix(e.Value, ".exe"), "gitsign") {
+                p.SigningFormat = formatGitsign
+            } else {
+                ignore(e)
+            }
+        case "core.sshcommand":
+            if key := sshCommandKey(e.Value); key != "" {
+                p.SSHKey = key
+            } else {
+                ignore(e)
+            }
+        case "commit.template":
+            p.CommitTemplate = e.Value
+        case "format.signoff":
+            p.SignOff = e.Value == "true"
+        case "http.proxy":
+            p.HTTPProxy = e.Value
+        case "http.sslcainfo":
+            p.SSLCAInfo = e.Value
+        case "http.extraheader":
+            p.HTTPExtraHeader = e.Value
+        case "lfs.url":
+            p.LFSURL = e.Value
+        case "commit.gpgsign", "tag.gpgsign", "gpg.ssh.allowedsignersfile":
+            // Written by gist from the signing setup.
+        case "include.path":
+            // Followed by git config --includes.
+        default:
+            if !strings.HasPrefix(e.Key, "url.") || !strings.HasSuffix(e.Key, ".insteadof") {
+                ignore(e)
+                continue
+            }
+            base := strings.TrimSuffix(strings.TrimPrefix(e.Key, "url."), ".insteadof")
+            mapURLRewrite(&in, base, e.Value)
+        }
+    }
+    return in, nil
+}
+
+// mapURLRewrite maps url.<base>.insteadOf <prefix>: the base's protocol
+// becomes remote_protocol, an SSH host alias for the prefix's host
+// ssh_host_alias, and the prefix a url rule.
+func mapURLRewrite(in *gitconfigImport, base, prefix string) {
+    p := &in.Profile
+    _, baseHost, _, ok := remoteParts(base)
+    if !ok {
+        in.Ignored = append(in.Ignored, "url."+base+".insteadof")
+        return
+    }
+    if strings.Contains(base, "://") && !strings.HasPrefix(base, "ssh://") {
+        p.RemoteProtocol = "https"
+    } else {
+        p.RemoteProtocol = "ssh"
+    }
+    _, host, _, ok := remoteParts(prefix)
+    if !ok {
+        // A shorthand such as "gh:": nothing to match remotes by.
+        return
+    }
+    baseHost, _, _ = strings.Cut(baseHost, ":")
+    host, _, _ = strings.Cut(host, ":")
+    if p.RemoteProtocol == "ssh" && baseHost != host {
+        if suffix, ok := strings.CutPrefix(baseHost, host); ok {
+            p.SSHHostAlias = "%h" + suffix
+        } else {
+            p.SSHHostAlias = baseHost
+        }
+    }
+    in.Rules = append(in.Rules, Rule{URL: strings.TrimSuffix(prefix, "/")})
+}
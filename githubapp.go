@@ -0,0 +1,234 @@
+package main
+
+import (
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// A bot profile can authenticate as a GitHub App installation instead of
+// with a stored token: with github_app_id and github_app_key (the App's
+// private key) gist mints a short-lived installation token whenever git
+// needs one, and caches it until shortly before it expires.
+
+// appTokenMargin is how long before its expiry a cached token is replaced.
+const appTokenMargin = 5 * time.Minute
+
+// appToken is a cached installation token.
+type appToken struct {
+    Token     string    `json:"token"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+// usesGitHubApp reports whether the profile authenticates as a GitHub App.
+func usesGitHubApp(p *Profile) bool {
+    return p.Bot && p.GitHubAppID != ""
+}
+
+// appTokenCachePath returns the installation token cache next to the
+// config.
+func appTokenCachePath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "app-tokens.json")
+}
+
+// githubAPI returns the REST API root of a GitHub host.
+func githubAPI(host string) string {
+    if host == "" || host == "github.com" {
+        return "https://api.github.com"
+    }
+    return "https://" + host + "/api/v3"
+}
+
+// appPrivateKey reads the App's PEM private key (PKCS#1, as GitHub issues
+// them, or PKCS#8).
+func appPrivateKey(p *Profile) (*rsa.PrivateKey, error) {
+    data, err := os.ReadFile(profilePath(p, p.GitHubAppKey))
+    if err != nil {
+        return nil, err
+    }
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, fmt.Errorf("%s is not a PEM private key", p.GitHubAppKey)
+    }
+    if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+        return key, nil
+    }
+    key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", p.GitHubAppKey, err)
+    }
+    rsaKey, ok := key.(*rsa.PrivateKey)
+    if !ok {
+        return nil, fmt.Errorf("%s is not an RSA key", p.GitHubAppKey)
+    }
+    return rsaKey, nil
+}
+
+// appJWT returns the RS256 JSON Web Token the App authenticates with. It is
+// backdated a minute against clock drift and valid for nine, under
+// GitHub's ten minute limit.
+func appJWT(appID string, key *rsa.PrivateKey, now time.Time) (string, error) {
+    enc := base64.RawURLEncoding
+    header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+    claims, err := json.Marshal(map[string]any{
+        "iat": now.Add(-time.Minute).Unix(),
+        "exp": now.Add(9 * time.Minute).Unix(),
+        "iss": appID,
+    })
+    if err != nil {
+        return "", err
+    }
+    unsigned := header + "." + enc.EncodeToString(claims)
+    digest := sha256.Sum256([]byte(unsigned))
+    sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+    if err != nil {
+        return "", err
+    }
+    return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// appInstallation returns the installation to mint a token for: the
+// configured one, the one on the repository when repo ("owner/name") is
+// known, or the App's only installation. The JWT is new each time, so the
+// answers bypass the forge cache.
+func appInstallation(p *Profile, api, jwt, repo string) (string, error) {
+    if p.GitHubAppInstallation != "" {
+        return p.GitHubAppInstallation, nil
+    }
+    auth := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+jwt) }
+    var inst struct{ ID int64 }
+    if repo != "" {
+        if err := forgeAPI.send("GET", api+"/repos/"+repo+"/installation", auth, nil, &inst, false); err == nil {
+            return strconv.FormatInt(inst.ID, 10), nil
+        }
+    }
+    var all []struct{ ID int64 }
+    if err := forgeAPI.send("GET", api+"/app/installations", auth, nil, &all, false); err != nil {
+        return "", err
+    }
+    if len(all) != 1 {
+        return "", fmt.Errorf("the App has %d installations; set github_app_installation", len(all))
+    }
+    return strconv.FormatInt(all[0].ID, 10), nil
+}
+
+// appTokenKey returns the cache key of the profile's tokens for repo on
+// host: by the configured installation, else by the repository the
+// installation is looked up for, so a cached token is found without asking
+// GitHub. The installations of one App on different accounts have tokens of
+// their own, and a repository belongs to one of them.
+func appTokenKey(p *Profile, host, repo string) string {
+    if p.GitHubAppInstallation != "" {
+        return p.GitHubAppID + "/" + p.GitHubAppInstallation + "@" + host
+    }
+    return p.GitHubAppID + "@" + host + "/" + repo
+}
+
+// githubAppToken returns an installation token for the profile's App on
+// host, from the cache while it stays valid for appTokenMargin, else newly
+// minted. repo ("owner/name", may be empty) helps find the installation.
+func githubAppToken(p *Profile, host, repo string) (string, error) {
+    if host == "" {
+        host = "github.com"
+    }
+    now := time.Now()
+    cacheKey := appTokenKey(p, host, repo)
+    cache := map[string]appToken{}
+    if data, err := os.ReadFile(appTokenCachePath()); err == nil {
+        json.Unmarshal(data, &cache)
+    }
+    if t, ok := cache[cacheKey]; ok && t.ExpiresAt.After(now.Add(appTokenMargin)) {
+        return t.Token, nil
+    }
+    if err := requireOnline("a new GitHub App installation token"); err != nil {
+        return "", err
+    }
+    key, err := appPrivateKey(p)
+    if err != nil {
+        return "", err
+    }
+    jwt, err := appJWT(p.GitHubAppID, key, now)
+    if err != nil {
+        return "", err
+    }
+    api := githubAPI(host)
+    inst, err := appInstallation(p, api, jwt, repo)
+    if err != nil {
+        return "", err
+    }
+    var minted appToken
+    auth := func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+jwt) }
+    if err := forgeRequest("POST", api+"/app/installations/"+inst+"/access_tokens", auth, nil, &minted); err != nil {
+        return "", err
+    }
+    if minted.Token == "" {
+        return "", errors.New("GitHub returned no installation token")
+    }
+    cache[cacheKey] = minted
+    for k, t := range cache {
+        if !t.ExpiresAt.After(now) {
+            delete(cache, k)
+        }
+    }
+    // The cache holds live tokens; only the user may read it.
+    if data, err := json.MarshalIndent(cache, "", "  "); err == nil {
+        if err := os.WriteFile(appTokenCachePath(), data, 0o600); err != nil {
+            fmt.Fprintf(os.Stderr, "warning: cannot cache the installation token: %v\n", err)
+        }
+    }
+    return minted.Token, nil
+}
+
+// repoGitHubRemote returns the host and "owner/name" of the current
+// repository's first GitHub remote, or "" outside one.
+func repoGitHubRemote(cfg Config) (host, repo string) {
+    for _, rm := range listRemotes() {
+        h := remoteHost(rm.URL)
+        if forgeKind(cfg, h) != forgeGitHub {
+            continue
+        }
+        if _, _, path, ok := remoteParts(rm.URL); ok {
+            repo = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+        }
+        return h, repo
+    }
+    return "", ""
+}
+
+// githubAppProblems checks a profile's GitHub App settings for list --check.
+func githubAppProblems(p *Profile) []error {
+    if p.GitHubAppID == "" && p.GitHubAppKey == "" && p.GitHubAppInstallation == "" {
+        return nil
+    }
+    var problems []error
+    if !p.Bot {
+        problems = append(problems, errors.New("github_app_* is only used by bot profiles"))
+    }
+    if _, err := strconv.ParseInt(p.GitHubAppID, 10, 64); err != nil {
+        problems = append(problems, fmt.Errorf("github_app_id %q must be the App's numeric id", p.GitHubAppID))
+    }
+    if p.GitHubAppInstallation != "" {
+        if _, err := strconv.ParseInt(p.GitHubAppInstallation, 10, 64); err != nil {
+            problems = append(problems, fmt.Errorf("github_app_installation %q must be numeric", p.GitHubAppInstallation))
+        }
+    }
+    if p.GitHubAppKey == "" {
+        problems = append(problems, errors.New("github_app_id needs github_app_key"))
+    } else if _, err := appPrivateKey(p); err != nil {
+        problems = append(problems, fmt.Errorf("github_app_key: %w", err))
+    }
+    return problems
+}
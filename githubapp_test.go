@@ -0,0 +1,56 @@
+package main
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// TestGitHubAppTokenCached checks that a cached token is returned before
+// any request: offline, and with no private key to sign a JWT with.
+func TestGitHubAppTokenCached(t *testing.T) {
+    dir := t.TempDir()
+    t.Setenv("GIST_CONFIG_PATH", filepath.Join(dir, "config.yaml"))
+    defer func(v bool) { offlineMode = v }(offlineMode)
+    offlineMode = true
+
+    p := &Profile{Name: "bot", Bot: true, GitHubAppID: "123", GitHubAppKey: filepath.Join(dir, "missing.pem")}
+    valid := time.Now().Add(time.Hour)
+    cache := map[string]appToken{
+        "123@github.com/acme/widgets":  {Token: "widgets-token", ExpiresAt: valid},
+        "123@github.com/other/widgets": {Token: "other-token", ExpiresAt: valid},
+        "123/42@ghe.corp.com":          {Token: "installation-token", ExpiresAt: valid},
+        "123@github.com/acme/stale":    {Token: "stale-token", ExpiresAt: time.Now().Add(time.Minute)},
+    }
+    data, err := json.Marshal(cache)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := os.WriteFile(appTokenCachePath(), data, 0o600); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, tc := range []struct {
+        name, install, host, repo, want string
+    }{
+        {"repository", "", "", "acme/widgets", "widgets-token"},
+        {"other account", "", "github.com", "other/widgets", "other-token"},
+        {"configured installation", "42", "ghe.corp.com", "acme/widgets", "installation-token"},
+        {"about to expire", "", "github.com", "acme/stale", ""},
+        {"not cached", "", "github.com", "acme/new", ""},
+    } {
+        p.GitHubAppInstallation = tc.install
+        got, err := githubAppToken(p, tc.host, tc.repo)
+        if tc.want == "" {
+            if err == nil {
+                t.Errorf("%s: got %q offline", tc.name, got)
+            }
+            continue
+        }
+        if err != nil || got != tc.want {
+            t.Errorf("%s: got %q, %v; want %q", tc.name, got, err, tc.want)
+        }
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "fmt"
+    "os/exec"
+    "strings"
+)
+
+// Signing formats a profile may set with signing_format. gitsign signs with
+// short-lived sigstore certificates (gpg.format x509) instead of a key.
+const (
+    formatOpenPGP = "openpgp"
+    formatSSH     = "ssh"
+    formatX509    = "x509"
+    formatGitsign = "gitsign"
+)
+
+// gitsignKeys are the settings a gitsign profile writes beyond the identity.
+var gitsignKeys = []string{"gpg.format", "gpg.x509.program", "commit.gpgsign", "tag.gpgsign"}
+
+// checkSigningFormat validates a profile's signing_format.
+func checkSigningFormat(p Profile) error {
+    switch p.SigningFormat {
+    case "", formatOpenPGP, formatSSH, formatX509:
+        return nil
+    case formatGitsign:
+        if _, err := exec.LookPath("gitsign"); err != nil {
+            return fmt.Errorf("signing_format gitsign needs the gitsign program in PATH")
+        }
+        return nil
+    }
+    return fmt.Errorf("unknown signing_format %q (want openpgp, ssh, x509 or gitsign)", p.SigningFormat)
+}
+
+// signingFormatSettings returns the gpg settings for a profile's
+// signing_format; none when the profile leaves the format to git.
+func signingFormatSettings(p *Profile) []setting {
+    switch p.SigningFormat {
+    case "":
+        return nil
+    case formatGitsign:
+        // gitsign has no key to choose, so it signs every commit and tag.
+        return []setting{
+            {"gpg.format", formatX509},
+            {"gpg.x509.program", "gitsign"},
+            {"commit.gpgsign", "true"},
+            {"tag.gpgsign", "true"},
+        }
+    }
+    return []setting{{"gpg.format", p.SigningFormat}}
+}
+
+// usesGitsign reports whether the current repository signs with gitsign.
+func usesGitsign() bool {
+    out, err := runGit("config", "--get", "gpg.x509.program")
+    return err == nil && strings.HasSuffix(strings.TrimSuffix(out, ".exe"), "gitsign")
+}
+
+// staleGitsign returns the local gitsign settings a previous profile wrote
+// and p doesn't, so a profile without it doesn't keep signing through
+// sigstore.
+func staleGitsign(p *Profile) []setting {
+    if p.SigningFormat == formatGitsign {
+        return nil
+    }
+    out, err := runGit("config", "--local", "--get", "gpg.x509.program")
+    if err != nil || !strings.HasSuffix(strings.TrimSuffix(out, ".exe"), "gitsign") {
+        return nil
+    }
+    var stale []setting
+    for _, key := range gitsignKeys {
+        if key == "gpg.format" && p.SigningFormat != "" {
+            continue
+        }
+        if value, err := runGit("config", "--local", "--get", key); err == nil {
+            stale = append(stale, setting{key, value})
+        }
+    }
+    return stale
+}
+
+// diagnoseGitsign reports gitsign profiles and repositories that cannot
+// sign because gitsign is not installed.
+func diagnoseGitsign(cfg Config) []diagnosis {
+    if _, err := exec.LookPath("gitsign"); err == nil {
+        return nil
+    }
+    var found []diagnosis
+    for _, p := range cfg.Profiles {
+        if p.SigningFormat == formatGitsign {
+            found = append(found, diagnosis{Problem: fmt.Sprintf("profile %s signs with gitsign, which is not in PATH (install it from sigstore)", p.Name)})
+        }
+    }
+    if inRepo, root := isGitRepo(); inRepo && usesGitsign() {
+        found = append(found, diagnosis{Problem: fmt.Sprintf("%s signs with gitsign, which is not in PATH", root)})
+    }
+    return found
+}
+
+// gitsignProblems checks a commit's signature status (%G?) and signer (%GS)
+// for a gitsign profile: the sigstore certificate must verify and be issued
+// to the profile's email.
+func gitsignProblems(p *Profile, status, signer string) []string {
+    switch status {
+    case "N":
+        return []string{"not signed (profile signs with gitsign)"}
+    case "G", "U":
+        // U is normal for sigstore: the certificate has expired by the time
+        // it is checked, its transparency log entry vouches for it.
+    default:
+        return []string{"gitsign signature does not verify (status " + status + ")"}
+    }
+    if !strings.Contains(strings.ToLower(signer), strings.ToLower(p.Email)) {
+        return []string{"signed by certificate for " + signer}
+    }
+    return nil
+}
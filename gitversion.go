@@ -0,0 +1,153 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// gitFeature is a git feature gist relies on and the release that added it.
+type gitFeature struct {
+    Major, Minor int
+    What         string
+}
+
+// The features gist checks the installed git for. Those with a fallback
+// degrade quietly; the others fail with requireGit's error rather than
+// with whatever older git makes of the setting.
+var (
+    gitOnBranch    = gitFeature{2, 23, `includeIf "onbranch:"`}
+    gitShowScope   = gitFeature{2, 26, "git config --show-scope"}
+    gitMaintenance = gitFeature{2, 29, "git maintenance (maintenance.* settings)"}
+    gitPathFormat  = gitFeature{2, 31, "git rev-parse --path-format"}
+    gitSSHSigning  = gitFeature{2, 34, "SSH signing (gpg.format=ssh)"}
+    gitHasConfig   = gitFeature{2, 36, `includeIf "hasconfig:remote.*.url:"`}
+)
+
+// gitFeatures lists them for selftest.
+var gitFeatures = []gitFeature{gitOnBranch, gitShowScope, gitMaintenance, gitPathFormat, gitSSHSigning, gitHasConfig}
+
+// gitVersionPattern finds the version in `git --version`, which vendors
+// decorate: "git version 2.39.3 (Apple Git-146)", "2.44.0.windows.1".
+var gitVersionPattern = regexp.MustCompile(`(\d+)\.(\d+)(\.\d+)?`)
+
+// gitVersion is the version of the installed git.
+type gitVersion struct {
+    Major, Minor int
+    // Text is the version as git reports it, e.g. "2.39.3".
+    Text string
+    // Known is false when git could not be run or its output not parsed;
+    // features are then assumed to be there.
+    Known bool
+}
+
+var (
+    gitVersionOnce   sync.Once
+    cachedGitVersion gitVersion
+)
+
+// parseGitVersion reads the output of `git --version`.
+func parseGitVersion(out string) gitVersion {
+    m := gitVersionPattern.FindStringSubmatch(out)
+    if m == nil {
+        return gitVersion{}
+    }
+    major, _ := strconv.Atoi(m[1])
+    minor, _ := strconv.Atoi(m[2])
+    return gitVersion{Major: major, Minor: minor, Text: m[0], Known: true}
+}
+
+// installedGitVersion returns the version of the git gist runs, asking it
+// once per process.
+func installedGitVersion() gitVersion {
+    gitVersionOnce.Do(func() {
+        out, err := exec.Command(getGitPath(), "--version").Output()
+        if err == nil {
+            cachedGitVersion = parseGitVersion(string(out))
+        }
+    })
+    return cachedGitVersion
+}
+
+// atLeast reports whether the version is major.minor or later.
+func (v gitVersion) atLeast(major, minor int) bool {
+    return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+// supported reports whether the installed git has the feature.
+func (f gitFeature) supported() bool {
+    v := installedGitVersion()
+    return !v.Known || v.atLeast(f.Major, f.Minor)
+}
+
+// requireGit fails when the installed git lacks the feature.
+func requireGit(f gitFeature) error {
+    if f.supported() {
+        return nil
+    }
+    return fmt.Errorf("%s requires git ≥ %d.%d, found %s", f.What, f.Major, f.Minor, installedGitVersion().Text)
+}
+
+// profileGitRequirements checks that the installed git can apply the
+// profile: an SSH signing key needs SSH signing, which older git would
+// hand to gpg, and the maintenance map needs git maintenance.
+func profileGitRequirements(p *Profile) error {
+    if p.SigningFormat == "ssh" || p.SigningFormat == "" && p.SigningKey != "" && isSSHSigningKey(p.SigningKey) {
+        if err := requireGit(gitSSHSigning); err != nil {
+            return fmt.Errorf("profile %s: %w", p.Name, err)
+        }
+    }
+    for key := range p.Maintenance {
+        if strings.HasPrefix(key, "maintenance.") {
+            if err := requireGit(gitMaintenance); err != nil {
+                return fmt.Errorf("profile %s: %w", p.Name, err)
+            }
+            break
+        }
+    }
+    return nil
+}
+
+// configOriginFlags are the git config flags that report where values come
+// from; without --show-scope, parseConfigOrigin infers the scope.
+func configOriginFlags() []string {
+    if gitShowScope.supported() {
+        return []string{"--show-origin", "--show-scope"}
+    }
+    return []string{"--show-origin"}
+}
+
+// originScope infers the scope of an origin as git before 2.26 shows it:
+// the repository's config is given relative to the work tree, the global
+// ones by their path. Other files, included ones among them, count as
+// system.
+func originScope(origin string) string {
+    switch {
+    case strings.HasPrefix(origin, "command line:"):
+        return "command"
+    case strings.HasSuffix(origin, "config.worktree"):
+        return "worktree"
+    }
+    file := strings.Trim(strings.TrimPrefix(origin, "file:"), "\"")
+    if !filepath.IsAbs(file) {
+        return "local"
+    }
+    globals := []string{expandHome("~/.gitconfig"), expandHome("~/.config/git/config")}
+    if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+        globals = append(globals, filepath.Join(xdg, "git", "config"))
+    }
+    if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+        globals = append(globals, expandHome(global))
+    }
+    for _, g := range globals {
+        if filepath.Clean(file) == filepath.Clean(g) {
+            return "global"
+        }
+    }
+    return "system"
+}
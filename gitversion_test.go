@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestParseGitVersion(t *testing.T) {
+    for _, tc := range []struct {
+        out  string
+        want gitVersion
+    }{
+        {"git version 2.43.0\n", gitVersion{2, 43, "2.43.0", true}},
+        {"git version 2.39.3 (Apple Git-146)\n", gitVersion{2, 39, "2.39.3", true}},
+        {"git version 2.44.0.windows.1\n", gitVersion{2, 44, "2.44.0", true}},
+        {"git version 1.8\n", gitVersion{1, 8, "1.8", true}},
+        {"", gitVersion{}},
+        {"git: command not found", gitVersion{}},
+    } {
+        if got := parseGitVersion(tc.out); got != tc.want {
+            t.Errorf("parseGitVersion(%q) = %+v, want %+v", tc.out, got, tc.want)
+        }
+    }
+}
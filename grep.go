@@ -0,0 +1,153 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// grepKeys are the keys `gist grep` searches, by section; hosts entries are
+// searched by host and profile alike.
+var grepKeys = map[string][]string{
+    "profiles": {"name", "username", "email"},
+    "rules":    {"profile", "dir", "url", "remote", "branch"},
+    "trash":    {"name", "username", "email"},
+}
+
+// includeList returns the files a config file's include: lists. It reads
+// nothing else, so listing the files runs none of their resolvers.
+func includeList(data []byte) []string {
+    var files []string
+    section := ""
+    for _, line := range strings.Split(string(data), "\n") {
+        line = stripComment(line)
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+            continue
+        }
+        if line[0] != ' ' && line[0] != '\t' && !strings.HasPrefix(trimmed, "-") {
+            key, value, ok := parseKeyValue(trimmed)
+            if !ok {
+                continue
+            }
+            section = key
+            if key == "include" && value != "" {
+                // include: [a.yaml, b.yaml]
+                for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+                    if item = strings.Trim(strings.TrimSpace(item), "\"'"); item != "" {
+                        files = append(files, item)
+                    }
+                }
+            }
+            continue
+        }
+        if section == "include" && strings.HasPrefix(trimmed, "-") {
+            files = append(files, strings.Trim(strings.TrimSpace(trimmed[1:]), "\"'"))
+        }
+    }
+    return files
+}
+
+// configFiles returns the config and every file it includes, in load order,
+// followed by the installed policy if there is one.
+func configFiles(configPath string) []string {
+    files := []string{configPath}
+    seen := map[string]bool{configPath: true}
+    for i := 0; i < len(files); i++ {
+        data, err := os.ReadFile(files[i])
+        if err != nil {
+            continue
+        }
+        for _, file := range includeList(data) {
+            if file = includePath(files[i], file); !seen[file] {
+                seen[file] = true
+                files = append(files, file)
+            }
+        }
+    }
+    if _, err := os.Stat(policyPath(configPath)); err == nil {
+        files = append(files, policyPath(configPath))
+    }
+    return files
+}
+
+// grepFile prints the lines of a config file whose searched values contain
+// term, with what they belong to, and returns how many matched.
+func grepFile(path, term string) (int, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return 0, err
+    }
+    term = strings.ToLower(term)
+    // Files without a section header are lists of profiles.
+    section, owner, rules, matches := "profiles", "", 0, 0
+    for n, line := range strings.Split(string(data), "\n") {
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+            continue
+        }
+        key, value, ok := parseKeyValue(line)
+        if !ok {
+            continue
+        }
+        if line[0] != ' ' && line[0] != '\t' && !strings.HasPrefix(trimmed, "-") {
+            if value == "" {
+                section, owner = key, ""
+            }
+            continue
+        }
+        if strings.HasPrefix(trimmed, "-") && section == "rules" {
+            rules++
+            owner = "rule " + strconv.Itoa(rules)
+        }
+        if key == "name" && (section == "profiles" || section == "trash") {
+            owner = "profile " + value
+            if section == "trash" {
+                owner = "trashed " + owner
+            }
+        }
+        text := ""
+        for _, k := range grepKeys[section] {
+            if k == key {
+                text = value
+            }
+        }
+        if section == "hosts" {
+            text = key + " " + value
+        }
+        if !strings.Contains(strings.ToLower(text), term) {
+            continue
+        }
+        where := section
+        if owner != "" {
+            where = owner
+        }
+        fmt.Printf("%s:%d: %s: %s\n", path, n+1, where, trimmed)
+        matches++
+    }
+    return matches, nil
+}
+
+// commandGrep searches profile names, usernames and emails, rules and the
+// hosts map across the config, its includes and the policy, printing each
+// match with its file and line. The search is case-insensitive; it returns
+// an error when nothing matches.
+func commandGrep(configPath string, args []string) error {
+    if len(args) != 1 || args[0] == "" {
+        return errors.New("usage: gist grep <term>")
+    }
+    total := 0
+    for _, file := range configFiles(configPath) {
+        n, err := grepFile(file, args[0])
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+        }
+        total += n
+    }
+    if total == 0 {
+        return fmt.Errorf("no profile, rule or host matches %q", args[0])
+    }
+    return nil
+}
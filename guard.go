@@ -0,0 +1,235 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// guardHook invokes the identity guard from a git hook.
+const guardHook = `#!/bin/sh
+# Installed by gist: guard against committing with the wrong identity.
+%s guard%s || exit 1
+`
+
+// privacyHookLine is appended to the pre-commit guard hook to lint staged
+// changes for other profiles' identities.
+const privacyHookLine = "%s privacy%s || exit 1\n"
+
+// signOffHook checks sign-off trailers from a commit-msg hook.
+const signOffHook = `#!/bin/sh
+# Installed by gist: check Signed-off-by trailers against the identity.
+exec %s verify --message "$1"
+`
+
+// errIdentityLeak is returned by the guard in blocking mode.
+var errIdentityLeak = errors.New("commit blocked: run `gist set --auto` first")
+
+// checkIdentityLeak reports whether a repository without local identity would
+// fall back to an inherited identity that differs from the profile the rules
+// select. Where branch rules apply the local identity is checked as well,
+// since it goes stale on checkout. It returns the expected profile when the
+// identity is wrong.
+func checkIdentityLeak(cfg Config) (*Profile, string, error) {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return nil, "", errors.New("not inside a git repository")
+    }
+    if _, err := runGit("config", "--local", "user.email"); err == nil && !hasBranchRules(cfg, repoRoot, listRemotes()) {
+        return nil, "", nil
+    }
+    name, err := resolveAutoProfile(cfg, false)
+    if err != nil {
+        // Nothing indicates a different identity should apply.
+        return nil, "", nil
+    }
+    p := findProfile(&cfg, name)
+    if p == nil {
+        return nil, "", fmt.Errorf("profile %s not found", name)
+    }
+    current, _ := runGit("config", "user.email")
+    if current == p.Email {
+        return nil, "", nil
+    }
+    return p, current, nil
+}
+
+// commandGuard warns, or with block fails, when the repository is about to
+// use an identity that the rules say is wrong. With stamp, repositories
+// with branch rules get the profile for the checked out branch applied
+// instead.
+func commandGuard(cfg Config, block, stamp bool) error {
+    p, current, err := checkIdentityLeak(cfg)
+    if err != nil || p == nil {
+        return err
+    }
+    _, repoRoot := isGitRepo()
+    if hasBranchRules(cfg, repoRoot, listRemotes()) {
+        if stamp {
+            return commandSet(cfg, p.Name)
+        }
+        fmt.Fprintf(os.Stderr, "⚠ gist: branch %s uses profile %s <%s> but git would use %s\n", currentBranch(), p.Name, p.Email, current)
+        if block {
+            return errIdentityLeak
+        }
+        return nil
+    }
+    if current == "" {
+        current = "(unset)"
+    }
+    fmt.Fprintf(os.Stderr, "⚠ gist: this repository has no local identity; git would use %s but the rules select profile %s <%s>\n", current, p.Name, p.Email)
+    if block {
+        return errIdentityLeak
+    }
+    return nil
+}
+
+// hooksDir returns the current repository's hooks directory.
+func hooksDir() (string, error) {
+    inRepo, _ := isGitRepo()
+    if !inRepo {
+        return "", errors.New("not inside a git repository")
+    }
+    hooks, err := runGit("rev-parse", "--git-path", "hooks")
+    if err != nil {
+        return "", fmt.Errorf("cannot locate hooks directory: %s", hooks)
+    }
+    if !filepath.IsAbs(hooks) {
+        hooks = filepath.Join(repoDir, hooks)
+    }
+    return hooks, os.MkdirAll(hooks, 0o755)
+}
+
+// Every hook gist writes is a /bin/sh script whose second line is its
+// marker, "# Installed by gist: <what it does>." A hook file can hold
+// several of them – the guard's pre-commit hook and the git template's, say
+// – chained: each runs in a subshell of its own, and the first to fail
+// fails the hook.
+
+// hookMarkerPrefix starts the marker line of every hook gist writes.
+const hookMarkerPrefix = "# Installed by gist:"
+
+// chainedHook heads a hook file running several gist hooks.
+const chainedHook = "#!/bin/sh\n# Installed by gist: run the gist hooks below in turn.\n"
+
+// hookMarker returns the marker line of a hook script, or "".
+func hookMarker(script string) string {
+    for _, line := range strings.Split(script, "\n") {
+        if strings.HasPrefix(line, hookMarkerPrefix) {
+            return line
+        }
+    }
+    return ""
+}
+
+// hookParts returns the gist hooks a hook file runs, without their #! line.
+func hookParts(script string) []string {
+    if !strings.HasPrefix(script, chainedHook) {
+        _, body, _ := strings.Cut(script, "\n")
+        return []string{body}
+    }
+    var parts []string
+    for _, section := range strings.Split(strings.TrimPrefix(script, chainedHook), "\n) || exit $?\n") {
+        if body, ok := strings.CutPrefix(section, "(\n"); ok {
+            parts = append(parts, body+"\n")
+        }
+    }
+    return parts
+}
+
+// joinHookParts returns the hook file running parts, as a plain script when
+// there is only one.
+func joinHookParts(parts []string) string {
+    if len(parts) == 1 {
+        return "#!/bin/sh\n" + parts[0]
+    }
+    var b strings.Builder
+    b.WriteString(chainedHook)
+    for _, part := range parts {
+        b.WriteString("(\n" + part + ") || exit $?\n")
+    }
+    return b.String()
+}
+
+// writeHook installs a hook script, replacing an earlier version of it and
+// chaining it with the other gist hooks the file runs. Hooks gist did not
+// write itself are never replaced.
+func writeHook(hooks, name, script string) error {
+    path := filepath.Join(hooks, name)
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return os.WriteFile(path, []byte(script), 0o755)
+    }
+    if !strings.Contains(string(data), hookMarkerPrefix) {
+        return fmt.Errorf("refusing to overwrite existing hook %s", path)
+    }
+    marker := hookMarker(script)
+    _, body, _ := strings.Cut(script, "\n")
+    var parts []string
+    replaced := false
+    for _, part := range hookParts(string(data)) {
+        if hookMarker(part) != marker {
+            parts = append(parts, part)
+        } else if !replaced {
+            parts = append(parts, body)
+            replaced = true
+        }
+    }
+    if !replaced {
+        parts = append(parts, body)
+    }
+    return os.WriteFile(path, []byte(joinHookParts(parts)), 0o755)
+}
+
+// removeHook removes the gist hook starting with marker from a hook file,
+// and the file once it runs nothing else.
+func removeHook(hooks, name, marker string) error {
+    path := filepath.Join(hooks, name)
+    data, err := os.ReadFile(path)
+    if err != nil || !strings.Contains(string(data), marker) {
+        return nil
+    }
+    var parts []string
+    for _, part := range hookParts(string(data)) {
+        if !strings.HasPrefix(hookMarker(part), marker) {
+            parts = append(parts, part)
+        }
+    }
+    if len(parts) == 0 {
+        return os.Remove(path)
+    }
+    return os.WriteFile(path, []byte(joinHookParts(parts)), 0o755)
+}
+
+// installGuard writes guard hooks into the current repository: a pre-commit
+// hook (blocking when block is set), a post-checkout hook that warns or
+// applies branch rules and a commit-msg hook checking sign-off trailers.
+// With privacy the pre-commit hook also lints staged changes for other
+// profiles' identities. Other gist hooks already there keep running.
+func installGuard(block, privacy bool) error {
+    hooks, err := hooksDir()
+    if err != nil {
+        return err
+    }
+    preCommitFlag := ""
+    if block {
+        preCommitFlag = " --block"
+    }
+    preCommit := fmt.Sprintf(guardHook, gistExecutable(), preCommitFlag)
+    if privacy {
+        preCommit += fmt.Sprintf(privacyHookLine, gistExecutable(), preCommitFlag)
+    }
+    if err := writeHook(hooks, "pre-commit", preCommit); err != nil {
+        return err
+    }
+    if err := writeHook(hooks, "post-checkout", fmt.Sprintf(guardHook, gistExecutable(), " --stamp")); err != nil {
+        return err
+    }
+    if err := writeHook(hooks, "commit-msg", fmt.Sprintf(signOffHook, gistExecutable())); err != nil {
+        return err
+    }
+    fmt.Printf("✔️  Installed identity guard hooks into %s\n", hooks)
+    return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestHookParts(t *testing.T) {
+    guard := "# Installed by gist: check the identity before committing.\nexec gist guard\n"
+    template := "# Installed by gist: apply the profile to new repositories.\ngist set --auto || true\n"
+    for _, tc := range []struct {
+        name   string
+        script string
+        want   []string
+    }{
+        {"plain", "#!/bin/sh\n" + guard, []string{guard}},
+        {"chained", chainedHook + "(\n" + guard + ") || exit $?\n(\n" + template + ") || exit $?\n", []string{guard, template}},
+    } {
+        parts := hookParts(tc.script)
+        if !reflect.DeepEqual(parts, tc.want) {
+            t.Errorf("%s: hookParts = %q, want %q", tc.name, parts, tc.want)
+        }
+        if got := joinHookParts(parts); got != tc.script {
+            t.Errorf("%s: joinHookParts(hookParts(s)) = %q, want %q", tc.name, got, tc.script)
+        }
+    }
+}
+
+func TestHookMarker(t *testing.T) {
+    for _, tc := range []struct{ script, want string }{
+        {"#!/bin/sh\n# Installed by gist: guard.\nexec gist guard\n", "# Installed by gist: guard."},
+        {"#!/bin/sh\nexec other\n", ""},
+    } {
+        if got := hookMarker(tc.script); got != tc.want {
+            t.Errorf("hookMarker(%q) = %q, want %q", tc.script, got, tc.want)
+        }
+    }
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// hostConflict is a pair of profiles a host can't tell apart: they reach
+// it with the same SSH key or the same credential, so whichever account
+// that belongs to is the one the server sees for both.
+type hostConflict struct {
+    Host  string
+    First string
+    // Second is the profile the suggested fix is for.
+    Second string
+    // Shared names what the profiles share, e.g. "SSH key ~/.ssh/id_ed25519".
+    Shared string
+    Fix    string
+}
+
+func (c hostConflict) String() string {
+    return fmt.Sprintf("profiles %s and %s both reach %s with %s, so the server can't tell them apart", c.First, c.Second, c.Host, c.Shared)
+}
+
+// profileTargets returns the hosts a profile is selected for: its hosts map
+// entries and the hosts of its url rules.
+func profileTargets(cfg Config, p *Profile) []string {
+    seen := map[string]bool{}
+    for _, host := range profileHosts(cfg, p) {
+        seen[strings.ToLower(host)] = true
+    }
+    for _, r := range cfg.Rules {
+        if r.Profile == p.Name && r.URL != "" {
+            if host := remoteHost(r.URL); host != "" {
+                seen[strings.ToLower(host)] = true
+            }
+        }
+    }
+    hosts := make([]string, 0, len(seen))
+    for host := range seen {
+        hosts = append(hosts, host)
+    }
+    sort.Strings(hosts)
+    return hosts
+}
+
+// sshIdentity describes the key a profile's ssh connections present.
+func sshIdentity(p *Profile) string {
+    if p.SSHKey == "" {
+        return "the default SSH key"
+    }
+    return "SSH key " + profilePath(p, p.SSHKey)
+}
+
+// credentialIdentity describes the credential a profile's HTTPS
+// connections present.
+func credentialIdentity(p *Profile) string {
+    switch {
+    case usesGitHubApp(p):
+        return "GitHub App " + p.GitHubAppID
+    case p.CredentialToken != "":
+        return "the same credential_token"
+    }
+    return "git's credential helper"
+}
+
+// hostConflicts finds the pairs of profiles selected for the same host that
+// present the same SSH key under the same host name, or the same HTTPS
+// credential. Profiles that pin remote_protocol to different protocols
+// never meet.
+func hostConflicts(cfg Config) []hostConflict {
+    byHost := map[string][]*Profile{}
+    var hosts []string
+    for i := range cfg.Profiles {
+        p := &cfg.Profiles[i]
+        for _, host := range profileTargets(cfg, p) {
+            if byHost[host] == nil {
+                hosts = append(hosts, host)
+            }
+            byHost[host] = append(byHost[host], p)
+        }
+    }
+    sort.Strings(hosts)
+    var conflicts []hostConflict
+    for _, host := range hosts {
+        profiles := byHost[host]
+        for i := 0; i < len(profiles); i++ {
+            for j := i + 1; j < len(profiles); j++ {
+                a, b := profiles[i], profiles[j]
+                if a.RemoteProtocol != "https" && b.RemoteProtocol != "https" &&
+                    sshIdentity(a) == sshIdentity(b) && a.SSHHostAlias == b.SSHHostAlias {
+                    alias := "%h-" + b.Name
+                    conflicts = append(conflicts, hostConflict{
+                        Host: host, First: a.Name, Second: b.Name, Shared: sshIdentity(a),
+                        Fix: fmt.Sprintf("give profile %s its own ssh_key and ssh_host_alias: %q, add `Host %s` with `HostName %s` and that IdentityFile to ~/.ssh/config, then run `gist remotes fix` in its repositories",
+                            b.Name, alias, strings.ReplaceAll(alias, "%h", host), host),
+                    })
+                    continue
+                }
+                if a.RemoteProtocol == "ssh" || b.RemoteProtocol == "ssh" || credentialIdentity(a) != credentialIdentity(b) {
+                    continue
+                }
+                explicit := a.CredentialToken != "" || usesGitHubApp(a)
+                if usesGitHubApp(a) && a.GitHubAppInstallation != b.GitHubAppInstallation ||
+                    !usesGitHubApp(a) && a.CredentialToken != b.CredentialToken {
+                    continue
+                }
+                if !explicit && (a.RemoteProtocol != "https" || b.RemoteProtocol != "https") {
+                    // Without a protocol either may use ssh; the key decides.
+                    continue
+                }
+                conflicts = append(conflicts, hostConflict{
+                    Host: host, First: a.Name, Second: b.Name, Shared: credentialIdentity(a),
+                    Fix: fmt.Sprintf("give profile %s its own credential_token, or use ssh with its own ssh_key and ssh_host_alias: %q", b.Name, "%h-"+b.Name),
+                })
+            }
+        }
+    }
+    return conflicts
+}
+
+// diagnoseHostConflicts reports the profiles a host can't tell apart.
+func diagnoseHostConflicts(cfg Config) []diagnosis {
+    var found []diagnosis
+    for _, c := range hostConflicts(cfg) {
+        found = append(found, diagnosis{Problem: c.String() + "; " + c.Fix})
+    }
+    return found
+}
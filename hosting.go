@@ -0,0 +1,127 @@
+package main
+
+import (
+    "os"
+    "strings"
+)
+
+// Azure DevOps and AWS CodeCommit spell the same repository differently
+// depending on the protocol, so their URLs are rewritten to one canonical
+// form before rules and the hosts map look at them:
+//
+//   dev.azure.com/<org>/<project>/<repo>
+//   git-codecommit.<region>.amazonaws.com/v1/repos/<repo>
+
+// azureHost is the canonical Azure DevOps host.
+const azureHost = "dev.azure.com"
+
+// normalizeCodeCommit rewrites a git-remote-codecommit URL
+// (codecommit::<region>://[<aws profile>@]<repo>, or codecommit://… in the
+// default region) to the HTTPS form. ok is false for other URLs.
+func normalizeCodeCommit(url string) (string, bool) {
+    rest, ok := strings.CutPrefix(url, "codecommit:")
+    if !ok {
+        return "", false
+    }
+    region := ""
+    if r, ok := strings.CutPrefix(rest, ":"); ok {
+        region, rest, _ = strings.Cut(r, "://")
+    } else {
+        rest = strings.TrimPrefix(rest, "//")
+    }
+    if _, repo, ok := strings.Cut(rest, "@"); ok {
+        rest = repo
+    }
+    if region == "" {
+        region = os.Getenv("AWS_REGION")
+    }
+    if region == "" {
+        region = os.Getenv("AWS_DEFAULT_REGION")
+    }
+    host := "git-codecommit.amazonaws.com"
+    if region != "" {
+        host = "git-codecommit." + region + ".amazonaws.com"
+    }
+    return host + "/v1/repos/" + rest, true
+}
+
+// normalizeAzureDevOps rewrites an already host/path-shaped Azure DevOps URL
+// (HTTPS with _git, SSH with v3, or a legacy visualstudio.com one) to the
+// canonical form; other URLs are returned unchanged.
+func normalizeAzureDevOps(u string) string {
+    host, path, _ := strings.Cut(u, "/")
+    parts := strings.Split(path, "/")
+    switch {
+    case host == "ssh."+azureHost || host == "vs-ssh.visualstudio.com":
+        // v3/<org>/<project>/<repo>
+        if len(parts) > 0 && parts[0] == "v3" {
+            parts = parts[1:]
+        }
+    case host == azureHost:
+    case strings.HasSuffix(host, ".visualstudio.com"):
+        // <org>.visualstudio.com/[DefaultCollection/]<project>/_git/<repo>
+        if len(parts) > 0 && strings.EqualFold(parts[0], "DefaultCollection") {
+            parts = parts[1:]
+        }
+        parts = append([]string{strings.TrimSuffix(host, ".visualstudio.com")}, parts...)
+    default:
+        return u
+    }
+    kept := parts[:0]
+    for _, p := range parts {
+        if p != "_git" && p != "" {
+            kept = append(kept, p)
+        }
+    }
+    return strings.Join(append([]string{azureHost}, kept...), "/")
+}
+
+// remoteHostKeys returns the hosts map keys a remote URL can match, most
+// specific first. Azure DevOps serves every organisation from one host, so
+// "dev.azure.com/<org>" can pick a profile per organisation.
+func remoteHostKeys(url string) []string {
+    host := remoteHost(url)
+    if host != azureHost {
+        return []string{host}
+    }
+    parts := strings.SplitN(normalizeRemoteURL(url), "/", 3)
+    if len(parts) < 2 {
+        return []string{host}
+    }
+    return []string{host + "/" + parts[1], host}
+}
+
+// usesHTTPPath reports whether credentials for a remote URL must be scoped
+// by path: one Azure DevOps or CodeCommit host holds repositories of
+// different organisations and accounts.
+func usesHTTPPath(url string) bool {
+    lower := strings.ToLower(url)
+    if !strings.HasPrefix(lower, "https://") {
+        return false
+    }
+    host := remoteHost(url)
+    return host == azureHost || strings.HasSuffix(host, ".visualstudio.com") ||
+        strings.HasPrefix(host, "git-codecommit.")
+}
+
+// hostedSettings returns the repository settings the remotes need beyond the
+// profile: credential.useHttpPath for Azure DevOps and CodeCommit HTTPS
+// remotes, so a credential helper keeps one login per organisation.
+func hostedSettings(remotes []remoteInfo) []setting {
+    for _, rm := range remotes {
+        if usesHTTPPath(rm.URL) {
+            return []setting{{"credential.useHttpPath", "true"}}
+        }
+    }
+    return nil
+}
+
+// codeCommitHelper is the credential helper for CodeCommit HTTPS remotes,
+// signing requests with an AWS CLI profile.
+func codeCommitHelper(awsProfile string) []setting {
+    scope := "credential.https://git-codecommit.*.amazonaws.com."
+    return []setting{
+        {scope + "helper", "!aws --profile " + awsProfile + " codecommit credential-helper $@"},
+        {scope + "useHttpPath", "true"},
+    }
+}
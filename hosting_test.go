@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestNormalizeAzureDevOps(t *testing.T) {
+    for _, tc := range []struct{ url, want string }{
+        {"dev.azure.com/org/proj/_git/repo", "dev.azure.com/org/proj/repo"},
+        {"ssh.dev.azure.com/v3/org/proj/repo", "dev.azure.com/org/proj/repo"},
+        {"vs-ssh.visualstudio.com/v3/org/proj/repo", "dev.azure.com/org/proj/repo"},
+        {"org.visualstudio.com/proj/_git/repo", "dev.azure.com/org/proj/repo"},
+        {"org.visualstudio.com/defaultcollection/proj/_git/repo", "dev.azure.com/org/proj/repo"},
+        {"dev.azure.com/org", "dev.azure.com/org"},
+        {"github.com/acme/_git/x", "github.com/acme/_git/x"},
+    } {
+        if got := normalizeAzureDevOps(tc.url); got != tc.want {
+            t.Errorf("normalizeAzureDevOps(%q) = %q, want %q", tc.url, got, tc.want)
+        }
+    }
+}
+
+// TestRemoteURLForms checks that the URL forms one Azure DevOps repository
+// is cloned by all normalise alike, so one rule matches each of them.
+func TestRemoteURLForms(t *testing.T) {
+    urls := []string{
+        "https://org@dev.azure.com/org/proj/_git/repo",
+        "git@ssh.dev.azure.com:v3/org/proj/repo",
+        "https://org.visualstudio.com/DefaultCollection/proj/_git/repo",
+        "org@vs-ssh.visualstudio.com:v3/org/proj/repo",
+    }
+    for _, url := range urls {
+        if got := normalizeRemoteURL(url); got != "dev.azure.com/org/proj/repo" {
+            t.Errorf("normalizeRemoteURL(%q) = %q", url, got)
+        }
+    }
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// A profile can pin the host keys of its SSH hosts, so a new machine
+// doesn't have to trust whatever key it sees first:
+//
+//   known_hosts:
+//     github.com: "SHA256:+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU"
+//
+// `gist ssh test` checks the keys the hosts present against the pins and
+// `gist ssh setup` adds the pinned keys to ~/.ssh/known_hosts.
+
+// hostKey is a public host key.
+type hostKey struct {
+    Type string
+    // Blob is the base64 key as in known_hosts.
+    Blob string
+}
+
+// Fingerprint returns the key's SHA256 fingerprint as ssh-keygen shows it.
+func (k hostKey) Fingerprint() string {
+    data, err := base64.StdEncoding.DecodeString(k.Blob)
+    if err != nil {
+        return ""
+    }
+    sum := sha256.Sum256(data)
+    return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+func (k hostKey) String() string {
+    return k.Type + " " + k.Blob
+}
+
+// parseHostKey parses "type base64".
+func parseHostKey(s string) (hostKey, bool) {
+    fields := strings.Fields(s)
+    if len(fields) < 2 {
+        return hostKey{}, false
+    }
+    k := hostKey{Type: fields[0], Blob: fields[1]}
+    return k, k.Fingerprint() != ""
+}
+
+// checkHostPin validates a known_hosts pin: a public key or a SHA256
+// fingerprint.
+func checkHostPin(host, pin string) error {
+    if strings.HasPrefix(pin, "SHA256:") {
+        if _, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(pin, "SHA256:")); err != nil {
+            return fmt.Errorf("known_hosts: %s: %q is not a SHA256 fingerprint", host, pin)
+        }
+        return nil
+    }
+    if _, ok := parseHostKey(pin); !ok {
+        return fmt.Errorf("known_hosts: %s: expected a public key (type base64) or a SHA256: fingerprint", host)
+    }
+    return nil
+}
+
+// pinMatches reports whether a key is the one pinned.
+func pinMatches(pin string, k hostKey) bool {
+    if strings.HasPrefix(pin, "SHA256:") {
+        return pin == k.Fingerprint()
+    }
+    pinned, ok := parseHostKey(pin)
+    return ok && pinned.Type == k.Type && pinned.Blob == k.Blob
+}
+
+// scanHostKeys asks host for its keys, of type keyType only when set. It
+// fails in offline mode.
+func scanHostKeys(host, keyType string) ([]hostKey, error) {
+    if err := requireOnline("ssh-keyscan " + host); err != nil {
+        return nil, err
+    }
+    args := []string{"-T", "10"}
+    if keyType != "" {
+        args = append(args, "-t", keyType)
+    }
+    var stderr bytes.Buffer
+    cmd := exec.Command("ssh-keyscan", append(args, host)...)
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil && len(out) == 0 {
+        if msg := strings.TrimSpace(stderr.String()); msg != "" {
+            return nil, fmt.Errorf("ssh-keyscan %s: %v: %s", host, err, msg)
+        }
+        return nil, fmt.Errorf("ssh-keyscan %s: %v", host, err)
+    }
+    var keys []hostKey
+    for _, line := range strings.Split(string(out), "\n") {
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        // host type base64
+        if _, rest, ok := strings.Cut(line, " "); ok {
+            if k, ok := parseHostKey(rest); ok {
+                keys = append(keys, k)
+            }
+        }
+    }
+    if len(keys) == 0 {
+        return nil, fmt.Errorf("%s presented no host keys", host)
+    }
+    return keys, nil
+}
+
+// pinnedKeyType returns the key type of a pinned public key, or "" for a
+// fingerprint pin, which may match any type.
+func pinnedKeyType(pin string) string {
+    if k, ok := parseHostKey(pin); ok {
+        return k.Type
+    }
+    return ""
+}
+
+// knownHostsFile returns the user's known_hosts file.
+func knownHostsFile() string {
+    return expandHome("~/.ssh/known_hosts")
+}
+
+// knownHostKeys returns the keys known_hosts has for host, hashed entries
+// included.
+func knownHostKeys(host string) []hostKey {
+    out, err := exec.Command("ssh-keygen", "-F", host, "-f", knownHostsFile()).Output()
+    if err != nil {
+        return nil
+    }
+    var keys []hostKey
+    for _, line := range strings.Split(string(out), "\n") {
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        fields := strings.Fields(line)
+        // A marker (@cert-authority, @revoked) comes before the host.
+        if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+            continue
+        }
+        if len(fields) >= 3 {
+            if k, ok := parseHostKey(fields[1] + " " + fields[2]); ok {
+                keys = append(keys, k)
+            }
+        }
+    }
+    return keys
+}
+
+// pinnedProfiles returns the profiles with host key pins, or only the named
+// one.
+func pinnedProfiles(cfg Config, name string) ([]*Profile, error) {
+    if name != "" {
+        p := findProfile(&cfg, name)
+        if p == nil {
+            return nil, fmt.Errorf("profile %s not found", name)
+        }
+        if len(p.KnownHosts) == 0 {
+            return nil, fmt.Errorf("profile %s pins no host keys (known_hosts)", p.Name)
+        }
+        return []*Profile{p}, nil
+    }
+    var profiles []*Profile
+    for i := range cfg.Profiles {
+        if len(cfg.Profiles[i].KnownHosts) > 0 {
+            profiles = append(profiles, &cfg.Profiles[i])
+        }
+    }
+    if len(profiles) == 0 {
+        return nil, errors.New("no profile pins host keys; add a known_hosts map to a profile")
+    }
+    return profiles, nil
+}
+
+// commandSSHTest checks the keys the pinned hosts present, and the entries
+// known_hosts has for them, against the pins.
+func commandSSHTest(cfg Config, name string) error {
+    profiles, err := pinnedProfiles(cfg, name)
+    if err != nil {
+        return err
+    }
+    bad := 0
+    for _, p := range profiles {
+        for _, host := range sortedKeys(p.KnownHosts) {
+            pin := p.KnownHosts[host]
+            if err := checkHostPin(host, pin); err != nil {
+                bad++
+                fmt.Printf("  ✘ %s: %v\n", p.Name, err)
+                continue
+            }
+            keys, err := scanHostKeys(host, pinnedKeyType(pin))
+            if err != nil {
+                bad++
+                fmt.Printf("  ✘ %s (%s): %v\n", p.Name, host, err)
+                continue
+            }
+            var presented []string
+            matched := false
+            for _, k := range keys {
+                presented = append(presented, k.Type+" "+k.Fingerprint())
+                matched = matched || pinMatches(pin, k)
+            }
+            if !matched {
+                bad++
+                fmt.Printf("  ✘ %s (%s): the host key doesn't match the pin; it presented %s\n", p.Name, host, strings.Join(presented, ", "))
+                continue
+            }
+            fmt.Printf("  ✔ %s (%s): the host key matches the pin\n", p.Name, host)
+            // ssh refuses the host when known_hosts has another key of the
+            // type it negotiates.
+            pinnedType := keyTypeOf(keys, pin)
+            for _, k := range knownHostKeys(host) {
+                if k.Type == pinnedType && !pinMatches(pin, k) {
+                    bad++
+                    fmt.Printf("  ✘ %s (%s): %s has another %s key for it (%s); remove it with `ssh-keygen -R %s`\n", p.Name, host, knownHostsFile(), k.Type, k.Fingerprint(), host)
+                }
+            }
+        }
+    }
+    if bad > 0 {
+        return fmt.Errorf("%d problem(s) found", bad)
+    }
+    return nil
+}
+
+// keyTypeOf returns the type of the key in keys matching the pin.
+func keyTypeOf(keys []hostKey, pin string) string {
+    for _, k := range keys {
+        if pinMatches(pin, k) {
+            return k.Type
+        }
+    }
+    return ""
+}
+
+// commandSSHSetup adds the pinned host keys to known_hosts. A fingerprint
+// pin needs the host to present the key; a host known_hosts already has
+// another key of the same type for is left alone.
+func commandSSHSetup(cfg Config, name string) error {
+    profiles, err := pinnedProfiles(cfg, name)
+    if err != nil {
+        return err
+    }
+    var lines []string
+    bad := 0
+    for _, p := range profiles {
+        for _, host := range sortedKeys(p.KnownHosts) {
+            pin := p.KnownHosts[host]
+            if err := checkHostPin(host, pin); err != nil {
+                bad++
+                fmt.Printf("  ✘ %s: %v\n", p.Name, err)
+                continue
+            }
+            key, ok := parseHostKey(pin)
+            if !ok {
+                keys, err := scanHostKeys(host, "")
+                if err != nil {
+                    bad++
+                    fmt.Printf("  ✘ %s (%s): %v\n", p.Name, host, err)
+                    continue
+                }
+                for _, k := range keys {
+                    if pinMatches(pin, k) {
+                        key, ok = k, true
+                    }
+                }
+                if !ok {
+                    bad++
+                    fmt.Printf("  ✘ %s (%s): the host presented no key matching the pin\n", p.Name, host)
+                    continue
+                }
+            }
+            known, conflict := false, false
+            for _, k := range knownHostKeys(host) {
+                switch {
+                case k == key:
+                    known = true
+                case k.Type == key.Type:
+                    conflict = true
+                }
+            }
+            switch {
+            case known:
+                fmt.Printf("  ✔ %s (%s): already in known_hosts\n", p.Name, host)
+            case conflict:
+                bad++
+                fmt.Printf("  ✘ %s (%s): known_hosts has another %s key for it; check it, remove it with `ssh-keygen -R %s` and run setup again\n", p.Name, host, key.Type, host)
+            default:
+                lines = append(lines, host+" "+key.String())
+                fmt.Printf("  + %s (%s): %s %s\n", p.Name, host, key.Type, key.Fingerprint())
+            }
+        }
+    }
+    if len(lines) > 0 {
+        path := knownHostsFile()
+        if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+            return err
+        }
+        f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+        if err != nil {
+            return err
+        }
+        _, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+        if cerr := f.Close(); err == nil {
+            err = cerr
+        }
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Added %d host key(s) to %s.\n", len(lines), path)
+    }
+    if bad > 0 {
+        return fmt.Errorf("%d problem(s) found", bad)
+    }
+    return nil
+}
+
+// commandSSH runs the `ssh` subcommands.
+func commandSSH(cfg Config, args []string) error {
+    usage := errors.New("usage: gist ssh test|setup [<profile>]")
+    if len(args) == 0 || len(args) > 2 {
+        return usage
+    }
+    name := ""
+    if len(args) == 2 {
+        name = args[1]
+    }
+    switch args[0] {
+    case "test":
+        return commandSSHTest(cfg, name)
+    case "setup":
+        return commandSSHSetup(cfg, name)
+    }
+    return usage
+}
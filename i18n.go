@@ -0,0 +1,231 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// baseMessages is the English catalog every locale falls back to. Keys are
+// stable message IDs; values are fmt format strings, so translations may
+// reorder arguments with %[n]s.
+var baseMessages = map[string]string{
+    "error":              "Error: %v",
+    "config.load_failed": "Failed to load config: %v",
+    "config.save_failed": "Failed to save config: %v",
+    "config.init_failed": "Error initializing config: %v",
+    "config.initialized": "Config initialized at %s",
+    "command.unknown":    "Unknown command: %s",
+    "profile.not_found":  "profile %s not found",
+    "repo.not_inside":    "not inside a git repository",
+    "list.header":        "available profiles:",
+    "info.header":        "current profile (%s):",
+    "info.pinned":        "  📌 pinned to %s",
+    "info.none":          "  (none)",
+    "info.shadow":        "  ⚠ `gist set` would add a local override shadowing this include",
+    "info.commits":       "recent commits (last %d):",
+    "set.done":           "✔️  Set profile \"%s\" for repository %s",
+    "set.shadow":         "warning: local config will shadow identity included from %s",
+    "add.name":           "Enter profile name: ",
+    "add.username":       "Enter username (git user.name): ",
+    "add.email":          "Enter email (git user.email): ",
+    "add.signing":        "Enter signing key (optional): ",
+    "add.ssh":            "Enter SSH key path (optional): ",
+    "add.done":           "Profile %s added.",
+    "add.updated":        "Profile %s updated.",
+    "add.default":        "Use it as the default profile when no rule matches? [y/N] ",
+    "add.default_set":    "Default profile set to %s.",
+
+    "help.usage":               "Usage: gist [-C <repo>] <command> [args]",
+    "help.commands":            "Commands:",
+    "help.init":                "Create default config if missing",
+    "help.init-repo":           "Run git init and apply the rule-matched or default profile",
+    "help.init-repo.template":  "Add gist hooks to the git template so plain git init repos get an identity",
+    "help.config.edit":         "Edit the config in $EDITOR, saving it only if it validates",
+    "help.config.backups":      "Show saved previous versions of the config",
+    "help.config.restore":      "Restore config backup n (1 is the newest)",
+    "help.log":                 "Show the changes gist made, from the log enabled by log: true",
+    "help.list":                "Show all configured profiles (--check validates keys and emails)",
+    "help.grep":                "Search profiles, rules and hosts across the config files",
+    "help.info":                "Show current active profile",
+    "help.stats.keys":          "List signing keys with expiry dates, warning about keys expiring soon",
+    "help.stats.usage":         "Show how often you ran each command (opt-in, kept locally)",
+    "help.keys.rotate":         "Replace a profile's signing key, upload it and retire the old one",
+    "help.signers.list":        "Show the SSH keys in gist's allowed_signers file",
+    "help.signers.add":         "Trust a teammate's SSH signing key",
+    "help.signers.remove":      "Stop trusting a teammate's SSH signing keys",
+    "help.forge.check":         "Check profile emails are verified on the forges the hosts map assigns",
+    "help.forge.noreply":       "Show (or --use) the profile's noreply address on its forge",
+    "help.forge.cache":         "Remove the cached forge API answers",
+    "help.trust.sync":          "Fetch the team roster of emails and signing keys",
+    "help.verify-signatures":   "Check commits are signed by their author's roster key",
+    "help.set":                 "Activate a profile for the current repository",
+    "help.set.auto":            "Activate the profile selected by the rules",
+    "help.diff":                "Show what `set <profile>` would change in the repository",
+    "help.detect":              "Suggest (and apply) a profile when no rule matches",
+    "help.rules.list":          "Show the rules with their numbers",
+    "help.rules.add":           "Add a rule",
+    "help.rules.remove":        "Delete rule number n",
+    "help.rules.test":          "Show how the rules resolve for a repository or directory",
+    "help.rules.lint":          "Report shadowed or unreachable rules",
+    "help.policy.install":      "Install an organisation policy bundle",
+    "help.policy.show":         "Show the installed policy",
+    "help.remotes.fix":         "Rewrite remotes to the active profile's protocol and SSH host alias",
+    "help.remotes.audit":       "Report remotes that belong to another profile than their repository's",
+    "help.unset":               "Remove the local identity from the current repository",
+    "help.which":               "Explain which rule selects the profile for this repository",
+    "help.explain":             "Trace how the repository's identity came to be: config files, includes, environment, pin and rules",
+    "help.profile.show":        "Show a profile's resolved settings, what selects it, its repositories and key health",
+    "help.whoami":              "Show the identity, signing key, forge accounts and SSH keys in use right now",
+    "help.pin":                 "Pin the repository to a profile that rules can't change (no profile: list pins)",
+    "help.unpin":               "Remove the repository's pin",
+    "help.fix-last-commit":     "Re-author the last N unpushed commits with a profile",
+    "help.guard":               "Warn (or fail) when the identity differs from the rule-selected one (--stamp: apply branch rules)",
+    "help.guard.install":       "Install the guard as pre-commit/post-checkout hooks",
+    "help.shim":                "Put a git wrapper in PATH that runs verify --quick before commits and pushes",
+    "help.privacy":             "Warn (or fail) when staged changes contain another profile's name or email",
+    "help.verify":              "Check commit authors and required sign-offs against the identity",
+    "help.audit":               "Find commits made as another profile and fix them forward via .mailmap",
+    "help.server-hook":         "Print a pre-receive hook rejecting pushes with non-allowed emails or unsigned commits",
+    "help.exec":                "Run a command with the profile's identity and env",
+    "help.shell":               "Start a subshell running as the profile",
+    "help.ssh.test":            "Check the keys SSH hosts present against the profiles' known_hosts pins",
+    "help.ssh.setup":           "Add the profiles' pinned host keys to ~/.ssh/known_hosts",
+    "help.ssh-select":          "Print the SSH key of the profile selected for a host, for ssh_config",
+    "help.credential":          "Git credential helper serving the token of the profile the URL selects",
+    "help.serve":               "Answer resolve, verify and set requests from editors over a unix socket (JSON-RPC)",
+    "help.lsp-lite":            "Stream identity status events for workspace folders over stdio, for editor status bars",
+    "help.bootstrap":           "Write a minimal gitconfig and SSH signing setup for a devcontainer",
+    "help.scan":                "Find repositories whose identity isn't the rule-selected profile (--fix applies it)",
+    "help.export":              "Write an inventory of repositories, remotes, profiles and signing",
+    "help.metrics":             "Print Prometheus metrics on repositories, policy and key expiry",
+    "help.watch":               "Keep re-checking known repositories and report identity drift",
+    "help.service":             "Install a systemd timer or launchd agent running scan --verify",
+    "help.tidy":                "Remove local identity config that duplicates inherited config",
+    "help.apply":               "Apply a plan mapping repository paths to profiles",
+    "help.ensure":              "Idempotently make a repository use a profile",
+    "help.render":              "Print the gitconfig a profile produces",
+    "help.includes":            "Write includeIf entries for the rules to the global gitconfig, so git switches identities by itself",
+    "help.template.edit":       "Edit the profile's commit message template",
+    "help.add":                 "Interactively add a new profile; --from prefills it from a forge account or a gitconfig file",
+    "help.remove":              "Move a profile to the trash after confirmation",
+    "help.restore":             "Bring a removed profile back from the trash",
+    "help.trash":               "List removed profiles",
+    "help.doctor":              "Find (and fix) problems with config, key permissions, hooks and includes",
+    "help.selftest":            "Run set, info, verify and unset in a throwaway repository against the installed git",
+    "help.completion":          "Print the completion script for bash, zsh or powershell",
+    "help.completion.install":  "Install the completion for your shell (or the one named) where it loads by itself",
+    "help.release":             "Write the Homebrew, scoop and nfpm packaging manifests for a release (maintainers)",
+    "help.path":                "Run repository commands against <repo> instead of the current directory",
+    "help.isolated":            "Use only this file as git's global config and ignore the system config",
+    "help.strict":              "Fail on unknown keys, duplicate profiles and unparsable config lines",
+    "help.offline":             "Make no network requests; answer forge queries from the cache",
+    "help.version":             "Print version and exit",
+    "help.help":                "Show this help message",
+}
+
+// messages is the catalog for the selected locale, loaded on first use.
+var messages map[string]string
+
+// locale returns the requested locale such as "de_DE", or "" for English.
+func locale() string {
+    for _, name := range []string{"GIST_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+        v := os.Getenv(name)
+        if v == "" {
+            continue
+        }
+        // Drop the encoding and modifier: de_DE.UTF-8@euro -> de_DE.
+        v, _, _ = strings.Cut(v, ".")
+        v, _, _ = strings.Cut(v, "@")
+        if v == "C" || v == "POSIX" {
+            return ""
+        }
+        return v
+    }
+    return ""
+}
+
+// localeDirs lists where catalogs are looked up, most specific first.
+func localeDirs() []string {
+    var dirs []string
+    if dir := os.Getenv("GIST_LOCALE_DIR"); dir != "" {
+        dirs = append(dirs, dir)
+    }
+    dirs = append(dirs, filepath.Join(filepath.Dir(getConfigPath()), "locale"))
+    if exe, err := os.Executable(); err == nil {
+        dirs = append(dirs, filepath.Join(filepath.Dir(exe), "..", "share", "gist", "locale"))
+    }
+    return append(dirs, "/usr/share/gist/locale")
+}
+
+// loadCatalog reads a catalog file of `id: "message"` lines into m.
+func loadCatalog(path string, m map[string]string) bool {
+    f, err := os.Open(path)
+    if err != nil {
+        return false
+    }
+    defer f.Close()
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        key, value, ok := strings.Cut(line, ":")
+        if !ok {
+            continue
+        }
+        value = strings.TrimSpace(value)
+        // Double quoted messages may use Go escapes such as \" and \n.
+        if unquoted, err := strconv.Unquote(value); err == nil {
+            value = unquoted
+        }
+        m[strings.TrimSpace(key)] = value
+    }
+    return true
+}
+
+// loadMessages builds the catalog for the current locale: the English
+// baseline overlaid with the language file (de) and then the regional
+// one (de_AT), taking each from the first directory that has it.
+func loadMessages() map[string]string {
+    m := make(map[string]string, len(baseMessages))
+    for k, v := range baseMessages {
+        m[k] = v
+    }
+    loc := locale()
+    if loc == "" {
+        return m
+    }
+    names := []string{loc}
+    if lang, _, ok := strings.Cut(loc, "_"); ok {
+        names = []string{lang, loc}
+    }
+    for _, name := range names {
+        for _, dir := range localeDirs() {
+            if loadCatalog(filepath.Join(dir, name+".yaml"), m) {
+                break
+            }
+        }
+    }
+    return m
+}
+
+// tr returns the localized message id formatted with args. Unknown ids are
+// returned as is so a missing entry never hides output.
+func tr(id string, args ...any) string {
+    if messages == nil {
+        messages = loadMessages()
+    }
+    format, ok := messages[id]
+    if !ok {
+        format = id
+    }
+    if len(args) == 0 {
+        return format
+    }
+    return fmt.Sprintf(format, args...)
+}
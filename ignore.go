@@ -0,0 +1,168 @@
+package main
+
+import (
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// ignoreFile is the name of the per-directory file listing paths scan and
+// apply skip, one pattern per line like .gitignore.
+const ignoreFile = ".gistignore"
+
+// ScanConfig configures repository traversal.
+type ScanConfig struct {
+    // Exclude lists patterns of directories to skip, relative to the
+    // directory scanned or absolute (~ allowed).
+    Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// ignoreRule is a pattern and the directory it is relative to, or no base for
+// an absolute pattern. A pattern without a slash matches a directory name at
+// any depth; one with a slash matches the path from base, where ** spans any
+// number of directories.
+type ignoreRule struct {
+    base    string
+    pattern string
+}
+
+// configIgnoreRule interprets a scan.exclude pattern: absolute and ~
+// patterns are paths, others are relative to base.
+func configIgnoreRule(base, pattern string) ignoreRule {
+    pattern = expandHome(pattern)
+    if filepath.IsAbs(pattern) {
+        return ignoreRule{pattern: "/" + strings.Trim(filepath.ToSlash(pattern), "/")}
+    }
+    return ignoreRule{base: base, pattern: strings.TrimSuffix(filepath.ToSlash(pattern), "/")}
+}
+
+// matches reports whether dir is excluded by the rule.
+func (r ignoreRule) matches(dir string) bool {
+    rel := dir
+    if r.base != "" {
+        var err error
+        rel, err = filepath.Rel(r.base, dir)
+        if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+            return false
+        }
+    }
+    segments := strings.Split(strings.TrimPrefix(filepath.ToSlash(rel), "/"), "/")
+    pattern := strings.TrimPrefix(r.pattern, "/")
+    if !strings.Contains(r.pattern, "/") {
+        ok, _ := path.Match(pattern, segments[len(segments)-1])
+        return ok
+    }
+    return globSegments(strings.Split(pattern, "/"), segments)
+}
+
+// globSegments matches path segments against pattern segments, where a
+// "**" segment matches zero or more path segments.
+func globSegments(pattern, segments []string) bool {
+    if len(pattern) == 0 {
+        return len(segments) == 0
+    }
+    if pattern[0] == "**" {
+        for i := 0; i <= len(segments); i++ {
+            if globSegments(pattern[1:], segments[i:]) {
+                return true
+            }
+        }
+        return false
+    }
+    if len(segments) == 0 {
+        return false
+    }
+    ok, _ := path.Match(pattern[0], segments[0])
+    return ok && globSegments(pattern[1:], segments[1:])
+}
+
+// loadIgnoreFile reads the rules of dir's .gistignore, if any.
+func loadIgnoreFile(dir string) []ignoreRule {
+    data, err := os.ReadFile(filepath.Join(dir, ignoreFile))
+    if err != nil {
+        return nil
+    }
+    var rules []ignoreRule
+    for _, line := range strings.Split(string(data), "\n") {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        rules = append(rules, ignoreRule{base: dir, pattern: strings.TrimSuffix(line, "/")})
+    }
+    return rules
+}
+
+// ignorer decides which directories a traversal skips: the config's
+// scan.exclude patterns plus the .gistignore files found on the way down.
+type ignorer struct {
+    root  string
+    base  []ignoreRule
+    files map[string][]ignoreRule
+}
+
+// newIgnorer returns the ignorer for a traversal starting at root.
+func newIgnorer(cfg Config, root string) *ignorer {
+    ig := &ignorer{root: root, files: map[string][]ignoreRule{}}
+    for _, pattern := range cfg.Scan.Exclude {
+        ig.base = append(ig.base, configIgnoreRule(root, pattern))
+    }
+    return ig
+}
+
+// rules returns the rules in force inside dir: the config's and those of
+// every .gistignore from the traversal root down to dir.
+func (ig *ignorer) rules(dir string) []ignoreRule {
+    if rules, ok := ig.files[dir]; ok {
+        return rules
+    }
+    var rules []ignoreRule
+    if parent := filepath.Dir(dir); dir != ig.root && parent != dir {
+        rules = append(rules, ig.rules(parent)...)
+    }
+    rules = append(rules, loadIgnoreFile(dir)...)
+    ig.files[dir] = rules
+    return rules
+}
+
+// ignored reports whether the traversal skips dir.
+func (ig *ignorer) ignored(dir string) bool {
+    for _, r := range ig.base {
+        if r.matches(dir) {
+            return true
+        }
+    }
+    for _, r := range ig.rules(filepath.Dir(dir)) {
+        if r.matches(dir) {
+            return true
+        }
+    }
+    return false
+}
+
+// excludedPath reports whether a directory, or one of its parents, matches
+// the config's scan.exclude patterns (relative ones by directory name). apply
+// uses it for the directories its plan globs expand to.
+func excludedPath(cfg Config, dir string) bool {
+    abs, err := filepath.Abs(dir)
+    if err != nil {
+        return false
+    }
+    for _, pattern := range cfg.Scan.Exclude {
+        r := configIgnoreRule("", pattern)
+        if !strings.HasPrefix(r.pattern, "/") && strings.Contains(r.pattern, "/") {
+            // Relative to whatever was scanned: anywhere will do.
+            r.pattern = "**/" + r.pattern
+        }
+        for d := abs; ; d = filepath.Dir(d) {
+            if r.matches(d) {
+                return true
+            }
+            if filepath.Dir(d) == d {
+                break
+            }
+        }
+    }
+    return false
+}
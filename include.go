@@ -0,0 +1,104 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+)
+
+// A config can split its profiles across files:
+//
+//   include: [~/.config/gist/work.yaml, ~/dotfiles/gist/personal.yaml]
+//
+// An included file has the config's layout (or is a bare list of profiles);
+// its profiles and rules are merged in, and its own includes followed.
+// Included profiles and rules remember their file, are read-only from the
+// CLI and are never written to the config.
+
+// includePath resolves an include entry: ~ is expanded and relative paths
+// are relative to the including file.
+func includePath(from, file string) string {
+    file = expandHome(file)
+    if !filepath.IsAbs(file) {
+        file = filepath.Join(filepath.Dir(from), file)
+    }
+    return filepath.Clean(file)
+}
+
+// profileOrigin names where a profile is defined.
+func profileOrigin(p *Profile) string {
+    if p.Source == "" {
+        return "the config"
+    }
+    return p.Source
+}
+
+// profileLabel names a profile in messages, with its file if included.
+func profileLabel(p *Profile) string {
+    if p.Source == "" {
+        return "profile " + p.Name
+    }
+    return fmt.Sprintf("profile %s (from %s)", p.Name, p.Source)
+}
+
+// loadIncludes merges into cfg the profiles and rules of the files that the
+// file at from includes. seen guards against include cycles.
+func loadIncludes(from string, files []string, cfg *Config, seen map[string]bool) error {
+    seen[filepath.Clean(from)] = true
+    for _, file := range files {
+        file = includePath(from, file)
+        if seen[file] {
+            return fmt.Errorf("%s: include cycle through %s", from, file)
+        }
+        data, err := os.ReadFile(file)
+        if err != nil {
+            return fmt.Errorf("%s: include: %w", from, err)
+        }
+        inc := Config{source: file}
+        parseConfig(&inc, data)
+        if cfg.Strict || strictConfig {
+            if err := strictCheck(&inc, file, data); err != nil {
+                return err
+            }
+        }
+        cfg.undefined = append(cfg.undefined, inc.undefined...)
+        if cfg.included == nil {
+            cfg.included = map[string]Profile{}
+        }
+        for _, p := range inc.Profiles {
+            p.dir = filepath.Dir(file)
+            if existing := findProfile(cfg, p.Name); existing != nil {
+                return fmt.Errorf("profile %s is defined in both %s and %s", p.Name, profileOrigin(existing), file)
+            }
+            cfg.Profiles = append(cfg.Profiles, p)
+            cfg.included[p.Name] = p
+        }
+        for _, r := range inc.Rules {
+            r.Source = "include " + file
+            cfg.Rules = append(cfg.Rules, r)
+        }
+        if err := loadIncludes(file, inc.Include, cfg, seen); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// checkIncluded refuses to save a config in which an included profile was
+// changed, removed or shadowed: the change would be lost, or the profile
+// would come back on the next load.
+func checkIncluded(cfg Config) error {
+    for name, loaded := range cfg.included {
+        p := findProfile(&cfg, name)
+        switch {
+        case p == nil:
+            return fmt.Errorf("profile %s comes from %s; remove it there", name, loaded.Source)
+        case p.Source == "":
+            return fmt.Errorf("profile %s is already defined in %s", name, loaded.Source)
+        case !reflect.DeepEqual(*p, loaded):
+            return fmt.Errorf("profile %s comes from %s; edit it there", name, loaded.Source)
+        }
+    }
+    return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// `gist includes sync` lets git switch identities by itself: it writes each
+// profile's include fragment (as `render --scope include` prints it) and
+// adds includeIf entries for the profile's rules to the global config –
+// gitdir: for directory rules, onbranch: for branch rules and, with git 2.36
+// or later, hasconfig:remote.*.url: for URL rules – so a clone picks up its
+// identity without hooks or `gist set`. Entries pointing into includesDir
+// are gist's; sync replaces them and leaves every other include alone.
+
+// includesDir returns the directory of the fragments sync writes.
+func includesDir() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "includes")
+}
+
+// managedIncludes returns the includeIf entries of the global config whose
+// path is a fragment in includesDir.
+func managedIncludes() ([]setting, error) {
+    out, err := runGit("config", "--global", "--null", "--get-regexp", `^includeif\..*\.path$`)
+    if err != nil {
+        // Exit status 1: there are none.
+        if out == "" {
+            return nil, nil
+        }
+        return nil, fmt.Errorf("cannot read the includes of %s: %s", globalConfigPath(), out)
+    }
+    dir := includesDir() + string(filepath.Separator)
+    var found []setting
+    for _, item := range strings.Split(out, "\x00") {
+        key, path, ok := strings.Cut(item, "\n")
+        if ok && strings.HasPrefix(expandHome(path), dir) {
+            found = append(found, setting{key, path})
+        }
+    }
+    return found, nil
+}
+
+// includeKey returns the key of an includeIf entry as git lists it, with
+// the section lower-cased.
+func includeKey(condition string) string {
+    return "includeif." + condition + ".path"
+}
+
+// includeSection returns how the key's section is written in a gitconfig.
+func includeSection(key string) string {
+    condition := strings.TrimSuffix(strings.TrimPrefix(key, "includeif."), ".path")
+    return fmt.Sprintf("[includeIf %q]", condition)
+}
+
+// wantedIncludes returns the includeIf entries and fragments the profiles'
+// rules call for, reporting the rules and profiles it has to leave out.
+func wantedIncludes(cfg Config) ([]setting, map[string]string) {
+    var entries []setting
+    fragments := map[string]string{}
+    for i := range cfg.Profiles {
+        p := &cfg.Profiles[i]
+        conditions, skipped := includeConditions(cfg, p)
+        for _, reason := range skipped {
+            fmt.Printf("! %s: %s\n", p.Name, reason)
+        }
+        if len(conditions) == 0 {
+            continue
+        }
+        if err := profileGitRequirements(p); err != nil {
+            fmt.Printf("! %v; skipped\n", err)
+            continue
+        }
+        content, err := renderProfile(cfg, p, "include")
+        if err != nil {
+            fmt.Printf("! %s: %v; skipped\n", p.Name, err)
+            continue
+        }
+        path := filepath.Join(includesDir(), p.Name+".gitconfig")
+        fragments[path] = content
+        for _, c := range conditions {
+            entries = append(entries, setting{includeKey(c), path})
+        }
+    }
+    return entries, fragments
+}
+
+// commandIncludesSync brings the global config's includeIf entries and the
+// fragments in line with the rules; with remove it removes them all. With
+// dryRun it only prints what it would change.
+func commandIncludesSync(cfg Config, remove, dryRun bool) error {
+    have, err := managedIncludes()
+    if err != nil {
+        return err
+    }
+    var want []setting
+    fragments := map[string]string{}
+    if !remove {
+        want, fragments = wantedIncludes(cfg)
+    }
+    contains := func(list []setting, s setting) bool {
+        for _, e := range list {
+            if e.Key == s.Key && expandHome(e.Value) == s.Value {
+                return true
+            }
+        }
+        return false
+    }
+    changes := 0
+    for _, path := range sortedKeys(fragments) {
+        if data, err := os.ReadFile(path); err == nil && string(data) == fragments[path] {
+            continue
+        }
+        fmt.Printf("~ %s\n", path)
+        changes++
+        if dryRun {
+            continue
+        }
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+            return err
+        }
+        if err := os.WriteFile(path, []byte(fragments[path]), 0o644); err != nil {
+            return err
+        }
+    }
+    for _, s := range have {
+        if contains(want, setting{s.Key, expandHome(s.Value)}) {
+            continue
+        }
+        fmt.Printf("- %s path = %s\n", includeSection(s.Key), s.Value)
+        changes++
+        if dryRun {
+            continue
+        }
+        if out, err := runGit("config", "--global", "--unset-all", s.Key, "^"+regexp.QuoteMeta(s.Value)+"$"); err != nil {
+            return fmt.Errorf("cannot remove %s from %s: %s", s.Key, globalConfigPath(), out)
+        }
+    }
+    for _, s := range want {
+        if contains(have, s) {
+            continue
+        }
+        fmt.Printf("+ %s path = %s\n", includeSection(s.Key), s.Value)
+        changes++
+        if dryRun {
+            continue
+        }
+        key := "includeIf." + strings.TrimPrefix(s.Key, "includeif.")
+        if out, err := runGit("config", "--global", "--add", key, s.Value); err != nil {
+            return fmt.Errorf("cannot add %s to %s: %s", s.Key, globalConfigPath(), out)
+        }
+    }
+    // Fragments of profiles that lost their rules or are gone.
+    stale, _ := filepath.Glob(filepath.Join(includesDir(), "*.gitconfig"))
+    for _, path := range stale {
+        if _, ok := fragments[path]; ok {
+            continue
+        }
+        data, err := os.ReadFile(path)
+        if err != nil || !strings.HasPrefix(string(data), "# gist profile ") {
+            continue
+        }
+        fmt.Printf("- %s\n", path)
+        changes++
+        if !dryRun {
+            if err := os.Remove(path); err != nil {
+                return err
+            }
+        }
+    }
+    switch {
+    case changes == 0:
+        fmt.Println("✔ includes are up to date")
+    case dryRun:
+        fmt.Printf("%d change(s) to make in %s and %s\n", changes, globalConfigPath(), includesDir())
+    }
+    return nil
+}
+
+// includesUsage is the usage of `gist includes`.
+var includesUsage = errors.New("usage: gist includes sync [--dry-run] | clear [--dry-run]")
+
+// commandIncludes runs the `includes` subcommands.
+func commandIncludes(cfg Config, args []string) error {
+    if len(args) == 0 || args[0] != "sync" && args[0] != "clear" {
+        return includesUsage
+    }
+    dryRun := false
+    for _, a := range args[1:] {
+        if a != "--dry-run" {
+            return includesUsage
+        }
+        dryRun = true
+    }
+    return commandIncludesSync(cfg, args[0] == "clear", dryRun)
+}
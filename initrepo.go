@@ -0,0 +1,83 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// templateHook is the body shared by the hooks installed into the git
+// template directory. It applies the rule-matched profile the first time a
+// repository without local identity is used.
+const templateHook = `#!/bin/sh
+# Installed by gist: apply the rule-matched identity on first use.
+git config --local user.email >/dev/null 2>&1 && exit 0
+%s set --auto >&2 || exit 0
+`
+
+// gistExecutable returns how hooks should invoke gist: its path, quoted
+// for sh (see shellQuote), which must not expand a $ or ` in it.
+func gistExecutable() string {
+    if exe, err := os.Executable(); err == nil {
+        return shellQuote(exe)
+    }
+    return "gist"
+}
+
+// commandInitRepo runs git init in dir and applies the profile selected by
+// the rules or the default profile.
+func commandInitRepo(cfg Config, dir string) error {
+    dir = expandHome(dir)
+    if out, err := runGit("init", dir); err != nil {
+        return fmt.Errorf("git init failed: %s", out)
+    }
+    // dir is relative to the -C directory, like git's own arguments.
+    if !filepath.IsAbs(dir) {
+        dir = filepath.Join(repoDir, dir)
+    }
+    repoDir = dir
+    name, err := resolveAutoProfile(cfg, true)
+    if err != nil {
+        return err
+    }
+    return commandSet(cfg, name)
+}
+
+// templateDir returns the git template directory hooks are installed into:
+// the one already configured in init.templateDir, or gist's own.
+func templateDir() (dir string, configured bool) {
+    if out, err := runGit("config", "--global", "--path", "init.templateDir"); err == nil && out != "" {
+        return out, true
+    }
+    return filepath.Join(filepath.Dir(getConfigPath()), "git-template"), false
+}
+
+// installTemplate writes the gist hooks into the git template directory and
+// points init.templateDir at it, so even plain `git init` and `git clone`
+// repositories pick up the right identity.
+func installTemplate() error {
+    dir, configured := templateDir()
+    hooks := filepath.Join(dir, "hooks")
+    if err := os.MkdirAll(hooks, 0o755); err != nil {
+        return err
+    }
+    body := fmt.Sprintf(templateHook, gistExecutable())
+    // pre-commit runs too late to change the identity of the commit being
+    // made, so it stops that commit and asks for a retry.
+    preCommit := strings.Replace(body, "|| exit 0\n",
+        "|| exit 0\necho \"gist: identity applied, please run the commit again\" >&2\nexit 1\n", 1)
+    for name, script := range map[string]string{"post-checkout": body, "pre-commit": preCommit} {
+        if err := writeHook(hooks, name, script); err != nil {
+            return err
+        }
+    }
+    if !configured {
+        if _, err := runGit("config", "--global", "init.templateDir", dir); err != nil {
+            return errors.New("failed to set init.templateDir")
+        }
+    }
+    fmt.Printf("✔️  Installed gist hooks into git template %s\n", dir)
+    return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// defaultKeyExpiry is the validity period of generated GPG keys.
+const defaultKeyExpiry = "2y"
+
+// interactive runs a command attached to the terminal, so tools such as
+// ssh-keygen and gpg can ask for passphrases.
+func interactive(name string, args ...string) error {
+    cmd := exec.Command(name, args...)
+    cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+    return cmd.Run()
+}
+
+// newSSHSigningKey generates an ed25519 key next to the old one (or in
+// ~/.ssh) and returns the path of its public half.
+func newSSHSigningKey(p *Profile) (string, error) {
+    dir := expandHome("~/.ssh")
+    if strings.ContainsAny(p.SigningKey, `/\`) {
+        dir = filepath.Dir(expandHome(p.SigningKey))
+    }
+    base := filepath.Join(dir, fmt.Sprintf("gist_%s_signing_%s", p.Name, time.Now().Format("20060102")))
+    if _, err := os.Stat(base); err == nil {
+        return "", fmt.Errorf("%s already exists", base)
+    }
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return "", err
+    }
+    if err := interactive("ssh-keygen", "-t", "ed25519", "-C", p.Email, "-f", base); err != nil {
+        return "", fmt.Errorf("ssh-keygen failed: %w", err)
+    }
+    return base + ".pub", nil
+}
+
+// newGPGSigningKey generates a signing-only GPG key for the profile and
+// returns its fingerprint.
+func newGPGSigningKey(p *Profile, expire string) (string, error) {
+    uid := fmt.Sprintf("%s <%s>", gitName(p), p.Email)
+    if err := interactive(getGPGPath(), "--quick-gen-key", uid, "ed25519", "sign", expire); err != nil {
+        return "", fmt.Errorf("gpg key generation failed: %w", err)
+    }
+    out, err := exec.Command(getGPGPath(), "--list-keys", "--with-colons", "<"+p.Email+">").Output()
+    if err != nil {
+        return "", errors.New("cannot find the generated key")
+    }
+    // The newest primary key is the one just generated.
+    var fpr string
+    var newest int64 = -1
+    pending := false
+    for _, line := range strings.Split(string(out), "\n") {
+        fields := strings.Split(line, ":")
+        switch {
+        case len(fields) > 5 && fields[0] == "pub":
+            created, _ := strconv.ParseInt(fields[5], 10, 64)
+            pending = created >= newest
+            if pending {
+                newest = created
+            }
+        case len(fields) > 9 && fields[0] == "fpr" && pending:
+            fpr, pending = fields[9], false
+        }
+    }
+    if fpr == "" {
+        return "", errors.New("cannot find the generated key")
+    }
+    return fpr, nil
+}
+
+// sshPublicKey returns the "type base64" part of a public key file.
+func sshPublicKey(path string) (string, error) {
+    data, err := os.ReadFile(expandHome(path))
+    if err != nil {
+        return "", err
+    }
+    fields := strings.Fields(string(data))
+    if len(fields) < 2 {
+        return "", fmt.Errorf("%s is not an SSH public key", path)
+    }
+    return fields[0] + " " + fields[1], nil
+}
+
+// allowedSignersFile returns the global gpg.ssh.allowedSignersFile, if set.
+func allowedSignersFile() string {
+    out, err := runGit("config", "--global", "--path", "gpg.ssh.allowedSignersFile")
+    if err != nil {
+        return ""
+    }
+    return expandHome(out)
+}
+
+// updateAllowedSigners trusts the new key for email and retires the old one:
+// it stays valid for signatures made before today, or is dropped entirely
+// when revoke is set.
+func updateAllowedSigners(path, email, oldKey, newKey string, revoke bool) error {
+    data, err := os.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    var lines []string
+    for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+        if line == "" {
+            continue
+        }
+        if oldKey == "" || !strings.Contains(line, oldKey) || strings.Contains(line, "valid-before=") {
+            lines = append(lines, line)
+            continue
+        }
+        if revoke {
+            continue
+        }
+        // principals [options] keytype key: the options come right after the
+        // principals, before the key.
+        principals, rest, _ := strings.Cut(line, " ")
+        option := fmt.Sprintf(`valid-before="%s"`, time.Now().Format("20060102"))
+        if strings.HasPrefix(rest, oldKey) {
+            line = principals + " " + option + " " + rest
+        } else {
+            opts, key, _ := strings.Cut(rest, " ")
+            line = principals + " " + opts + "," + option + " " + key
+        }
+        lines = append(lines, line)
+    }
+    lines = append(lines, fmt.Sprintf(`%s namespaces="git" %s`, email, newKey))
+    return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+}
+
+// uploadKey adds the new public key to every forge the hosts map assigns
+// to the profile. Failures only warn.
+func uploadKey(cfg Config, p *Profile, key string, ssh bool) {
+    title := fmt.Sprintf("gist %s signing %s", p.Name, time.Now().Format("2006-01-02"))
+    for _, host := range profileHosts(cfg, p) {
+        f := forgeFor(cfg, host)
+        if f == nil {
+            fmt.Printf("  ! upload the new key to %s manually\n", host)
+            continue
+        }
+        if err := f.UploadKey(p, key, title, ssh); err != nil {
+            fmt.Fprintf(os.Stderr, "warning: upload to %s failed: %v\n", host, err)
+            continue
+        }
+        fmt.Printf("  ✔ uploaded to %s\n", host)
+    }
+}
+
+// keyUser returns a profile that still uses rotated's old signing key: as
+// its signing key or, for an SSH key pair, as its ssh_key. nil means none
+// does.
+func keyUser(cfg Config, rotated *Profile, old string, ssh bool) *Profile {
+    base := strings.TrimSuffix(profilePath(rotated, old), ".pub")
+    for i := range cfg.Profiles {
+        p := &cfg.Profiles[i]
+        if !ssh {
+            if p.SigningKey == old {
+                return p
+            }
+            continue
+        }
+        signing := p.SigningKey
+        if !isSSHSigningKey(signing) {
+            signing = ""
+        }
+        for _, f := range []string{signing, p.SSHKey} {
+            if f != "" && strings.TrimSuffix(profilePath(p, f), ".pub") == base {
+                return p
+            }
+        }
+    }
+    return nil
+}
+
+// revokeOldKey retires p's previous key: SSH key files move to an archive
+// directory, GPG keys get a revocation certificate imported. A key another
+// profile (or p's ssh_key) still uses is left alone; it is only no longer
+// trusted for p's signatures.
+func revokeOldKey(cfg Config, p *Profile, old string, ssh bool) error {
+    if user := keyUser(cfg, p, old, ssh); user != nil {
+        fmt.Printf("  ! kept %s: profile %s still uses it\n", old, user.Name)
+        return nil
+    }
+    if !ssh {
+        cert := filepath.Join(os.TempDir(), "gist-revoke-"+strconv.Itoa(os.Getpid())+".asc")
+        defer os.Remove(cert)
+        if err := interactive(getGPGPath(), "--output", cert, "--gen-revoke", old); err != nil {
+            return fmt.Errorf("gpg --gen-revoke failed: %w", err)
+        }
+        return interactive(getGPGPath(), "--import", cert)
+    }
+    if strings.HasPrefix(old, "key::") || strings.HasPrefix(old, "ssh-") {
+        return nil
+    }
+    pub := profilePath(p, old)
+    archive := filepath.Join(filepath.Dir(pub), "archive")
+    if err := os.MkdirAll(archive, 0o700); err != nil {
+        return err
+    }
+    base := strings.TrimSuffix(pub, ".pub")
+    for _, f := range []string{pub, base, base + "-cert.pub"} {
+        if _, err := os.Stat(f); err != nil {
+            continue
+        }
+        if err := os.Rename(f, filepath.Join(archive, filepath.Base(f))); err != nil {
+            return err
+        }
+    }
+    fmt.Printf("  ✔ archived old key files in %s\n", archive)
+    return nil
+}
+
+// commandKeysRotate replaces a profile's signing key with a newly generated
+// one of the same kind, publishes it and retires the old key. It reports
+// whether the profile changed and the config needs saving.
+func commandKeysRotate(cfg *Config, profileName string, revoke, upload, force bool, expire string) (bool, error) {
+    p := findProfile(cfg, profileName)
+    if p == nil {
+        return false, fmt.Errorf("profile %s not found", profileName)
+    }
+    if err := checkUnlocked(p, force); err != nil {
+        return false, err
+    }
+    if raw, ok := p.raw["signingkey"]; ok {
+        return false, fmt.Errorf("the signing key of profile %s comes from %s; rotate it at the source", p.Name, raw)
+    }
+    old := p.SigningKey
+    ssh := old != "" && isSSHSigningKey(old)
+    var (
+        key string
+        err error
+    )
+    if ssh {
+        key, err = newSSHSigningKey(p)
+    } else {
+        key, err = newGPGSigningKey(p, expire)
+    }
+    if err != nil {
+        return false, err
+    }
+    p.SigningKey = key
+    fmt.Printf("✔️  Profile %s now signs with %s\n", p.Name, key)
+    if upload {
+        uploadKey(*cfg, p, key, ssh)
+    }
+    if ssh {
+        if path := allowedSignersFile(); path != "" {
+            oldKey, _ := sshPublicKey(old)
+            newKey, err := sshPublicKey(key)
+            if err == nil {
+                err = updateAllowedSigners(path, p.Email, oldKey, newKey, revoke)
+            }
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "warning: cannot update %s: %v\n", path, err)
+            } else {
+                fmt.Printf("  ✔ updated %s\n", path)
+            }
+        }
+    }
+    if revoke && old != "" {
+        if err := revokeOldKey(*cfg, p, old, ssh); err != nil {
+            fmt.Fprintf(os.Stderr, "warning: cannot revoke %s: %v\n", old, err)
+        }
+    }
+    fmt.Println("Run `gist set` (or `gist apply`) in repositories using this profile to switch them to the new key.")
+    return true, nil
+}
+
+// commandKeys runs the `keys` subcommands.
+func commandKeys(cfg *Config, args []string) (bool, error) {
+    usage := errors.New("usage: gist keys rotate [--revoke] [--no-upload] [--expire <period>] [--force] <profile>")
+    if len(args) == 0 || args[0] != "rotate" {
+        return false, usage
+    }
+    revoke, upload, force, expire := false, true, false, defaultKeyExpiry
+    var rest []string
+    for i := 1; i < len(args); i++ {
+        switch args[i] {
+        case "--revoke":
+            revoke = true
+        case "--no-upload":
+            upload = false
+        case "--force":
+            force = true
+        case "--expire":
+            if i+1 >= len(args) {
+                return false, errors.New("--expire requires a period such as 1y")
+            }
+            expire = args[i+1]
+            i++
+        default:
+            rest = append(rest, args[i])
+        }
+    }
+    if len(rest) != 1 {
+        return false, usage
+    }
+    return commandKeysRotate(cfg, rest[0], revoke, upload, force, expire)
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "reflect"
+    "time"
+)
+
+// `gist lsp-lite` is for editor extensions showing the active profile in
+// the status bar. It reads commands from stdin and writes events to
+// stdout, one JSON object per line:
+//
+//   → {"method":"addFolders","params":{"folders":["/src/app"]}}
+//   ← {"event":"status","folder":"/src/app","repository":true,"profile":"work",...}
+//
+// It watches the files an identity depends on – the repository's git
+// config and HEAD, the global git config, gist's config and the
+// repository's state record – and sends a folder's status again only when
+// it changes. While `gist serve` runs it also subscribes to the changes gist
+// broadcasts, so a `gist set` shows without waiting for the next look. It
+// exits on the shutdown command or when stdin closes.
+
+// defaultLSPInterval is how often lsp-lite looks for changed files.
+const defaultLSPInterval = 2 * time.Second
+
+type lspCommand struct {
+    Method string `json:"method"`
+    Params struct {
+        Folders []string `json:"folders"`
+    } `json:"params"`
+    // err is set instead for a line that isn't a command.
+    err error
+}
+
+// folderStatus is the status event of a workspace folder.
+type folderStatus struct {
+    Event      string `json:"event"`
+    Folder     string `json:"folder"`
+    Repository bool   `json:"repository"`
+    Root       string `json:"root,omitempty"`
+    // Profile is the profile of the identity git uses, Expected the one
+    // the pin, rules, hosts map or default select.
+    Profile  string   `json:"profile"`
+    Expected string   `json:"expected"`
+    Name     string   `json:"name"`
+    Email    string   `json:"email"`
+    Pinned   bool     `json:"pinned"`
+    OK       bool     `json:"ok"`
+    Problems []string `json:"problems"`
+}
+
+// lspFolder is a watched folder and what was last sent for it.
+type lspFolder struct {
+    stamps string
+    sent   *folderStatus
+}
+
+// fileStamp identifies a version of a file by its size and modification
+// time; a missing file has an empty stamp.
+func fileStamp(path string) string {
+    fi, err := os.Stat(path)
+    if err != nil {
+        return ""
+    }
+    return fmt.Sprintf("%d/%d", fi.Size(), fi.ModTime().UnixNano())
+}
+
+// folderStamps returns the stamps of the files the folder's status depends
+// on, with those of the config files and global git config in shared.
+func folderStamps(dir, shared string) string {
+    repoDir = dir
+    // A folder becomes a repository with git init or clone.
+    stamps := shared + fileStamp(filepath.Join(dir, ".git")) + " "
+    if inRepo, root := isGitRepo(); inRepo {
+        gitDir, _ := runGit("rev-parse", "--absolute-git-dir")
+        var common string
+        if gitPathFormat.supported() {
+            common, _ = runGit("rev-parse", "--path-format=absolute", "--git-common-dir")
+        } else if common, _ = runGit("rev-parse", "--git-common-dir"); !filepath.IsAbs(common) {
+            // Older git answers relative to the directory it runs in.
+            common = filepath.Join(dir, common)
+        }
+        stamps += fileStamp(filepath.Join(common, "config")) + " " +
+            fileStamp(filepath.Join(gitDir, "config.worktree")) + " " +
+            fileStamp(filepath.Join(gitDir, "HEAD")) + " " +
+            fileStamp(repoRecordPath(root))
+    }
+    return stamps
+}
+
+// sharedStamps returns the stamps of gist's config files and the global git
+// config.
+func sharedStamps(configPath string) string {
+    stamps := ""
+    files := append(configFiles(configPath), expandHome("~/.gitconfig"), expandHome("~/.config/git/config"))
+    if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+        files = append(files, global)
+    }
+    for _, file := range files {
+        stamps += fileStamp(file) + " "
+    }
+    return stamps
+}
+
+// lspStatus works out a folder's status.
+func lspStatus(cfg Config, dir string) folderStatus {
+    repoDir = dir
+    st := folderStatus{Event: "status", Folder: dir, Problems: []string{}}
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        st.OK = true
+        return st
+    }
+    res := scanRepo(cfg, dir)
+    st.Repository, st.Root = true, root
+    st.Profile, st.Expected, st.Email = res.Current, res.Want, res.Email
+    st.Name, _ = runGit("config", "user.name")
+    st.Pinned = res.Want != "" && pinnedProfile(root) == res.Want
+    if res.Problem != "" && res.Want != "" {
+        st.Problems = append(st.Problems, fmt.Sprintf("%s, should be %s", res.Problem, res.Want))
+    }
+    st.Problems = append(st.Problems, res.Violations...)
+    st.OK = len(st.Problems) == 0
+    return st
+}
+
+// subscribeChanges signals changes on the channel for as long as the
+// running server, if any, announces them.
+func subscribeChanges(changes chan<- struct{}) {
+    socket := runningServer()
+    if socket == "" {
+        return
+    }
+    conn, err := net.Dial("unix", socket)
+    if err != nil {
+        return
+    }
+    defer conn.Close()
+    if err := json.NewEncoder(conn).Encode(rpcRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: "subscribe"}); err != nil {
+        return
+    }
+    scanner := bufio.NewScanner(conn)
+    for scanner.Scan() {
+        var msg struct {
+            Method string `json:"method"`
+        }
+        if json.Unmarshal(scanner.Bytes(), &msg) == nil && msg.Method == "changed" {
+            select {
+            case changes <- struct{}{}:
+            default:
+            }
+        }
+    }
+}
+
+// commandLSPLite runs the stdio status protocol until shutdown.
+func commandLSPLite(configPath string, args []string) error {
+    interval := defaultLSPInterval
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--interval" && i+1 < len(args):
+            d, err := time.ParseDuration(args[i+1])
+            if err != nil || d <= 0 {
+                return fmt.Errorf("invalid --interval %q (e.g. 2s)", args[i+1])
+            }
+            interval = d
+            i++
+        default:
+            return errors.New("usage: gist lsp-lite [--interval <d>]")
+        }
+    }
+    os.Setenv("GIST_NONINTERACTIVE", "1")
+    enc := json.NewEncoder(os.Stdout)
+    send := func(event any) {
+        if err := enc.Encode(event); err != nil {
+            // The editor is gone.
+            os.Exit(0)
+        }
+    }
+    sendError := func(err error) {
+        send(map[string]string{"event": "error", "message": err.Error()})
+    }
+    commands := make(chan lspCommand)
+    go func() {
+        defer close(commands)
+        scanner := bufio.NewScanner(os.Stdin)
+        scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+        for scanner.Scan() {
+            if len(scanner.Bytes()) == 0 {
+                continue
+            }
+            var c lspCommand
+            if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+                c.err = fmt.Errorf("cannot parse command: %w", err)
+            }
+            commands <- c
+        }
+    }()
+    folders := map[string]*lspFolder{}
+    var order []string
+    var cfg Config
+    cfgStamp, cfgErr := "", error(nil)
+    // refresh sends the status of every folder whose files changed, or of
+    // every folder when force is set.
+    refresh := func(force bool) {
+        shared := sharedStamps(configPath)
+        if shared != cfgStamp {
+            cfgStamp = shared
+            var err error
+            if cfg, err = loadConfig(configPath); err != nil {
+                if cfgErr == nil || err.Error() != cfgErr.Error() {
+                    sendError(fmt.Errorf("cannot load config: %w", err))
+                }
+                cfgErr = err
+                return
+            }
+            setGitTimeouts(cfg.Timeouts)
+            cfgErr = nil
+        }
+        if cfgErr != nil {
+            return
+        }
+        for _, dir := range order {
+            f := folders[dir]
+            stamps := folderStamps(dir, shared)
+            if !force && stamps == f.stamps {
+                continue
+            }
+            f.stamps = stamps
+            st := lspStatus(cfg, dir)
+            if force || f.sent == nil || !reflect.DeepEqual(*f.sent, st) {
+                send(st)
+                f.sent = &st
+            }
+        }
+    }
+    changes := make(chan struct{}, 1)
+    go subscribeChanges(changes)
+    send(map[string]string{"event": "ready", "version": version})
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case c, ok := <-commands:
+            if !ok {
+                return nil
+            }
+            if c.err != nil {
+                sendError(c.err)
+                continue
+            }
+            switch c.Method {
+            case "addFolders":
+                for _, dir := range c.Params.Folders {
+                    dir, err := filepath.Abs(expandHome(dir))
+                    if err != nil {
+                        sendError(err)
+                        continue
+                    }
+                    if folders[dir] == nil {
+                        folders[dir] = &lspFolder{}
+                        order = append(order, dir)
+                    }
+                }
+                refresh(false)
+            case "removeFolders":
+                for _, dir := range c.Params.Folders {
+                    dir, _ := filepath.Abs(expandHome(dir))
+                    delete(folders, dir)
+                    for i, d := range order {
+                        if d == dir {
+                            order = append(order[:i], order[i+1:]...)
+                            break
+                        }
+                    }
+                }
+            case "refresh":
+                cfgStamp = ""
+                refresh(true)
+            case "shutdown":
+                return nil
+            default:
+                sendError(fmt.Errorf("unknown method %q", c.Method))
+            }
+        case <-ticker.C:
+            refresh(false)
+        case <-changes:
+            refresh(false)
+        }
+    }
+}
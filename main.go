@@ -8,7 +8,10 @@ import (
     "os"
     "os/exec"
     "path/filepath"
+    "sort"
+    "strconv"
     "strings"
+    "time"
 )
 
 // Version of the application.
@@ -19,12 +22,147 @@ type Profile struct {
     Name       string `yaml:"name"`
     Username   string `yaml:"username"`
     Email      string `yaml:"email"`
+    // AlsoEmails are earlier addresses of the same identity (an old company
+    // domain, a forge noreply address): commits made with them are not
+    // flagged by verify, audit or info --commits.
+    AlsoEmails []string `yaml:"also_emails,omitempty"`
+    // Transliterate set to "ascii" writes the username to git without
+    // diacritics (Dvořák becomes Dvorak); otherwise it is written in NFC.
+    // See normalize.go.
+    Transliterate string `yaml:"transliterate,omitempty"`
     SigningKey string `yaml:"signingkey,omitempty"`
+    SSHKey     string `yaml:"ssh_key,omitempty"`
+    // SigningFormat sets gpg.format (openpgp, ssh, x509) or, for gitsign,
+    // configures sigstore's keyless signing; see gitsign.go.
+    SigningFormat string `yaml:"signing_format,omitempty"`
+    // AWSProfile is the AWS CLI profile whose credentials CodeCommit
+    // remotes use; see hosting.go.
+    AWSProfile string `yaml:"aws_profile,omitempty"`
+    // CommitTemplate is a commit message template file (commit.template).
+    CommitTemplate string `yaml:"commit_template,omitempty"`
+    // SignOff adds Signed-off-by trailers to format-patch (format.signOff).
+    SignOff bool `yaml:"signoff,omitempty"`
+    // RequireSignOff makes verify and the commit-msg hook demand a DCO
+    // Signed-off-by trailer matching the identity.
+    RequireSignOff bool `yaml:"require_signoff,omitempty"`
+    // TicketPattern is a regular expression finding a ticket id (e.g.
+    // "[A-Z]+-[0-9]+") in branch names; commit messages get it as a prefix.
+    TicketPattern string `yaml:"ticket_pattern,omitempty"`
+    // HTTPProxy, SSLCAInfo and HTTPExtraHeader set http.proxy,
+    // http.sslCAInfo and http.extraHeader for corporate networks.
+    HTTPProxy       string `yaml:"http_proxy,omitempty"`
+    SSLCAInfo       string `yaml:"ssl_ca_info,omitempty"`
+    HTTPExtraHeader string `yaml:"http_extra_header,omitempty"`
+    // LFSURL and LFSAccess point git LFS at a mirror (lfs.url) and set how
+    // it authenticates (lfs.<url>.access, e.g. basic or negotiate).
+    LFSURL    string `yaml:"lfs_url,omitempty"`
+    LFSAccess string `yaml:"lfs_access,omitempty"`
+    // PartialCloneFilter makes origin a promisor remote fetched with this
+    // filter (remote.origin.partialclonefilter), e.g. "blob:none".
+    PartialCloneFilter string `yaml:"partial_clone_filter,omitempty"`
+    // RemoteProtocol is the protocol (ssh or https) `gist remotes fix`
+    // rewrites remotes to; SSHHostAlias is the ssh_config host to use for
+    // SSH remotes, with %h standing for the real host (e.g. "%h-work").
+    RemoteProtocol string `yaml:"remote_protocol,omitempty"`
+    SSHHostAlias   string `yaml:"ssh_host_alias,omitempty"`
+    // CredentialUsername and CredentialToken are what `gist credential`
+    // answers git with for HTTPS remotes the profile is selected for. The
+    // token is kept unresolved until git asks for it; see credential.go.
+    CredentialUsername string `yaml:"credential_username,omitempty"`
+    CredentialToken    string `yaml:"credential_token,omitempty"`
+    // GitHubAppID and GitHubAppKey (the App's private key file) let a bot
+    // profile mint short-lived GitHub App installation tokens instead of
+    // storing a credential_token; GitHubAppInstallation is looked up when
+    // unset. See githubapp.go.
+    GitHubAppID           string `yaml:"github_app_id,omitempty"`
+    GitHubAppInstallation string `yaml:"github_app_installation,omitempty"`
+    GitHubAppKey          string `yaml:"github_app_key,omitempty"`
+    // Bot profiles are machine identities for CI: `gist exec` runs them
+    // with their credential_token as the only credential and with every
+    // prompt disabled; see bot.go.
+    Bot bool `yaml:"bot,omitempty"`
+    // Locked profiles cannot be edited or removed from the CLI without
+    // --force, protecting mandated identities.
+    Locked bool `yaml:"locked,omitempty"`
+    // Env holds extra environment variables for `gist exec`.
+    Env map[string]string `yaml:"env,omitempty"`
+    // KnownHosts pins the host keys of the profile's SSH hosts: host names
+    // mapped to a public key ("ssh-ed25519 AAAA...") or its SHA256
+    // fingerprint. See hostkeys.go.
+    KnownHosts map[string]string `yaml:"known_hosts,omitempty"`
+    // Maintenance holds the profile's maintenance.*, gc.* and fetch.prune
+    // git settings, e.g. aggressive prefetching for work repositories.
+    Maintenance map[string]string `yaml:"maintenance,omitempty"`
+    // Source is the included file a profile comes from; empty for the
+    // config's own profiles. Included profiles are never saved.
+    Source string `yaml:"-"`
+    // dir is the directory of the included file defining the profile,
+    // which its relative paths are resolved against; see profilePath.
+    dir string
+    // raw keeps resolver expressions (e.g. "!env WORK_EMAIL") by key so the
+    // config is saved with them rather than the resolved values.
+    raw map[string]string
+    // quotes records how the file quoted each value – by key, by
+    // "<map>.<key>" for env, known_hosts and maintenance entries and by
+    // "also_emails.<email>" for those – so saving keeps it; see yamlScalar.
+    quotes map[string]string
 }
 
-// Config holds all profiles.
+// Config holds all profiles and the rules that select between them.
 type Config struct {
-    Profiles []Profile `yaml:"profiles"`
+    // Version is the schema version; see migrate.go.
+    Version int `yaml:"version"`
+    // DefaultProfile is applied by `set --auto` when no rule matches.
+    DefaultProfile string    `yaml:"default_profile,omitempty"`
+    Profiles       []Profile `yaml:"profiles"`
+    Rules          []Rule    `yaml:"rules,omitempty"`
+    // Include lists files whose profiles and rules are merged in; see
+    // include.go.
+    Include []string `yaml:"include,omitempty"`
+    // Hosts maps remote hosts to profiles, a shorthand for URL rules that
+    // applies when no rule matches.
+    Hosts map[string]string `yaml:"hosts,omitempty"`
+    // Forges maps self-hosted hosts to their forge kind (github, gitlab,
+    // gitea, forgejo, bitbucket) where the host name doesn't tell; see forge.go.
+    Forges map[string]string `yaml:"forges,omitempty"`
+    // Scan configures repository traversal for scan and apply.
+    Scan ScanConfig `yaml:"scan,omitempty"`
+    // Timeouts bounds the git commands gist runs, by git subcommand or
+    // "default"; see timeout.go.
+    Timeouts map[string]string `yaml:"timeouts,omitempty"`
+    // Hooks maps lifecycle events (see events.go) to user scripts.
+    Hooks map[string]string `yaml:"hooks,omitempty"`
+    // Signers are teammates' SSH signing keys added to allowed_signers.
+    Signers []Signer `yaml:"signers,omitempty"`
+    // Trash holds removed profiles until they are restored or purged.
+    Trash []TrashedProfile `yaml:"trash,omitempty"`
+    // Policy is the installed organisation policy; it is kept in its own
+    // file and never saved with the config.
+    Policy *Policy `yaml:"-"`
+    // StrictEnv makes a ${VAR} reference to an unset variable without a
+    // default an error instead of expanding to nothing.
+    StrictEnv bool `yaml:"strict_env,omitempty"`
+    // Strict makes unknown keys, duplicate profile names and unparsable
+    // lines errors instead of being ignored; see configedit.go.
+    Strict bool `yaml:"strict,omitempty"`
+    // SortProfiles is the order profiles are saved in: "insertion" (the
+    // default) or "alphabetical".
+    SortProfiles string `yaml:"sort_profiles,omitempty"`
+    // Log records every change gist makes in a JSON-lines log; see
+    // oplog.go.
+    Log bool `yaml:"log,omitempty"`
+    // Offline keeps gist off the network, like --offline; see offline.go.
+    Offline bool `yaml:"offline,omitempty"`
+    // UsageStats counts the commands run in a local file for
+    // `stats usage`; see usage.go.
+    UsageStats bool `yaml:"usage_stats,omitempty"`
+    // source is the included file being parsed, recorded on its profiles.
+    source string
+    // undefined lists the unset variables profiles and rules reference.
+    undefined []string
+    // included holds the included profiles as loaded, by name, so saving
+    // can refuse changes that would be lost.
+    included map[string]Profile
 }
 
 // getConfigPath returns the path to the configuration file.
@@ -42,6 +180,65 @@ func getConfigPath() string {
     return filepath.Join(home, ".config", "gist", "config.yaml")
 }
 
+// stdin is shared by every prompt so that answers piped in together are
+// not swallowed by one prompt's buffering.
+var stdin = bufio.NewReader(os.Stdin)
+
+// repoDir is the directory git commands run in; empty means the current
+// directory. It is set by the global -C/--path option.
+var repoDir string
+
+// globalArgs returns how many of args are global options. They come before
+// the command: what follows it, or a "--", is the command's own even where
+// it reads like -C or --isolated.
+func globalArgs(args []string) int {
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--" || !strings.HasPrefix(args[i], "-"):
+            return i
+        case args[i] == "-C" || args[i] == "--path" || args[i] == "--isolated":
+            i++
+        }
+    }
+    return len(args)
+}
+
+// extractPathFlag removes -C <dir>, --path <dir> and --path=<dir> from the
+// global options and returns the remaining arguments along with the
+// requested directory.
+func extractPathFlag(args []string) ([]string, string, error) {
+    var rest []string
+    dir := ""
+    n := globalArgs(args)
+    for i := 0; i < n; i++ {
+        switch {
+        case args[i] == "-C" || args[i] == "--path":
+            if i+1 >= len(args) {
+                return nil, "", fmt.Errorf("%s requires a directory", args[i])
+            }
+            dir = args[i+1]
+            i++
+        case strings.HasPrefix(args[i], "--path="):
+            dir = strings.TrimPrefix(args[i], "--path=")
+        default:
+            rest = append(rest, args[i])
+        }
+    }
+    rest = append(rest, args[n:]...)
+    if dir == "" {
+        return rest, "", nil
+    }
+    dir = expandHome(dir)
+    info, err := os.Stat(dir)
+    if err != nil {
+        return nil, "", err
+    }
+    if !info.IsDir() {
+        return nil, "", fmt.Errorf("cannot use %s: not a directory", dir)
+    }
+    return rest, dir, nil
+}
+
 // getGitPath returns the git executable path.
 func getGitPath() string {
     if env := os.Getenv("GIST_GIT_PATH"); env != "" {
@@ -55,8 +252,17 @@ func getGitPath() string {
 
 // runGit runs a git command and returns trimmed stdout.
 func runGit(args ...string) (string, error) {
-    cmd := exec.Command(getGitPath(), args...)
+    return logGit(args, func() (string, error) { return execGit(args) })
+}
+
+// execGit runs a git command for runGit.
+func execGit(args []string) (string, error) {
+    cmd, done := gitCommand(args)
+    cmd.Dir = repoDir
     out, err := cmd.Output()
+    if timedOut := done(err); timedOut != nil {
+        return timedOut.Error(), timedOut
+    }
     if err != nil {
         // If git writes to stderr (e.g., when key not found), capture that.
         if ee, ok := err.(*exec.ExitError); ok {
@@ -67,19 +273,72 @@ func runGit(args ...string) (string, error) {
     return strings.TrimSpace(string(out)), nil
 }
 
-// isGitRepo checks if the current directory is inside a git repository.
+// sshCommand builds the core.sshCommand value that forces the given key.
+func sshCommand(keyPath string) string {
+    return fmt.Sprintf("ssh -i %q -o IdentitiesOnly=yes", keyPath)
+}
+
+// isGitRepo checks if the current directory is inside a git repository and
+// returns its root: the work tree top level, or the git directory itself for
+// bare repositories. GIT_DIR and GIT_WORK_TREE are honored since git inherits
+// our environment.
 func isGitRepo() (bool, string) {
-    out, err := runGit("rev-parse", "--show-toplevel")
+    out, err := runGit("rev-parse", "--is-bare-repository", "--absolute-git-dir")
     if err != nil {
         return false, ""
     }
-    return true, out
+    lines := strings.SplitN(out, "\n", 2)
+    if len(lines) != 2 {
+        return false, ""
+    }
+    gitDir := strings.TrimSpace(lines[1])
+    if lines[0] == "true" {
+        return true, gitDir
+    }
+    top, err := runGit("rev-parse", "--show-toplevel")
+    if err != nil {
+        // No work tree (e.g. GIT_DIR without GIT_WORK_TREE, or cwd inside
+        // .git): the git directory is the best root we have.
+        return true, gitDir
+    }
+    return true, top
+}
+
+// stripComment removes a trailing comment from a config line: a # at the
+// start of the line or after whitespace, outside quoted scalars.
+func stripComment(line string) string {
+    var quote, prev byte
+    for i := 0; i < len(line); i++ {
+        c := line[i]
+        switch {
+        case quote == '"' && c == '\\':
+            i++
+        case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+            // '' is a quote inside single quotes.
+            i++
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '"' || c == '\'':
+            // Quotes only matter where a scalar starts.
+            if prev == 0 || strings.IndexByte(":-[,{", prev) >= 0 {
+                quote = c
+            }
+        case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+            return strings.TrimRight(line[:i], " \t")
+        }
+        if c != ' ' && c != '\t' {
+            prev = c
+        }
+    }
+    return line
 }
 
 // parseKeyValue parses a line like "key: value" (optionally prefixed with "-").
 func parseKeyValue(line string) (key, value string, ok bool) {
     // Remove any leading dash.
-    line = strings.TrimSpace(line)
+    line = strings.TrimSpace(stripComment(line))
     if strings.HasPrefix(line, "-") {
         // Remove leading dash and any following spaces.
         line = strings.TrimPrefix(line, "-")
@@ -96,70 +355,551 @@ func parseKeyValue(line string) (key, value string, ok bool) {
     return key, value, true
 }
 
-// loadConfig reads the configuration file.
+// loadConfig reads the configuration file and the files it includes.
 func loadConfig(path string) (Config, error) {
     var cfg Config
     data, err := os.ReadFile(path)
     if err != nil {
         return cfg, err
     }
+    parseConfig(&cfg, data)
+    if err := strictCheck(&cfg, path, data); err != nil {
+        return cfg, err
+    }
+    if err := migrateConfig(path, &cfg, data); err != nil {
+        return cfg, err
+    }
+    if err := loadIncludes(path, cfg.Include, &cfg, map[string]bool{}); err != nil {
+        return cfg, err
+    }
+    if cfg.StrictEnv && len(cfg.undefined) > 0 {
+        return cfg, fmt.Errorf("undefined environment variables (strict_env): %s", strings.Join(cfg.undefined, ", "))
+    }
+    if err := loadPolicy(path, &cfg); err != nil {
+        return cfg, err
+    }
+    return cfg, nil
+}
+
+// parseConfig parses a configuration file into cfg.
+func parseConfig(cfg *Config, data []byte) {
     lines := strings.Split(string(data), "\n")
+    // Entries without a section header are treated as profiles.
+    section := "profiles"
     var current *Profile
+    var rule *Rule
+    var trashed *TrashedProfile
+    // mapKey names the nested map (e.g. a profile's env) that lines indented
+    // deeper than mapIndent belong to.
+    mapKey, mapIndent := "", 0
     for _, line := range lines {
+        line = stripComment(line)
         trimmed := strings.TrimSpace(line)
         if trimmed == "" || strings.HasPrefix(trimmed, "#") {
             continue
         }
-        if strings.HasPrefix(trimmed, "profiles:") {
+        // An unindented "key:" line opens a new top-level section.
+        if line[0] != ' ' && line[0] != '\t' && !strings.HasPrefix(trimmed, "-") {
+            if key, value, ok := parseKeyValue(trimmed); ok {
+                if value == "" {
+                    section = key
+                } else {
+                    loadTopLevelKey(cfg, key, value)
+                }
+                continue
+            }
+        }
+        // include, scan.exclude and also_emails are lists of bare values,
+        // not keys.
+        if section == "include" && strings.HasPrefix(trimmed, "-") {
+            cfg.Include = append(cfg.Include, strings.Trim(strings.TrimSpace(trimmed[1:]), "\"'"))
+            continue
+        }
+        indent := len(line) - len(strings.TrimLeft(line, " \t"))
+        if mapKey == "also_emails" && strings.HasPrefix(trimmed, "-") && indent >= mapIndent {
+            email := strings.Trim(strings.TrimSpace(trimmed[1:]), "\"'")
+            if section == "profiles" && current != nil {
+                current.AlsoEmails = append(current.AlsoEmails, email)
+                noteQuote(&current.quotes, "also_emails."+email, trimmed[1:])
+            } else if section == "trash" && trashed != nil {
+                trashed.AlsoEmails = append(trashed.AlsoEmails, email)
+                noteQuote(&trashed.quotes, "also_emails."+email, trimmed[1:])
+            }
+            continue
+        }
+        if section == "scan" && mapKey == "exclude" && strings.HasPrefix(trimmed, "-") {
+            cfg.Scan.Exclude = append(cfg.Scan.Exclude, strings.Trim(strings.TrimSpace(trimmed[1:]), "\"'"))
             continue
         }
         key, value, ok := parseKeyValue(line)
         if !ok {
             continue
         }
-        switch key {
-        case "name":
-            // start a new profile
-            p := Profile{Name: value}
-            cfg.Profiles = append(cfg.Profiles, p)
-            // set pointer to the newly added profile
-            current = &cfg.Profiles[len(cfg.Profiles)-1]
-        case "username":
-            if current != nil {
-                current.Username = value
-            }
-        case "email":
-            if current != nil {
-                current.Email = value
-            }
-        case "signingkey":
-            if current != nil {
-                current.SigningKey = value
+        _, raw, _ := strings.Cut(trimmed, ":")
+        if mapKey != "" && indent > mapIndent {
+            if section == "profiles" && current != nil {
+                setProfileMapEntry(current, mapKey, key, value, raw)
+            } else if section == "trash" && trashed != nil {
+                setProfileMapEntry(&trashed.Profile, mapKey, key, value, raw)
+            }
+            continue
+        }
+        mapKey = ""
+        if strings.HasSuffix(trimmed, ":") && !strings.HasPrefix(trimmed, "-") {
+            mapKey, mapIndent = key, indent
+            continue
+        }
+        if section == "profiles" || section == "rules" {
+            cfg.undefined = append(cfg.undefined, undefinedVars(value)...)
+        }
+        switch section {
+        case "profiles":
+            if current = loadProfileKey(cfg, current, key, value); current != nil {
+                noteQuote(&current.quotes, key, raw)
+            }
+        case "rules":
+            // Each list item starts a new rule.
+            if strings.HasPrefix(trimmed, "-") {
+                cfg.Rules = append(cfg.Rules, Rule{})
+                rule = &cfg.Rules[len(cfg.Rules)-1]
+            }
+            if rule != nil {
+                loadRuleKey(rule, key, value)
+                noteQuote(&rule.quotes, key, raw)
+            }
+        case "trash":
+            if trashed = loadTrashKey(cfg, trashed, key, value); trashed != nil {
+                noteQuote(&trashed.quotes, key, raw)
+            }
+        case "hosts":
+            if cfg.Hosts == nil {
+                cfg.Hosts = map[string]string{}
+            }
+            cfg.Hosts[strings.ToLower(key)] = value
+        case "forges":
+            if cfg.Forges == nil {
+                cfg.Forges = map[string]string{}
+            }
+            cfg.Forges[strings.ToLower(key)] = strings.ToLower(value)
+        case "timeouts":
+            if cfg.Timeouts == nil {
+                cfg.Timeouts = map[string]string{}
             }
+            cfg.Timeouts[key] = value
+        case "hooks":
+            if cfg.Hooks == nil {
+                cfg.Hooks = map[string]string{}
+            }
+            cfg.Hooks[key] = value
+        case "signers":
+            loadSignerKey(cfg, trimmed, key, value)
         default:
-            // ignore unknown keys
+            // ignore unknown sections
         }
     }
-    return cfg, nil
 }
 
-// saveConfig writes the configuration file.
+// loadTopLevelKey applies a top-level scalar setting.
+func loadTopLevelKey(cfg *Config, key, value string) {
+    switch key {
+    case "version":
+        if n, err := strconv.Atoi(value); err == nil {
+            cfg.Version = n
+        }
+    case "default_profile":
+        cfg.DefaultProfile = value
+    case "strict_env":
+        cfg.StrictEnv = value == "true"
+    case "strict":
+        cfg.Strict = value == "true"
+    case "sort_profiles":
+        cfg.SortProfiles = value
+    case "log":
+        cfg.Log = value == "true"
+    case "offline":
+        cfg.Offline = value == "true"
+    case "usage_stats":
+        cfg.UsageStats = value == "true"
+    case "include":
+        // include: [a.yaml, b.yaml]
+        for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+            if item = strings.Trim(strings.TrimSpace(item), "\"'"); item != "" {
+                cfg.Include = append(cfg.Include, item)
+            }
+        }
+    default:
+        // ignore unknown keys
+    }
+}
+
+// loadProfileKey applies a single profile key to the config and returns the
+// profile subsequent keys belong to.
+func loadProfileKey(cfg *Config, current *Profile, key, value string) *Profile {
+    switch key {
+    case "name":
+        // start a new profile
+        p := Profile{Name: value, Source: cfg.source}
+        cfg.Profiles = append(cfg.Profiles, p)
+        // set pointer to the newly added profile
+        return &cfg.Profiles[len(cfg.Profiles)-1]
+    default:
+        if current != nil {
+            setProfileField(current, key, value)
+        }
+    }
+    return current
+}
+
+// setProfileField applies a profile key other than name. Values starting
+// with "!" are resolver expressions and ${VAR} references are expanded from
+// the environment; both are resolved here, except in credential_token.
+func setProfileField(p *Profile, key, value string) {
+    if strings.HasPrefix(value, "!") || strings.Contains(value, "${") {
+        if p.raw == nil {
+            p.raw = map[string]string{}
+        }
+        p.raw[key] = value
+    }
+    if key == "credential_token" {
+        p.CredentialToken = value
+        return
+    }
+    if strings.HasPrefix(value, "!") {
+        resolved, err := resolveValue(value)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "warning: %s: cannot resolve %s: %v\n", profileLabel(p), key, err)
+        }
+        value = resolved
+    } else if strings.Contains(value, "${") {
+        value = interpolate(value)
+    }
+    switch key {
+    case "username":
+        p.Username = value
+    case "email":
+        p.Email = value
+    case "also_emails":
+        // also_emails: [old@corp.com, 123+jane@users.noreply.github.com]
+        for _, item := range strings.Split(strings.Trim(value, "[]"), ",") {
+            if item = strings.Trim(strings.TrimSpace(item), "\"'"); item != "" {
+                p.AlsoEmails = append(p.AlsoEmails, item)
+            }
+        }
+    case "signingkey":
+        p.SigningKey = value
+    case "ssh_key":
+        p.SSHKey = value
+    case "signing_format":
+        p.SigningFormat = value
+    case "aws_profile":
+        p.AWSProfile = value
+    case "commit_template":
+        p.CommitTemplate = value
+    case "signoff":
+        p.SignOff = value == "true"
+    case "require_signoff":
+        p.RequireSignOff = value == "true"
+    case "ticket_pattern":
+        p.TicketPattern = value
+    case "http_proxy":
+        p.HTTPProxy = value
+    case "ssl_ca_info":
+        p.SSLCAInfo = value
+    case "http_extra_header":
+        p.HTTPExtraHeader = value
+    case "lfs_url":
+        p.LFSURL = value
+    case "lfs_access":
+        p.LFSAccess = value
+    case "partial_clone_filter":
+        p.PartialCloneFilter = value
+    case "transliterate":
+        p.Transliterate = value
+    case "remote_protocol":
+        p.RemoteProtocol = value
+    case "ssh_host_alias":
+        p.SSHHostAlias = value
+    case "credential_username":
+        p.CredentialUsername = value
+    case "github_app_id":
+        p.GitHubAppID = value
+    case "github_app_installation":
+        p.GitHubAppInstallation = value
+    case "github_app_key":
+        p.GitHubAppKey = value
+    case "bot":
+        p.Bot = value == "true"
+    case "locked":
+        p.Locked = value == "true"
+    default:
+        // ignore unknown keys
+    }
+}
+
+// setProfileMapEntry applies an entry of a nested profile map.
+func setProfileMapEntry(p *Profile, mapKey, key, value, raw string) {
+    switch mapKey {
+    case "env":
+        if p.Env == nil {
+            p.Env = map[string]string{}
+        }
+        p.Env[key] = value
+    case "known_hosts":
+        if p.KnownHosts == nil {
+            p.KnownHosts = map[string]string{}
+        }
+        key = strings.ToLower(key)
+        p.KnownHosts[key] = value
+    case "maintenance":
+        if p.Maintenance == nil {
+            p.Maintenance = map[string]string{}
+        }
+        p.Maintenance[key] = value
+    default:
+        // ignore unknown maps
+        return
+    }
+    noteQuote(&p.quotes, mapKey+"."+key, raw)
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]string) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// writeMap serializes a nested map with sorted keys, quoted as the file had
+// them (see Profile.quotes).
+func writeMap(sb *strings.Builder, name string, m map[string]string, quotes map[string]string) {
+    if len(m) == 0 {
+        return
+    }
+    sb.WriteString("    " + name + ":\n")
+    for _, k := range sortedKeys(m) {
+        sb.WriteString("      " + k + ": " + yamlScalar(m[k], quoteStyle(quotes, name+"."+k)) + "\n")
+    }
+}
+
+// noteQuote records in quotes how the raw value of key on a config line was
+// quoted: its quote character, or "" when bare.
+func noteQuote(quotes *map[string]string, key, raw string) {
+    raw = strings.TrimSpace(raw)
+    if raw == "" {
+        return
+    }
+    quote := ""
+    if raw[0] == '"' || raw[0] == '\'' {
+        quote = raw[:1]
+    }
+    if *quotes == nil {
+        *quotes = map[string]string{}
+    }
+    (*quotes)[key] = quote
+}
+
+// quoteStyle returns how key's value is quoted when saved: as the file had
+// it, double-quoted when it is new.
+func quoteStyle(quotes map[string]string, key string) string {
+    if quote, ok := quotes[key]; ok {
+        return quote
+    }
+    return "\""
+}
+
+// yamlScalar writes a value the way the file had it (quote is "" for bare,
+// or the quote character): bare or single-quoted if it reads back unchanged
+// that way, double-quoted otherwise. Keeping the user's quoting keeps saves
+// from rewriting lines they didn't change.
+func yamlScalar(value, quote string) string {
+    unquoted := value != "" && value == strings.TrimSpace(value) && value == strings.Trim(value, "\"'")
+    switch {
+    case quote == "" && unquoted && !strings.ContainsAny(value[:1], "!&*[]{}|>@`%#,?-") && !strings.Contains(value, " #"):
+        return value
+    case quote == "'" && unquoted && !strings.Contains(value, "'"):
+        return "'" + value + "'"
+    }
+    return "\"" + value + "\""
+}
+
+// writeProfile serializes a profile as a YAML list item. Fields loaded from
+// a resolver expression are written back as that expression, never as the
+// resolved value.
+func writeProfile(sb *strings.Builder, p Profile) {
+    field := func(key, value string, always bool) {
+        if raw, ok := p.raw[key]; ok {
+            sb.WriteString("    " + key + ": " + raw + "\n")
+        } else if value != "" || always {
+            sb.WriteString("    " + key + ": " + yamlScalar(value, quoteStyle(p.quotes, key)) + "\n")
+        }
+    }
+    sb.WriteString("  - name: " + p.Name + "\n")
+    field("username", p.Username, true)
+    field("email", p.Email, true)
+    if len(p.AlsoEmails) > 0 {
+        sb.WriteString("    also_emails:\n")
+        for _, email := range p.AlsoEmails {
+            sb.WriteString("      - " + yamlScalar(email, quoteStyle(p.quotes, "also_emails."+email)) + "\n")
+        }
+    }
+    field("transliterate", p.Transliterate, false)
+    field("signingkey", p.SigningKey, false)
+    field("ssh_key", p.SSHKey, false)
+    field("signing_format", p.SigningFormat, false)
+    field("aws_profile", p.AWSProfile, false)
+    field("commit_template", p.CommitTemplate, false)
+    if p.SignOff {
+        sb.WriteString("    signoff: true\n")
+    }
+    if p.RequireSignOff {
+        sb.WriteString("    require_signoff: true\n")
+    }
+    field("ticket_pattern", p.TicketPattern, false)
+    field("http_proxy", p.HTTPProxy, false)
+    field("ssl_ca_info", p.SSLCAInfo, false)
+    field("http_extra_header", p.HTTPExtraHeader, false)
+    field("lfs_url", p.LFSURL, false)
+    field("lfs_access", p.LFSAccess, false)
+    field("partial_clone_filter", p.PartialCloneFilter, false)
+    field("remote_protocol", p.RemoteProtocol, false)
+    field("ssh_host_alias", p.SSHHostAlias, false)
+    field("credential_username", p.CredentialUsername, false)
+    field("credential_token", p.CredentialToken, false)
+    field("github_app_id", p.GitHubAppID, false)
+    field("github_app_installation", p.GitHubAppInstallation, false)
+    field("github_app_key", p.GitHubAppKey, false)
+    if p.Bot {
+        sb.WriteString("    bot: true\n")
+    }
+    if p.Locked {
+        sb.WriteString("    locked: true\n")
+    }
+    writeMap(sb, "env", p.Env, p.quotes)
+    writeMap(sb, "known_hosts", p.KnownHosts, p.quotes)
+    writeMap(sb, "maintenance", p.Maintenance, p.quotes)
+}
+
+// saveConfig writes the configuration file, backing up the previous version.
 func saveConfig(path string, cfg Config) error {
+    if err := checkIncluded(cfg); err != nil {
+        return err
+    }
     dir := filepath.Dir(path)
     if err := os.MkdirAll(dir, 0o755); err != nil {
         return err
     }
+    if err := backupConfig(path); err != nil {
+        return fmt.Errorf("failed to back up config: %w", err)
+    }
     var sb strings.Builder
+    sb.WriteString("version: " + strconv.Itoa(configVersion) + "\n")
+    if cfg.DefaultProfile != "" {
+        sb.WriteString("default_profile: " + cfg.DefaultProfile + "\n")
+    }
+    if cfg.StrictEnv {
+        sb.WriteString("strict_env: true\n")
+    }
+    if cfg.Strict {
+        sb.WriteString("strict: true\n")
+    }
+    if cfg.SortProfiles != "" {
+        sb.WriteString("sort_profiles: " + cfg.SortProfiles + "\n")
+    }
+    if cfg.Log {
+        sb.WriteString("log: true\n")
+    }
+    if cfg.Offline {
+        sb.WriteString("offline: true\n")
+    }
+    if cfg.UsageStats {
+        sb.WriteString("usage_stats: true\n")
+    }
+    if len(cfg.Include) > 0 {
+        sb.WriteString("include:\n")
+        for _, file := range cfg.Include {
+            sb.WriteString("  - \"" + file + "\"\n")
+        }
+    }
     sb.WriteString("profiles:\n")
+    for _, p := range savedProfiles(cfg) {
+        writeProfile(&sb, p)
+    }
+    var own []Rule
+    for _, r := range cfg.Rules {
+        if r.Source == "" {
+            own = append(own, r)
+        }
+    }
+    if len(own) > 0 {
+        sb.WriteString("rules:\n")
+        for _, r := range own {
+            writeRule(&sb, r)
+        }
+    }
+    if len(cfg.Hosts) > 0 {
+        sb.WriteString("hosts:\n")
+        for _, host := range sortedKeys(cfg.Hosts) {
+            sb.WriteString("  " + host + ": " + cfg.Hosts[host] + "\n")
+        }
+    }
+    if len(cfg.Forges) > 0 {
+        sb.WriteString("forges:\n")
+        for _, host := range sortedKeys(cfg.Forges) {
+            sb.WriteString("  " + host + ": " + cfg.Forges[host] + "\n")
+        }
+    }
+    if len(cfg.Scan.Exclude) > 0 {
+        sb.WriteString("scan:\n  exclude:\n")
+        for _, pattern := range cfg.Scan.Exclude {
+            sb.WriteString("    - \"" + pattern + "\"\n")
+        }
+    }
+    if len(cfg.Hooks) > 0 {
+        sb.WriteString("hooks:\n")
+        for _, event := range sortedKeys(cfg.Hooks) {
+            sb.WriteString("  " + event + ": \"" + cfg.Hooks[event] + "\"\n")
+        }
+    }
+    if len(cfg.Timeouts) > 0 {
+        sb.WriteString("timeouts:\n")
+        for _, name := range sortedKeys(cfg.Timeouts) {
+            sb.WriteString("  " + name + ": " + cfg.Timeouts[name] + "\n")
+        }
+    }
+    writeSigners(&sb, cfg.Signers)
+    writeTrash(&sb, purgeTrash(cfg.Trash, time.Now()))
+    before, _ := os.ReadFile(path)
+    if err := os.WriteFile(path, []byte(sb.String()), 0o644); err != nil {
+        return err
+    }
+    logConfigSave(before, []byte(sb.String()))
+    return nil
+}
+
+// savedProfiles returns the config's own profiles in the order they are
+// saved: as they were added, or sorted by name with sort_profiles:
+// alphabetical, so the config diffs cleanly whatever order they are added
+// in.
+func savedProfiles(cfg Config) []Profile {
+    var own []Profile
     for _, p := range cfg.Profiles {
-        sb.WriteString("  - name: " + p.Name + "\n")
-        sb.WriteString("    username: \"" + p.Username + "\"\n")
-        sb.WriteString("    email: \"" + p.Email + "\"\n")
-        if p.SigningKey != "" {
-            sb.WriteString("    signingkey: \"" + p.SigningKey + "\"\n")
+        if p.Source == "" {
+            own = append(own, p)
         }
     }
-    return os.WriteFile(path, []byte(sb.String()), 0o644)
+    if cfg.SortProfiles == "alphabetical" {
+        sort.SliceStable(own, func(i, j int) bool {
+            a, b := strings.ToLower(own[i].Name), strings.ToLower(own[j].Name)
+            if a != b {
+                return a < b
+            }
+            return own[i].Name < own[j].Name
+        })
+    }
+    return own
 }
 
 // initConfig creates a default config if missing.
@@ -172,6 +912,30 @@ func initConfig(path string) error {
     return saveConfig(path, cfg)
 }
 
+// matchProfile returns the profile with the given identity, if any. Names
+// are compared in NFC, so a name written by another platform in NFD still
+// matches.
+func matchProfile(cfg *Config, name, email string) *Profile {
+    for i, p := range cfg.Profiles {
+        if (p.Username == name || gitName(&p) == nfc(name)) && p.Email == email {
+            return &cfg.Profiles[i]
+        }
+    }
+    return nil
+}
+
+// checkUnlocked refuses changes to a locked profile unless forced, and to an
+// included one always.
+func checkUnlocked(p *Profile, force bool) error {
+    if p.Source != "" {
+        return fmt.Errorf("profile %s comes from %s; edit it there", p.Name, p.Source)
+    }
+    if p.Locked && !force {
+        return fmt.Errorf("profile %s is locked; use --force to change it", p.Name)
+    }
+    return nil
+}
+
 // findProfile returns a pointer to a profile by its name.
 func findProfile(cfg *Config, name string) *Profile {
     for i, p := range cfg.Profiles {
@@ -184,59 +948,195 @@ func findProfile(cfg *Config, name string) *Profile {
 
 // commandList prints all configured profiles.
 func commandList(cfg Config) {
-    fmt.Println("available profiles:")
+    fmt.Println(tr("list.header"))
     for _, p := range cfg.Profiles {
         // Use a bullet for each profile.
-        fmt.Printf("  • %s\t(%s)\n", p.Name, p.Email)
+        lock := ""
+        if p.Bot {
+            lock = " 🤖"
+        }
+        if p.Locked {
+            lock += " 🔒"
+        }
+        fmt.Printf("  • %s\t(%s)%s\n", p.Name, p.Email, lock)
     }
 }
 
-// commandInfo shows the current profile for the repository or globally.
-func commandInfo(cfg Config) {
+// configSource describes where git found a config value.
+type configSource struct {
+    Value string
+    // Scope is git's scope name: "local", "global", "system", "worktree" or
+    // "command".
+    Scope string
+    // File is the config file the value was read from.
+    File string
+    // Included is set when the value comes from a file pulled in by an
+    // include or includeIf directive rather than the scope's own file.
+    Included bool
+}
+
+// parseConfigOrigin splits a line of `git config --show-scope --show-origin`
+// output into its scope, file and value.
+func parseConfigOrigin(line string) (scope, file, value string) {
+    parts := strings.SplitN(line, "\t", 3)
+    if !gitShowScope.supported() {
+        // Older git shows no scope: "file:<path>\t<value>".
+        parts = strings.SplitN(line, "\t", 2)
+        if len(parts) == 2 {
+            parts = []string{originScope(parts[0]), parts[0], parts[1]}
+        }
+    }
+    if len(parts) != 3 {
+        return "", "", strings.TrimSpace(line)
+    }
+    file = strings.TrimPrefix(parts[1], "file:")
+    return parts[0], strings.Trim(file, "\""), parts[2]
+}
+
+// lookupConfig returns the value of key as git resolves it along with where
+// it came from. Extra arguments such as "--global" are passed to git config.
+func lookupConfig(key string, extra ...string) (configSource, error) {
+    query := func(flags ...string) (string, error) {
+        args := append(append([]string{"config"}, configOriginFlags()...), flags...)
+        args = append(args, extra...)
+        return runGit(append(args, "--get", key)...)
+    }
+    out, err := query()
+    if err != nil {
+        return configSource{}, err
+    }
+    var src configSource
+    src.Scope, src.File, src.Value = parseConfigOrigin(out)
+    // Without includes git either finds nothing or a value from a different
+    // file when the effective one was included.
+    direct, err := query("--no-includes")
+    _, directFile, _ := parseConfigOrigin(direct)
+    src.Included = err != nil || directFile != src.File
+    return src, nil
+}
+
+// scopeLabel returns how info describes where an identity comes from.
+func (s configSource) scopeLabel() string {
+    if s.Included {
+        return fmt.Sprintf("included (%s)", s.File)
+    }
+    if s.Scope == "local" || s.Scope == "worktree" {
+        return "repo"
+    }
+    if s.Scope == "global" && os.Getenv("GIT_CONFIG_GLOBAL") != "" {
+        return fmt.Sprintf("global (%s)", s.File)
+    }
+    return s.Scope
+}
+
+// identity is the git identity in effect and the profile it belongs to.
+type identity struct {
+    InRepo  bool
+    Scope   string
+    Name    configSource
+    Email   configSource
+    Profile *Profile
+}
+
+// currentIdentity looks up the identity for the repository, or the global
+// one outside a repository.
+func currentIdentity(cfg *Config) identity {
     // Determine if we are inside a repo.
     inRepo, _ := isGitRepo()
-    var nameVal, emailVal string
+    var extra []string
+    if !inRepo {
+        extra = append(extra, "--global")
+    }
+    id := identity{InRepo: inRepo, Scope: "global"}
+    id.Name, _ = lookupConfig("user.name", extra...)
     var err error
+    id.Email, err = lookupConfig("user.email", extra...)
+    id.Profile = matchProfile(cfg, id.Name.Value, id.Email.Value)
+    if err == nil {
+        id.Scope = id.Email.scopeLabel()
+    } else if inRepo {
+        id.Scope = "repo"
+    }
+    return id
+}
+
+// commandInfo shows the current profile for the repository or globally.
+func commandInfo(cfg Config) {
+    id := currentIdentity(&cfg)
+    inRepo, emailSrc, matched := id.InRepo, id.Email, id.Profile
+    fmt.Println(tr("info.header", id.Scope))
     if inRepo {
-        nameVal, err = runGit("config", "user.name")
-        if err != nil {
-            nameVal = ""
-        }
-        emailVal, err = runGit("config", "user.email")
-        if err != nil {
-            emailVal = ""
+        _, root := isGitRepo()
+        rec, _ := loadRepoRecord(root)
+        if rec.Pin != "" {
+            fmt.Println(tr("info.pinned", rec.Pin))
         }
-    } else {
-        nameVal, err = runGit("config", "--global", "user.name")
-        if err != nil {
-            nameVal = ""
-        }
-        emailVal, err = runGit("config", "--global", "user.email")
-        if err != nil {
-            emailVal = ""
-        }
-    }
-    // Find matching profile.
-    var matched *Profile
-    for i, p := range cfg.Profiles {
-        if p.Username == nameVal && p.Email == emailVal {
-            matched = &cfg.Profiles[i]
-            break
+        if v := rec.LastVerify; v != nil {
+            if v.OK {
+                fmt.Printf("  last verified: %s ✔\n", v.Time.Local().Format("2006-01-02 15:04"))
+            } else {
+                fmt.Printf("  last verified: %s ✘ %s\n", v.Time.Local().Format("2006-01-02 15:04"), v.Reason)
+            }
         }
     }
-    scope := "global"
-    if inRepo {
-        scope = "repo"
-    }
-    fmt.Printf("current profile (%s):\n", scope)
     if matched != nil {
         fmt.Printf("  name: %s\n", matched.Name)
         fmt.Printf("  user: %s <%s>\n", matched.Username, matched.Email)
+        if len(matched.AlsoEmails) > 0 {
+            fmt.Printf("  also: %s\n", strings.Join(matched.AlsoEmails, ", "))
+        }
         if matched.SigningKey != "" {
             fmt.Printf("  signingkey: %s\n", matched.SigningKey)
         }
+        if matched.SigningFormat != "" {
+            fmt.Printf("  signing_format: %s\n", matched.SigningFormat)
+        }
+        if matched.SSHKey != "" {
+            fmt.Printf("  ssh_key: %s\n", profilePath(matched, matched.SSHKey))
+        }
+        if matched.HTTPProxy != "" {
+            fmt.Printf("  http.proxy: %s\n", matched.HTTPProxy)
+        }
+        if matched.SSLCAInfo != "" {
+            fmt.Printf("  http.sslCAInfo: %s\n", profilePath(matched, matched.SSLCAInfo))
+        }
+        if matched.HTTPExtraHeader != "" {
+            // Headers usually carry credentials; show only the name.
+            name, _, _ := strings.Cut(matched.HTTPExtraHeader, ":")
+            fmt.Printf("  http.extraHeader: %s: ***\n", name)
+        }
+        if matched.LFSURL != "" {
+            fmt.Printf("  lfs.url: %s\n", matched.LFSURL)
+        }
+        if matched.PartialCloneFilter != "" {
+            fmt.Printf("  partial clone filter: %s\n", matched.PartialCloneFilter)
+        }
+        if matched.RemoteProtocol != "" {
+            fmt.Printf("  remote protocol: %s\n", matched.RemoteProtocol)
+        }
+        for _, key := range sortedKeys(matched.Maintenance) {
+            if !maintenanceKey(key) {
+                continue
+            }
+            // Show what the repository has, which may have drifted.
+            value, err := runGit("config", key)
+            switch {
+            case err != nil:
+                fmt.Printf("  %s: unset (profile: %s)\n", key, matched.Maintenance[key])
+            case value != matched.Maintenance[key]:
+                fmt.Printf("  %s: %s (profile: %s)\n", key, value, matched.Maintenance[key])
+            default:
+                fmt.Printf("  %s: %s\n", key, value)
+            }
+        }
     } else {
-        fmt.Println("  (none)")
+        fmt.Println(tr("info.none"))
+    }
+    if inRepo && emailSrc.Included && emailSrc.Scope != "local" {
+        fmt.Println(tr("info.shadow"))
+    }
+    for _, w := range identityOverrideWarnings() {
+        fmt.Println("  ⚠ " + w)
     }
 }
 
@@ -244,71 +1144,259 @@ func commandInfo(cfg Config) {
 func commandSet(cfg Config, profileName string) error {
     p := findProfile(&cfg, profileName)
     if p == nil {
-        return fmt.Errorf("profile %s not found", profileName)
+        return errors.New(tr("profile.not_found", profileName))
     }
     // Ensure we are inside a git repository.
     inRepo, repoRoot := isGitRepo()
     if !inRepo {
-        return errors.New("not inside a git repository")
+        return errors.New(tr("repo.not_inside"))
     }
-    // Set local git config values.
-    if _, err := runGit("config", "user.name", p.Username); err != nil {
-        return fmt.Errorf("failed to set user.name: %w", err)
+    if err := checkPin(repoRoot, p.Name); err != nil {
+        return err
     }
-    if _, err := runGit("config", "user.email", p.Email); err != nil {
-        return fmt.Errorf("failed to set user.email: %w", err)
+    if err := profileGitRequirements(p); err != nil {
+        return err
     }
-    if p.SigningKey != "" {
-        if _, err := runGit("config", "user.signingkey", p.SigningKey); err != nil {
-            // Non‑fatal, continue.
-            fmt.Fprintf(os.Stderr, "warning: failed to set signingkey: %v\n", err)
-        }
+    if src, err := lookupConfig("user.email"); err == nil && src.Included && src.Scope != "local" && src.Value != p.Email {
+        fmt.Fprintln(os.Stderr, tr("set.shadow", src.File))
+    }
+    settings := repoSettings(p)
+    if err := setProfile(cfg, p, repoRoot, settings, pendingChanges(p, settings)); err != nil {
+        return err
+    }
+    fmt.Println(tr("set.done", p.Name, repoRoot))
+    for _, w := range identityOverrideWarnings() {
+        fmt.Fprintln(os.Stderr, "⚠ "+w)
     }
-    fmt.Printf("✔️  Set profile \"%s\" for repository %s\n", p.Name, repoRoot)
     return nil
 }
 
-// commandAdd interactively adds a new profile.
-func commandAdd(cfg *Config) error {
-    reader := bufio.NewReader(os.Stdin)
-    fmt.Print("Enter profile name: ")
-    name, err := reader.ReadString('\n')
+// commandAdd interactively adds a new profile. With from, the answers are
+// prefilled: from a forge account (a forge name or host), or with
+// "gitconfig <path>" from a gitconfig file.
+func commandAdd(cfg *Config, from []string) error {
+    var prefill Profile
+    var rules []Rule
+    host := ""
+    switch {
+    case len(from) == 2 && from[0] == "gitconfig":
+        in, err := gitconfigProfile(from[1])
+        if err != nil {
+            return err
+        }
+        prefill, rules = in.Profile, in.Rules
+        if len(in.Ignored) > 0 {
+            fmt.Printf("Not mapped to the profile: %s\n", strings.Join(in.Ignored, ", "))
+        }
+    case len(from) == 1 && from[0] != "gitconfig":
+        var err error
+        if prefill, host, err = forgeProfile(*cfg, from[0]); err != nil {
+            return err
+        }
+    case len(from) > 0:
+        return errors.New("usage: gist add [--from github|gitlab|codeberg|bitbucket|<host> | --from gitconfig <path>]")
+    }
+    // ask reads an answer, the default when empty; only optional answers
+    // and those with a default may end the input.
+    ask := func(prompt, def string, optional bool) (string, error) {
+        if def != "" {
+            prompt = strings.TrimSuffix(prompt, ": ") + " [" + def + "]: "
+        }
+        fmt.Print(prompt)
+        answer, err := stdin.ReadString('\n')
+        if err != nil && (err != io.EOF || !optional && def == "") {
+            return "", err
+        }
+        // Trim whitespace and newlines.
+        if answer = strings.TrimSpace(answer); answer == "" {
+            return def, nil
+        }
+        return answer, nil
+    }
+    name, err := ask(tr("add.name"), prefill.Name, false)
     if err != nil {
         return err
     }
-    fmt.Print("Enter username (git user.name): ")
-    username, err := reader.ReadString('\n')
+    username, err := ask(tr("add.username"), prefill.Username, false)
     if err != nil {
         return err
     }
-    fmt.Print("Enter email (git user.email): ")
-    email, err := reader.ReadString('\n')
+    email, err := ask(tr("add.email"), prefill.Email, false)
     if err != nil {
         return err
     }
-    fmt.Print("Enter signing key (optional): ")
-    signing, err := reader.ReadString('\n')
-    if err != nil && err != io.EOF {
+    signing, err := ask(tr("add.signing"), prefill.SigningKey, true)
+    if err != nil {
+        return err
+    }
+    sshKey, err := ask(tr("add.ssh"), prefill.SSHKey, true)
+    if err != nil {
         return err
     }
-    // Trim whitespace and newlines.
-    name = strings.TrimSpace(name)
-    username = strings.TrimSpace(username)
-    email = strings.TrimSpace(email)
-    signing = strings.TrimSpace(signing)
     if name == "" || username == "" || email == "" {
         return errors.New("profile name, username and email are required")
     }
-    // Append new profile.
-    newProf := Profile{Name: name, Username: username, Email: email, SigningKey: signing}
-    cfg.Profiles = append(cfg.Profiles, newProf)
-    fmt.Printf("Profile %s added.\n", name)
-    return nil
-}
-
-// commandRemove deletes a profile from the config.
-func commandRemove(cfg *Config, name string) error {
-    idx := -1
+    if existing := findProfile(cfg, name); existing != nil {
+        if err := checkUnlocked(existing, false); err != nil {
+            return err
+        }
+        // Two profiles of one name would leave the second unreachable.
+        if !confirm(fmt.Sprintf("Profile %s exists; replace its username, email and keys?", name)) {
+            return fmt.Errorf("profile %s already exists", name)
+        }
+        existing.Username, existing.Email, existing.SigningKey, existing.SSHKey = username, email, signing, sshKey
+        fmt.Println(tr("add.updated", name))
+    } else {
+        // Append new profile, with what else the source prefilled.
+        newProf := prefill
+        newProf.Name, newProf.Username, newProf.Email, newProf.SigningKey, newProf.SSHKey = name, username, email, signing, sshKey
+        cfg.Profiles = append(cfg.Profiles, newProf)
+        fmt.Println(tr("add.done", name))
+    }
+    if len(rules) > 0 {
+        for _, r := range rules {
+            fmt.Printf("  url rule: %s → %s\n", r.URL, name)
+        }
+        if confirm(fmt.Sprintf("The file rewrites these URLs; add rules selecting profile %s for them?", name)) {
+            for _, r := range rules {
+                r.Profile = name
+                cfg.Rules = append(cfg.Rules, r)
+            }
+        }
+    }
+    // Let the forge commands find the account.
+    if _, assigned := cfg.Hosts[host]; host != "" && !assigned {
+        if confirm(fmt.Sprintf("Use profile %s for repositories on %s (hosts map)?", name, host)) {
+            if cfg.Hosts == nil {
+                cfg.Hosts = map[string]string{}
+            }
+            cfg.Hosts[host] = name
+        }
+    }
+    // Offer a default so new repositories never end up without an identity.
+    if cfg.DefaultProfile == "" {
+        fmt.Print(tr("add.default"))
+        answer, _ := stdin.ReadString('\n')
+        answer = strings.ToLower(strings.TrimSpace(answer))
+        if answer == "y" || answer == "yes" {
+            cfg.DefaultProfile = name
+            fmt.Println(tr("add.default_set", name))
+        }
+    }
+    return nil
+}
+
+// removeImpact is what depends on a profile about to be removed: the
+// indexes of the rules that reference it, the hosts the hosts map assigns
+// to it and the repositories gist set it in or pinned to it.
+type removeImpact struct {
+    rules []int
+    hosts []string
+    repos []repoRecord
+}
+
+// reportRemoveImpact prints what depends on a profile about to be removed
+// and returns it.
+func reportRemoveImpact(cfg *Config, name string) removeImpact {
+    var impact removeImpact
+    for i, r := range cfg.Rules {
+        if r.Profile == name {
+            impact.rules = append(impact.rules, i)
+        }
+    }
+    impact.hosts = profileHosts(*cfg, &Profile{Name: name})
+    records, err := loadRepoRecords()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot read %s: %v\n", statePath(), err)
+    }
+    pinned := 0
+    for _, rec := range records {
+        if rec.Profile == name || rec.Pin == name {
+            impact.repos = append(impact.repos, rec)
+        }
+        if rec.Pin == name {
+            pinned++
+        }
+    }
+    if len(impact.rules) == 0 && len(impact.hosts) == 0 && len(impact.repos) == 0 && cfg.DefaultProfile != name {
+        fmt.Printf("Profile %s is not referenced by any rule, host or repository.\n", name)
+        return impact
+    }
+    fmt.Printf("Profile %s is referenced by:\n", name)
+    for _, i := range impact.rules {
+        fmt.Printf("  rule %d: %s\n", i+1, cfg.Rules[i].describe())
+    }
+    for _, host := range impact.hosts {
+        fmt.Printf("  hosts: %s\n", host)
+    }
+    if cfg.DefaultProfile == name {
+        fmt.Println("  default_profile")
+    }
+    if len(impact.repos) > 0 {
+        fmt.Printf("  %d repositories (%d pinned):\n", len(impact.repos), pinned)
+        for _, rec := range impact.repos {
+            if rec.Pin == name {
+                fmt.Printf("    %s (pinned)\n", rec.Root)
+            } else {
+                fmt.Printf("    %s\n", rec.Root)
+            }
+        }
+    }
+    return impact
+}
+
+// reassignProfile offers to point the rules, hosts and repositories that
+// use a profile at another one. Repositories are switched with set, and
+// pins follow; one that fails is reported and keeps its identity.
+func reassignProfile(cfg *Config, name string, impact removeImpact) error {
+    fmt.Print("Reassign these to profile (empty to keep them): ")
+    answer, _ := stdin.ReadString('\n')
+    target := strings.TrimSpace(answer)
+    if target == "" {
+        return nil
+    }
+    if target == name || findProfile(cfg, target) == nil {
+        return fmt.Errorf("profile %s not found", target)
+    }
+    for _, i := range impact.rules {
+        cfg.Rules[i].Profile = target
+    }
+    for _, host := range impact.hosts {
+        cfg.Hosts[host] = target
+    }
+    saved := repoDir
+    defer func() { repoDir = saved }()
+    switched := 0
+    for _, rec := range impact.repos {
+        repoDir = rec.Root
+        if inRepo, _ := isGitRepo(); !inRepo {
+            fmt.Printf("! %s: not a git repository any more, skipped\n", rec.Root)
+            continue
+        }
+        if rec.Pin == name {
+            err := updateRepoRecord(rec.Root, func(r *repoRecord) {
+                r.record("pin", target, r.Pin)
+                r.Pin = target
+            })
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "%s: %v\n", rec.Root, err)
+                continue
+            }
+        }
+        if err := commandSet(*cfg, target); err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %v\n", rec.Root, err)
+            continue
+        }
+        switched++
+    }
+    fmt.Printf("Reassigned %d rule(s), %d host(s) and %d of %d repositories to %s.\n", len(impact.rules), len(impact.hosts), switched, len(impact.repos), target)
+    return nil
+}
+
+// commandRemove moves a profile from the config into the trash. Unless force
+// is set it first reports what uses the profile and asks for confirmation.
+func commandRemove(cfg *Config, name string, force bool) error {
+    idx := -1
     for i, p := range cfg.Profiles {
         if p.Name == name {
             idx = i
@@ -318,32 +1406,162 @@ func commandRemove(cfg *Config, name string) error {
     if idx == -1 {
         return fmt.Errorf("profile %s not found", name)
     }
+    if err := checkUnlocked(&cfg.Profiles[idx], force); err != nil {
+        return err
+    }
+    if !force {
+        impact := reportRemoveImpact(cfg, name)
+        if !confirm(fmt.Sprintf("Remove profile %s?", name)) {
+            return errors.New("aborted (use --force to skip confirmation)")
+        }
+        if len(impact.rules) > 0 || len(impact.hosts) > 0 || len(impact.repos) > 0 {
+            if err := reassignProfile(cfg, name, impact); err != nil {
+                return err
+            }
+        }
+    }
+    trashProfile(cfg, cfg.Profiles[idx], time.Now())
     cfg.Profiles = append(cfg.Profiles[:idx], cfg.Profiles[idx+1:]...)
-    fmt.Printf("Profile %s removed.\n", name)
+    fmt.Printf("Profile %s moved to trash (gist restore %s brings it back).\n", name, name)
+    if cfg.DefaultProfile == name {
+        cfg.DefaultProfile = ""
+        fmt.Println("It was the default profile; no default is set now.")
+    }
     return nil
 }
 
+// helpCommands pairs each usage line with the message ID of its description.
+var helpCommands = []struct{ usage, id string }{
+    {"init", "help.init"},
+    {"init-repo [dir]", "help.init-repo"},
+    {"init-repo --install-template", "help.init-repo.template"},
+    {"config edit", "help.config.edit"},
+    {"config backups list", "help.config.backups"},
+    {"config restore <n>", "help.config.restore"},
+    {"log show [-n <count>] [--target <t>] [--json]", "help.log"},
+    {"list [--check] [--tree] [--porcelain]", "help.list"},
+    {"grep <term>", "help.grep"},
+    {"info [--commits [N]] [--porcelain]", "help.info"},
+    {"stats keys [--within <days>] [--strict]", "help.stats.keys"},
+    {"stats usage [--json] [--reset]", "help.stats.usage"},
+    {"keys rotate [--revoke] [--no-upload] [--expire <period>] [--force] <profile>", "help.keys.rotate"},
+    {"signers list", "help.signers.list"},
+    {"signers add <email> <key>", "help.signers.add"},
+    {"signers remove <email>", "help.signers.remove"},
+    {"forge check [<profile>]", "help.forge.check"},
+    {"forge noreply [--use] <profile>", "help.forge.noreply"},
+    {"forge cache clear", "help.forge.cache"},
+    {"trust sync [--from <url>]", "help.trust.sync"},
+    {"verify-signatures [<range>]", "help.verify-signatures"},
+    {"set <profile>", "help.set"},
+    {"set --auto", "help.set.auto"},
+    {"diff <profile>", "help.diff"},
+    {"detect [--yes]", "help.detect"},
+    {"rules list", "help.rules.list"},
+    {"rules add --profile <p> [--dir <d>] [--url <u>] [--remote <r>] [--branch <glob>] [--priority <n>]", "help.rules.add"},
+    {"rules remove <n>", "help.rules.remove"},
+    {"rules test [path]", "help.rules.test"},
+    {"rules lint", "help.rules.lint"},
+    {"policy install <url>", "help.policy.install"},
+    {"policy show", "help.policy.show"},
+    {"remotes fix [--dry-run] [--yes]", "help.remotes.fix"},
+    {"remotes audit [--json] [--jobs <n>] [dir...]", "help.remotes.audit"},
+    {"unset", "help.unset"},
+    {"which [--porcelain]", "help.which"},
+    {"explain [<repo>] [--json]", "help.explain"},
+    {"profile show <name> [--json]", "help.profile.show"},
+    {"whoami", "help.whoami"},
+    {"pin [profile]", "help.pin"},
+    {"unpin", "help.unpin"},
+    {"fix-last-commit [profile] [-n N]", "help.fix-last-commit"},
+    {"guard [--block] [--stamp]", "help.guard"},
+    {"guard install [--block] [--privacy]", "help.guard.install"},
+    {"shim install [--dir <dir>] [--force] | uninstall", "help.shim"},
+    {"privacy [--block]", "help.privacy"},
+    {"verify [--range <revs>] [--quick]", "help.verify"},
+    {"audit [--range <revs>] [--remediate [--note]]", "help.audit"},
+    {"server-hook generate [--allow-domain <d>] [--allow-email <e>] [--require-signed]", "help.server-hook"},
+    {"exec <profile> -- <cmd> [args]", "help.exec"},
+    {"shell <profile>", "help.shell"},
+    {"ssh test [<profile>]", "help.ssh.test"},
+    {"ssh setup [<profile>]", "help.ssh.setup"},
+    {"ssh-select [--match <profile>] <host> [<user>]", "help.ssh-select"},
+    {"credential get|store|erase", "help.credential"},
+    {"serve [--socket <path>]", "help.serve"},
+    {"lsp-lite [--interval <d>]", "help.lsp-lite"},
+    {"bootstrap --devcontainer [--profile <p>] [--dir <dir>] [--force]", "help.bootstrap"},
+    {"scan [--fix] [--resume] [--verify] [--jobs <n>] [dir...]", "help.scan"},
+    {"export --repos [--format json|csv] [-o <file>] [dir...]", "help.export"},
+    {"metrics [-o <file>] [--jobs <n>] [dir...]", "help.metrics"},
+    {"watch [--notify] [--interval <d>] [--once] [dir...]", "help.watch"},
+    {"service install [--interval <d>] [dir...] | uninstall | status", "help.service"},
+    {"tidy [--yes] [repo...]", "help.tidy"},
+    {"apply -f <plan> [--dry-run]", "help.apply"},
+    {"ensure --profile <p> [--repo <dir>] [--check]", "help.ensure"},
+    {"render <profile> [--scope local|global|include]", "help.render"},
+    {"includes sync [--dry-run] | clear [--dry-run]", "help.includes"},
+    {"template edit [--force] <profile>", "help.template.edit"},
+    {"add [--from <forge> | --from gitconfig <path>]", "help.add"},
+    {"remove [--force] <profile>", "help.remove"},
+    {"restore <profile>", "help.restore"},
+    {"trash", "help.trash"},
+    {"doctor [--fix] [--yes]", "help.doctor"},
+    {"selftest [--keep]", "help.selftest"},
+    {"completion <shell>", "help.completion"},
+    {"completion --install [<shell>]", "help.completion.install"},
+    {"release manifest [--version <v>] [--checksums <file>] [--format <f>] [-o <dir>]", "help.release"},
+    {"-C, --path <repo>", "help.path"},
+    {"--isolated <gitconfig>", "help.isolated"},
+    {"--strict", "help.strict"},
+    {"--offline", "help.offline"},
+    {"--version", "help.version"},
+    {"--help", "help.help"},
+}
+
 // printHelp displays usage information.
 func printHelp() {
-    fmt.Println("Usage: gist <command> [args]")
-    fmt.Println("Commands:")
-    fmt.Println("  init                 Create default config if missing")
-    fmt.Println("  list                 Show all configured profiles")
-    fmt.Println("  info                 Show current active profile")
-    fmt.Println("  set <profile>        Activate a profile for the current repository")
-    fmt.Println("  add                  Interactively add a new profile")
-    fmt.Println("  remove <profile>     Delete a profile from config")
-    fmt.Println("  --version            Print version and exit")
-    fmt.Println("  --help               Show this help message")
+    fmt.Println(tr("help.usage"))
+    fmt.Println(tr("help.commands"))
+    for _, c := range helpCommands {
+        fmt.Printf("  %-20s %s\n", c.usage, tr(c.id))
+    }
 }
 
 func main() {
-    args := os.Args[1:]
+    args, dir, err := extractPathFlag(os.Args[1:])
+    if err != nil {
+        fmt.Fprintln(os.Stderr, tr("error", err))
+        os.Exit(1)
+    }
+    repoDir = dir
+    args, isolated, err := extractIsolatedFlag(args)
+    if err == nil && isolated != "" {
+        err = isolate(isolated)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, tr("error", err))
+        os.Exit(1)
+    }
+    if invokedAsCredentialHelper(os.Args[0]) {
+        args = append([]string{"credential"}, args...)
+    }
     if len(args) == 0 {
         printHelp()
         return
     }
     // Handle global flags.
+    for len(args) > 0 && (args[0] == "--strict" || args[0] == "--offline") {
+        if args[0] == "--strict" {
+            strictConfig = true
+        } else {
+            offlineMode = true
+        }
+        args = args[1:]
+        if len(args) == 0 {
+            printHelp()
+            return
+        }
+    }
     switch args[0] {
     case "--version":
         fmt.Println(version)
@@ -355,72 +1573,909 @@ func main() {
     configPath := getConfigPath()
     // Load configuration; for commands that don't need config, we may ignore errors.
     cfg, cfgErr := loadConfig(configPath)
+    setGitTimeouts(cfg.Timeouts)
+    operationLog = cfg.Log
+    offlineMode = offlineMode || cfg.Offline
+    if cfg.UsageStats {
+        recordUsage(args)
+    }
 
     switch args[0] {
     case "init":
         if err := initConfig(configPath); err != nil {
-            fmt.Fprintf(os.Stderr, "Error initializing config: %v\n", err)
+            fmt.Fprintln(os.Stderr, tr("config.init_failed", err))
+            os.Exit(1)
+        }
+        fmt.Println(tr("config.initialized", configPath))
+    case "init-repo":
+        if len(args) > 1 && args[1] == "--install-template" {
+            if err := installTemplate(); err != nil {
+                fmt.Fprintln(os.Stderr, tr("error", err))
+                os.Exit(1)
+            }
+            return
+        }
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        dir := "."
+        if len(args) > 1 {
+            dir = args[1]
+        }
+        if err := commandInitRepo(cfg, dir); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "config":
+        if err := commandConfig(configPath, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "log":
+        if err := commandLog(args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
             os.Exit(1)
         }
-        fmt.Println("Config initialized at", configPath)
     case "list":
         if cfgErr != nil {
-            fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", cfgErr)
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
             os.Exit(1)
         }
+        rest, porcelain, err := porcelainFlag(args[1:])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        if len(rest) > 0 && rest[0] == "--check" {
+            if !commandCheck(cfg) {
+                os.Exit(1)
+            }
+            return
+        }
+        if len(rest) > 0 && rest[0] == "--tree" {
+            commandListTree(cfg)
+            return
+        }
+        if porcelain > 0 {
+            porcelainList(cfg)
+            return
+        }
         commandList(cfg)
+    case "grep":
+        if err := commandGrep(configPath, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
     case "info":
         if cfgErr != nil {
-            fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", cfgErr)
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        rest, porcelain, err := porcelainFlag(args[1:])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
             os.Exit(1)
         }
+        if porcelain > 0 {
+            porcelainInfo(cfg)
+            return
+        }
+        commits := 0
+        for i := 0; i < len(rest); i++ {
+            if rest[i] != "--commits" {
+                continue
+            }
+            commits = 10
+            if i+1 < len(rest) {
+                if n, err := strconv.Atoi(rest[i+1]); err == nil && n > 0 {
+                    commits = n
+                    i++
+                }
+            }
+        }
         commandInfo(cfg)
+        if inRepo, _ := isGitRepo(); commits > 0 && inRepo {
+            printRecentCommits(currentIdentity(&cfg).Profile, commits)
+        }
     case "set":
         if len(args) < 2 {
-            fmt.Fprintln(os.Stderr, "Usage: gist set <profile>")
+            fmt.Fprintln(os.Stderr, "Usage: gist set <profile>|--auto")
+            os.Exit(1)
+        }
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        profileName := args[1]
+        if profileName == "--auto" {
+            name, err := resolveAutoProfile(cfg, true)
+            if err != nil {
+                fmt.Fprintln(os.Stderr, tr("error", err))
+                os.Exit(1)
+            }
+            profileName = name
+        }
+        if err := commandSet(cfg, profileName); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "detect":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        assumeYes := len(args) > 1 && (args[1] == "--yes" || args[1] == "-y")
+        if err := commandDetect(cfg, assumeYes); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
             os.Exit(1)
         }
+    case "rules":
         if cfgErr != nil {
-            fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", cfgErr)
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandRules(&cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        if len(args) > 1 && (args[1] == "add" || args[1] == "remove") {
+            if err := saveConfig(configPath, cfg); err != nil {
+                fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
+                os.Exit(1)
+            }
+        }
+    case "policy":
+        if cfgErr != nil && !os.IsNotExist(cfgErr) {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandPolicy(cfg, configPath, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "unset":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandUnset(cfg); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "which":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        _, porcelain, err := porcelainFlag(args[1:])
+        if err == nil {
+            if porcelain > 0 {
+                err = porcelainWhich(cfg)
+            } else {
+                err = commandWhich(cfg)
+            }
+        }
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "explain":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        dir, asJSON := "", false
+        for _, a := range args[1:] {
+            switch {
+            case a == "--json":
+                asJSON = true
+            case dir == "" && !strings.HasPrefix(a, "-"):
+                dir = a
+            default:
+                fmt.Fprintln(os.Stderr, "Usage: gist explain [<repo>] [--json]")
+                os.Exit(1)
+            }
+        }
+        if err := commandExplain(cfg, configPath, dir, asJSON); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "profile":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandProfile(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "whoami":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandWhoami(cfg); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "fix-last-commit":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        profileName, n := "", 1
+        for i := 1; i < len(args); i++ {
+            if args[i] == "-n" && i+1 < len(args) {
+                count, err := strconv.Atoi(args[i+1])
+                if err != nil {
+                    fmt.Fprintf(os.Stderr, "Error: invalid commit count %q\n", args[i+1])
+                    os.Exit(1)
+                }
+                n = count
+                i++
+                continue
+            }
+            profileName = args[i]
+        }
+        if err := commandFixLastCommit(cfg, profileName, n); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
             os.Exit(1)
         }
-        if err := commandSet(cfg, args[1]); err != nil {
-            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+    case "guard":
+        block, install, privacy, stamp := false, false, false, false
+        for _, a := range args[1:] {
+            switch a {
+            case "--block":
+                block = true
+            case "--stamp":
+                stamp = true
+            case "--privacy":
+                privacy = true
+            case "install":
+                install = true
+            }
+        }
+        if install {
+            if err := installGuard(block, privacy); err != nil {
+                fmt.Fprintln(os.Stderr, tr("error", err))
+                os.Exit(1)
+            }
+            return
+        }
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandGuard(cfg, block, stamp); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "privacy":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        block := len(args) > 1 && args[1] == "--block"
+        if err := commandPrivacy(cfg, block); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "shim":
+        if err := commandShim(args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "verify":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        revs, message, quick := "", "", false
+        for i := 1; i < len(args); i++ {
+            switch {
+            case args[i] == "--quick":
+                quick = true
+            case args[i] == "--range" && i+1 < len(args):
+                revs = args[i+1]
+                i++
+            case args[i] == "--message" && i+1 < len(args):
+                message = args[i+1]
+                i++
+            }
+        }
+        var err error
+        if quick {
+            err = verifyQuick(cfg)
+        } else if message != "" {
+            err = verifyMessageFile(cfg, message)
+        } else {
+            err = commandVerify(cfg, revs)
+        }
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "remotes":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandRemotes(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "audit":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        revs, fix, note := "HEAD", false, false
+        for i := 1; i < len(args); i++ {
+            switch {
+            case args[i] == "--remediate":
+                fix = true
+            case args[i] == "--note":
+                note = true
+            case args[i] == "--range" && i+1 < len(args):
+                revs = args[i+1]
+                i++
+            }
+        }
+        if note && !fix {
+            fmt.Fprintln(os.Stderr, tr("error", "--note requires --remediate"))
+            os.Exit(1)
+        }
+        if err := commandAudit(cfg, revs, fix, note); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "exec":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if len(args) < 3 {
+            fmt.Fprintln(os.Stderr, "Usage: gist exec <profile> -- <command> [args]")
+            os.Exit(1)
+        }
+        argv := args[2:]
+        if argv[0] == "--" {
+            argv = argv[1:]
+        }
+        code, err := commandExec(cfg, args[1], argv)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        os.Exit(code)
+    case "shell":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if len(args) < 2 {
+            fmt.Fprintln(os.Stderr, "Usage: gist shell <profile>")
             os.Exit(1)
         }
+        code, err := commandShell(cfg, args[1])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        os.Exit(code)
+    case "ssh":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandSSH(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "serve":
+        if err := commandServe(configPath, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "lsp-lite":
+        if err := commandLSPLite(configPath, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "ssh-select":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        ok, err := commandSSHSelect(cfg, args[1:])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        if !ok {
+            os.Exit(1)
+        }
+    case "credential":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandCredential(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "bootstrap":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandBootstrap(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "hook":
+        // Invoked by hooks gist installs; not meant to be run by hand.
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandHook(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "scan":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        fix, resume, verify, jobs := false, false, false, 0
+        var roots []string
+        for i := 1; i < len(args); i++ {
+            switch args[i] {
+            case "--fix":
+                fix = true
+            case "--verify":
+                verify = true
+            case "--resume":
+                resume = true
+            case "--jobs", "-j":
+                if i+1 < len(args) {
+                    jobs, _ = strconv.Atoi(args[i+1])
+                    i++
+                }
+            default:
+                roots = append(roots, args[i])
+            }
+        }
+        if verify && (fix || resume) {
+            fmt.Fprintln(os.Stderr, tr("error", "--verify cannot be combined with --fix or --resume"))
+            os.Exit(2)
+        }
+        if verify {
+            err = commandScanVerify(cfg, roots, jobs)
+        } else {
+            err = commandScan(cfg, roots, fix, resume, jobs)
+        }
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "export":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandExport(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "metrics":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandMetrics(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "watch":
+        if err := commandWatch(configPath, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "service":
+        if err := commandService(args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "tidy":
+        if err := commandTidy(args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "apply":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        planPath, dryRun := "", false
+        for i := 1; i < len(args); i++ {
+            switch {
+            case args[i] == "--dry-run":
+                dryRun = true
+            case (args[i] == "-f" || args[i] == "--file") && i+1 < len(args):
+                planPath = args[i+1]
+                i++
+            }
+        }
+        if planPath == "" {
+            fmt.Fprintln(os.Stderr, "Usage: gist apply -f <plan.yaml> [--dry-run]")
+            os.Exit(1)
+        }
+        if err := commandApply(cfg, planPath, dryRun); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "ensure":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        profileName, check := "", false
+        for i := 1; i < len(args); i++ {
+            switch {
+            case args[i] == "--check":
+                check = true
+            case args[i] == "--profile" && i+1 < len(args):
+                profileName = args[i+1]
+                i++
+            case args[i] == "--repo" && i+1 < len(args):
+                repoDir = expandHome(args[i+1])
+                i++
+            }
+        }
+        if profileName == "" {
+            fmt.Fprintln(os.Stderr, "Usage: gist ensure --profile <profile> [--repo <dir>] [--check]")
+            os.Exit(1)
+        }
+        if err := commandEnsure(cfg, profileName, check); err != nil {
+            if errors.Is(err, errDrift) {
+                // Distinguish drift from failures for configuration management.
+                os.Exit(2)
+            }
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "render":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        profileName, scope := "", "local"
+        for i := 1; i < len(args); i++ {
+            switch {
+            case args[i] == "--scope" && i+1 < len(args):
+                scope = args[i+1]
+                i++
+            case strings.HasPrefix(args[i], "--scope="):
+                scope = strings.TrimPrefix(args[i], "--scope=")
+            default:
+                profileName = args[i]
+            }
+        }
+        if profileName == "" {
+            fmt.Fprintln(os.Stderr, "Usage: gist render <profile> [--scope local|global|include]")
+            os.Exit(1)
+        }
+        if err := commandRender(cfg, profileName, scope); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "includes":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandIncludes(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "template":
+        force := false
+        var rest []string
+        for _, a := range args[1:] {
+            if a == "--force" {
+                force = true
+                continue
+            }
+            rest = append(rest, a)
+        }
+        if len(rest) < 2 || rest[0] != "edit" {
+            fmt.Fprintln(os.Stderr, "Usage: gist template edit [--force] <profile>")
+            os.Exit(1)
+        }
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        changed, err := commandTemplateEdit(&cfg, configPath, rest[1], force)
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        if changed {
+            if err := saveConfig(configPath, cfg); err != nil {
+                fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
+                os.Exit(1)
+            }
+        }
     case "add":
         if cfgErr != nil {
+            if !os.IsNotExist(cfgErr) {
+                fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+                os.Exit(1)
+            }
             // If config doesn't exist, start with empty config.
             cfg = Config{}
         }
-        if err := commandAdd(&cfg); err != nil {
-            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        var from []string
+        if len(args) > 2 && args[1] == "--from" {
+            from = args[2:]
+        } else if len(args) > 1 {
+            fmt.Fprintln(os.Stderr, "Usage: gist add [--from github|gitlab|codeberg|bitbucket|<host> | --from gitconfig <path>]")
+            os.Exit(1)
+        }
+        if err := commandAdd(&cfg, from); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
             os.Exit(1)
         }
         // Save config after adding.
         if err := saveConfig(configPath, cfg); err != nil {
-            fmt.Fprintf(os.Stderr, "Failed to save config: %v\n", err)
+            fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
             os.Exit(1)
         }
     case "remove":
+        force := false
+        var names []string
+        for _, a := range args[1:] {
+            if a == "--force" || a == "-f" {
+                force = true
+                continue
+            }
+            names = append(names, a)
+        }
+        if len(names) != 1 {
+            fmt.Fprintln(os.Stderr, "Usage: gist remove [--force] <profile>")
+            os.Exit(1)
+        }
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandRemove(&cfg, names[0], force); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        if err := saveConfig(configPath, cfg); err != nil {
+            fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
+            os.Exit(1)
+        }
+    case "restore":
         if len(args) < 2 {
-            fmt.Fprintln(os.Stderr, "Usage: gist remove <profile>")
+            fmt.Fprintln(os.Stderr, "Usage: gist restore <profile>")
             os.Exit(1)
         }
         if cfgErr != nil {
-            fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", cfgErr)
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
             os.Exit(1)
         }
-        if err := commandRemove(&cfg, args[1]); err != nil {
-            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        if err := commandRestore(&cfg, args[1]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
             os.Exit(1)
         }
         if err := saveConfig(configPath, cfg); err != nil {
-            fmt.Fprintf(os.Stderr, "Failed to save config: %v\n", err)
+            fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
+            os.Exit(1)
+        }
+    case "trash":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        commandTrash(cfg)
+    case "diff":
+        if len(args) < 2 {
+            fmt.Fprintln(os.Stderr, "Usage: gist diff <profile>")
+            os.Exit(1)
+        }
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandDiff(cfg, args[1]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "stats":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandStats(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "keys":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        changed, err := commandKeys(&cfg, args[1:])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+        }
+        if changed {
+            if err := saveConfig(configPath, cfg); err != nil {
+                fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
+                os.Exit(1)
+            }
+        }
+        if err != nil {
+            os.Exit(1)
+        }
+    case "signers":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        changed, err := commandSigners(&cfg, args[1:])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        if changed {
+            if err := saveConfig(configPath, cfg); err != nil {
+                fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
+                os.Exit(1)
+            }
+        }
+    case "forge":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        changed, err := commandForge(&cfg, args[1:])
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+        if changed {
+            if err := saveConfig(configPath, cfg); err != nil {
+                fmt.Fprintln(os.Stderr, tr("config.save_failed", err))
+                os.Exit(1)
+            }
+        }
+    case "trust":
+        if cfgErr != nil && !os.IsNotExist(cfgErr) {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandTrust(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "verify-signatures":
+        revs := "HEAD"
+        if len(args) > 1 {
+            revs = args[1]
+        }
+        if err := commandVerifySignatures(revs); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "server-hook":
+        if cfgErr != nil && !os.IsNotExist(cfgErr) {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if err := commandServerHook(cfg, args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "pin":
+        if cfgErr != nil {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        var err error
+        if len(args) < 2 {
+            err = commandPins()
+        } else {
+            err = commandPin(cfg, args[1])
+        }
+        if err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
             os.Exit(1)
         }
+    case "unpin":
+        if err := commandUnpin(); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "doctor":
+        fix, assumeYes := false, false
+        for _, a := range args[1:] {
+            switch a {
+            case "--fix":
+                fix = true
+            case "--yes", "-y":
+                assumeYes = true
+            default:
+                fmt.Fprintln(os.Stderr, "Usage: gist doctor [--fix [--yes]]")
+                os.Exit(1)
+            }
+        }
+        // A missing config is one of the problems doctor reports.
+        if cfgErr != nil && !os.IsNotExist(cfgErr) {
+            fmt.Fprintln(os.Stderr, tr("config.load_failed", cfgErr))
+            os.Exit(1)
+        }
+        if !commandDoctor(cfg, configPath, fix, assumeYes) {
+            os.Exit(1)
+        }
+    case "selftest":
+        keep := false
+        for _, a := range args[1:] {
+            if a != "--keep" {
+                fmt.Fprintln(os.Stderr, "Usage: gist selftest [--keep]")
+                os.Exit(1)
+            }
+            keep = true
+        }
+        if err := commandSelftest(keep); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "completion":
+        if len(args) < 2 {
+            fmt.Fprintln(os.Stderr, "Usage: gist completion bash|zsh|powershell | --install [<shell>]")
+            os.Exit(1)
+        }
+        if args[1] == "--install" {
+            shell := ""
+            if len(args) > 2 {
+                shell = args[2]
+            }
+            if err := installCompletion(shell); err != nil {
+                fmt.Fprintln(os.Stderr, tr("error", err))
+                os.Exit(1)
+            }
+            return
+        }
+        if err := commandCompletion(args[1]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "release":
+        if err := commandRelease(args[1:]); err != nil {
+            fmt.Fprintln(os.Stderr, tr("error", err))
+            os.Exit(1)
+        }
+    case "__scan-repo":
+        // Run by scan's workers, one process per repository.
+        if cfgErr != nil {
+            os.Exit(1)
+        }
+        printScanRepo(cfg)
+    case "__complete":
+        // Called by the completion scripts; a broken config just means
+        // fewer candidates.
+        for _, c := range completions(cfg, args[1:]) {
+            fmt.Println(c)
+        }
     default:
-        fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+        fmt.Fprintln(os.Stderr, tr("command.unknown", args[0]))
         printHelp()
         os.Exit(1)
     }
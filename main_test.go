@@ -0,0 +1,235 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestStripComment(t *testing.T) {
+    for _, tc := range []struct{ line, want string }{
+        {"# whole line", ""},
+        {`signingkey: "0xABCD1234"   # optional – GPG key`, `signingkey: "0xABCD1234"`},
+        {"env:                       # optional", "env:"},
+        {"  - name: work # the day job", "  - name: work"},
+        {`pattern: "a # b"  # c`, `pattern: "a # b"`},
+        {`note: 'it''s # here' # c`, `note: 'it''s # here'`},
+        {`escaped: "say \"# hi\"" # c`, `escaped: "say \"# hi\""`},
+        {"url: https://host/path#anchor", "url: https://host/path#anchor"},
+        {"name: Jane's laptop # c", "name: Jane's laptop"},
+        {"token: !exec pass show corp/git-token   # optional", "token: !exec pass show corp/git-token"},
+        {"also_emails: [\"a@x.com\", 'b@x.com']   # c", "also_emails: [\"a@x.com\", 'b@x.com']"},
+    } {
+        if got := stripComment(tc.line); got != tc.want {
+            t.Errorf("stripComment(%q) = %q, want %q", tc.line, got, tc.want)
+        }
+    }
+}
+
+// readmeConfig returns the example config of README.md.
+func readmeConfig(t *testing.T) []byte {
+    data, err := os.ReadFile("README.md")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, rest, ok := strings.Cut(string(data), "```yaml\n# $HOME/.config/gist/config.yaml\n")
+    if !ok {
+        t.Fatal("README.md has no example config")
+    }
+    example, _, _ := strings.Cut(rest, "```")
+    return []byte(example)
+}
+
+func TestReadmeExampleConfig(t *testing.T) {
+    data := readmeConfig(t)
+    if problems := lintConfigLines(data); len(problems) > 0 {
+        t.Errorf("strict check rejects the example:\n  %s", strings.Join(problems, "\n  "))
+    }
+    var cfg Config
+    parseConfig(&cfg, data)
+    work := findProfile(&cfg, "work")
+    if work == nil || findProfile(&cfg, "personal") == nil {
+        t.Fatalf("profiles: %+v", cfg.Profiles)
+    }
+    for _, tc := range []struct{ field, got, want string }{
+        {"username", work.Username, "Jane Doe"},
+        {"signingkey", work.SigningKey, "0xABCD1234"},
+        {"ssh_key", work.SSHKey, "~/.ssh/id_work"},
+        {"ticket_pattern", work.TicketPattern, "[A-Z]+-[0-9]+"},
+        {"http_extra_header", work.HTTPExtraHeader, "Authorization: Bearer …"},
+        {"ssh_host_alias", work.SSHHostAlias, "%h-work"},
+        {"credential_token", work.CredentialToken, "!exec pass show corp/git-token"},
+        {"env.HTTPS_PROXY", work.Env["HTTPS_PROXY"], "http://proxy.corp:3128"},
+        {"env.GONOSUMDB", work.Env["GONOSUMDB"], "git.corp.com"},
+        {"maintenance.strategy", work.Maintenance["maintenance.strategy"], "incremental"},
+        {"maintenance.fetch.prune", work.Maintenance["fetch.prune"], "true"},
+        {"known_hosts.git.corp.com", work.KnownHosts["git.corp.com"], "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8"},
+    } {
+        if tc.got != tc.want {
+            t.Errorf("%s = %q, want %q", tc.field, tc.got, tc.want)
+        }
+    }
+    if len(work.AlsoEmails) != 2 || work.AlsoEmails[0] != "jane@oldcorp.com" {
+        t.Errorf("also_emails = %q", work.AlsoEmails)
+    }
+    if !work.SignOff || !work.RequireSignOff || !work.Locked {
+        t.Errorf("signoff, require_signoff, locked = %v, %v, %v", work.SignOff, work.RequireSignOff, work.Locked)
+    }
+    if len(cfg.Profiles) != 2 {
+        t.Errorf("%d profiles loaded, want 2", len(cfg.Profiles))
+    }
+}
+
+// TestSaveConfigQuoting checks that saving keeps how each value was quoted,
+// in maps and lists too, and double-quotes only new values.
+func TestSaveConfigQuoting(t *testing.T) {
+    in := `version: 1
+profiles:
+  - name: work
+    username: Jane Doe
+    email: 'jane@example.com'
+    also_emails:
+      - old@corp.com
+      - 'older@corp.com'
+      - "oldest@corp.com"
+    env:
+      HTTPS_PROXY: http://proxy.corp:3128
+      GONOSUMDB: 'git.corp.com'
+    known_hosts:
+      git.corp.com: "SHA256:abc"
+rules:
+  - profile: work
+    url: 'github.com/acme'
+`
+    var cfg Config
+    parseConfig(&cfg, []byte(in))
+    work := findProfile(&cfg, "work")
+    work.AlsoEmails = append(work.AlsoEmails, "new@corp.com")
+    work.Env["EDITOR"] = "vi"
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := saveConfig(path, cfg); err != nil {
+        t.Fatal(err)
+    }
+    out, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, want := range []string{
+        "    username: Jane Doe\n",
+        "    email: 'jane@example.com'\n",
+        "      - old@corp.com\n",
+        "      - 'older@corp.com'\n",
+        "      - \"oldest@corp.com\"\n",
+        "      - \"new@corp.com\"\n",
+        "      HTTPS_PROXY: http://proxy.corp:3128\n",
+        "      GONOSUMDB: 'git.corp.com'\n",
+        "      EDITOR: \"vi\"\n",
+        "      git.corp.com: \"SHA256:abc\"\n",
+        "    url: 'github.com/acme'\n",
+    } {
+        if !strings.Contains(string(out), want) {
+            t.Errorf("saved config lacks %q:\n%s", want, out)
+        }
+    }
+}
+
+// saveParsed parses a config and returns what saveConfig writes for it.
+func saveParsed(t *testing.T, in string) string {
+    var cfg Config
+    parseConfig(&cfg, []byte(in))
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := saveConfig(path, cfg); err != nil {
+        t.Fatal(err)
+    }
+    out, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return string(out)
+}
+
+// TestConfigRoundTrip checks that a saved config reads back to the same
+// file: saving what was loaded changes nothing.
+func TestConfigRoundTrip(t *testing.T) {
+    t.Setenv("WORK_EMAIL", "jane@corp.com")
+    t.Setenv("WORK", "/src/work")
+    for _, tc := range []struct{ name, config string }{
+        {"minimal", `version: 1
+profiles:
+  - name: example
+    username: "Your Name"
+    email: "you@example.com"
+`},
+        {"quoting", `version: 1
+profiles:
+  - name: work
+    username: Jane Doe
+    email: 'jane@corp.com'
+    also_emails:
+      - old@corp.com
+      - "older@corp.com"
+    http_extra_header: "X-Note: a # b"
+    ssh_host_alias: "%h-work"
+    env:
+      GOPRIVATE: 'git.corp.com/*'
+`},
+        {"resolvers", `version: 1
+profiles:
+  - name: work
+    username: "Jane"
+    email: !env WORK_EMAIL
+    credential_token: !exec pass show corp/git-token
+rules:
+  - profile: work
+    dir: "${WORK}/api"
+`},
+        {"top level", `version: 1
+default_profile: work
+strict: true
+sort_profiles: alphabetical
+log: true
+offline: true
+include:
+  - "team.yaml"
+profiles:
+  - name: oss
+    username: "Jane"
+    email: "jane@example.com"
+    signoff: true
+  - name: work
+    username: "Jane Doe"
+    email: "jane@corp.com"
+    bot: true
+    locked: true
+    maintenance:
+      maintenance.strategy: incremental
+rules:
+  - profile: work
+    dir: "/src/work"
+    url: "github.com/acme"
+    remote: "*"
+    branch: "release/*"
+    priority: 10
+hosts:
+  git.corp.com: work
+forges:
+  git.corp.com: gitlab
+scan:
+  exclude:
+    - "/src/vendor"
+hooks:
+  on_set: "notify-send gist"
+timeouts:
+  forge: 5s
+`},
+    } {
+        if got := saveParsed(t, tc.config); got != tc.config {
+            t.Errorf("%s: saved as\n%s\nwant\n%s", tc.name, got, tc.config)
+        }
+    }
+    saved := saveParsed(t, string(readmeConfig(t)))
+    if again := saveParsed(t, saved); again != saved {
+        t.Errorf("README example: second save differs:\n%s\nfirst:\n%s", again, saved)
+    }
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// metricsWriter builds Prometheus text exposition format output.
+type metricsWriter struct {
+    sb strings.Builder
+}
+
+// family starts a metric family with its help text and type.
+func (m *metricsWriter) family(name, kind, help string) {
+    fmt.Fprintf(&m.sb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample; labels alternate names and values.
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+    m.sb.WriteString(name)
+    if len(labels) > 0 {
+        pairs := make([]string, 0, len(labels)/2)
+        for i := 0; i+1 < len(labels); i += 2 {
+            pairs = append(pairs, labels[i]+"="+metricsLabel(labels[i+1]))
+        }
+        m.sb.WriteString("{" + strings.Join(pairs, ",") + "}")
+    }
+    m.sb.WriteString(" " + strconv.FormatFloat(value, 'f', -1, 64) + "\n")
+}
+
+// metricsLabel quotes a label value.
+func metricsLabel(s string) string {
+    return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// collectMetrics checks the repositories under the roots (or the known ones)
+// and the profiles' signing keys and returns the metrics.
+func collectMetrics(cfg Config, roots []string, jobs int) (string, error) {
+    st, err := loadState()
+    if err != nil {
+        return "", err
+    }
+    roots, err = watchRoots(st, roots)
+    if err != nil {
+        return "", err
+    }
+    byProfile := map[string]int{}
+    for _, p := range cfg.Profiles {
+        byProfile[p.Name] = 0
+    }
+    drifted, violations := 0, 0
+    err = scanParallel(cfg, roots, jobs, nil, func(dir string, res scanResult) {
+        profile := res.Current
+        if profile == "" {
+            profile = "none"
+        }
+        byProfile[profile]++
+        if driftReason(res, st.Repos[res.Root]) != "" {
+            drifted++
+        }
+        violations += len(res.Violations)
+    })
+    if err != nil {
+        return "", err
+    }
+    names := make([]string, 0, len(byProfile))
+    for name := range byProfile {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var m metricsWriter
+    m.family("gist_repositories", "gauge", "Repositories checked, by the profile their identity matches.")
+    for _, name := range names {
+        m.sample("gist_repositories", float64(byProfile[name]), "profile", name)
+    }
+    m.family("gist_repositories_drifted", "gauge", "Repositories whose identity differs from their expected profile or the policy.")
+    m.sample("gist_repositories_drifted", float64(drifted))
+    m.family("gist_policy_violations", "gauge", "Policy violations across the repositories checked.")
+    m.sample("gist_policy_violations", float64(violations))
+
+    order, keys := signingKeys(cfg)
+    m.family("gist_signing_key_ok", "gauge", "Whether a profile's signing key was found and is not revoked (1) or not (0).")
+    for _, key := range order {
+        k := keys[key]
+        ok := 1.0
+        if k.Err != nil {
+            ok = 0
+        }
+        m.sample("gist_signing_key_ok", ok, "key", key, "type", k.Kind, "profiles", strings.Join(k.Profiles, ","))
+    }
+    m.family("gist_signing_key_expiry_timestamp_seconds", "gauge", "When a signing key expires, as a Unix timestamp; keys that never expire are left out.")
+    for _, key := range order {
+        if k := keys[key]; k.Err == nil && !k.Expires.IsZero() {
+            m.sample("gist_signing_key_expiry_timestamp_seconds", float64(k.Expires.Unix()), "key", key, "type", k.Kind, "profiles", strings.Join(k.Profiles, ","))
+        }
+    }
+    m.family("gist_last_run_timestamp_seconds", "gauge", "When gist collected these metrics, as a Unix timestamp.")
+    m.sample("gist_last_run_timestamp_seconds", float64(time.Now().Unix()))
+    return m.sb.String(), nil
+}
+
+// commandMetrics prints the metrics, or writes them to a file for
+// node_exporter's textfile collector. The file is replaced atomically so the
+// collector never reads it half written.
+func commandMetrics(cfg Config, args []string) error {
+    output, jobs := "", 0
+    var roots []string
+    for i := 0; i < len(args); i++ {
+        switch args[i] {
+        case "-o", "--output", "--jobs", "-j":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s requires a value", args[i])
+            }
+            if args[i] == "--jobs" || args[i] == "-j" {
+                jobs, _ = strconv.Atoi(args[i+1])
+            } else {
+                output = args[i+1]
+            }
+            i++
+        default:
+            roots = append(roots, args[i])
+        }
+    }
+    text, err := collectMetrics(cfg, roots, jobs)
+    if err != nil {
+        return err
+    }
+    if output == "" {
+        fmt.Print(text)
+        return nil
+    }
+    tmp, err := os.CreateTemp(filepath.Dir(output), ".gist-metrics-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.WriteString(text); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), output)
+}
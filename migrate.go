@@ -0,0 +1,43 @@
+package main
+
+import (
+    "fmt"
+    "os"
+)
+
+// configVersion is the config schema version this build reads and writes.
+const configVersion = 1
+
+// migrations[v] upgrades a config from version v to v+1. Files written
+// before versioning existed are version 0.
+var migrations = []func(cfg *Config){
+    // 0 → 1: the version key is introduced. Old files may list profiles
+    // without a "profiles:" header; the loader already accepts that, and
+    // saving rewrites them in the canonical layout.
+    func(cfg *Config) {},
+}
+
+// migrateConfig upgrades cfg to configVersion, keeping a copy of the original
+// file next to it before rewriting it.
+func migrateConfig(path string, cfg *Config, original []byte) error {
+    if cfg.Version > configVersion {
+        return fmt.Errorf("config version %d is newer than this gist supports (%d); please upgrade gist", cfg.Version, configVersion)
+    }
+    if cfg.Version == configVersion {
+        return nil
+    }
+    from := cfg.Version
+    for cfg.Version < configVersion {
+        migrations[cfg.Version](cfg)
+        cfg.Version++
+    }
+    backup := fmt.Sprintf("%s.v%d.bak", path, from)
+    if err := os.WriteFile(backup, original, 0o600); err != nil {
+        return fmt.Errorf("failed to back up config before migration: %w", err)
+    }
+    if err := saveConfig(path, *cfg); err != nil {
+        return fmt.Errorf("failed to save migrated config: %w", err)
+    }
+    fmt.Fprintf(os.Stderr, "gist: migrated config from version %d to %d (backup: %s)\n", from, configVersion, backup)
+    return nil
+}
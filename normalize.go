@@ -0,0 +1,231 @@
+package main
+
+import (
+    "strings"
+    "sync"
+    "unicode/utf8"
+)
+
+// The same name typed on macOS and on Linux can reach git in different
+// Unicode normalization forms (an "ä" as one code point, or as "a" plus a
+// combining diaeresis), which git shortlog and forges count as different
+// people. gist writes names in NFC, the composed form. The tables cover the
+// Latin, Greek and Cyrillic letters with diacritics; other scripts are left
+// as they are.
+
+// compositions maps a combining mark to pairs of a base letter and the
+// letter it composes to.
+var compositions = map[rune]string{
+    0x0300: "AÀEÈIÌNǸOÒUÙWẀYỲaàeèiìnǹoòuùwẁyỳ¨῭ÂẦÊỀÔỒÜǛâầêềôồ" + // combining grave accent
+        "üǜĂẰăằĒḔēḕŌṐōṑƠỜơờƯỪưừΑᾺΕῈΗῊΙῚΟῸΥῪΩῺαὰεὲηὴιὶοὸυὺ" +
+        "ωὼϊῒϋῢЕЀИЍеѐиѝἀἂἁἃἈἊἉἋἐἒἑἓἘἚἙἛἠἢἡἣἨἪἩἫἰἲἱἳἸἺἹἻὀὂ" +
+        "ὁὃὈὊὉὋὐὒὑὓὙὛὠὢὡὣὨὪὩὫ᾿῍῾῝",
+    0x0301: "AÁCĆEÉGǴIÍKḰLĹMḾNŃOÓPṔRŔSŚUÚWẂYÝZŹaácćeégǵiíkḱlĺ" + // combining acute accent
+        "mḿnńoópṕrŕsśuúwẃyýzź¨΅ÂẤÅǺÆǼÇḈÊẾÏḮÔỐÕṌØǾÜǗâấåǻæǽ" +
+        "çḉêếïḯôốõṍøǿüǘĂẮăắĒḖēḗŌṒōṓŨṸũṹƠỚơớƯỨưứΑΆΕΈΗΉΙΊΟΌ" +
+        "ΥΎΩΏαάεέηήιίοόυύωώϊΐϋΰϒϓГЃКЌгѓкќἀἄἁἅἈἌἉἍἐἔἑἕἘἜἙἝ" +
+        "ἠἤἡἥἨἬἩἭἰἴἱἵἸἼἹἽὀὄὁὅὈὌὉὍὐὔὑὕὙὝὠὤὡὥὨὬὩὭ᾿῎῾῞",
+    0x0302: "AÂCĈEÊGĜHĤIÎJĴOÔSŜUÛWŴYŶZẐaâcĉeêgĝhĥiîjĵoôsŝuûwŵ" + // combining circumflex accent
+        "yŷzẑẠẬạậẸỆẹệỌỘọộ",
+    0x0303: "AÃEẼIĨNÑOÕUŨVṼYỸaãeẽiĩnñoõuũvṽyỹÂẪÊỄÔỖâẫêễôỗĂẴăẵ" + // combining tilde
+        "ƠỠơỡƯỮưữ",
+    0x0304: "AĀEĒGḠIĪOŌUŪYȲaāeēgḡiīoōuūyȳÄǞÆǢÕȬÖȪÜǕäǟæǣõȭöȫüǖ" + // combining macron
+        "ǪǬǫǭȦǠȧǡȮȰȯȱΑᾹΙῙΥῩαᾱιῑυῡИӢУӮиӣуӯḶḸḷḹṚṜṛṝ",
+    0x0306: "AĂEĔGĞIĬOŎUŬaăeĕgğiĭoŏuŭȨḜȩḝΑᾸΙῘΥῨαᾰιῐυῠАӐЕӖЖӁИЙ" + // combining breve
+        "УЎаӑеӗжӂийуўẠẶạặ",
+    0x0307: "AȦBḂCĊDḊEĖFḞGĠHḢIİMṀNṄOȮPṖRṘSṠTṪWẆXẊYẎZŻaȧbḃcċdḋ" + // combining dot above
+        "eėfḟgġhḣmṁnṅoȯpṗrṙsṡtṫwẇxẋyẏzżŚṤśṥŠṦšṧſẛṢṨṣṩ",
+    0x0308: "AÄEËHḦIÏOÖUÜWẄXẌYŸaäeëhḧiïoötẗuüwẅxẍyÿÕṎõṏŪṺūṻΙΪ" + // combining diaeresis
+        "ΥΫιϊυϋϒϔІЇАӒЕЁЖӜЗӞИӤОӦУӰЧӴЫӸЭӬаӓеёжӝзӟиӥоӧуӱчӵыӹ" +
+        "эӭіїӘӚәӛӨӪөӫ",
+    0x0309: "AẢEẺIỈOỎUỦYỶaảeẻiỉoỏuủyỷÂẨÊỂÔỔâẩêểôổĂẲăẳƠỞơởƯỬưử", // combining hook above
+    0x030A: "AÅUŮaåuůwẘyẙ", // combining ring above
+    0x030B: "OŐUŰoőuűУӲуӳ", // combining double acute accent
+    0x030C: "AǍCČDĎEĚGǦHȞIǏKǨLĽNŇOǑRŘSŠTŤUǓZŽaǎcčdďeěgǧhȟiǐjǰ" + // combining caron
+        "kǩlľnňoǒrřsštťuǔzžÜǙüǚƷǮʒǯ",
+    0x030F: "AȀEȄIȈOȌRȐUȔaȁeȅiȉoȍrȑuȕѴѶѵѷ", // combining double grave accent
+    0x0311: "AȂEȆIȊOȎRȒUȖaȃeȇiȋoȏrȓuȗ", // combining inverted breve
+    0x0313: "ΑἈΕἘΗἨΙἸΟὈΩὨαἀεἐηἠιἰοὀρῤυὐωὠ", // combining comma above
+    0x0314: "ΑἉΕἙΗἩΙἹΟὉΡῬΥὙΩὩαἁεἑηἡιἱοὁρῥυὑωὡ", // combining reversed comma above
+    0x031B: "OƠUƯoơuư", // combining horn
+    0x0323: "AẠBḄDḌEẸHḤIỊKḲLḶMṂNṆOỌRṚSṢTṬUỤVṾWẈYỴZẒaạbḅdḍeẹhḥ" + // combining dot below
+        "iịkḳlḷmṃnṇoọrṛsṣtṭuụvṿwẉyỵzẓƠỢơợƯỰưự",
+    0x0324: "UṲuṳ", // combining diaeresis below
+    0x0325: "AḀaḁ", // combining ring below
+    0x0326: "SȘTȚsștț", // combining comma below
+    0x0327: "CÇDḐEȨGĢHḨKĶLĻNŅRŖSŞTŢcçdḑeȩgģhḩkķlļnņrŗsştţ", // combining cedilla
+    0x0328: "AĄEĘIĮOǪUŲaąeęiįoǫuų", // combining ogonek
+    0x032D: "DḒEḘLḼNṊTṰUṶdḓeḙlḽnṋtṱuṷ", // combining circumflex accent below
+    0x032E: "HḪhḫ", // combining breve below
+    0x0330: "EḚIḬUṴeḛiḭuṵ", // combining tilde below
+    0x0331: "BḆDḎKḴLḺNṈRṞTṮZẔbḇdḏhẖkḵlḻnṉrṟtṯzẕ", // combining macron below
+    0x0342: "¨῁αᾶηῆιῖυῦωῶϊῗϋῧἀἆἁἇἈἎἉἏἠἦἡἧἨἮἩἯἰἶἱἷἸἾἹἿὐὖὑὗὙὟὠὦ" + // combining greek perispomeni
+        "ὡὧὨὮὩὯ᾿῏῾῟",
+    0x0345: "ΑᾼΗῌΩῼάᾴήῄαᾳηῃωῳώῴἀᾀἁᾁἂᾂἃᾃἄᾄἅᾅἆᾆἇᾇἈᾈἉᾉἊᾊἋᾋἌᾌἍᾍἎᾎ" + // combining greek ypogegrammeni
+        "ἏᾏἠᾐἡᾑἢᾒἣᾓἤᾔἥᾕἦᾖἧᾗἨᾘἩᾙἪᾚἫᾛἬᾜἭᾝἮᾞἯᾟὠᾠὡᾡὢᾢὣᾣὤᾤὥᾥὦᾦ" +
+        "ὧᾧὨᾨὩᾩὪᾪὫᾫὬᾬὭᾭὮᾮὯᾯὰᾲὴῂὼῲᾶᾷῆῇῶῷ",
+}
+
+// combiningClasses holds the canonical combining class of the combining
+// diacritical marks (U+0300–U+036F) whose class isn't 230 (above).
+var combiningClasses = map[rune]uint8{
+    0x034F: 0,
+    0x0334: 1, 0x0335: 1, 0x0336: 1, 0x0337: 1, 0x0338: 1,
+    0x0321: 202, 0x0322: 202, 0x0327: 202, 0x0328: 202,
+    0x031B: 216,
+    0x0316: 220, 0x0317: 220, 0x0318: 220, 0x0319: 220, 0x031C: 220, 0x031D: 220, 0x031E: 220, 0x031F: 220,
+    0x0320: 220, 0x0323: 220, 0x0324: 220, 0x0325: 220, 0x0326: 220, 0x0329: 220, 0x032A: 220, 0x032B: 220,
+    0x032C: 220, 0x032D: 220, 0x032E: 220, 0x032F: 220, 0x0330: 220, 0x0331: 220, 0x0332: 220, 0x0333: 220,
+    0x0339: 220, 0x033A: 220, 0x033B: 220, 0x033C: 220, 0x0347: 220, 0x0348: 220, 0x0349: 220, 0x034D: 220,
+    0x034E: 220, 0x0353: 220, 0x0354: 220, 0x0355: 220, 0x0356: 220, 0x0359: 220, 0x035A: 220,
+    0x0315: 232, 0x031A: 232, 0x0358: 232,
+    0x035C: 233, 0x035F: 233, 0x0362: 233,
+    0x035D: 234, 0x035E: 234, 0x0360: 234, 0x0361: 234,
+    0x0345: 240,
+}
+
+// singletons are the code points NFC replaces by another one, such as the
+// Greek letters with oxia, which become the same letters with tonos.
+var singletons = map[rune]rune{
+    0x0374: 0x02B9, 0x037E: 0x003B, 0x0387: 0x00B7, 0x1F71: 0x03AC,
+    0x1F73: 0x03AD, 0x1F75: 0x03AE, 0x1F77: 0x03AF, 0x1F79: 0x03CC,
+    0x1F7B: 0x03CD, 0x1F7D: 0x03CE, 0x1FBB: 0x0386, 0x1FBE: 0x03B9,
+    0x1FC9: 0x0388, 0x1FCB: 0x0389, 0x1FD3: 0x0390, 0x1FDB: 0x038A,
+    0x1FE3: 0x03B0, 0x1FEB: 0x038E, 0x1FEE: 0x0385, 0x1FEF: 0x0060,
+    0x1FF9: 0x038C, 0x1FFB: 0x038F, 0x1FFD: 0x00B4,
+}
+
+// composeTable and decomposeTable are built from compositions on first use.
+var (
+    composeTable   map[[2]rune]rune
+    decomposeTable map[rune][2]rune
+    tablesOnce     sync.Once
+)
+
+// loadNormalizationTables builds composeTable and decomposeTable.
+func loadNormalizationTables() {
+    composeTable = map[[2]rune]rune{}
+    decomposeTable = map[rune][2]rune{}
+    for mark, pairs := range compositions {
+        runes := []rune(pairs)
+        for i := 0; i+1 < len(runes); i += 2 {
+            composeTable[[2]rune{runes[i], mark}] = runes[i+1]
+            decomposeTable[runes[i+1]] = [2]rune{runes[i], mark}
+        }
+    }
+}
+
+// combiningClass returns a rune's canonical combining class; runes other
+// than the combining diacritical marks and Cyrillic titlos are starters.
+func combiningClass(r rune) uint8 {
+    if r >= 0x0483 && r <= 0x0487 {
+        // Cyrillic titlo and pokrytie.
+        return 230
+    }
+    if r < 0x0300 || r > 0x036F {
+        return 0
+    }
+    if c, ok := combiningClasses[r]; ok {
+        return c
+    }
+    return 230
+}
+
+// decompose returns s fully decomposed with its combining marks in
+// canonical order (NFD, for the letters the tables cover).
+func decompose(s string) []rune {
+    tablesOnce.Do(loadNormalizationTables)
+    var out []rune
+    var expand func(r rune)
+    expand = func(r rune) {
+        if single, ok := singletons[r]; ok {
+            r = single
+        }
+        if d, ok := decomposeTable[r]; ok {
+            expand(d[0])
+            out = append(out, d[1])
+            return
+        }
+        out = append(out, r)
+    }
+    for _, r := range s {
+        expand(r)
+    }
+    // Sort each run of combining marks by class, keeping equal classes in
+    // order.
+    for i := 1; i < len(out); i++ {
+        for j := i; j > 0; j-- {
+            a, b := combiningClass(out[j-1]), combiningClass(out[j])
+            if b == 0 || a <= b {
+                break
+            }
+            out[j-1], out[j] = out[j], out[j-1]
+        }
+    }
+    return out
+}
+
+// nfc returns s in Unicode normalization form C.
+func nfc(s string) string {
+    if isASCII(s) {
+        return s
+    }
+    var out []rune
+    starter, last := -1, uint8(0)
+    for _, r := range decompose(s) {
+        class := combiningClass(r)
+        // A mark composes with the last starter unless a mark of the same
+        // or a lower class (or another starter) comes between them.
+        if starter >= 0 && (starter == len(out)-1 || (last != 0 && last < class)) {
+            if c, ok := composeTable[[2]rune{out[starter], r}]; ok {
+                out[starter] = c
+                continue
+            }
+        }
+        if class == 0 {
+            starter = len(out)
+        }
+        last = class
+        out = append(out, r)
+    }
+    return string(out)
+}
+
+// isASCII reports whether s is plain ASCII, which needs no normalization.
+func isASCII(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] >= utf8.RuneSelf {
+            return false
+        }
+    }
+    return true
+}
+
+// asciiLetters transliterates the Latin letters that don't decompose into a
+// base letter and marks.
+var asciiLetters = strings.NewReplacer(
+    "ß", "ss", "ẞ", "SS", "æ", "ae", "Æ", "AE", "œ", "oe", "Œ", "OE",
+    "ø", "o", "Ø", "O", "ł", "l", "Ł", "L", "đ", "d", "Đ", "D",
+    "ð", "d", "Ð", "D", "þ", "th", "Þ", "Th", "ı", "i", "ħ", "h", "Ħ", "H",
+)
+
+// transliterate drops the diacritics of Latin letters and spells out the
+// rest (ß, æ, ø, ł, ...) in ASCII. Other scripts are kept.
+func transliterate(s string) string {
+    var sb strings.Builder
+    for _, r := range decompose(s) {
+        if r < 0x0300 || r > 0x036F {
+            sb.WriteRune(r)
+        }
+    }
+    return nfc(asciiLetters.Replace(sb.String()))
+}
+
+// gitName returns the name gist writes to git for a profile: its username
+// in NFC, transliterated to ASCII with `transliterate: ascii`.
+func gitName(p *Profile) string {
+    if p.Transliterate == "ascii" {
+        return transliterate(p.Username)
+    }
+    return nfc(p.Username)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "time"
+)
+
+// In offline mode – the global --offline flag or offline: true in the
+// config – gist makes no network requests: forge API and gh/glab calls are
+// answered from the forge cache however old the answers are, saying so,
+// and everything that can only be done online (uploading keys, installing
+// a policy from a URL, fetching a GitHub App token) fails up front instead
+// of waiting for a network that isn't there.
+
+// offlineMode is set by --offline or the config's offline: true.
+var offlineMode bool
+
+// requireOnline fails in offline mode; what names what needs the network.
+func requireOnline(what string) error {
+    if offlineMode {
+        return fmt.Errorf("%s needs the network, which is off (offline mode)", what)
+    }
+    return nil
+}
+
+// noteStale tells, in offline mode, that an answer comes from the cache and
+// how old it is.
+func noteStale(e forgeCacheEntry) {
+    age := time.Since(e.Fetched).Round(time.Minute)
+    fmt.Fprintf(os.Stderr, "offline: using the stale answer to %s from %s (%s old)\n", e.Request, e.Fetched.Local().Format("2006-01-02 15:04"), age)
+}
+
+// offlineAnswer returns the cached entry for key in offline mode, failing
+// when there is none.
+func offlineAnswer(key, request string) ([]byte, error) {
+    e, ok := loadForgeCache(key)
+    if !ok {
+        return nil, fmt.Errorf("%s needs the network, which is off (offline mode), and has no cached answer", request)
+    }
+    noteStale(e)
+    return []byte(e.Body), nil
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "os/user"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// With `log: true` in the config gist appends every change it makes (to a
+// repository's or the global git config, to remotes, the config file and
+// its state) to a JSON-lines log next to the config, for auditing shared
+// machines: who ran what, when, and each key's old and new value.
+
+// operationLog is set from the config's log setting when gist starts.
+var operationLog bool
+
+// maxLogSize is the size at which the log is rotated to log.jsonl.1.
+const maxLogSize = 5 << 20
+
+// logEntry is one changed key.
+type logEntry struct {
+    Time    string `json:"time"`
+    User    string `json:"user"`
+    Host    string `json:"host"`
+    Command string `json:"command"`
+    // Target is where the key lives: a repository root, "global",
+    // "system", a gitconfig file, "config" or "state".
+    Target string `json:"target"`
+    Key    string `json:"key"`
+    Old    string `json:"old,omitempty"`
+    New    string `json:"new,omitempty"`
+    // Op is "set" or "unset".
+    Op string `json:"op"`
+}
+
+// logPath returns the operation log's location.
+func logPath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "log.jsonl")
+}
+
+// logUser names who runs gist, including who became root through sudo.
+func logUser() string {
+    name := os.Getenv("USER")
+    if u, err := user.Current(); err == nil {
+        name = u.Username
+    }
+    if sudo := os.Getenv("SUDO_USER"); sudo != "" && sudo != name {
+        name += " (sudo from " + sudo + ")"
+    }
+    return name
+}
+
+// secretKey reports whether a key's values must not be logged: tokens,
+// extra HTTP headers and a profile's environment.
+func secretKey(key string) bool {
+    last := key[strings.LastIndex(key, ".")+1:]
+    return last == "credential_token" || strings.EqualFold(last, "extraheader") || last == "http_extra_header" ||
+        strings.Contains(key, ".env.")
+}
+
+// logChanges appends the changes to the operation log when it is enabled.
+// Failing to log never fails the operation; it is reported instead.
+func logChanges(entries []logEntry) {
+    if !operationLog || len(entries) == 0 {
+        return
+    }
+    if err := writeLog(entries); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot write %s: %v\n", logPath(), err)
+    }
+}
+
+// writeLog stamps the entries and appends them to the log, rotating it
+// once it outgrows maxLogSize.
+func writeLog(entries []logEntry) error {
+    path := logPath()
+    if info, err := os.Stat(path); err == nil && info.Size() > maxLogSize {
+        if err := os.Rename(path, path+".1"); err != nil {
+            return err
+        }
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return err
+    }
+    f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    host, _ := os.Hostname()
+    now, who, command := time.Now().Format(time.RFC3339), logUser(), strings.Join(append([]string{"gist"}, os.Args[1:]...), " ")
+    var sb strings.Builder
+    for _, e := range entries {
+        e.Time, e.User, e.Host, e.Command = now, who, host, command
+        if secretKey(e.Key) {
+            if e.Old != "" {
+                e.Old = "(redacted)"
+            }
+            if e.New != "" {
+                e.New = "(redacted)"
+            }
+        }
+        line, err := json.Marshal(e)
+        if err != nil {
+            return err
+        }
+        sb.Write(line)
+        sb.WriteByte('\n')
+    }
+    _, err = f.WriteString(sb.String())
+    return err
+}
+
+// diffValues returns the entries turning old into new.
+func diffValues(target string, old, new map[string]string) []logEntry {
+    var entries []logEntry
+    for _, key := range sortedKeys(old) {
+        if v, ok := new[key]; !ok {
+            entries = append(entries, logEntry{Target: target, Key: key, Old: old[key], Op: "unset"})
+        } else if v != old[key] {
+            entries = append(entries, logEntry{Target: target, Key: key, Old: old[key], New: v, Op: "set"})
+        }
+    }
+    for _, key := range sortedKeys(new) {
+        if _, ok := old[key]; !ok {
+            entries = append(entries, logEntry{Target: target, Key: key, New: new[key], Op: "set"})
+        }
+    }
+    return entries
+}
+
+// flattenConfig maps the config text's values to dotted keys, such as
+// profiles.work.email or rules.2.dir, without resolving anything. List
+// items are named by their name key or their position; lists of bare
+// values are joined.
+func flattenConfig(data []byte) map[string]string {
+    values := map[string]string{}
+    type level struct {
+        indent int
+        path   string
+        item   bool
+    }
+    var stack []level
+    items := map[string]int{}
+    for _, line := range strings.Split(string(data), "\n") {
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+            continue
+        }
+        indent := len(line) - len(strings.TrimLeft(line, " \t"))
+        isItem := strings.HasPrefix(trimmed, "-")
+        // A list may be indented as deep as its key.
+        for len(stack) > 0 {
+            top := stack[len(stack)-1]
+            if top.indent < indent || (top.indent == indent && isItem && !top.item) {
+                break
+            }
+            stack = stack[:len(stack)-1]
+        }
+        parent := ""
+        if len(stack) > 0 {
+            parent = stack[len(stack)-1].path + "."
+        }
+        key, value, ok := parseKeyValue(trimmed)
+        if isItem {
+            list := strings.TrimSuffix(parent, ".")
+            if !ok {
+                item := strings.Trim(strings.TrimSpace(trimmed[1:]), "\"'")
+                if values[list] != "" {
+                    item = values[list] + ", " + item
+                }
+                values[list] = item
+                continue
+            }
+            items[list]++
+            id := strconv.Itoa(items[list])
+            if key == "name" {
+                id = value
+            }
+            stack = append(stack, level{indent, parent + id, true})
+            parent += id + "."
+        }
+        if !ok {
+            continue
+        }
+        if value == "" {
+            stack = append(stack, level{indent, parent + key, false})
+            continue
+        }
+        values[parent+key] = value
+    }
+    return values
+}
+
+// logConfigSave logs the changes between the config text before and after
+// a save.
+func logConfigSave(before, after []byte) {
+    if operationLog {
+        logChanges(diffValues("config", flattenConfig(before), flattenConfig(after)))
+    }
+}
+
+// logStateSave logs the pins and tracked repositories a state save changes.
+func logStateSave(before, after State) {
+    if !operationLog {
+        return
+    }
+    flatten := func(st State) map[string]string {
+        values := map[string]string{}
+        for repo, profile := range st.Pins {
+            values["pins."+repo] = profile
+        }
+        for repo, profile := range st.Repos {
+            values["repos."+repo] = profile
+        }
+        return values
+    }
+    logChanges(diffValues("state", flatten(before), flatten(after)))
+}
+
+// gitWrite recognises a git command that changes configuration: a git
+// config set or unset, or a remote's URL. It returns the command reading
+// the key's current value and describes the change.
+func gitWrite(args []string) (get []string, entry logEntry, ok bool) {
+    sub := gitSubcommand(args)
+    i := 0
+    for i < len(args) && args[i] != sub {
+        i++
+    }
+    prefix, rest := args[:i], args[i+1:]
+    if sub == "remote" && len(rest) >= 3 && rest[0] == "set-url" && !strings.HasPrefix(rest[1], "-") {
+        key := "remote." + rest[1] + ".url"
+        get = append(append([]string{}, prefix...), "config", "--get", key)
+        return get, logEntry{Key: key, New: rest[2], Op: "set"}, true
+    }
+    if sub != "config" {
+        return nil, logEntry{}, false
+    }
+    var scope, positional []string
+    op := "set"
+    for j := 0; j < len(rest); j++ {
+        switch a := rest[j]; {
+        case a == "--local" || a == "--global" || a == "--system" || a == "--worktree":
+            scope = append(scope, a)
+        case a == "--file" || a == "-f":
+            if j+1 < len(rest) {
+                scope = append(scope, a, rest[j+1])
+                j++
+            }
+        case a == "--unset" || a == "--unset-all":
+            op = "unset"
+        case a == "--add" || a == "--replace-all" || strings.HasPrefix(a, "--type"):
+        case strings.HasPrefix(a, "-"):
+            // --get, --list, --get-regexp and friends only read.
+            return nil, logEntry{}, false
+        default:
+            positional = append(positional, a)
+        }
+    }
+    switch {
+    case op == "unset" && len(positional) >= 1:
+        entry = logEntry{Key: positional[0], Op: op}
+    case op == "set" && len(positional) == 2:
+        entry = logEntry{Key: positional[0], New: positional[1], Op: op}
+    default:
+        return nil, logEntry{}, false
+    }
+    get = append(append(append(append([]string{}, prefix...), "config"), scope...), "--get-all", entry.Key)
+    return get, entry, true
+}
+
+// gitTarget names the config a git config command writes to.
+func gitTarget(args []string) string {
+    for i, a := range args {
+        switch a {
+        case "--global":
+            return "global"
+        case "--system":
+            return "system"
+        case "--file", "-f":
+            if i+1 < len(args) {
+                return args[i+1]
+            }
+        }
+    }
+    sub := gitSubcommand(args)
+    var prefix []string
+    for _, a := range args {
+        if a == sub {
+            break
+        }
+        prefix = append(prefix, a)
+    }
+    if root, err := runGit(append(prefix, "rev-parse", "--show-toplevel")...); err == nil {
+        return root
+    }
+    return "local"
+}
+
+// logGit runs a git command through run, logging the change it makes to
+// the configuration when the log is enabled and the command succeeds.
+func logGit(args []string, run func() (string, error)) (string, error) {
+    if !operationLog {
+        return run()
+    }
+    get, entry, ok := gitWrite(args)
+    if !ok {
+        return run()
+    }
+    old, getErr := runGit(get...)
+    out, err := run()
+    if err != nil {
+        return out, err
+    }
+    if getErr == nil {
+        entry.Old = old
+    }
+    if entry.Old == entry.New && entry.Op == "set" {
+        return out, err
+    }
+    entry.Target = gitTarget(args)
+    logChanges([]logEntry{entry})
+    return out, err
+}
+
+// readLog returns the logged entries, oldest first, including the rotated
+// log.
+func readLog() ([]logEntry, error) {
+    var entries []logEntry
+    for _, path := range []string{logPath() + ".1", logPath()} {
+        f, err := os.Open(path)
+        if os.IsNotExist(err) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        scanner := bufio.NewScanner(f)
+        scanner.Buffer(make([]byte, 64*1024), 1<<20)
+        for scanner.Scan() {
+            var e logEntry
+            if json.Unmarshal(scanner.Bytes(), &e) == nil {
+                entries = append(entries, e)
+            }
+        }
+        f.Close()
+        if err := scanner.Err(); err != nil {
+            return nil, err
+        }
+    }
+    return entries, nil
+}
+
+// commandLog runs the `log` subcommands.
+func commandLog(args []string) error {
+    usage := errors.New("usage: gist log show [-n <count>] [--target <repo|config|state|global>] [--json]")
+    if len(args) == 0 || args[0] != "show" {
+        return usage
+    }
+    count, target, asJSON := 50, "", false
+    for i := 1; i < len(args); i++ {
+        switch {
+        case args[i] == "--json":
+            asJSON = true
+        case args[i] == "-n" && i+1 < len(args):
+            n, err := strconv.Atoi(args[i+1])
+            if err != nil || n < 0 {
+                return usage
+            }
+            count = n
+            i++
+        case args[i] == "--target" && i+1 < len(args):
+            target = args[i+1]
+            if abs, err := filepath.Abs(expandHome(target)); err == nil && strings.ContainsAny(target, `/\.~`) {
+                target = abs
+            }
+            i++
+        default:
+            return usage
+        }
+    }
+    entries, err := readLog()
+    if err != nil {
+        return err
+    }
+    var shown []logEntry
+    for _, e := range entries {
+        if target == "" || e.Target == target {
+            shown = append(shown, e)
+        }
+    }
+    if count > 0 && len(shown) > count {
+        shown = shown[len(shown)-count:]
+    }
+    if asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        for _, e := range shown {
+            if err := enc.Encode(e); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+    if len(shown) == 0 {
+        if !operationLog {
+            fmt.Println("nothing logged; enable the log with `log: true` in the config")
+        } else {
+            fmt.Println("nothing logged")
+        }
+        return nil
+    }
+    for _, e := range shown {
+        change := fmt.Sprintf("%q → %q", e.Old, e.New)
+        switch {
+        case e.Op == "unset":
+            change = fmt.Sprintf("unset (was %q)", e.Old)
+        case e.Old == "":
+            change = fmt.Sprintf("= %q", e.New)
+        }
+        fmt.Printf("%s  %s@%s  %s  %s %s\n    by `%s`\n", e.Time, e.User, e.Host, e.Target, e.Key, change, e.Command)
+    }
+    return nil
+}
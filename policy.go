@@ -0,0 +1,235 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// Policy is an organisation-provided bundle installed with `gist policy
+// install`. It is merged read-only into the config: its rules take part in
+// resolution and its constraints are enforced by verify.
+type Policy struct {
+    Name string `yaml:"name"`
+    // Source is the URL or path the policy was installed from.
+    Source string `yaml:"source"`
+    // AllowedDomains restricts identity emails to these domains.
+    AllowedDomains []string `yaml:"allowed_domains,omitempty"`
+    // RequireSigning demands a signing key on the active profile.
+    RequireSigning bool `yaml:"require_signing,omitempty"`
+    // ForbiddenHosts lists remote hosts repositories must not use.
+    ForbiddenHosts []string `yaml:"forbidden_hosts,omitempty"`
+    Rules          []Rule   `yaml:"rules,omitempty"`
+}
+
+// policyPath returns where the installed policy is kept.
+func policyPath(configPath string) string {
+    return filepath.Join(filepath.Dir(configPath), "policy.yaml")
+}
+
+// parsePolicy reads a policy bundle.
+func parsePolicy(data []byte) (*Policy, error) {
+    pol := &Policy{}
+    section := ""
+    var rule *Rule
+    for _, line := range strings.Split(string(data), "\n") {
+        line = stripComment(line)
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+            continue
+        }
+        if line[0] != ' ' && line[0] != '\t' && !strings.HasPrefix(trimmed, "-") {
+            key, value, ok := parseKeyValue(trimmed)
+            if !ok {
+                return nil, fmt.Errorf("invalid policy line %q", trimmed)
+            }
+            section = ""
+            switch key {
+            case "name":
+                pol.Name = value
+            case "source":
+                pol.Source = value
+            case "require_signing":
+                pol.RequireSigning = value == "true"
+            default:
+                if value == "" {
+                    section = key
+                }
+            }
+            continue
+        }
+        item := strings.Trim(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")), "\"'")
+        switch section {
+        case "allowed_domains":
+            // They end up in server hooks; only plain domains are accepted.
+            if !hookDomain.MatchString(strings.ToLower(item)) {
+                return nil, fmt.Errorf("allowed domain %q is not a valid domain", item)
+            }
+            pol.AllowedDomains = append(pol.AllowedDomains, strings.ToLower(item))
+        case "forbidden_hosts":
+            pol.ForbiddenHosts = append(pol.ForbiddenHosts, strings.ToLower(item))
+        case "rules":
+            if strings.HasPrefix(trimmed, "-") {
+                pol.Rules = append(pol.Rules, Rule{})
+                rule = &pol.Rules[len(pol.Rules)-1]
+            }
+            if key, value, ok := parseKeyValue(trimmed); ok && rule != nil {
+                loadRuleKey(rule, key, value)
+            }
+        }
+    }
+    if pol.Name == "" {
+        return nil, errors.New("policy has no name")
+    }
+    return pol, nil
+}
+
+// loadPolicy merges the installed policy, if any, into cfg. Policy rules are
+// appended after the user's rules and marked so they are never saved.
+func loadPolicy(configPath string, cfg *Config) error {
+    data, err := os.ReadFile(policyPath(configPath))
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return err
+    }
+    pol, err := parsePolicy(data)
+    if err != nil {
+        return fmt.Errorf("invalid policy %s: %w", policyPath(configPath), err)
+    }
+    cfg.Policy = pol
+    for _, r := range pol.Rules {
+        r.Source = "policy " + pol.Name
+        cfg.Rules = append(cfg.Rules, r)
+    }
+    return nil
+}
+
+// fetchPolicy reads a policy from an http(s) URL or a local path.
+func fetchPolicy(source string) ([]byte, error) {
+    if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+        return os.ReadFile(expandHome(source))
+    }
+    if err := requireOnline("fetching " + source); err != nil {
+        return nil, err
+    }
+    client := &http.Client{Timeout: 30 * time.Second}
+    resp, err := client.Get(source)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+    }
+    return io.ReadAll(resp.Body)
+}
+
+// installPolicy fetches, validates and stores a policy bundle read-only.
+func installPolicy(configPath, source string) error {
+    data, err := fetchPolicy(source)
+    if err != nil {
+        return err
+    }
+    pol, err := parsePolicy(data)
+    if err != nil {
+        return err
+    }
+    path := policyPath(configPath)
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return err
+    }
+    // Record provenance; a source line in the bundle itself is replaced.
+    body := "source: " + source + "\n"
+    for _, line := range strings.Split(string(data), "\n") {
+        if !strings.HasPrefix(line, "source:") {
+            body += line + "\n"
+        }
+    }
+    os.Remove(path)
+    if err := os.WriteFile(path, []byte(strings.TrimRight(body, "\n")+"\n"), 0o444); err != nil {
+        return err
+    }
+    fmt.Printf("✔️  Installed policy %s from %s (%d rules)\n", pol.Name, source, len(pol.Rules))
+    return nil
+}
+
+// describe returns where the policy comes from, for error messages.
+func (p *Policy) describe() string {
+    return fmt.Sprintf("policy %s from %s", p.Name, p.Source)
+}
+
+// policyViolations checks the active profile and the repository's remotes
+// against the policy.
+func policyViolations(pol *Policy, p *Profile, remotes []remoteInfo) []string {
+    if pol == nil {
+        return nil
+    }
+    var problems []string
+    if len(pol.AllowedDomains) > 0 {
+        _, domain, _ := strings.Cut(strings.ToLower(p.Email), "@")
+        allowed := false
+        for _, d := range pol.AllowedDomains {
+            if domain == d || strings.HasSuffix(domain, "."+d) {
+                allowed = true
+            }
+        }
+        if !allowed {
+            problems = append(problems, fmt.Sprintf("email domain %s is not allowed (%s)", domain, pol.describe()))
+        }
+    }
+    if pol.RequireSigning && p.SigningKey == "" && p.SigningFormat != formatGitsign {
+        problems = append(problems, fmt.Sprintf("profile %s has no signing key but signing is required (%s)", p.Name, pol.describe()))
+    }
+    for _, rm := range remotes {
+        host := remoteHost(rm.URL)
+        for _, f := range pol.ForbiddenHosts {
+            if host == f {
+                problems = append(problems, fmt.Sprintf("remote %s uses forbidden host %s (%s)", rm.Name, host, pol.describe()))
+            }
+        }
+    }
+    return problems
+}
+
+// commandPolicy runs the `policy` subcommands.
+func commandPolicy(cfg Config, configPath string, args []string) error {
+    if len(args) == 0 {
+        return errors.New("usage: gist policy install <url>|show")
+    }
+    switch args[0] {
+    case "install":
+        if len(args) < 2 {
+            return errors.New("usage: gist policy install <url>")
+        }
+        return installPolicy(configPath, args[1])
+    case "show":
+        pol := cfg.Policy
+        if pol == nil {
+            fmt.Println("no policy installed")
+            return nil
+        }
+        fmt.Printf("%s\n", pol.describe())
+        if len(pol.AllowedDomains) > 0 {
+            fmt.Printf("  allowed domains: %s\n", strings.Join(pol.AllowedDomains, ", "))
+        }
+        if pol.RequireSigning {
+            fmt.Println("  signing required")
+        }
+        if len(pol.ForbiddenHosts) > 0 {
+            fmt.Printf("  forbidden hosts: %s\n", strings.Join(pol.ForbiddenHosts, ", "))
+        }
+        for _, r := range pol.Rules {
+            fmt.Printf("  rule: %s (%s)\n", r.Profile, r.describe())
+        }
+        return nil
+    default:
+        return fmt.Errorf("unknown policy subcommand: %s", args[0])
+    }
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// porcelainVersion is the newest porcelain format. Fields are only ever
+// appended within a version; anything else bumps it.
+const porcelainVersion = 1
+
+// porcelainFlag removes --porcelain[=v<N>] from args and returns the
+// requested format version, or 0 when the flag is absent.
+func porcelainFlag(args []string) ([]string, int, error) {
+    var rest []string
+    version := 0
+    for _, a := range args {
+        if a == "--porcelain" {
+            version = porcelainVersion
+            continue
+        }
+        if v, ok := strings.CutPrefix(a, "--porcelain="); ok {
+            n, err := strconv.Atoi(strings.TrimPrefix(v, "v"))
+            if err != nil || n < 1 || n > porcelainVersion {
+                return nil, 0, fmt.Errorf("unsupported porcelain version %q (newest is v%d)", v, porcelainVersion)
+            }
+            version = n
+            continue
+        }
+        rest = append(rest, a)
+    }
+    return rest, version, nil
+}
+
+// porcelainLine prints one tab-separated record. Tabs and newlines inside
+// fields are replaced by spaces so every record stays on one line.
+func porcelainLine(fields ...string) {
+    for i, f := range fields {
+        fields[i] = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(f)
+    }
+    fmt.Println(strings.Join(fields, "\t"))
+}
+
+// flag renders a boolean porcelain field.
+func flag(b bool) string {
+    if b {
+        return "1"
+    }
+    return "0"
+}
+
+// porcelainList prints one record per profile:
+//
+//  profile <name> <email> <username> <locked> <default>
+func porcelainList(cfg Config) {
+    for _, p := range cfg.Profiles {
+        porcelainLine("profile", p.Name, p.Email, p.Username, flag(p.Locked), flag(p.Name == cfg.DefaultProfile))
+    }
+}
+
+// porcelainInfo prints the identity in effect:
+//
+//  scope <repo|global|system|included>
+//  profile <name or empty> <pinned profile or empty>
+//  user.name <value> <config file>
+//  user.email <value> <config file>
+func porcelainInfo(cfg Config) {
+    id := currentIdentity(&cfg)
+    scope := id.Scope
+    if id.Email.Included {
+        scope = "included"
+    }
+    porcelainLine("scope", scope)
+    name := ""
+    if id.Profile != nil {
+        name = id.Profile.Name
+    }
+    pin := ""
+    if inRepo, root := isGitRepo(); inRepo {
+        pin = pinnedProfile(root)
+    }
+    porcelainLine("profile", name, pin)
+    porcelainLine("user.name", id.Name.Value, id.Name.File)
+    porcelainLine("user.email", id.Email.Value, id.Email.File)
+}
+
+// porcelainWhich prints how the profile for the repository is chosen:
+//
+//  repository <root>
+//  remote <name> <url> <profile or empty> <rule number or 0>
+//  resolved <profile or empty> <pin|rule|host|default|none> <rule number, host or empty>
+func porcelainWhich(cfg Config) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    remotes := listRemotes()
+    porcelainLine("repository", repoRoot)
+    for _, rm := range remotes {
+        profile, rule := "", 0
+        if i := remoteRule(cfg, repoRoot, rm); i != -1 {
+            profile, rule = cfg.Rules[i].Profile, i+1
+        }
+        porcelainLine("remote", rm.Name, rm.URL, profile, strconv.Itoa(rule))
+    }
+    if pin := pinnedProfile(repoRoot); pin != "" {
+        porcelainLine("resolved", pin, "pin", "")
+        return nil
+    }
+    if idx := resolveRule(cfg, repoRoot, remotes); idx != -1 {
+        porcelainLine("resolved", cfg.Rules[idx].Profile, "rule", strconv.Itoa(idx+1))
+        return nil
+    }
+    name, reason := fallbackProfile(cfg, remotes)
+    switch kind, detail, _ := strings.Cut(reason, " "); kind {
+    case "host":
+        porcelainLine("resolved", name, "host", detail)
+    case "default_profile":
+        porcelainLine("resolved", name, "default", "")
+    default:
+        porcelainLine("resolved", "", "none", "")
+    }
+    return nil
+}
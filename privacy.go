@@ -0,0 +1,75 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// errPrivacyLeak is returned by the privacy lint in blocking mode.
+var errPrivacyLeak = errors.New("commit blocked: staged changes contain another profile's identity")
+
+// foreignIdentities returns the emails and full names of every profile other
+// than the active one. Single-word names are skipped as too likely to appear
+// in code by coincidence.
+func foreignIdentities(cfg Config, active *Profile) []string {
+    var needles []string
+    for _, p := range cfg.Profiles {
+        if p.Name == active.Name {
+            continue
+        }
+        if p.Email != "" && !strings.EqualFold(p.Email, active.Email) {
+            needles = append(needles, p.Email)
+        }
+        if strings.Contains(strings.TrimSpace(p.Username), " ") && p.Username != active.Username {
+            needles = append(needles, p.Username)
+        }
+    }
+    return needles
+}
+
+// commandPrivacy scans the lines added by the staged changes for identities
+// of profiles other than the active one, warning (or with block, failing)
+// before e.g. a personal email is committed under a work profile.
+func commandPrivacy(cfg Config, block bool) error {
+    inRepo, _ := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    active, err := activeProfile(&cfg)
+    if err != nil {
+        // Without a known identity there is nothing to compare against.
+        return nil
+    }
+    needles := foreignIdentities(cfg, active)
+    if len(needles) == 0 {
+        return nil
+    }
+    diff, err := runGit("diff", "--cached", "--no-color", "--no-ext-diff", "-U0")
+    if err != nil {
+        return fmt.Errorf("cannot read staged changes: %s", diff)
+    }
+    leaks := 0
+    file := ""
+    for _, line := range strings.Split(diff, "\n") {
+        if strings.HasPrefix(line, "+++ ") {
+            file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+            continue
+        }
+        if !strings.HasPrefix(line, "+") {
+            continue
+        }
+        lower := strings.ToLower(line)
+        for _, n := range needles {
+            if strings.Contains(lower, strings.ToLower(n)) {
+                fmt.Fprintf(os.Stderr, "⚠ gist: %s adds %q while committing as profile %s\n", file, n, active.Name)
+                leaks++
+            }
+        }
+    }
+    if leaks > 0 && block {
+        return errPrivacyLeak
+    }
+    return nil
+}
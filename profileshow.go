@@ -0,0 +1,326 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "reflect"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+)
+
+// `gist profile show <name>` is one page per identity: every setting as gist
+// applies it – resolver expressions and ${VAR} references resolved, with
+// where the value came from, secrets masked – then what selects the
+// profile (rules, the hosts map, default_profile), the repositories gist set
+// it in or pinned to it, and the health of its keys.
+
+// profileSetting is one setting of the profile. Raw is the config text of
+// values that are resolved (a "!name arg" expression or a ${VAR}
+// reference); Secret values are masked.
+type profileSetting struct {
+    Key    string `json:"key"`
+    Value  string `json:"value"`
+    Raw    string `json:"raw,omitempty"`
+    Secret bool   `json:"secret,omitempty"`
+}
+
+// profileRepo is a repository gist knows the profile from.
+type profileRepo struct {
+    Root   string `json:"root"`
+    Pinned bool   `json:"pinned,omitempty"`
+    // Verified is the time of the last check; Drift says how it failed.
+    Verified *time.Time `json:"verified,omitempty"`
+    Drift    string     `json:"drift,omitempty"`
+}
+
+// profileKey is the health of one of the profile's keys.
+type profileKey struct {
+    Kind    string     `json:"kind"`
+    Key     string     `json:"key"`
+    Created *time.Time `json:"created,omitempty"`
+    Expires *time.Time `json:"expires,omitempty"`
+    Problem string     `json:"problem,omitempty"`
+}
+
+// profileView is the whole page.
+type profileView struct {
+    Name     string           `json:"name"`
+    Source   string           `json:"source"`
+    Locked   bool             `json:"locked,omitempty"`
+    Settings []profileSetting `json:"settings"`
+    Rules    []string         `json:"rules,omitempty"`
+    Hosts    []string         `json:"hosts,omitempty"`
+    Default  bool             `json:"default,omitempty"`
+    Repos    []profileRepo    `json:"repositories,omitempty"`
+    Keys     []profileKey     `json:"keys,omitempty"`
+    Problems []string         `json:"problems,omitempty"`
+}
+
+// secretSettings are masked unless they come from a resolver, whose
+// expression says where the secret lives rather than what it is.
+var secretSettings = map[string]bool{"credential_token": true, "http_extra_header": true}
+
+// secretEnvName matches env entries likely to hold a credential.
+var secretEnvName = regexp.MustCompile(`(?i)token|password|secret|_key$`)
+
+// viewSettings lists the profile's non-empty settings in the order the
+// Profile struct declares them; maps are listed entry by entry.
+func viewSettings(p *Profile) []profileSetting {
+    var settings []profileSetting
+    v, t := reflect.ValueOf(*p), reflect.TypeOf(*p)
+    for i := 0; i < t.NumField(); i++ {
+        key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+        if key == "" || key == "-" || key == "name" || key == "locked" {
+            continue
+        }
+        f := v.Field(i)
+        switch f.Kind() {
+        case reflect.String:
+            if f.String() == "" {
+                continue
+            }
+            s := profileSetting{Key: key, Value: f.String(), Raw: p.raw[key]}
+            if secretSettings[key] {
+                s.Secret = true
+                switch {
+                case s.Raw == "":
+                    s.Value = "••••••"
+                case key == "credential_token":
+                    // Kept unresolved until git asks for it.
+                    s.Value = "(resolved when git asks)"
+                default:
+                    s.Value = "•••••• (resolved)"
+                }
+            }
+            settings = append(settings, s)
+        case reflect.Bool:
+            if f.Bool() {
+                settings = append(settings, profileSetting{Key: key, Value: "true"})
+            }
+        case reflect.Slice:
+            for j := 0; j < f.Len(); j++ {
+                settings = append(settings, profileSetting{Key: key, Value: f.Index(j).String()})
+            }
+        case reflect.Map:
+            entries := map[string]string{}
+            for _, k := range f.MapKeys() {
+                entries[k.String()] = f.MapIndex(k).String()
+            }
+            for _, k := range sortedKeys(entries) {
+                s := profileSetting{Key: key + "." + k, Value: entries[k]}
+                if key == "env" {
+                    if expanded := os.ExpandEnv(s.Value); expanded != s.Value {
+                        s.Raw, s.Value = s.Value, expanded
+                    }
+                    if secretEnvName.MatchString(k) {
+                        s.Secret, s.Value = true, "••••••"
+                    }
+                }
+                settings = append(settings, s)
+            }
+        }
+    }
+    return settings
+}
+
+// profileKeys checks the profile's signing and SSH keys: the signing key's
+// dates as `stats keys` reads them, and the SSH key file as `list --check`
+// does.
+func profileKeys(p *Profile) []profileKey {
+    var keys []profileKey
+    at := func(t time.Time) *time.Time {
+        if t.IsZero() {
+            return nil
+        }
+        return &t
+    }
+    if p.SigningKey != "" {
+        order, dates := signingKeys(Config{Profiles: []Profile{*p}})
+        for _, key := range order {
+            d := dates[key]
+            k := profileKey{Kind: d.Kind + " signing", Key: key, Created: at(d.Created), Expires: at(d.Expires)}
+            switch {
+            case d.Err != nil:
+                k.Problem = d.Err.Error()
+            case !d.Expires.IsZero() && d.Expires.Before(time.Now()):
+                k.Problem = "expired"
+            case !d.Expires.IsZero() && d.Expires.Before(time.Now().AddDate(0, 0, defaultExpiryWindow)):
+                k.Problem = fmt.Sprintf("expires within %d days", defaultExpiryWindow)
+            }
+            keys = append(keys, k)
+        }
+    }
+    if p.SSHKey != "" {
+        k := profileKey{Kind: "ssh", Key: p.SSHKey}
+        if err := checkKeyFile(profilePath(p, p.SSHKey), true); err != nil {
+            k.Problem = err.Error()
+        }
+        keys = append(keys, k)
+    }
+    return keys
+}
+
+// viewProfile gathers the page for the profile.
+func viewProfile(cfg Config, p *Profile) (profileView, error) {
+    view := profileView{Name: p.Name, Source: profileOrigin(p), Locked: p.Locked, Settings: viewSettings(p)}
+    for i, r := range cfg.Rules {
+        if r.Profile != p.Name {
+            continue
+        }
+        rule := fmt.Sprintf("rule %d: %s", i+1, r.describe())
+        if r.Source != "" {
+            rule += " (" + r.Source + ")"
+        }
+        view.Rules = append(view.Rules, rule)
+    }
+    view.Hosts = profileHosts(cfg, p)
+    view.Default = cfg.DefaultProfile == p.Name
+    records, err := loadRepoRecords()
+    if err != nil {
+        return view, err
+    }
+    for _, rec := range records {
+        if rec.Profile != p.Name && rec.Pin != p.Name {
+            continue
+        }
+        repo := profileRepo{Root: rec.Root, Pinned: rec.Pin == p.Name}
+        if v := rec.LastVerify; v != nil {
+            repo.Verified, repo.Drift = &v.Time, v.Reason
+        }
+        view.Repos = append(view.Repos, repo)
+    }
+    sort.Slice(view.Repos, func(i, j int) bool { return view.Repos[i].Root < view.Repos[j].Root })
+    view.Keys = profileKeys(p)
+    // The key checks are among checkProfile's; they are shown with the keys.
+    reported := map[string]bool{}
+    for _, k := range view.Keys {
+        reported[k.Problem] = true
+    }
+    for _, problem := range checkProfile(*p) {
+        if !reported[problem.Error()] {
+            view.Problems = append(view.Problems, problem.Error())
+        }
+    }
+    if err := profileGitRequirements(p); err != nil {
+        view.Problems = append(view.Problems, err.Error())
+    }
+    return view, nil
+}
+
+// printProfileView writes the page as text.
+func printProfileView(view profileView) {
+    fmt.Printf("profile %s (%s)", view.Name, view.Source)
+    if view.Locked {
+        fmt.Print(", locked")
+    }
+    fmt.Println()
+    fmt.Println("settings:")
+    width := 0
+    for _, s := range view.Settings {
+        if len(s.Key) > width {
+            width = len(s.Key)
+        }
+    }
+    for _, s := range view.Settings {
+        from := ""
+        if s.Raw != "" {
+            from = "  ← " + s.Raw
+        }
+        fmt.Printf("  %-*s  %s%s\n", width, s.Key, s.Value, from)
+    }
+    fmt.Println("selected by:")
+    if len(view.Rules) == 0 && len(view.Hosts) == 0 && !view.Default {
+        fmt.Println("  nothing (only set explicitly)")
+    }
+    for _, r := range view.Rules {
+        fmt.Println("  " + r)
+    }
+    for _, h := range view.Hosts {
+        fmt.Println("  hosts: " + h)
+    }
+    if view.Default {
+        fmt.Println("  default_profile")
+    }
+    fmt.Println("repositories:")
+    if len(view.Repos) == 0 {
+        fmt.Println("  none recorded")
+    }
+    for _, r := range view.Repos {
+        line := "  " + r.Root
+        if r.Pinned {
+            line += " (pinned)"
+        }
+        switch {
+        case r.Verified == nil:
+        case r.Drift != "":
+            line += fmt.Sprintf(" ✘ drifted %s: %s", r.Verified.Local().Format("2006-01-02"), r.Drift)
+        default:
+            line += fmt.Sprintf(" ✔ verified %s", r.Verified.Local().Format("2006-01-02"))
+        }
+        fmt.Println(line)
+    }
+    if len(view.Keys) > 0 {
+        fmt.Println("keys:")
+    }
+    for _, k := range view.Keys {
+        line := fmt.Sprintf("  %s %s", k.Kind, k.Key)
+        if k.Expires != nil {
+            line += ", expires " + k.Expires.Format("2006-01-02")
+        }
+        if k.Problem != "" {
+            line = "  ✘" + strings.TrimPrefix(line, " ") + ": " + k.Problem
+        } else {
+            line = "  ✔" + strings.TrimPrefix(line, " ")
+        }
+        fmt.Println(line)
+    }
+    if len(view.Problems) > 0 {
+        fmt.Println("problems:")
+        for _, problem := range view.Problems {
+            fmt.Println("  ✘ " + problem)
+        }
+    }
+}
+
+// profileUsage is the usage of `gist profile`.
+var profileUsage = errors.New("usage: gist profile show <name> [--json]")
+
+// commandProfile runs the `profile` subcommands.
+func commandProfile(cfg Config, args []string) error {
+    if len(args) < 2 || args[0] != "show" {
+        return profileUsage
+    }
+    name, asJSON := "", false
+    for _, a := range args[1:] {
+        switch {
+        case a == "--json":
+            asJSON = true
+        case name == "" && !strings.HasPrefix(a, "-"):
+            name = a
+        default:
+            return profileUsage
+        }
+    }
+    if name == "" {
+        return profileUsage
+    }
+    p := findProfile(&cfg, name)
+    if p == nil {
+        return errors.New(tr("profile.not_found", name))
+    }
+    view, err := viewProfile(cfg, p)
+    if err != nil {
+        return err
+    }
+    if asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        return enc.Encode(view)
+    }
+    printProfileView(view)
+    return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// `gist release manifest` writes the packaging manifests for a release –
+// the Homebrew formula, the scoop manifest and the nfpm config for .deb and
+// .rpm – from the version and the command registry, so packages install
+// the completions and their tests check that every command is there.
+
+const (
+    releaseRepo        = "https://github.com/Hnatekmar/gist"
+    releaseDescription = "Git Identity Switching Tool: switch git personas per directory"
+    releaseMaintainer  = "Hnatekmar"
+)
+
+// releaseAsset returns the name goreleaser gives an archive.
+func releaseAsset(ver, goos, arch string) string {
+    return fmt.Sprintf("gist_%s_%s_%s.tar.gz", ver, goos, arch)
+}
+
+// releaseAssetURL returns the download URL of a release archive.
+func releaseAssetURL(ver, asset string) string {
+    return releaseRepo + "/releases/download/v" + ver + "/" + asset
+}
+
+// readChecksums reads a sha256sum listing ("<hash>  <file>"), as goreleaser
+// writes it.
+func readChecksums(path string) (map[string]string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    sums := map[string]string{}
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        fields := strings.Fields(scanner.Text())
+        if len(fields) == 2 {
+            sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+        }
+    }
+    return sums, scanner.Err()
+}
+
+// rubyWords formats words as a Ruby %w[] literal.
+func rubyWords(words []string) string {
+    return "%w[" + strings.Join(words, " ") + "]"
+}
+
+// brewFormula returns the Homebrew formula.
+func brewFormula(ver string, sum func(asset string) (string, error)) (string, error) {
+    var b strings.Builder
+    fmt.Fprintf(&b, "class Gist < Formula\n")
+    fmt.Fprintf(&b, "  desc %q\n", releaseDescription)
+    fmt.Fprintf(&b, "  homepage %q\n", releaseRepo)
+    fmt.Fprintf(&b, "  version %q\n", ver)
+    fmt.Fprintf(&b, "  license \"MIT\"\n\n")
+    for _, goos := range []string{"darwin", "linux"} {
+        block := "on_macos"
+        if goos == "linux" {
+            block = "on_linux"
+        }
+        fmt.Fprintf(&b, "  %s do\n", block)
+        for _, arch := range []struct{ block, goarch string }{{"on_intel", "amd64"}, {"on_arm", "arm64"}} {
+            asset := releaseAsset(ver, goos, arch.goarch)
+            hash, err := sum(asset)
+            if err != nil {
+                return "", err
+            }
+            fmt.Fprintf(&b, "    %s do\n", arch.block)
+            fmt.Fprintf(&b, "      url %q\n", releaseAssetURL(ver, asset))
+            fmt.Fprintf(&b, "      sha256 %q\n", hash)
+            fmt.Fprintf(&b, "    end\n")
+        }
+        fmt.Fprintf(&b, "  end\n\n")
+    }
+    fmt.Fprintf(&b, "  def install\n")
+    fmt.Fprintf(&b, "    bin.install \"gist\"\n")
+    fmt.Fprintf(&b, "    generate_completions_from_executable(bin/\"gist\", \"completion\", shells: [:bash])\n")
+    fmt.Fprintf(&b, "    (zsh_completion/\"_gist\").write <<~'EOS'\n")
+    for _, line := range strings.Split(strings.TrimSuffix(zshCompletion, "\n"), "\n") {
+        fmt.Fprintf(&b, "      %s\n", line)
+    }
+    fmt.Fprintf(&b, "    EOS\n")
+    fmt.Fprintf(&b, "  end\n\n")
+    fmt.Fprintf(&b, "  test do\n")
+    fmt.Fprintf(&b, "    assert_match version.to_s, shell_output(\"#{bin}/gist --version\")\n")
+    fmt.Fprintf(&b, "    commands = shell_output(\"#{bin}/gist __complete\").split\n")
+    fmt.Fprintf(&b, "    %s.each { |c| assert_includes commands, c }\n", rubyWords(commandNames))
+    fmt.Fprintf(&b, "  end\n")
+    fmt.Fprintf(&b, "end\n")
+    return b.String(), nil
+}
+
+// scoopManifest returns the scoop manifest.
+func scoopManifest(ver string, sum func(asset string) (string, error)) (string, error) {
+    arch := map[string]any{}
+    autoupdate := map[string]any{}
+    for _, a := range []struct{ scoop, goarch string }{{"64bit", "amd64"}, {"arm64", "arm64"}} {
+        asset := releaseAsset(ver, "windows", a.goarch)
+        hash, err := sum(asset)
+        if err != nil {
+            return "", err
+        }
+        arch[a.scoop] = map[string]string{"url": releaseAssetURL(ver, asset), "hash": hash}
+        autoupdate[a.scoop] = map[string]string{
+            "url": releaseAssetURL("$version", releaseAsset("$version", "windows", a.goarch)),
+        }
+    }
+    manifest := map[string]any{
+        "version":      ver,
+        "description":  releaseDescription,
+        "homepage":     releaseRepo,
+        "license":      "MIT",
+        "architecture": arch,
+        "bin":          "gist.exe",
+        "notes":        "Run `gist completion --install` for tab completion, and `gist init` to start a config.",
+        "checkver":     map[string]string{"github": releaseRepo},
+        "autoupdate": map[string]any{
+            "architecture": autoupdate,
+            "hash":         map[string]string{"url": "$baseurl/gist_$version_checksums.txt"},
+        },
+    }
+    data, err := json.MarshalIndent(manifest, "", "    ")
+    if err != nil {
+        return "", err
+    }
+    return string(data) + "\n", nil
+}
+
+// nfpmConfig returns the nfpm config for .deb and .rpm packages; nfpm
+// takes the architecture from $GOARCH. The completions are the files
+// written next to it.
+func nfpmConfig(ver string) string {
+    var b strings.Builder
+    b.WriteString("# Generated by `gist release manifest`; build with\n")
+    b.WriteString("#   GOARCH=amd64 nfpm package -f nfpm.yaml -p deb\n")
+    b.WriteString("name: gist\n")
+    b.WriteString("arch: ${GOARCH}\n")
+    b.WriteString("platform: linux\n")
+    fmt.Fprintf(&b, "version: %s\n", ver)
+    fmt.Fprintf(&b, "maintainer: %s\n", releaseMaintainer)
+    fmt.Fprintf(&b, "description: %q\n", releaseDescription+" (commands: "+strings.Join(commandNames, ", ")+")")
+    fmt.Fprintf(&b, "homepage: %s\n", releaseRepo)
+    b.WriteString("license: MIT\n")
+    b.WriteString("depends:\n")
+    b.WriteString("  - git\n")
+    b.WriteString("contents:\n")
+    for _, c := range []struct{ src, dst string }{
+        {"./gist", "/usr/bin/gist"},
+        {"./completions/gist.bash", "/usr/share/bash-completion/completions/gist"},
+        {"./completions/_gist", "/usr/share/zsh/vendor-completions/_gist"},
+    } {
+        fmt.Fprintf(&b, "  - src: %s\n    dst: %s\n", c.src, c.dst)
+    }
+    return b.String()
+}
+
+// releaseUsage is the usage of `gist release`.
+var releaseUsage = errors.New("usage: gist release manifest [--version <v>] [--checksums <file>] [--format brew|scoop|nfpm] [-o <dir>]")
+
+// commandReleaseManifest writes the manifests for the formats (all without
+// --format) into the output directory.
+func commandReleaseManifest(args []string) error {
+    ver, checksums, out := strings.TrimPrefix(version, "v"), "", "dist"
+    var formats []string
+    for i := 0; i < len(args); i++ {
+        if i+1 >= len(args) {
+            return releaseUsage
+        }
+        switch args[i] {
+        case "--version":
+            ver = strings.TrimPrefix(args[i+1], "v")
+        case "--checksums":
+            checksums = args[i+1]
+        case "--format":
+            switch args[i+1] {
+            case "brew", "scoop", "nfpm":
+                formats = append(formats, args[i+1])
+            default:
+                return fmt.Errorf("unknown format %q (brew, scoop or nfpm)", args[i+1])
+            }
+        case "-o", "--output":
+            out = args[i+1]
+        default:
+            return releaseUsage
+        }
+        i++
+    }
+    if len(formats) == 0 {
+        formats = []string{"brew", "scoop", "nfpm"}
+    }
+    var sums map[string]string
+    if checksums != "" {
+        var err error
+        if sums, err = readChecksums(checksums); err != nil {
+            return err
+        }
+    }
+    sum := func(asset string) (string, error) {
+        if sums == nil {
+            return "", errors.New("the brew and scoop manifests need --checksums <file>, the release's checksums.txt")
+        }
+        hash, ok := sums[asset]
+        if !ok {
+            return "", fmt.Errorf("%s lists no checksum for %s", checksums, asset)
+        }
+        return hash, nil
+    }
+    files := map[string]string{}
+    for _, format := range formats {
+        switch format {
+        case "brew":
+            formula, err := brewFormula(ver, sum)
+            if err != nil {
+                return err
+            }
+            files["gist.rb"] = formula
+        case "scoop":
+            manifest, err := scoopManifest(ver, sum)
+            if err != nil {
+                return err
+            }
+            files["gist.json"] = manifest
+        case "nfpm":
+            files["nfpm.yaml"] = nfpmConfig(ver)
+            files[filepath.Join("completions", "gist.bash")] = bashCompletion
+            files[filepath.Join("completions", "_gist")] = zshCompletion
+        }
+    }
+    for _, name := range sortedKeys(files) {
+        path := filepath.Join(out, name)
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+            return err
+        }
+        if err := os.WriteFile(path, []byte(files[name]), 0o644); err != nil {
+            return err
+        }
+        fmt.Println("Wrote " + path)
+    }
+    return nil
+}
+
+// commandRelease runs the `release` subcommands, for maintainers.
+func commandRelease(args []string) error {
+    if len(args) == 0 || args[0] != "manifest" {
+        return releaseUsage
+    }
+    return commandReleaseManifest(args[1:])
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/url"
+    "os"
+    "os/exec"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// remoteConflict is a remote whose host or organisation the config assigns
+// to another profile than the one its repository uses.
+type remoteConflict struct {
+    Repo    string `json:"path"`
+    Profile string `json:"profile"`
+    Remote  string `json:"remote"`
+    URL     string `json:"url"`
+    Owner   string `json:"remote_profile"`
+    Reason  string `json:"matched_by"`
+}
+
+// remoteParts splits a remote URL into its user, host (with any port) and
+// path. ok is false for local paths.
+func remoteParts(raw string) (user, host, path string, ok bool) {
+    if strings.Contains(raw, "://") {
+        u, err := url.Parse(raw)
+        if err != nil || u.Host == "" || u.Scheme == "file" {
+            return "", "", "", false
+        }
+        if u.User != nil {
+            user = u.User.Username()
+        }
+        return user, u.Host, strings.TrimPrefix(u.Path, "/"), true
+    }
+    // scp-like syntax: [user@]host:path
+    i := strings.Index(raw, ":")
+    if i <= 0 || strings.Contains(raw[:i], "/") {
+        return "", "", "", false
+    }
+    host = raw[:i]
+    if j := strings.LastIndex(host, "@"); j >= 0 {
+        user, host = host[:j], host[j+1:]
+    }
+    return user, host, raw[i+1:], true
+}
+
+// sshHostName returns the host name ssh connects to for host, following
+// ssh_config aliases, or host itself when ssh can't tell.
+func sshHostName(host string) string {
+    out, err := exec.Command("ssh", "-G", host).Output()
+    if err != nil {
+        return host
+    }
+    for _, line := range strings.Split(string(out), "\n") {
+        if name, ok := strings.CutPrefix(line, "hostname "); ok {
+            return strings.TrimSpace(name)
+        }
+    }
+    return host
+}
+
+// unalias returns the real host behind an SSH host: the host the profile's
+// alias pattern was made from, else what ssh_config says.
+func unalias(alias, host string) string {
+    if prefix, suffix, ok := strings.Cut(alias, "%h"); ok && len(host) > len(prefix)+len(suffix) &&
+        strings.HasPrefix(host, prefix) && strings.HasSuffix(host, suffix) {
+        return host[len(prefix) : len(host)-len(suffix)]
+    }
+    return sshHostName(host)
+}
+
+// preferredRemoteURL rewrites a remote URL to the profile's remote_protocol
+// and ssh_host_alias. It returns the URL unchanged when the profile has no
+// preference or the URL can't be rewritten: local paths, and Azure DevOps
+// and CodeCommit, whose paths differ between protocols.
+func preferredRemoteURL(p *Profile, raw string) string {
+    user, host, path, ok := remoteParts(raw)
+    if !ok || p.RemoteProtocol == "" {
+        return raw
+    }
+    if _, codeCommit := normalizeCodeCommit(raw); codeCommit || remoteHost(raw) == azureHost ||
+        strings.HasSuffix(remoteHost(raw), ".visualstudio.com") || strings.HasSuffix(host, "ssh.dev.azure.com") {
+        return raw
+    }
+    isSSH := !strings.HasPrefix(raw, "http://") && !strings.HasPrefix(raw, "https://")
+    name, _, _ := strings.Cut(host, ":")
+    if isSSH {
+        name = unalias(p.SSHHostAlias, name)
+    }
+    switch p.RemoteProtocol {
+    case "https":
+        return "https://" + name + "/" + path
+    case "ssh":
+        if isSSH && p.SSHHostAlias == "" {
+            return raw
+        }
+        target := name
+        if p.SSHHostAlias != "" {
+            target = strings.ReplaceAll(p.SSHHostAlias, "%h", name)
+        }
+        if !isSSH || user == "" {
+            user = "git"
+        }
+        return user + "@" + target + ":" + path
+    }
+    return raw
+}
+
+// commandRemotesFix rewrites the current repository's remotes to the
+// active profile's preferred protocol and host alias, asking first unless
+// assumeYes is set.
+func commandRemotesFix(cfg Config, dryRun, assumeYes bool) error {
+    inRepo, _ := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    p, err := activeProfile(&cfg)
+    if err != nil {
+        name, rerr := resolveAutoProfile(cfg, false)
+        if rerr != nil {
+            return err
+        }
+        p = findProfile(&cfg, name)
+    }
+    if p == nil {
+        return errors.New("no profile is selected for this repository")
+    }
+    if p.RemoteProtocol == "" {
+        return fmt.Errorf("profile %s has no remote_protocol", p.Name)
+    }
+    type change struct{ name, from, to string }
+    var changes []change
+    for _, rm := range listRemotes() {
+        if to := preferredRemoteURL(p, rm.URL); to != rm.URL {
+            changes = append(changes, change{rm.Name, rm.URL, to})
+        }
+    }
+    if len(changes) == 0 {
+        fmt.Printf("remotes already use %s for profile %s\n", p.RemoteProtocol, p.Name)
+        return nil
+    }
+    for _, c := range changes {
+        fmt.Printf("  %s: %s → %s\n", c.name, c.from, c.to)
+    }
+    if dryRun || (!assumeYes && !confirm("Rewrite the remotes?")) {
+        return nil
+    }
+    before, _ := resolveAutoProfile(cfg, false)
+    for _, c := range changes {
+        if out, err := runGit("remote", "set-url", c.name, c.to); err != nil {
+            return fmt.Errorf("cannot rewrite remote %s: %s", c.name, out)
+        }
+    }
+    fmt.Printf("✔ Rewrote %d remote(s) for profile %s\n", len(changes), p.Name)
+    // Rules and the hosts map match remote URLs, which a host alias changes.
+    if after, _ := resolveAutoProfile(cfg, false); after != before {
+        fmt.Printf("⚠ the rules now select %q instead of %q for this repository; add a rule or hosts entry for the new URLs\n", after, before)
+    }
+    return nil
+}
+
+// remoteOwner returns the profile the config assigns a remote URL to by the
+// URL alone: the highest ranked rule whose only condition is a url, else
+// the hosts map.
+func remoteOwner(cfg Config, u string) (profile, reason string) {
+    for _, i := range rankedRules(cfg) {
+        r := cfg.Rules[i]
+        if r.URL != "" && r.Dir == "" && r.Branch == "" && r.matchesURL(u) {
+            return r.Profile, "rule " + strconv.Itoa(i+1)
+        }
+    }
+    if name, host := hostProfile(cfg, []remoteInfo{{Name: "origin", URL: u}}); name != "" {
+        return name, "host " + host
+    }
+    return "", ""
+}
+
+// remoteConflicts returns the remotes of a scanned repository that belong
+// to another profile than its identity's. Repositories whose identity
+// matches no profile are scan's business, not this one's.
+func remoteConflicts(cfg Config, res scanResult) []remoteConflict {
+    if res.Current == "" {
+        return nil
+    }
+    var conflicts []remoteConflict
+    for _, rm := range res.Remotes {
+        if owner, reason := remoteOwner(cfg, rm.URL); owner != "" && owner != res.Current {
+            conflicts = append(conflicts, remoteConflict{res.Root, res.Current, rm.Name, rm.URL, owner, reason})
+        }
+    }
+    return conflicts
+}
+
+// commandRemotesAudit checks the remotes of the repositories under dirs, or
+// of those gist has set a profile in or pinned, against the profile each
+// repository uses, and fails if any belongs to another profile.
+func commandRemotesAudit(cfg Config, dirs []string, asJSON bool, jobs int) error {
+    st, err := loadState()
+    if err != nil {
+        return err
+    }
+    roots, err := watchRoots(st, dirs)
+    if err != nil {
+        return err
+    }
+    conflicts := []remoteConflict{}
+    checked := 0
+    err = scanParallel(cfg, roots, jobs, nil, func(dir string, res scanResult) {
+        checked++
+        conflicts = append(conflicts, remoteConflicts(cfg, res)...)
+    })
+    if err != nil {
+        return err
+    }
+    sort.SliceStable(conflicts, func(i, j int) bool { return conflicts[i].Repo < conflicts[j].Repo })
+    if asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(conflicts); err != nil {
+            return err
+        }
+    } else {
+        for _, c := range conflicts {
+            fmt.Printf("✘ %s: profile %s, but %s (%s) belongs to %s (%s)\n", c.Repo, c.Profile, c.Remote, c.URL, c.Owner, c.Reason)
+        }
+        if len(conflicts) == 0 {
+            fmt.Printf("✔ every remote matches its repository's profile (%d repositories checked)\n", checked)
+        }
+    }
+    if len(conflicts) > 0 {
+        return fmt.Errorf("%d remote(s) belong to another profile than their repository's", len(conflicts))
+    }
+    return nil
+}
+
+// commandRemotes dispatches the remotes subcommands.
+func commandRemotes(cfg Config, args []string) error {
+    usage := errors.New("usage: gist remotes fix [--dry-run] [--yes] | gist remotes audit [--json] [--jobs <n>] [dir...]")
+    if len(args) > 0 && args[0] == "audit" {
+        asJSON, jobs := false, 0
+        var dirs []string
+        for i := 1; i < len(args); i++ {
+            switch args[i] {
+            case "--json":
+                asJSON = true
+            case "--jobs", "-j":
+                if i+1 >= len(args) {
+                    return fmt.Errorf("%s requires a value", args[i])
+                }
+                jobs, _ = strconv.Atoi(args[i+1])
+                i++
+            default:
+                dirs = append(dirs, args[i])
+            }
+        }
+        return commandRemotesAudit(cfg, dirs, asJSON, jobs)
+    }
+    if len(args) == 0 || args[0] != "fix" {
+        return usage
+    }
+    dryRun, assumeYes := false, false
+    for _, a := range args[1:] {
+        switch a {
+        case "--dry-run":
+            dryRun = true
+        case "--yes", "-y":
+            assumeYes = true
+        default:
+            return usage
+        }
+    }
+    return commandRemotesFix(cfg, dryRun, assumeYes)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// gitconfigValue quotes a value for a gitconfig file when git would
+// otherwise misread it.
+func gitconfigValue(v string) string {
+    if v == "" || strings.ContainsAny(v, "\"\\#;") || strings.TrimSpace(v) != v {
+        v = strings.ReplaceAll(v, `\`, `\\`)
+        v = strings.ReplaceAll(v, `"`, `\"`)
+        return `"` + v + `"`
+    }
+    return v
+}
+
+// renderSettings formats settings as gitconfig sections, keeping the order in
+// which sections first appear.
+func renderSettings(settings []setting) string {
+    var sections []string
+    entries := map[string][]string{}
+    for _, s := range settings {
+        dot := strings.LastIndex(s.Key, ".")
+        section, name := s.Key[:dot], s.Key[dot+1:]
+        if _, ok := entries[section]; !ok {
+            sections = append(sections, section)
+        }
+        entries[section] = append(entries[section], fmt.Sprintf("\t%s = %s", name, gitconfigValue(s.Value)))
+    }
+    var sb strings.Builder
+    for _, section := range sections {
+        sb.WriteString("[" + section + "]\n")
+        for _, e := range entries[section] {
+            sb.WriteString(e + "\n")
+        }
+    }
+    return sb.String()
+}
+
+// includeConditions returns the includeIf conditions matching the
+// profile's directory, branch and URL rules, and why rules the installed git
+// can't express were left out. includeIf takes a single condition, so rules
+// combining several are left out too.
+func includeConditions(cfg Config, p *Profile) (conditions, skipped []string) {
+    for _, r := range cfg.Rules {
+        if r.Profile != p.Name {
+            continue
+        }
+        var matching []string
+        var feature *gitFeature
+        switch {
+        case r.Dir != "" && r.Branch == "" && r.URL == "":
+            matching = []string{"gitdir:" + strings.TrimSuffix(r.Dir, "/") + "/"}
+        case r.Branch != "" && r.Dir == "" && r.URL == "":
+            matching, feature = []string{"onbranch:" + r.Branch}, &gitOnBranch
+        case r.URL != "" && r.Dir == "" && r.Branch == "":
+            for _, pattern := range urlIncludePatterns(r.URL) {
+                matching = append(matching, "hasconfig:remote.*.url:"+pattern)
+            }
+            feature = &gitHasConfig
+        }
+        if len(matching) == 0 {
+            continue
+        }
+        if feature != nil {
+            if err := requireGit(*feature); err != nil {
+                skipped = append(skipped, fmt.Sprintf("no stanza for %s: %v", matching[0], err))
+                continue
+            }
+        }
+        conditions = append(conditions, matching...)
+    }
+    return conditions, skipped
+}
+
+// urlIncludePatterns returns the hasconfig globs matching the remote URLs a
+// url rule matches: git compares the URL as written, so the HTTPS, scp-like
+// and ssh:// forms each need one.
+func urlIncludePatterns(ruleURL string) []string {
+    _, host, path, ok := remoteParts(ruleURL)
+    if !ok {
+        return nil
+    }
+    prefix := strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".git")
+    if prefix != "" {
+        prefix += "/"
+    }
+    return []string{
+        "https://" + host + "/" + prefix + "**",
+        "*@" + host + ":" + prefix + "**",
+        "ssh://*@" + host + "/" + prefix + "**",
+    }
+}
+
+// renderProfile returns the gitconfig a profile produces for scope. The
+// include scope adds the includeIf stanzas matching the profile's directory,
+// branch and URL rules as comments, those the installed git supports.
+func renderProfile(cfg Config, p *Profile, scope string) (string, error) {
+    var sb strings.Builder
+    switch scope {
+    case "local":
+        fmt.Fprintf(&sb, "# gist profile %s for .git/config\n", p.Name)
+    case "global":
+        fmt.Fprintf(&sb, "# gist profile %s for %s\n", p.Name, globalConfigPath())
+    case "include":
+        fmt.Fprintf(&sb, "# gist profile %s, to be included from %s:\n", p.Name, globalConfigPath())
+        conditions, skipped := includeConditions(cfg, p)
+        for _, condition := range conditions {
+            fmt.Fprintf(&sb, "#   [includeIf \"%s\"]\n#   \tpath = <this file>\n", condition)
+        }
+        for _, reason := range skipped {
+            fmt.Fprintf(&sb, "#   (%s)\n", reason)
+        }
+    default:
+        return "", errors.New("scope must be local, global or include")
+    }
+    sb.WriteString(renderSettings(profileSettings(p)))
+    return sb.String(), nil
+}
+
+// commandRender prints the gitconfig a profile produces without applying it.
+func commandRender(cfg Config, profileName, scope string) error {
+    p := findProfile(&cfg, profileName)
+    if p == nil {
+        return fmt.Errorf("profile %s not found", profileName)
+    }
+    out, err := renderProfile(cfg, p, scope)
+    if err != nil {
+        return err
+    }
+    fmt.Print(out)
+    return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "regexp"
+    "strings"
+)
+
+// valueResolver turns the argument of a "!name arg" config value into the
+// actual value, so secrets can live in a password manager or environment
+// instead of the config file.
+type valueResolver interface {
+    resolve(arg string) (string, error)
+}
+
+// envResolver reads an environment variable: "!env WORK_EMAIL".
+type envResolver struct{}
+
+func (envResolver) resolve(arg string) (string, error) {
+    v, ok := os.LookupEnv(arg)
+    if !ok {
+        return "", fmt.Errorf("environment variable %s is not set", arg)
+    }
+    return v, nil
+}
+
+// execResolver runs a command and uses its first output line:
+// "!exec pass show work/email".
+type execResolver struct{}
+
+func (execResolver) resolve(arg string) (string, error) {
+    fields := strings.Fields(arg)
+    if len(fields) == 0 {
+        return "", errors.New("!exec needs a command")
+    }
+    return runResolverCommand(fields[0], fields[1:]...)
+}
+
+// pluginResolver delegates "!name arg" to a gist-resolver-<name> executable
+// on PATH, which receives arg as its arguments.
+type pluginResolver struct {
+    name string
+}
+
+func (r pluginResolver) resolve(arg string) (string, error) {
+    return runResolverCommand("gist-resolver-"+r.name, strings.Fields(arg)...)
+}
+
+// resolvers holds the built-in resolvers by name.
+var resolvers = map[string]valueResolver{
+    "env":  envResolver{},
+    "exec": execResolver{},
+}
+
+// runResolverCommand runs a resolver command and returns its first line of
+// output.
+func runResolverCommand(name string, args ...string) (string, error) {
+    out, err := exec.Command(name, args...).Output()
+    if err != nil {
+        return "", fmt.Errorf("%s failed: %w", name, err)
+    }
+    line, _, _ := strings.Cut(string(out), "\n")
+    return strings.TrimSpace(line), nil
+}
+
+// interpolation matches ${VAR} and ${VAR:-default} in config values.
+var interpolation = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// interpolate expands ${VAR} references from the environment. An unset
+// variable expands to its default, if given, or to nothing.
+func interpolate(value string) string {
+    return interpolation.ReplaceAllStringFunc(value, func(ref string) string {
+        m := interpolation.FindStringSubmatch(ref)
+        if v, ok := os.LookupEnv(m[1]); ok {
+            return v
+        }
+        return strings.TrimPrefix(m[2], ":-")
+    })
+}
+
+// undefinedVars returns the variables a value references that are unset
+// and have no default.
+func undefinedVars(value string) []string {
+    var names []string
+    for _, m := range interpolation.FindAllStringSubmatch(value, -1) {
+        if _, ok := os.LookupEnv(m[1]); !ok && m[2] == "" {
+            names = append(names, m[1])
+        }
+    }
+    return names
+}
+
+// resolveValue resolves a "!name arg" expression.
+func resolveValue(expr string) (string, error) {
+    name, arg, _ := strings.Cut(strings.TrimPrefix(expr, "!"), " ")
+    r, ok := resolvers[name]
+    if !ok {
+        r = pluginResolver{name: name}
+    }
+    return r.resolve(strings.TrimSpace(arg))
+}
@@ -0,0 +1,745 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// Rule selects a profile for repositories located under Dir and/or whose
+// remote URL starts with URL, optionally only while a branch matching Branch
+// is checked out. All conditions that are set must hold.
+type Rule struct {
+    Profile string `yaml:"profile"`
+    Dir     string `yaml:"dir,omitempty"`
+    URL     string `yaml:"url,omitempty"`
+    // Remote names the remote whose URL is matched. Empty means "origin",
+    // falling back to any remote when the repository has no origin; "*"
+    // always matches any remote.
+    Remote string `yaml:"remote,omitempty"`
+    // Branch is a glob such as "release/*" matched against the current
+    // branch; empty matches any branch.
+    Branch string `yaml:"branch,omitempty"`
+    // Priority ranks rules above specificity; higher wins. Defaults to 0.
+    Priority int `yaml:"priority,omitempty"`
+    // Source names where a read-only rule comes from (e.g. a policy);
+    // empty for the user's own rules, which are the only ones saved.
+    Source string `yaml:"-"`
+    // raw keeps values with ${VAR} references by key so the rule is saved
+    // with them rather than the expanded values.
+    raw map[string]string
+    // quotes records how the file quoted each value; see yamlScalar.
+    quotes map[string]string
+}
+
+// remoteInfo is a configured git remote.
+type remoteInfo struct {
+    Name string `json:"name"`
+    URL  string `json:"url"`
+}
+
+// loadRuleKey applies a single rule key.
+func loadRuleKey(r *Rule, key, value string) {
+    if (key == "dir" || key == "url" || key == "remote" || key == "branch") && strings.Contains(value, "${") {
+        if r.raw == nil {
+            r.raw = map[string]string{}
+        }
+        r.raw[key] = value
+        value = interpolate(value)
+    }
+    switch key {
+    case "profile":
+        r.Profile = value
+    case "dir":
+        r.Dir = value
+    case "url":
+        r.URL = value
+    case "remote":
+        r.Remote = value
+    case "branch":
+        r.Branch = value
+    case "priority":
+        if n, err := strconv.Atoi(value); err == nil {
+            r.Priority = n
+        }
+    default:
+        // ignore unknown keys
+    }
+}
+
+// writeRule serializes a rule as a YAML list item.
+func writeRule(sb *strings.Builder, r Rule) {
+    field := func(key, value string) {
+        if raw, ok := r.raw[key]; ok {
+            value = raw
+        }
+        if value != "" {
+            sb.WriteString("    " + key + ": " + yamlScalar(value, quoteStyle(r.quotes, key)) + "\n")
+        }
+    }
+    sb.WriteString("  - profile: " + r.Profile + "\n")
+    field("dir", r.Dir)
+    field("url", r.URL)
+    field("remote", r.Remote)
+    field("branch", r.Branch)
+    if r.Priority != 0 {
+        sb.WriteString("    priority: " + strconv.Itoa(r.Priority) + "\n")
+    }
+}
+
+// describe returns a short human readable summary of the rule's conditions.
+func (r Rule) describe() string {
+    var parts []string
+    if r.Dir != "" {
+        parts = append(parts, "dir "+r.Dir)
+    }
+    if r.URL != "" {
+        parts = append(parts, "url "+r.URL)
+        if r.Remote != "" {
+            parts = append(parts, "remote "+r.Remote)
+        }
+    }
+    if r.Branch != "" {
+        parts = append(parts, "branch "+r.Branch)
+    }
+    return strings.Join(parts, ", ")
+}
+
+// expandHome replaces a leading "~" with the user's home directory.
+func expandHome(path string) string {
+    if path != "~" && !strings.HasPrefix(path, "~/") {
+        return path
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return path
+    }
+    return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// profilePath resolves a file a profile refers to (ssh_key, commit_template,
+// ssl_ca_info): ~ is expanded and a relative path is relative to the file
+// defining the profile, so one config works for any user on any machine.
+func profilePath(p *Profile, path string) string {
+    path = expandHome(path)
+    if path == "" || filepath.IsAbs(path) {
+        return path
+    }
+    dir := p.dir
+    if dir == "" {
+        dir = filepath.Dir(getConfigPath())
+    }
+    return filepath.Join(dir, path)
+}
+
+// normalizeRemoteURL reduces the various URL shapes git accepts to a
+// comparable "host/path" form, e.g. both git@github.com:acme/x.git and
+// https://user@github.com/acme/x become github.com/acme/x. Azure DevOps and
+// CodeCommit URLs get their canonical form (see hosting.go).
+func normalizeRemoteURL(url string) string {
+    u := strings.TrimSpace(url)
+    if cc, ok := normalizeCodeCommit(u); ok {
+        return strings.ToLower(cc)
+    }
+    if i := strings.Index(u, "://"); i >= 0 {
+        u = u[i+3:]
+    } else if i := strings.Index(u, ":"); i >= 0 && !strings.Contains(u[:i], "/") {
+        // scp-like syntax: [user@]host:path
+        u = u[:i] + "/" + u[i+1:]
+    }
+    if i := strings.Index(u, "@"); i >= 0 && !strings.Contains(u[:i], "/") {
+        u = u[i+1:]
+    }
+    u = strings.TrimSuffix(u, "/")
+    u = strings.TrimSuffix(u, ".git")
+    return normalizeAzureDevOps(strings.ToLower(u))
+}
+
+// listRemotes returns the remotes configured for the current repository.
+func listRemotes() []remoteInfo {
+    out, err := runGit("config", "--get-regexp", `^remote\..*\.url$`)
+    if err != nil || out == "" {
+        return nil
+    }
+    var remotes []remoteInfo
+    for _, line := range strings.Split(out, "\n") {
+        key, url, ok := strings.Cut(line, " ")
+        if !ok {
+            continue
+        }
+        name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+        remotes = append(remotes, remoteInfo{Name: name, URL: url})
+    }
+    return remotes
+}
+
+// matchesDir reports whether repoRoot lies within the rule's directory.
+func (r Rule) matchesDir(repoRoot string) bool {
+    if r.Dir == "" {
+        return true
+    }
+    dir := filepath.Clean(expandHome(r.Dir))
+    root := filepath.Clean(repoRoot)
+    return root == dir || strings.HasPrefix(root, dir+string(filepath.Separator))
+}
+
+// matchesURL reports whether a remote URL matches the rule's URL prefix.
+func (r Rule) matchesURL(url string) bool {
+    want := normalizeRemoteURL(r.URL)
+    got := normalizeRemoteURL(url)
+    return got == want || strings.HasPrefix(got, want+"/")
+}
+
+// candidateRemotes returns the remotes whose URLs the rule inspects.
+func (r Rule) candidateRemotes(remotes []remoteInfo) []remoteInfo {
+    name := r.Remote
+    if name == "*" {
+        return remotes
+    }
+    if name == "" {
+        name = "origin"
+    }
+    for _, rm := range remotes {
+        if rm.Name == name {
+            return []remoteInfo{rm}
+        }
+    }
+    if r.Remote == "" {
+        // No origin: fall back to any remote.
+        return remotes
+    }
+    return nil
+}
+
+// currentBranch returns the checked out branch, or "" on a detached HEAD.
+func currentBranch() string {
+    branch, err := runGit("symbolic-ref", "--short", "-q", "HEAD")
+    if err != nil {
+        return ""
+    }
+    return branch
+}
+
+// matchesBranch reports whether a branch name matches the rule's pattern.
+func (r Rule) matchesBranch(branch string) bool {
+    if r.Branch == "" {
+        return true
+    }
+    ok, _ := path.Match(r.Branch, branch)
+    return ok
+}
+
+// matches reports whether the rule applies to the repository.
+func (r Rule) matches(repoRoot string, remotes []remoteInfo) bool {
+    if !r.matchesDir(repoRoot) {
+        return false
+    }
+    if r.Branch != "" && !r.matchesBranch(currentBranch()) {
+        return false
+    }
+    if r.URL == "" {
+        return true
+    }
+    for _, rm := range r.candidateRemotes(remotes) {
+        if r.matchesURL(rm.URL) {
+            return true
+        }
+    }
+    return false
+}
+
+// specificity measures how narrowly a rule matches: first by the number of
+// conditions it sets, then by the length of its directory and URL prefixes
+// and branch pattern.
+func (r Rule) specificity() (conditions, length int) {
+    if r.Dir != "" {
+        conditions++
+        length += len(filepath.Clean(expandHome(r.Dir)))
+    }
+    if r.URL != "" {
+        conditions++
+        length += len(normalizeRemoteURL(r.URL))
+    }
+    if r.Branch != "" {
+        conditions++
+        length += len(r.Branch)
+    }
+    return conditions, length
+}
+
+// compareRules orders rules for resolution: higher priority first, then the
+// more specific rule, so the longest matching prefix wins. It returns a
+// negative number when a ranks before b and 0 when they tie.
+func compareRules(a, b Rule) int {
+    if a.Priority != b.Priority {
+        return b.Priority - a.Priority
+    }
+    ac, al := a.specificity()
+    bc, bl := b.specificity()
+    if ac != bc {
+        return bc - ac
+    }
+    return bl - al
+}
+
+// rankedRules returns rule indexes in resolution order; ties keep their
+// order in the config file.
+func rankedRules(cfg Config) []int {
+    idx := make([]int, len(cfg.Rules))
+    for i := range idx {
+        idx[i] = i
+    }
+    sort.SliceStable(idx, func(i, j int) bool {
+        return compareRules(cfg.Rules[idx[i]], cfg.Rules[idx[j]]) < 0
+    })
+    return idx
+}
+
+// resolveRule returns the index of the highest ranked rule that applies to
+// the repository, or -1 if none does.
+func resolveRule(cfg Config, repoRoot string, remotes []remoteInfo) int {
+    if m := matchingRules(cfg, repoRoot, remotes); len(m) > 0 {
+        return m[0]
+    }
+    return -1
+}
+
+// matchingRules returns the indexes of every rule that applies to the
+// repository, in resolution order.
+func matchingRules(cfg Config, repoRoot string, remotes []remoteInfo) []int {
+    var idx []int
+    for _, i := range rankedRules(cfg) {
+        if cfg.Rules[i].matches(repoRoot, remotes) {
+            idx = append(idx, i)
+        }
+    }
+    return idx
+}
+
+// conflictingRules returns one rule per distinct profile among the best
+// ranked matches when they tie but disagree, or nil when the winner is clear.
+func conflictingRules(cfg Config, matches []int) []int {
+    seen := map[string]bool{}
+    var firsts []int
+    for _, i := range matches {
+        if compareRules(cfg.Rules[matches[0]], cfg.Rules[i]) != 0 {
+            break
+        }
+        if !seen[cfg.Rules[i].Profile] {
+            seen[cfg.Rules[i].Profile] = true
+            firsts = append(firsts, i)
+        }
+    }
+    if len(firsts) < 2 {
+        return nil
+    }
+    return firsts
+}
+
+// isInteractive reports whether stdin is a terminal we can prompt on, and
+// gist isn't running under a bot profile.
+func isInteractive() bool {
+    if nonInteractive() {
+        return false
+    }
+    info, err := os.Stdin.Stat()
+    return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// chooseRule asks the user which of several conflicting rules to apply.
+func chooseRule(cfg Config, candidates []int) int {
+    fmt.Println("Several rules match this repository:")
+    for n, i := range candidates {
+        r := cfg.Rules[i]
+        fmt.Printf("  %d) %s (rule %d: %s)\n", n+1, r.Profile, i+1, r.describe())
+    }
+    fmt.Print("Choose a profile [1]: ")
+    answer, _ := stdin.ReadString('\n')
+    n, err := strconv.Atoi(strings.TrimSpace(answer))
+    if err != nil || n < 1 || n > len(candidates) {
+        return candidates[0]
+    }
+    return candidates[n-1]
+}
+
+// remoteHost returns the host part of a remote URL, without any port.
+func remoteHost(url string) string {
+    host, _, _ := strings.Cut(normalizeRemoteURL(url), "/")
+    host, _, _ = strings.Cut(host, ":")
+    return host
+}
+
+// hostProfile returns the profile the hosts map assigns to the repository's
+// remotes, preferring origin, along with the matched host.
+func hostProfile(cfg Config, remotes []remoteInfo) (string, string) {
+    if len(cfg.Hosts) == 0 {
+        return "", ""
+    }
+    for _, rm := range (Rule{}).candidateRemotes(remotes) {
+        for _, host := range remoteHostKeys(rm.URL) {
+            if p, ok := cfg.Hosts[host]; ok {
+                return p, host
+            }
+        }
+    }
+    return "", ""
+}
+
+// hasBranchRules reports whether rules keyed on branch names apply to the
+// repository on some branch, so its identity depends on what is checked out.
+func hasBranchRules(cfg Config, repoRoot string, remotes []remoteInfo) bool {
+    for _, r := range cfg.Rules {
+        if r.Branch == "" {
+            continue
+        }
+        r.Branch = ""
+        if r.matches(repoRoot, remotes) {
+            return true
+        }
+    }
+    return false
+}
+
+// fallbackProfile returns the profile used when no rule matches: the hosts
+// map first, then default_profile. The reason describes which applied.
+func fallbackProfile(cfg Config, remotes []remoteInfo) (name, reason string) {
+    if p, host := hostProfile(cfg, remotes); p != "" {
+        return p, "host " + host
+    }
+    if cfg.DefaultProfile != "" {
+        return cfg.DefaultProfile, "default_profile"
+    }
+    return "", ""
+}
+
+// resolveAutoProfile returns the name of the profile selected by the rules
+// for the current repository, falling back to the hosts map and then the
+// default profile. When matching rules disagree and interactive is set, the
+// user picks one; otherwise the first rule wins with a warning.
+func resolveAutoProfile(cfg Config, interactive bool) (string, error) {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return "", errors.New("not inside a git repository")
+    }
+    if pin := pinnedProfile(repoRoot); pin != "" {
+        return pin, nil
+    }
+    remotes := listRemotes()
+    matches := matchingRules(cfg, repoRoot, remotes)
+    if len(matches) == 0 {
+        if name, _ := fallbackProfile(cfg, remotes); name != "" {
+            return name, nil
+        }
+        return "", fmt.Errorf("no rule or host matches repository %s and no default_profile is set", repoRoot)
+    }
+    idx := matches[0]
+    if conflicts := conflictingRules(cfg, matches); conflicts != nil {
+        if interactive && isInteractive() {
+            idx = chooseRule(cfg, conflicts)
+        } else {
+            fmt.Fprintf(os.Stderr, "warning: %d rules with different profiles match; using rule %d\n", len(conflicts), idx+1)
+        }
+    }
+    return cfg.Rules[idx].Profile, nil
+}
+
+// remoteRule returns the index of the best URL rule matching a single
+// remote of the repository at repoRoot, or -1.
+func remoteRule(cfg Config, repoRoot string, rm remoteInfo) int {
+    for _, i := range rankedRules(cfg) {
+        r := cfg.Rules[i]
+        if r.URL != "" && r.matchesDir(repoRoot) && r.matchesURL(rm.URL) {
+            return i
+        }
+    }
+    return -1
+}
+
+// commandWhich explains which rule selects the profile for the current
+// repository, including per-remote matches when remotes disagree.
+func commandWhich(cfg Config) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    remotes := listRemotes()
+    fmt.Printf("repository: %s\n", repoRoot)
+    if len(remotes) > 0 {
+        fmt.Println("remotes:")
+    }
+    // Record which profile each remote alone would select.
+    perRemote := map[string]string{}
+    for _, rm := range remotes {
+        match := "(no rule)"
+        if i := remoteRule(cfg, repoRoot, rm); i != -1 {
+            perRemote[rm.Name] = cfg.Rules[i].Profile
+            match = fmt.Sprintf("%s (rule %d)", cfg.Rules[i].Profile, i+1)
+        }
+        fmt.Printf("  %-10s %s → %s\n", rm.Name, rm.URL, match)
+    }
+    profiles := map[string]bool{}
+    for _, p := range perRemote {
+        profiles[p] = true
+    }
+    if len(profiles) > 1 {
+        var parts []string
+        for _, rm := range remotes {
+            if p, ok := perRemote[rm.Name]; ok {
+                parts = append(parts, rm.Name+"→"+p)
+            }
+        }
+        fmt.Printf("  ⚠ remotes disagree: %s\n", strings.Join(parts, ", "))
+    }
+    if pin := pinnedProfile(repoRoot); pin != "" {
+        fmt.Printf("resolved profile: %s (📌 pinned, rules are ignored)\n", pin)
+        return nil
+    }
+    idx := resolveRule(cfg, repoRoot, remotes)
+    if idx == -1 {
+        if name, reason := fallbackProfile(cfg, remotes); name != "" {
+            fmt.Printf("resolved profile: %s (%s)\n", name, reason)
+            return nil
+        }
+        fmt.Println("resolved profile: (none)")
+        return nil
+    }
+    r := cfg.Rules[idx]
+    fmt.Printf("resolved profile: %s (rule %d: %s)\n", r.Profile, idx+1, r.describe())
+    return nil
+}
+
+// covers reports whether every repository matched by b is also matched by r.
+func (r Rule) covers(b Rule) bool {
+    if r.Dir != "" && (b.Dir == "" || !r.matchesDir(filepath.Clean(expandHome(b.Dir)))) {
+        return false
+    }
+    if r.Branch != "" && (b.Branch == "" || !r.matchesBranch(b.Branch)) {
+        return false
+    }
+    if r.URL == "" {
+        return true
+    }
+    if b.URL == "" || !r.matchesURL(b.URL) {
+        return false
+    }
+    return r.Remote == b.Remote || r.Remote == "*" || (r.Remote == "" && b.Remote == "origin")
+}
+
+// lintRules returns a description of every problem found in the rules:
+// references to missing profiles and rules that can never be selected
+// because a higher ranked rule matches everything they match.
+func lintRules(cfg Config) []string {
+    problems := danglingReferences(cfg)
+    ranked := rankedRules(cfg)
+    for pos, i := range ranked {
+        for _, j := range ranked[:pos] {
+            a, b := cfg.Rules[j], cfg.Rules[i]
+            if !a.covers(b) {
+                continue
+            }
+            kind := "shadowed"
+            if a.Profile == b.Profile {
+                kind = "redundant"
+            }
+            if compareRules(a, b) == 0 {
+                // Equal rank: b can still be picked when conflicts are prompted.
+                if a.Profile == b.Profile {
+                    problems = append(problems, fmt.Sprintf("rule %d: redundant, rule %d already selects %s", i+1, j+1, a.Profile))
+                }
+                break
+            }
+            problems = append(problems, fmt.Sprintf("rule %d: %s by rule %d (%s), never selected", i+1, kind, j+1, a.describe()))
+            break
+        }
+    }
+    return problems
+}
+
+// rulesUsage lists the `rules` subcommands.
+const rulesUsage = "usage: gist rules list|add|remove <n>|test <path>|lint"
+
+// parseRuleFlags builds a rule from `rules add` options.
+func parseRuleFlags(args []string) (Rule, error) {
+    var r Rule
+    for i := 0; i < len(args); i++ {
+        flag, value, hasValue := strings.Cut(args[i], "=")
+        if !hasValue {
+            if i+1 >= len(args) {
+                return r, fmt.Errorf("%s requires a value", flag)
+            }
+            value = args[i+1]
+            i++
+        }
+        switch flag {
+        case "--profile":
+            r.Profile = value
+        case "--dir":
+            // Stored as typed, "." would match wherever the config is
+            // read.
+            dir, err := argPath(value)
+            if err != nil {
+                return r, err
+            }
+            r.Dir = dir
+        case "--url":
+            r.URL = value
+        case "--remote":
+            r.Remote = value
+        case "--branch":
+            r.Branch = value
+        case "--priority":
+            n, err := strconv.Atoi(value)
+            if err != nil {
+                return r, fmt.Errorf("invalid priority %q", value)
+            }
+            r.Priority = n
+        default:
+            return r, fmt.Errorf("unknown option %s", flag)
+        }
+    }
+    if r.Profile == "" {
+        return r, errors.New("--profile is required")
+    }
+    if r.Dir == "" && r.URL == "" && r.Branch == "" {
+        return r, errors.New("a rule needs --dir, --url and/or --branch")
+    }
+    return r, nil
+}
+
+// listRules prints every rule with the number other subcommands refer to it by.
+func listRules(cfg Config) {
+    if len(cfg.Rules) == 0 {
+        fmt.Println("no rules configured")
+        return
+    }
+    fmt.Println("rules:")
+    for i, r := range cfg.Rules {
+        line := fmt.Sprintf("  %d) %s\t%s", i+1, r.Profile, r.describe())
+        if r.Priority != 0 {
+            line += fmt.Sprintf(", priority %d", r.Priority)
+        }
+        if r.Source != "" {
+            line += " [" + r.Source + "]"
+        }
+        fmt.Println(line)
+    }
+}
+
+// ruleNumber parses a 1-based rule number.
+func ruleNumber(cfg Config, arg string) (int, error) {
+    n, err := strconv.Atoi(arg)
+    if err != nil || n < 1 || n > len(cfg.Rules) {
+        return 0, fmt.Errorf("no rule %s (see gist rules list)", arg)
+    }
+    return n - 1, nil
+}
+
+// argPath returns the absolute path of a path argument, with ~ expanded; a
+// relative path is relative to the -C directory, like git's own arguments.
+func argPath(path string) (string, error) {
+    path = expandHome(path)
+    if !filepath.IsAbs(path) {
+        path = filepath.Join(repoDir, path)
+    }
+    return filepath.Abs(path)
+}
+
+// testRules shows how the rules resolve for the repository or directory at
+// path (see argPath), listing every matching rule in resolution order.
+func testRules(cfg Config, path string) error {
+    abs, err := argPath(path)
+    if err != nil {
+        return err
+    }
+    saved := repoDir
+    repoDir = abs
+    defer func() { repoDir = saved }()
+    root, remotes := abs, []remoteInfo(nil)
+    if inRepo, top := isGitRepo(); inRepo {
+        root, remotes = top, listRemotes()
+    }
+    fmt.Printf("testing %s\n", root)
+    matches := matchingRules(cfg, root, remotes)
+    for n, i := range matches {
+        mark := " "
+        if n == 0 {
+            mark = "→"
+        }
+        fmt.Printf("  %s rule %d: %s (%s)\n", mark, i+1, cfg.Rules[i].Profile, cfg.Rules[i].describe())
+    }
+    if len(matches) == 0 {
+        if name, reason := fallbackProfile(cfg, remotes); name != "" {
+            fmt.Printf("  → no rule matches, %s selects %s\n", reason, name)
+        } else {
+            fmt.Println("  no rule matches")
+        }
+    }
+    return nil
+}
+
+// commandRules runs the `rules` subcommands. Subcommands that modify the
+// rules leave saving the config to the caller.
+func commandRules(cfg *Config, args []string) error {
+    if len(args) == 0 {
+        return errors.New(rulesUsage)
+    }
+    switch args[0] {
+    case "list":
+        listRules(*cfg)
+        return nil
+    case "add":
+        r, err := parseRuleFlags(args[1:])
+        if err != nil {
+            return err
+        }
+        if findProfile(cfg, r.Profile) == nil {
+            return fmt.Errorf("profile %s not found", r.Profile)
+        }
+        // Keep read-only policy rules after the user's own.
+        at := len(cfg.Rules)
+        for at > 0 && cfg.Rules[at-1].Source != "" {
+            at--
+        }
+        cfg.Rules = append(cfg.Rules[:at], append([]Rule{r}, cfg.Rules[at:]...)...)
+        fmt.Printf("Rule %d added: %s (%s)\n", at+1, r.Profile, r.describe())
+        return nil
+    case "remove":
+        if len(args) < 2 {
+            return errors.New("usage: gist rules remove <n>")
+        }
+        idx, err := ruleNumber(*cfg, args[1])
+        if err != nil {
+            return err
+        }
+        r := cfg.Rules[idx]
+        if r.Source != "" {
+            return fmt.Errorf("rule %d comes from %s and is read-only", idx+1, r.Source)
+        }
+        cfg.Rules = append(cfg.Rules[:idx], cfg.Rules[idx+1:]...)
+        fmt.Printf("Rule %d removed: %s (%s)\n", idx+1, r.Profile, r.describe())
+        return nil
+    case "test":
+        path := "."
+        if len(args) > 1 {
+            path = args[1]
+        }
+        return testRules(*cfg, path)
+    case "lint":
+        problems := lintRules(*cfg)
+        if len(problems) == 0 {
+            fmt.Println("✔ rules look good")
+            return nil
+        }
+        for _, p := range problems {
+            fmt.Printf("  ✘ %s\n", p)
+        }
+        return fmt.Errorf("%d rule problem(s) found", len(problems))
+    default:
+        return fmt.Errorf("unknown rules subcommand: %s", args[0])
+    }
+}
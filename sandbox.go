@@ -0,0 +1,86 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// identityEnv lists the environment variables with which git overrides the
+// configured identity for commits.
+var identityEnv = []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL"}
+
+// extractIsolatedFlag removes --isolated <file> and --isolated=<file> from
+// the global options and returns the remaining arguments and the file.
+func extractIsolatedFlag(args []string) ([]string, string, error) {
+    var rest []string
+    file := ""
+    n := globalArgs(args)
+    for i := 0; i < n; i++ {
+        switch {
+        case args[i] == "--isolated":
+            if i+1 >= len(args) {
+                return nil, "", fmt.Errorf("%s requires a gitconfig file", args[i])
+            }
+            file = args[i+1]
+            i++
+        case strings.HasPrefix(args[i], "--isolated="):
+            file = strings.TrimPrefix(args[i], "--isolated=")
+        default:
+            rest = append(rest, args[i])
+        }
+    }
+    return append(rest, args[n:]...), file, nil
+}
+
+// isolate makes every git gist runs, and every program it starts, use file
+// as the global config and no system config, so containers, Nix shells and
+// tests see nothing of the machine's git setup. The file is created when
+// missing.
+func isolate(file string) error {
+    file, err := filepath.Abs(expandHome(file))
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+        return err
+    }
+    f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0o644)
+    if err != nil {
+        return err
+    }
+    f.Close()
+    os.Setenv("GIT_CONFIG_GLOBAL", file)
+    os.Setenv("GIT_CONFIG_SYSTEM", os.DevNull)
+    os.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+    return nil
+}
+
+// globalConfigPath returns the global config file git uses: GIT_CONFIG_GLOBAL
+// when set, else ~/.gitconfig.
+func globalConfigPath() string {
+    if file := os.Getenv("GIT_CONFIG_GLOBAL"); file != "" {
+        return file
+    }
+    return "~/.gitconfig"
+}
+
+// identityOverrideWarnings describes what in the environment overrides the
+// identity git reads from its config files: the GIT_AUTHOR_*/GIT_COMMITTER_*
+// variables, and user.name or user.email given through GIT_CONFIG_COUNT or
+// GIT_CONFIG_PARAMETERS (git's "command" scope).
+func identityOverrideWarnings() []string {
+    var warnings []string
+    for _, name := range identityEnv {
+        if value, ok := os.LookupEnv(name); ok {
+            warnings = append(warnings, fmt.Sprintf("%s=%s overrides the identity for commits", name, value))
+        }
+    }
+    for _, key := range []string{"user.name", "user.email"} {
+        if src, err := lookupConfig(key); err == nil && src.Scope == "command" {
+            warnings = append(warnings, fmt.Sprintf("%s=%s is set through the environment (GIT_CONFIG_*) and overrides the config files", key, src.Value))
+        }
+    }
+    return warnings
+}
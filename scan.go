@@ -0,0 +1,409 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// scanResult is a repository found by scan and how its identity compares
+// with the profile the rules select.
+type scanResult struct {
+    Root    string        `json:"path"`
+    Current string        `json:"profile"`
+    Want    string        `json:"expected_profile"`
+    Email   string        `json:"email"`
+    Remotes []remoteInfo  `json:"remotes"`
+    Signing signingStatus `json:"signing"`
+    Problem string        `json:"problem"`
+    // Violations are the installed policy's objections to the identity.
+    Violations []string `json:"policy_violations,omitempty"`
+}
+
+// signingStatus is how a repository signs commits.
+type signingStatus struct {
+    Enabled bool   `json:"enabled"`
+    Format  string `json:"format"`
+    Key     string `json:"key"`
+}
+
+// walkRepos calls found for every git repository under root, not descending
+// into repositories, hidden directories, node_modules or what scan.exclude
+// and .gistignore files exclude.
+func walkRepos(cfg Config, root string, found func(dir string)) error {
+    ig := newIgnorer(cfg, root)
+    return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            // Unreadable directories are skipped, not fatal.
+            if d != nil && d.IsDir() && path != root {
+                return filepath.SkipDir
+            }
+            return err
+        }
+        if !d.IsDir() {
+            return nil
+        }
+        name := d.Name()
+        if path != root && (strings.HasPrefix(name, ".") || name == "node_modules" || ig.ignored(path)) {
+            return filepath.SkipDir
+        }
+        if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+            found(path)
+            return filepath.SkipDir
+        }
+        return nil
+    })
+}
+
+// scanRepo checks the repository in repoDir.
+func scanRepo(cfg Config, dir string) scanResult {
+    repoDir = dir
+    res := scanResult{Root: dir}
+    if _, root := isGitRepo(); root != "" {
+        res.Root = root
+    }
+    res.Remotes = listRemotes()
+    res.Signing.Key, _ = runGit("config", "user.signingkey")
+    res.Signing.Format, _ = runGit("config", "gpg.format")
+    if res.Signing.Format == "" {
+        res.Signing.Format = formatOpenPGP
+    }
+    if sign, _ := runGit("config", "--type=bool", "commit.gpgsign"); sign == "true" {
+        res.Signing.Enabled = true
+    }
+    name, _ := runGit("config", "user.name")
+    email, _ := runGit("config", "user.email")
+    res.Email = email
+    var p *Profile
+    if email != "" {
+        p = matchProfile(&cfg, name, email)
+    }
+    if p != nil {
+        res.Current = p.Name
+        res.Violations = policyViolations(cfg.Policy, p, res.Remotes)
+    }
+    want, err := resolveAutoProfile(cfg, false)
+    if err != nil {
+        res.Problem = "no rule, host or default selects a profile"
+        return res
+    }
+    res.Want = want
+    switch {
+    case email == "":
+        res.Problem = "no identity"
+    case p == nil:
+        res.Problem = fmt.Sprintf("identity %s <%s> matches no profile", name, email)
+    case p.Name != want:
+        res.Problem = "identity is profile " + p.Name
+    }
+    return res
+}
+
+// scanAnswer asks what to do with one repository, git add -p style.
+func scanAnswer(res scanResult) string {
+    for {
+        fmt.Printf("Apply profile %s to %s [y,n,a,q,?]? ", res.Want, res.Root)
+        answer, err := stdin.ReadString('\n')
+        answer = strings.ToLower(strings.TrimSpace(answer))
+        if err != nil && answer == "" {
+            return "q"
+        }
+        switch answer {
+        case "y", "n", "a", "q":
+            return answer
+        }
+        fmt.Println("y - apply the profile to this repository")
+        fmt.Println("n - skip this repository")
+        fmt.Println("a - apply to this and all remaining repositories")
+        fmt.Println("q - quit; skip this and all remaining repositories")
+        fmt.Println("? - print help")
+    }
+}
+
+// scanCheckpoint records finished repositories so an interrupted scan can
+// continue with --resume.
+type scanCheckpoint struct {
+    file *os.File
+}
+
+// scanCheckpointPath returns where the checkpoint of a running scan is kept.
+func scanCheckpointPath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "scan-checkpoint")
+}
+
+// loadScanCheckpoint returns the results a previous scan of the same roots
+// recorded, by directory; nil when there is none for these roots.
+func loadScanCheckpoint(roots []string) map[string]scanResult {
+    data, err := os.ReadFile(scanCheckpointPath())
+    if err != nil {
+        return nil
+    }
+    lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+    if len(lines) < 2 || lines[1] != "roots: "+strings.Join(roots, "\t") {
+        return nil
+    }
+    done := map[string]scanResult{}
+    for _, line := range lines[2:] {
+        dir, record, _ := strings.Cut(line, "\t")
+        var res scanResult
+        if json.Unmarshal([]byte(record), &res) == nil {
+            done[dir] = res
+        }
+    }
+    return done
+}
+
+// newScanCheckpoint starts a checkpoint for roots, keeping the results of
+// done, which a resumed scan carries over.
+func newScanCheckpoint(roots []string, done map[string]scanResult) (*scanCheckpoint, error) {
+    path := scanCheckpointPath()
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return nil, err
+    }
+    f, err := os.Create(path)
+    if err != nil {
+        return nil, err
+    }
+    c := &scanCheckpoint{file: f}
+    fmt.Fprintf(f, "# gist scan checkpoint; removed when the scan completes.\nroots: %s\n", strings.Join(roots, "\t"))
+    for _, dir := range sortedResultKeys(done) {
+        c.record(dir, done[dir])
+    }
+    return c, nil
+}
+
+// record appends a finished repository.
+func (c *scanCheckpoint) record(dir string, res scanResult) {
+    record, _ := json.Marshal(res)
+    fmt.Fprintf(c.file, "%s\t%s\n", dir, record)
+}
+
+// finish closes the checkpoint and, for a completed scan, deletes it.
+func (c *scanCheckpoint) finish(completed bool) {
+    c.file.Close()
+    if completed {
+        os.Remove(c.file.Name())
+    }
+}
+
+// sortedResultKeys returns the directories of results in sorted order.
+func sortedResultKeys(results map[string]scanResult) []string {
+    keys := make([]string, 0, len(results))
+    for k := range results {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// scanRepoProcess checks one repository in a separate gist process: git
+// commands run in the global repoDir, so workers can't share this one.
+func scanRepoProcess(exe, dir string) scanResult {
+    out, err := exec.Command(exe, "-C", dir, "__scan-repo").Output()
+    var res scanResult
+    if err != nil || json.Unmarshal(out, &res) != nil {
+        return scanResult{Root: dir, Problem: "cannot inspect the repository"}
+    }
+    return res
+}
+
+// printScanRepo prints the record the scan workers read.
+func printScanRepo(cfg Config) {
+    record, _ := json.Marshal(scanRepo(cfg, repoDir))
+    fmt.Println(string(record))
+}
+
+// absRoots makes the directories to scan absolute, defaulting to the
+// current one.
+func absRoots(roots []string) ([]string, error) {
+    if len(roots) == 0 {
+        roots = []string{"."}
+    }
+    abs := make([]string, len(roots))
+    for i, root := range roots {
+        dir, err := filepath.Abs(expandHome(root))
+        if err != nil {
+            return nil, err
+        }
+        abs[i] = dir
+    }
+    return abs, nil
+}
+
+// scanParallel checks the repositories under roots jobs at a time (one per
+// CPU when jobs < 1), skipping those in done, while the walk is still
+// discovering more. each gets the results in the order they finish, on one
+// goroutine; a progress line shows on a terminal meanwhile.
+func scanParallel(cfg Config, roots []string, jobs int, done map[string]scanResult, each func(dir string, res scanResult)) error {
+    if jobs < 1 {
+        jobs = runtime.NumCPU()
+    }
+    exe, err := os.Executable()
+    if err != nil {
+        return err
+    }
+    dirs := make(chan string)
+    var walkErr error
+    found := len(done)
+    var mu sync.Mutex
+    go func() {
+        defer close(dirs)
+        for _, root := range roots {
+            walkErr = walkRepos(cfg, root, func(dir string) {
+                if _, ok := done[dir]; ok {
+                    return
+                }
+                mu.Lock()
+                found++
+                mu.Unlock()
+                dirs <- dir
+            })
+            if walkErr != nil {
+                return
+            }
+        }
+    }()
+    type finished struct {
+        dir string
+        res scanResult
+    }
+    results := make(chan finished)
+    var wg sync.WaitGroup
+    for i := 0; i < jobs; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for dir := range dirs {
+                results <- finished{dir, scanRepoProcess(exe, dir)}
+            }
+        }()
+    }
+    go func() {
+        wg.Wait()
+        close(results)
+    }()
+    progress := isTerminal(os.Stderr)
+    scanned := len(done)
+    for r := range results {
+        scanned++
+        if progress {
+            fmt.Fprint(os.Stderr, "\r\x1b[K")
+        }
+        each(r.dir, r.res)
+        if progress {
+            mu.Lock()
+            fmt.Fprintf(os.Stderr, "scanning: %d/%d repositories", scanned, found)
+            mu.Unlock()
+        }
+    }
+    if progress {
+        fmt.Fprint(os.Stderr, "\r\x1b[K")
+    }
+    return walkErr
+}
+
+// scanStatus prints a finished repository and reports whether it has the
+// wrong identity.
+func scanStatus(res scanResult) bool {
+    switch {
+    case res.Problem == "":
+        fmt.Printf("  ✔ %s (%s)\n", res.Root, res.Want)
+    case res.Want == "":
+        fmt.Printf("  ? %s: %s\n", res.Root, res.Problem)
+    default:
+        fmt.Printf("  ✘ %s: %s, should be %s\n", res.Root, res.Problem, res.Want)
+        return true
+    }
+    return false
+}
+
+// commandScan reports every repository under the roots whose identity isn't
+// the profile its rules select, checking jobs repositories at a time and
+// printing results as they come in. Progress is checkpointed, and resume
+// skips the repositories an interrupted scan of the same roots finished.
+// With fix it then steps through the problems offering to apply the
+// profile. It returns an error while problems remain.
+func commandScan(cfg Config, roots []string, fix, resume bool, jobs int) error {
+    roots, err := absRoots(roots)
+    if err != nil {
+        return err
+    }
+    done := map[string]scanResult{}
+    if resume {
+        if previous := loadScanCheckpoint(roots); previous != nil {
+            done = previous
+            fmt.Printf("Resuming: %d repositories already scanned.\n", len(done))
+        }
+    }
+    checkpoint, err := newScanCheckpoint(roots, done)
+    if err != nil {
+        return err
+    }
+    var problems []scanResult
+    for _, dir := range sortedResultKeys(done) {
+        if res := done[dir]; res.Problem != "" && res.Want != "" {
+            problems = append(problems, res)
+        }
+    }
+    scanned := len(done)
+    err = scanParallel(cfg, roots, jobs, done, func(dir string, res scanResult) {
+        scanned++
+        checkpoint.record(dir, res)
+        if scanStatus(res) {
+            problems = append(problems, res)
+        }
+    })
+    checkpoint.finish(err == nil)
+    if err != nil {
+        return fmt.Errorf("%v (rerun with --resume to continue)", err)
+    }
+    sort.Slice(problems, func(i, j int) bool { return problems[i].Root < problems[j].Root })
+    fmt.Printf("%d repositories, %d with the wrong identity.\n", scanned, len(problems))
+    if !fix || len(problems) == 0 {
+        if len(problems) > 0 {
+            return errors.New("run `gist scan --fix` to apply the expected profiles")
+        }
+        return nil
+    }
+    remaining, all := 0, false
+    for i, res := range problems {
+        answer := "y"
+        if !all {
+            answer = scanAnswer(res)
+        }
+        if answer == "q" {
+            remaining += len(problems) - i
+            break
+        }
+        if answer == "a" {
+            all = true
+        }
+        if answer == "n" {
+            remaining++
+            continue
+        }
+        repoDir = res.Root
+        if err := commandSet(cfg, res.Want); err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %v\n", res.Root, err)
+            remaining++
+        }
+    }
+    if remaining > 0 {
+        return fmt.Errorf("%d repositories still have the wrong identity", remaining)
+    }
+    return nil
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
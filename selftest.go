@@ -0,0 +1,227 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// `gist selftest` runs gist against the installed git in a throwaway
+// repository: it sets a profile, reads it back with info, verifies a commit
+// and unsets it again, each step a separate gist process. Nothing of the
+// user's setup takes part – the config, HOME and git's global config all
+// live in the temporary directory – so a failure points at git or the
+// environment rather than at the config.
+
+// selftestEnv is the temporary setup the steps run in.
+type selftestEnv struct {
+    dir        string
+    repo       string
+    configPath string
+    env        []string
+}
+
+// newSelftestEnv creates the temporary directory and the environment for
+// git and gist: the temporary HOME, config and global gitconfig, no system
+// config, and none of the variables that override the identity or the
+// repository.
+func newSelftestEnv() (*selftestEnv, error) {
+    dir, err := os.MkdirTemp("", "gist-selftest-")
+    if err != nil {
+        return nil, err
+    }
+    t := &selftestEnv{dir: dir, repo: filepath.Join(dir, "repo"), configPath: filepath.Join(dir, "gist", "config.yaml")}
+    home := filepath.Join(dir, "home")
+    if err := os.MkdirAll(home, 0o700); err != nil {
+        return t, err
+    }
+    global := filepath.Join(dir, "gitconfig")
+    if err := os.WriteFile(global, nil, 0o644); err != nil {
+        return t, err
+    }
+    drop := map[string]bool{
+        "HOME": true, "USERPROFILE": true, "XDG_CONFIG_HOME": true, "GIST_CONFIG_PATH": true,
+        "GIT_CONFIG_GLOBAL": true, "GIT_CONFIG_SYSTEM": true, "GIT_CONFIG_COUNT": true,
+        "GIT_CONFIG_PARAMETERS": true, "GIT_DIR": true, "GIT_WORK_TREE": true,
+        "GIT_COMMON_DIR": true, "GIT_INDEX_FILE": true,
+    }
+    for _, name := range identityEnv {
+        drop[name] = true
+    }
+    for _, kv := range os.Environ() {
+        name, _, _ := strings.Cut(kv, "=")
+        if !drop[name] {
+            t.env = append(t.env, kv)
+        }
+    }
+    t.env = append(t.env,
+        "HOME="+home, "USERPROFILE="+home,
+        "GIST_CONFIG_PATH="+t.configPath,
+        "GIT_CONFIG_GLOBAL="+global, "GIT_CONFIG_SYSTEM="+os.DevNull, "GIT_CONFIG_NOSYSTEM=1",
+        "GIST_NONINTERACTIVE=1",
+    )
+    return t, nil
+}
+
+// run runs a command in the repository and returns its combined output.
+func (t *selftestEnv) run(name string, args ...string) (string, error) {
+    cmd := exec.Command(name, args...)
+    cmd.Dir = t.repo
+    cmd.Env = t.env
+    out, err := cmd.CombinedOutput()
+    return strings.TrimSpace(string(out)), err
+}
+
+// git runs the installed git in the repository.
+func (t *selftestEnv) git(args ...string) (string, error) {
+    return t.run(getGitPath(), args...)
+}
+
+// gist runs this gist in the repository.
+func (t *selftestEnv) gist(args ...string) (string, error) {
+    exe, err := os.Executable()
+    if err != nil {
+        return "", err
+    }
+    return t.run(exe, args...)
+}
+
+// selftestConfig writes the config of the test: a profile signing with an
+// SSH key when ssh-keygen can make one, and a second profile to tell it
+// from.
+func (t *selftestEnv) selftestConfig(signing bool) error {
+    p := Profile{Name: "selftest", Username: "Gist Selftest", Email: "selftest@example.com"}
+    if signing {
+        key := filepath.Join(t.dir, "id_selftest")
+        if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", p.Email, "-f", key).CombinedOutput(); err != nil {
+            return fmt.Errorf("ssh-keygen failed: %s", strings.TrimSpace(string(out)))
+        }
+        p.SigningKey, p.SigningFormat = key+".pub", "ssh"
+    }
+    cfg := Config{Version: configVersion, Profiles: []Profile{
+        p,
+        {Name: "other", Username: "Someone Else", Email: "other@example.com"},
+    }}
+    return saveConfig(t.configPath, cfg)
+}
+
+// commandSelftest runs the self-test and reports every step; keep leaves
+// the temporary directory for a look at what failed.
+func commandSelftest(keep bool) error {
+    failed := 0
+    report := func(step string, err error) bool {
+        if err != nil {
+            fmt.Printf("  ✘ %s: %v\n", step, err)
+            failed++
+            return false
+        }
+        fmt.Printf("  ✔ %s\n", step)
+        return true
+    }
+    v := installedGitVersion()
+    if !v.Known {
+        return fmt.Errorf("cannot run %s --version or tell the version from its output", getGitPath())
+    }
+    fmt.Println("git version " + v.Text)
+    for _, f := range gitFeatures {
+        if !f.supported() {
+            report(f.What, fmt.Errorf("needs git %d.%d or later", f.Major, f.Minor))
+        }
+    }
+    _, lookErr := exec.LookPath("ssh-keygen")
+    signing := lookErr == nil && gitSSHSigning.supported()
+    if !signing {
+        fmt.Println("  - skipping SSH signing")
+    }
+
+    t, err := newSelftestEnv()
+    if t != nil {
+        if keep {
+            defer fmt.Println("Left the test files in " + t.dir)
+        } else {
+            defer os.RemoveAll(t.dir)
+        }
+    }
+    if err != nil {
+        return err
+    }
+    if !report("config", t.selftestConfig(signing)) {
+        return fmt.Errorf("%d step(s) failed", failed)
+    }
+    if err := os.MkdirAll(t.repo, 0o755); err != nil {
+        return err
+    }
+    if out, err := t.git("init", "-q"); !report("git init", gitStepError(out, err)) {
+        return fmt.Errorf("%d step(s) failed", failed)
+    }
+
+    // set must write the identity where git reads it.
+    out, err := t.gist("set", "selftest")
+    if report("gist set", gitStepError(out, err)) {
+        want := map[string]string{"user.name": "Gist Selftest", "user.email": "selftest@example.com"}
+        if signing {
+            want["gpg.format"], want["user.signingkey"] = "ssh", filepath.Join(t.dir, "id_selftest.pub")
+        }
+        var wrong []string
+        for _, key := range sortedKeys(want) {
+            if got, _ := t.git("config", "--local", "--get", key); got != want[key] {
+                wrong = append(wrong, fmt.Sprintf("%s is %q, want %q", key, got, want[key]))
+            }
+        }
+        if len(wrong) > 0 {
+            err = errors.New(strings.Join(wrong, "; "))
+        }
+        report("repository config", err)
+    }
+
+    out, err = t.gist("info")
+    if err == nil && !strings.Contains(out, "selftest@example.com") {
+        err = fmt.Errorf("does not show the profile:\n%s", out)
+    }
+    report("gist info", gitStepError(out, err))
+
+    // A commit made by git itself must pass verify, signature included.
+    commit := []string{"commit", "-q", "--allow-empty", "-m", "gist selftest"}
+    if signing {
+        commit = append(commit, "-S")
+    }
+    out, err = t.git(commit...)
+    if report("git commit", gitStepError(out, err)) {
+        if signing {
+            status, err := t.git("log", "-1", "--format=%G?")
+            if err == nil && status != "G" {
+                err = fmt.Errorf("signature status %q, want \"G\"", status)
+            }
+            report("SSH signature", gitStepError(status, err))
+        }
+        out, err = t.gist("verify")
+        report("gist verify", gitStepError(out, err))
+    }
+
+    out, err = t.gist("unset")
+    if report("gist unset", gitStepError(out, err)) {
+        if email, err := t.git("config", "--local", "--get", "user.email"); err == nil {
+            report("repository config after unset", fmt.Errorf("user.email is still %s", email))
+        }
+    }
+    if failed > 0 {
+        return fmt.Errorf("%d step(s) failed", failed)
+    }
+    fmt.Println("✔ gist works with this git")
+    return nil
+}
+
+// gitStepError adds a failed command's output to its error.
+func gitStepError(out string, err error) error {
+    if err == nil {
+        return nil
+    }
+    var exit *exec.ExitError
+    if errors.As(err, &exit) && out != "" {
+        return fmt.Errorf("%v\n      %s", err, strings.ReplaceAll(out, "\n", "\n      "))
+    }
+    return err
+}
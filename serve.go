@@ -0,0 +1,352 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "sync"
+    "syscall"
+)
+
+// `gist serve` answers editor plugins and shell prompts over a unix socket,
+// sparing them a process per question. The protocol is JSON-RPC 2.0, one
+// request or response per line:
+//
+//   → {"jsonrpc":"2.0","id":1,"method":"resolve","params":{"dir":"/src/app"}}
+//   ← {"jsonrpc":"2.0","id":1,"result":{"root":"/src/app","profile":"work",...}}
+//
+// resolve, verify and set take the absolute path of a directory inside a
+// repository as dir. The config is read again for every request, so edits
+// apply without a restart. A client that calls subscribe is sent a changed
+// notification whenever gist changes a repository's identity.
+
+// JSON-RPC error codes.
+const (
+    rpcParseError     = -32700
+    rpcInvalidRequest = -32600
+    rpcMethodNotFound = -32601
+    rpcInvalidParams  = -32602
+    // rpcFailed is the code of errors the operation itself returns.
+    rpcFailed = -32000
+)
+
+type rpcRequest struct {
+    JSONRPC string          `json:"jsonrpc"`
+    ID      json.RawMessage `json:"id,omitempty"`
+    Method  string          `json:"method"`
+    Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+    Code    int    `json:"code"`
+    Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+    return e.Message
+}
+
+type rpcNotification struct {
+    JSONRPC string `json:"jsonrpc"`
+    Method  string `json:"method"`
+    Params  any    `json:"params"`
+}
+
+type rpcResponse struct {
+    JSONRPC string          `json:"jsonrpc"`
+    ID      json.RawMessage `json:"id"`
+    Result  any             `json:"result,omitempty"`
+    Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcParams are the parameters of every method; profile is only used by
+// set, where it defaults to the profile the repository resolves to.
+type rpcParams struct {
+    Dir     string `json:"dir"`
+    Profile string `json:"profile"`
+}
+
+// resolveResult is what resolve answers: the profile the repository should
+// use, how it was chosen, and the identity git uses there now.
+type resolveResult struct {
+    Root    string `json:"root"`
+    Profile string `json:"profile"`
+    Pinned  bool   `json:"pinned"`
+    Current struct {
+        Profile string `json:"profile"`
+        Name    string `json:"name"`
+        Email   string `json:"email"`
+        Scope   string `json:"scope"`
+    } `json:"current"`
+}
+
+type verifyResponse struct {
+    OK       bool     `json:"ok"`
+    Profile  string   `json:"profile"`
+    Problems []string `json:"problems"`
+}
+
+type setResult struct {
+    Root    string `json:"root"`
+    Profile string `json:"profile"`
+}
+
+// rpcConn is a client connection; notifications for subscribers are
+// written to it while it is answering requests.
+type rpcConn struct {
+    mu  sync.Mutex
+    enc *json.Encoder
+}
+
+func (c *rpcConn) send(v any) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.enc.Encode(v)
+}
+
+// server answers requests one at a time: git commands run in repoDir,
+// which a request sets for its duration.
+type server struct {
+    configPath string
+    mu         sync.Mutex
+    subMu      sync.Mutex
+    subs       map[*rpcConn]bool
+}
+
+// publish sends a change to the subscribers.
+func (s *server) publish(change identityChange) int {
+    s.subMu.Lock()
+    defer s.subMu.Unlock()
+    for c := range s.subs {
+        if err := c.send(rpcNotification{JSONRPC: "2.0", Method: "changed", Params: change}); err != nil {
+            delete(s.subs, c)
+        }
+    }
+    return len(s.subs)
+}
+
+// defaultSocketPath returns the socket serve listens on without --socket.
+func defaultSocketPath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "gist.sock")
+}
+
+// call runs one method for the client c, in the repository containing
+// params.dir.
+func (s *server) call(c *rpcConn, method string, raw json.RawMessage) (any, error) {
+    switch method {
+    case "subscribe":
+        s.subMu.Lock()
+        s.subs[c] = true
+        s.subMu.Unlock()
+        return map[string]bool{"subscribed": true}, nil
+    case "changed":
+        // Sent by the gist that made the change.
+        var change identityChange
+        if err := json.Unmarshal(raw, &change); err != nil {
+            return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+        }
+        return map[string]int{"subscribers": s.publish(change)}, nil
+    }
+    var params rpcParams
+    if len(raw) > 0 {
+        if err := json.Unmarshal(raw, &params); err != nil {
+            return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+        }
+    }
+    switch method {
+    case "resolve", "verify", "set":
+    default:
+        return nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + method}
+    }
+    if params.Dir == "" || !filepath.IsAbs(params.Dir) {
+        return nil, &rpcError{Code: rpcInvalidParams, Message: "dir must be an absolute path"}
+    }
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    cfg, err := loadConfig(s.configPath)
+    if err != nil {
+        return nil, fmt.Errorf("cannot load config: %w", err)
+    }
+    setGitTimeouts(cfg.Timeouts)
+    operationLog = cfg.Log
+    saved := repoDir
+    repoDir = params.Dir
+    defer func() { repoDir = saved }()
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        return nil, errors.New(tr("repo.not_inside"))
+    }
+    switch method {
+    case "resolve":
+        return serveResolve(cfg, root), nil
+    case "verify":
+        return serveVerify(cfg, root)
+    }
+    name := params.Profile
+    if name == "" {
+        if name, err = resolveAutoProfile(cfg, false); err != nil {
+            return nil, err
+        }
+    }
+    if err := commandSet(cfg, name); err != nil {
+        return nil, err
+    }
+    return setResult{Root: root, Profile: findProfile(&cfg, name).Name}, nil
+}
+
+func serveResolve(cfg Config, root string) resolveResult {
+    res := resolveResult{Root: root}
+    res.Profile, _ = resolveAutoProfile(cfg, false)
+    res.Pinned = res.Profile != "" && pinnedProfile(root) == res.Profile
+    id := currentIdentity(&cfg)
+    if id.Profile != nil {
+        res.Current.Profile = id.Profile.Name
+    }
+    res.Current.Name, res.Current.Email, res.Current.Scope = id.Name.Value, id.Email.Value, id.Scope
+    return res
+}
+
+// serveVerify checks the identity like the git shim's quick verify.
+func serveVerify(cfg Config, root string) (verifyResponse, error) {
+    p, err := activeProfile(&cfg)
+    if err != nil {
+        return verifyResponse{Problems: []string{err.Error()}}, nil
+    }
+    problems := identityProblems(cfg, p, root)
+    if problems == nil {
+        problems = []string{}
+    }
+    return verifyResponse{OK: len(problems) == 0, Profile: p.Name, Problems: problems}, nil
+}
+
+// handle answers one request line; it returns nil for a notification.
+func (s *server) handle(c *rpcConn, line []byte) *rpcResponse {
+    var req rpcRequest
+    if err := json.Unmarshal(line, &req); err != nil {
+        return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}}
+    }
+    id := req.ID
+    if len(id) == 0 {
+        id = json.RawMessage("null")
+    }
+    if req.JSONRPC != "2.0" || req.Method == "" {
+        return &rpcResponse{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: rpcInvalidRequest, Message: `expected "jsonrpc": "2.0" and a method`}}
+    }
+    result, err := s.call(c, req.Method, req.Params)
+    if len(req.ID) == 0 {
+        return nil
+    }
+    resp := &rpcResponse{JSONRPC: "2.0", ID: id, Result: result}
+    if err != nil {
+        var rerr *rpcError
+        if !errors.As(err, &rerr) {
+            rerr = &rpcError{Code: rpcFailed, Message: err.Error()}
+        }
+        resp.Result, resp.Error = nil, rerr
+    }
+    return resp
+}
+
+// serveConn answers the requests of one connection until it closes.
+func (s *server) serveConn(conn net.Conn) {
+    c := &rpcConn{enc: json.NewEncoder(conn)}
+    defer func() {
+        s.subMu.Lock()
+        delete(s.subs, c)
+        s.subMu.Unlock()
+        conn.Close()
+    }()
+    scanner := bufio.NewScanner(conn)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        if len(scanner.Bytes()) == 0 {
+            continue
+        }
+        if resp := s.handle(c, scanner.Bytes()); resp != nil {
+            if err := c.send(resp); err != nil {
+                return
+            }
+        }
+    }
+}
+
+// listenSocket listens on a unix socket only the user can connect to,
+// replacing the socket file a server that is gone left behind.
+func listenSocket(path string) (net.Listener, error) {
+    if _, err := os.Lstat(path); err == nil {
+        if conn, err := net.Dial("unix", path); err == nil {
+            conn.Close()
+            return nil, fmt.Errorf("another server is listening on %s", path)
+        }
+        if err := os.Remove(path); err != nil {
+            return nil, err
+        }
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return nil, err
+    }
+    l, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.Chmod(path, 0o600); err != nil {
+        l.Close()
+        return nil, err
+    }
+    return l, nil
+}
+
+// commandServe runs the server until it is interrupted or terminated.
+func commandServe(configPath string, args []string) error {
+    path := defaultSocketPath()
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "--socket" && i+1 < len(args):
+            i++
+            path = expandHome(args[i])
+        default:
+            return errors.New("usage: gist serve [--socket <path>]")
+        }
+    }
+    l, err := listenSocket(path)
+    if err != nil {
+        return err
+    }
+    // A request must never wait for an answer on a terminal nobody watches.
+    os.Setenv("GIST_NONINTERACTIVE", "1")
+    // Tell the gist commands that change identities where to broadcast.
+    if abs, err := filepath.Abs(path); err == nil {
+        path = abs
+    }
+    if err := writeFileAtomic(serveSocketRecord(), []byte(path+"\n")); err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot record the socket in %s; set won't broadcast changes: %v\n", serveSocketRecord(), err)
+    }
+    defer func() {
+        if runningServer() == path {
+            os.Remove(serveSocketRecord())
+        }
+    }()
+    stop := make(chan os.Signal, 1)
+    signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-stop
+        l.Close()
+    }()
+    fmt.Fprintf(os.Stderr, "gist: serving on %s\n", path)
+    s := &server{configPath: configPath, subs: map[*rpcConn]bool{}}
+    for {
+        conn, err := l.Accept()
+        if err != nil {
+            if errors.Is(err, net.ErrClosed) {
+                return nil
+            }
+            return err
+        }
+        go s.serveConn(conn)
+    }
+}
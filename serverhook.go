@@ -0,0 +1,142 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// receiveHook is a standalone pre-receive hook: it needs only git and a POSIX
+// shell on the server, not gist itself.
+const receiveHook = `#!/bin/sh
+# Generated by gist: reject pushed commits from non-allowed emails or
+# without a signature. Regenerate with ` + "`gist server-hook generate`" + `.
+allowed_domains=%s
+allowed_emails=%s
+require_signed=%d
+
+# allowed reports whether an email matches the allowed emails or domains.
+allowed() {
+    [ -z "$allowed_domains$allowed_emails" ] && return 0
+    email=$(printf '%%s' "$1" | tr 'A-Z' 'a-z')
+    for e in $allowed_emails; do
+        [ "$email" = "$e" ] && return 0
+    done
+    domain=${email#*@}
+    for d in $allowed_domains; do
+        case "$domain" in "$d"|*."$d") return 0 ;; esac
+    done
+    return 1
+}
+
+status=0
+while read -r old new ref; do
+    # Deleted refs bring no commits.
+    case "$new" in *[!0]*) ;; *) continue ;; esac
+    # Commits already reachable from some ref were checked when pushed.
+    for commit in $(git rev-list "$new" --not --all); do
+        git log -1 --format='%%ae%%n%%ce%%n%%G?' "$commit" | {
+            read -r author; read -r committer; read -r sig
+            bad=0
+            [ "$committer" = "$author" ] && committer=
+            for email in "$author" $committer; do
+                if ! allowed "$email"; then
+                    echo "gist: $ref: commit $commit uses non-allowed email $email" >&2
+                    bad=1
+                fi
+            done
+            if [ "$require_signed" = 1 ] && [ "$sig" = N ]; then
+                echo "gist: $ref: commit $commit is not signed" >&2
+                bad=1
+            fi
+            exit $bad
+        } || status=1
+    done
+done
+[ $status = 0 ] || echo "gist: push rejected by identity policy" >&2
+exit $status
+`
+
+// hookDomain and hookEmail are the domains and addresses a hook accepts:
+// they are matched word by word in the shell, so nothing that could split,
+// glob or quote gets in.
+var (
+    hookDomain = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+    hookEmail  = regexp.MustCompile(`^[a-z0-9._%+-]+@[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+)
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+    return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// generateReceiveHook returns the pre-receive hook enforcing the allowed
+// domains and emails and, with requireSigned, signatures. It refuses
+// values that aren't plain domains or email addresses.
+func generateReceiveHook(domains, emails []string, requireSigned bool) (string, error) {
+    signed := 0
+    if requireSigned {
+        signed = 1
+    }
+    list := func(values []string, valid *regexp.Regexp, what string) (string, error) {
+        var lower []string
+        for _, v := range values {
+            v = strings.ToLower(v)
+            if !valid.MatchString(v) {
+                return "", fmt.Errorf("%q is not a valid %s", v, what)
+            }
+            lower = append(lower, v)
+        }
+        return shellQuote(strings.Join(lower, " ")), nil
+    }
+    d, err := list(domains, hookDomain, "domain")
+    if err != nil {
+        return "", err
+    }
+    e, err := list(emails, hookEmail, "email address")
+    if err != nil {
+        return "", err
+    }
+    return fmt.Sprintf(receiveHook, d, e, signed), nil
+}
+
+// commandServerHook runs `server-hook generate`, printing a pre-receive hook
+// for repository hosts. Without options it enforces the installed policy.
+func commandServerHook(cfg Config, args []string) error {
+    if len(args) == 0 || args[0] != "generate" {
+        return errors.New("usage: gist server-hook generate [--allow-domain <d>]... [--allow-email <e>]... [--require-signed]")
+    }
+    var domains, emails []string
+    requireSigned := false
+    for i := 1; i < len(args); i++ {
+        switch args[i] {
+        case "--allow-domain", "--allow-email":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s requires a value", args[i])
+            }
+            if args[i] == "--allow-domain" {
+                domains = append(domains, args[i+1])
+            } else {
+                emails = append(emails, args[i+1])
+            }
+            i++
+        case "--require-signed":
+            requireSigned = true
+        default:
+            return fmt.Errorf("unknown option %s", args[i])
+        }
+    }
+    if len(domains) == 0 && len(emails) == 0 && !requireSigned {
+        if cfg.Policy == nil {
+            return errors.New("nothing to enforce: pass --allow-domain, --allow-email or --require-signed, or install a policy")
+        }
+        domains, requireSigned = cfg.Policy.AllowedDomains, cfg.Policy.RequireSigning
+    }
+    hook, err := generateReceiveHook(domains, emails, requireSigned)
+    if err != nil {
+        return err
+    }
+    fmt.Print(hook)
+    return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "os/exec"
+    "strings"
+    "testing"
+)
+
+func TestShellQuote(t *testing.T) {
+    for _, s := range []string{"", "plain", "it's", `"; echo INJECTED; "`, "$(id) `id` $HOME", "a'b'c"} {
+        out, err := exec.Command("sh", "-c", "printf '%s' "+shellQuote(s)).Output()
+        if err != nil {
+            t.Fatalf("%q: %v", s, err)
+        }
+        if string(out) != s {
+            t.Errorf("shellQuote(%q) reads back as %q", s, out)
+        }
+    }
+}
+
+func TestGenerateReceiveHook(t *testing.T) {
+    hook, err := generateReceiveHook([]string{"Corp.com", "dev.example.org"}, []string{"Jane.Doe+git@x.io"}, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, want := range []string{
+        "allowed_domains='corp.com dev.example.org'\n",
+        "allowed_emails='jane.doe+git@x.io'\n",
+        "require_signed=1\n",
+    } {
+        if !strings.Contains(hook, want) {
+            t.Errorf("hook lacks %q", want)
+        }
+    }
+    if out, err := exec.Command("sh", "-n", "-c", hook).CombinedOutput(); err != nil {
+        t.Errorf("hook is not valid sh: %v\n%s", err, out)
+    }
+
+    for _, tc := range []struct {
+        domains, emails []string
+    }{
+        {[]string{`x"; echo INJECTED; "`}, nil},
+        {[]string{"corp.com'"}, nil},
+        {[]string{"*.corp.com"}, nil},
+        {[]string{"-corp.com"}, nil},
+        {[]string{"corp..com"}, nil},
+        {[]string{""}, nil},
+        {nil, []string{"jane@corp.com $(id)"}},
+        {nil, []string{"jane"}},
+        {nil, []string{"`id`@corp.com"}},
+    } {
+        if _, err := generateReceiveHook(tc.domains, tc.emails, false); err == nil {
+            t.Errorf("domains %q, emails %q: accepted", tc.domains, tc.emails)
+        }
+    }
+}
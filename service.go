@@ -0,0 +1,350 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// serviceName names the systemd units and the launchd agent label.
+const (
+    serviceName  = "gist-verify"
+    serviceLabel = "io.github.hnatekmar.gist.verify"
+)
+
+// defaultServiceInterval is how often the installed service verifies.
+const defaultServiceInterval = time.Hour
+
+// verifyReport is what scan --verify leaves in the state directory for
+// service status to show.
+type verifyReport struct {
+    Time         time.Time     `json:"time"`
+    Roots        []string      `json:"roots"`
+    Repositories int           `json:"repositories"`
+    Drifted      []driftRecord `json:"drifted"`
+    Error        string        `json:"error,omitempty"`
+}
+
+// driftRecord is a repository whose identity has drifted.
+type driftRecord struct {
+    Path   string `json:"path"`
+    Reason string `json:"reason"`
+}
+
+// verifyReportPath returns where scan --verify writes its report.
+func verifyReportPath() string {
+    return filepath.Join(filepath.Dir(statePath()), "last-verify.json")
+}
+
+// loadVerifyReport reads the last scan --verify report.
+func loadVerifyReport() (verifyReport, error) {
+    var report verifyReport
+    data, err := os.ReadFile(verifyReportPath())
+    if err != nil {
+        return report, err
+    }
+    return report, json.Unmarshal(data, &report)
+}
+
+// commandScanVerify checks the repositories under the roots, or the ones gist
+// has set a profile in or pinned when there are none, against their profile
+// and the policy like watch does, and records the outcome for service
+// status. It returns an error if any has drifted.
+func commandScanVerify(cfg Config, roots []string, jobs int) error {
+    st, err := loadState()
+    if err != nil {
+        return err
+    }
+    roots, err = watchRoots(st, roots)
+    if err != nil {
+        return err
+    }
+    report := verifyReport{Time: time.Now().UTC(), Roots: roots, Drifted: []driftRecord{}}
+    err = scanParallel(cfg, roots, jobs, nil, func(dir string, res scanResult) {
+        report.Repositories++
+        reason := driftReason(res, st.Repos[res.Root])
+        if reason != "" {
+            fmt.Printf("  ✘ %s: %s\n", res.Root, reason)
+            report.Drifted = append(report.Drifted, driftRecord{res.Root, reason})
+        }
+        if err := rememberVerify(res.Root, reason); err != nil {
+            fmt.Fprintf(os.Stderr, "warning: cannot record the result for %s: %v\n", res.Root, err)
+        }
+    })
+    if err != nil {
+        report.Error = err.Error()
+    }
+    sort.Slice(report.Drifted, func(i, j int) bool { return report.Drifted[i].Path < report.Drifted[j].Path })
+    data, _ := json.MarshalIndent(report, "", "  ")
+    if werr := os.WriteFile(verifyReportPath(), append(data, '\n'), 0o644); werr != nil && err == nil {
+        err = werr
+    }
+    if err != nil {
+        return err
+    }
+    fmt.Printf("%d repositories, %d drifted.\n", report.Repositories, len(report.Drifted))
+    if len(report.Drifted) > 0 {
+        return fmt.Errorf("%d repositories have drifted", len(report.Drifted))
+    }
+    return nil
+}
+
+// serviceFiles returns the unit or agent files service install writes, by
+// path, for running gist at exe every interval.
+func serviceFiles(exe string, interval time.Duration, dirs []string) (map[string]string, error) {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return nil, err
+    }
+    args := append([]string{exe, "scan", "--verify"}, dirs...)
+    env := os.Getenv("GIST_CONFIG_PATH")
+    switch runtime.GOOS {
+    case "darwin":
+        var sb strings.Builder
+        sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+    <key>Label</key>
+    <string>` + serviceLabel + `</string>
+    <key>ProgramArguments</key>
+    <array>
+`)
+        for _, arg := range args {
+            fmt.Fprintf(&sb, "        <string>%s</string>\n", xmlEscape(arg))
+        }
+        sb.WriteString("    </array>\n")
+        if env != "" {
+            fmt.Fprintf(&sb, "    <key>EnvironmentVariables</key>\n    <dict>\n        <key>GIST_CONFIG_PATH</key>\n        <string>%s</string>\n    </dict>\n", xmlEscape(env))
+        }
+        fmt.Fprintf(&sb, "    <key>StartInterval</key>\n    <integer>%d</integer>\n", int(interval.Seconds()))
+        sb.WriteString("    <key>RunAtLoad</key>\n    <true/>\n</dict>\n</plist>\n")
+        path := filepath.Join(home, "Library", "LaunchAgents", serviceLabel+".plist")
+        return map[string]string{path: sb.String()}, nil
+    case "windows":
+        return nil, errors.New("service install supports systemd and launchd; use the Task Scheduler to run `gist scan --verify` on Windows")
+    }
+    quoted := make([]string, len(args))
+    for i, arg := range args {
+        quoted[i] = systemdQuote(arg)
+    }
+    service := "[Unit]\nDescription=Verify git identities with gist\n\n[Service]\nType=oneshot\n"
+    if env != "" {
+        service += "Environment=" + systemdQuote("GIST_CONFIG_PATH="+env) + "\n"
+    }
+    service += "ExecStart=" + strings.Join(quoted, " ") + "\n"
+    timer := fmt.Sprintf("[Unit]\nDescription=Verify git identities with gist every %s\n\n[Timer]\nOnBootSec=5min\nOnUnitActiveSec=%ds\n\n[Install]\nWantedBy=timers.target\n",
+        shortDuration(interval), int(interval.Seconds()))
+    dir := filepath.Join(home, ".config", "systemd", "user")
+    return map[string]string{
+        filepath.Join(dir, serviceName+".service"): service,
+        filepath.Join(dir, serviceName+".timer"):   timer,
+    }, nil
+}
+
+// shortDuration formats d without zero minutes and seconds: 1h, not 1h0m0s.
+func shortDuration(d time.Duration) string {
+    s := d.String()
+    if strings.HasSuffix(s, "m0s") {
+        s = strings.TrimSuffix(s, "0s")
+    }
+    if strings.HasSuffix(s, "h0m") {
+        s = strings.TrimSuffix(s, "0m")
+    }
+    return s
+}
+
+// systemdQuote quotes a word of a unit file command line: % starts a
+// specifier, and spaces or quotes need double quotes.
+func systemdQuote(s string) string {
+    s = strings.ReplaceAll(s, "%", "%%")
+    if strings.ContainsAny(s, " \t\"'\\") {
+        return strconv.Quote(s)
+    }
+    return s
+}
+
+// xmlEscape escapes text for a plist string.
+func xmlEscape(s string) string {
+    return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// serviceControl runs systemctl --user or launchctl.
+func serviceControl(args ...string) (string, error) {
+    name := "launchctl"
+    if runtime.GOOS != "darwin" {
+        name = "systemctl"
+        args = append([]string{"--user"}, args...)
+    }
+    out, err := exec.Command(name, args...).CombinedOutput()
+    msg := strings.TrimSpace(string(out))
+    if err != nil {
+        if msg != "" {
+            return msg, fmt.Errorf("%s %s: %s", name, strings.Join(args, " "), msg)
+        }
+        return msg, fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+    }
+    return msg, nil
+}
+
+// serviceInstall writes the timer or agent and starts it.
+func serviceInstall(interval time.Duration, dirs []string) error {
+    exe, err := os.Executable()
+    if err != nil {
+        return err
+    }
+    if len(dirs) > 0 {
+        if dirs, err = absRoots(dirs); err != nil {
+            return err
+        }
+    }
+    files, err := serviceFiles(exe, interval, dirs)
+    if err != nil {
+        return err
+    }
+    for _, path := range sortedKeys(files) {
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+            return err
+        }
+        if err := os.WriteFile(path, []byte(files[path]), 0o644); err != nil {
+            return err
+        }
+        fmt.Println("Wrote " + path)
+    }
+    if runtime.GOOS == "darwin" {
+        path := sortedKeys(files)[0]
+        serviceControl("unload", path)
+        _, err = serviceControl("load", "-w", path)
+    } else if _, err = serviceControl("daemon-reload"); err == nil {
+        _, err = serviceControl("enable", "--now", serviceName+".timer")
+    }
+    if err != nil {
+        return fmt.Errorf("%v (the files are in place; start them by hand)", err)
+    }
+    fmt.Printf("gist now verifies identities every %s; see `gist service status`.\n", shortDuration(interval))
+    return nil
+}
+
+// serviceUninstall stops the timer or agent and removes its files.
+func serviceUninstall() error {
+    files, err := serviceFiles("", defaultServiceInterval, nil)
+    if err != nil {
+        return err
+    }
+    if runtime.GOOS == "darwin" {
+        serviceControl("unload", "-w", sortedKeys(files)[0])
+    } else {
+        serviceControl("disable", "--now", serviceName+".timer")
+    }
+    removed := false
+    for _, path := range sortedKeys(files) {
+        if err := os.Remove(path); err == nil {
+            fmt.Println("Removed " + path)
+            removed = true
+        } else if !os.IsNotExist(err) {
+            return err
+        }
+    }
+    if !removed {
+        return errors.New("the service is not installed")
+    }
+    if runtime.GOOS != "darwin" {
+        serviceControl("daemon-reload")
+    }
+    return nil
+}
+
+// serviceStatus shows whether the service is installed and running and the
+// summary of the last verification.
+func serviceStatus() error {
+    files, err := serviceFiles("", defaultServiceInterval, nil)
+    if err != nil {
+        return err
+    }
+    installed := true
+    for path := range files {
+        if _, err := os.Stat(path); err != nil {
+            installed = false
+        }
+    }
+    switch {
+    case !installed:
+        fmt.Println("Service: not installed (run `gist service install`)")
+    case runtime.GOOS == "darwin":
+        if _, err := serviceControl("list", serviceLabel); err != nil {
+            fmt.Println("Service: installed, not loaded")
+        } else {
+            fmt.Println("Service: installed, loaded")
+        }
+    default:
+        // is-active fails for inactive timers too; only its word matters.
+        state, _ := serviceControl("is-active", serviceName+".timer")
+        if state == "" || strings.ContainsAny(state, " \n") {
+            state = "unknown"
+        }
+        fmt.Println("Service: installed, timer " + state)
+        if next, err := serviceControl("show", "-P", "NextElapseUSecRealtime", serviceName+".timer"); err == nil && next != "" {
+            fmt.Println("Next run: " + next)
+        }
+    }
+    report, err := loadVerifyReport()
+    if os.IsNotExist(err) {
+        fmt.Println("Last run: never")
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("cannot read %s: %v", verifyReportPath(), err)
+    }
+    fmt.Printf("Last run: %s (%s ago)\n", report.Time.Local().Format("2006-01-02 15:04"), time.Since(report.Time).Round(time.Minute))
+    if report.Error != "" {
+        fmt.Println("  failed: " + report.Error)
+    }
+    fmt.Printf("  %d repositories, %d drifted\n", report.Repositories, len(report.Drifted))
+    for _, d := range report.Drifted {
+        fmt.Printf("  ✘ %s: %s\n", d.Path, d.Reason)
+    }
+    return nil
+}
+
+// commandService installs, removes or reports on the background
+// verification service.
+func commandService(args []string) error {
+    usage := errors.New("usage: gist service install [--interval <d>] [dir...] | uninstall | status")
+    if len(args) == 0 {
+        return usage
+    }
+    switch args[0] {
+    case "install":
+        interval := defaultServiceInterval
+        var dirs []string
+        for i := 1; i < len(args); i++ {
+            if args[i] != "--interval" {
+                dirs = append(dirs, args[i])
+                continue
+            }
+            if i+1 >= len(args) {
+                return errors.New("--interval requires a value")
+            }
+            d, err := time.ParseDuration(args[i+1])
+            if err != nil || d < time.Minute {
+                return fmt.Errorf("invalid --interval %q (at least 1m, e.g. 1h)", args[i+1])
+            }
+            interval = d
+            i++
+        }
+        return serviceInstall(interval, dirs)
+    case "uninstall":
+        return serviceUninstall()
+    case "status":
+        return serviceStatus()
+    }
+    return usage
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "runtime"
+    "strings"
+)
+
+// shimScript is the git wrapper `shim install` writes. It finds the git
+// subcommand past the global options, runs `gist verify --quick` in the
+// right directory before commits and pushes, and hands everything to the
+// real git. GIST_SHIM=off skips the check.
+const shimScript = `#!/bin/sh
+# Installed by gist: check the identity before commits and pushes.
+real=%s
+sub= skip= dir=
+for arg do
+    if [ -n "$skip" ]; then
+        [ "$skip" = C ] && dir=$arg
+        skip=
+        continue
+    fi
+    case $arg in
+        -C) skip=C ;;
+        -c|--git-dir|--work-tree|--namespace|--exec-path|--config-env) skip=1 ;;
+        -*) ;;
+        *) sub=$arg; break ;;
+    esac
+done
+case $sub in
+    commit|push|merge|cherry-pick|revert|am|tag)
+        if [ "$GIST_SHIM" != off ]; then
+            if [ -n "$dir" ]; then
+                %s -C "$dir" verify --quick || exit 1
+            else
+                %s verify --quick || exit 1
+            fi
+        fi
+        ;;
+esac
+exec "$real" "$@"
+`
+
+// defaultShimDir is where the shim goes without --dir.
+func defaultShimDir() (string, error) {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(home, ".local", "bin"), nil
+}
+
+// realGit finds the git executable on PATH other than the one in dir.
+func realGit(dir string) (string, error) {
+    for _, d := range filepath.SplitList(os.Getenv("PATH")) {
+        if abs, err := filepath.Abs(d); err != nil || abs == dir {
+            continue
+        }
+        if path, err := exec.LookPath(filepath.Join(d, "git")); err == nil {
+            return path, nil
+        }
+    }
+    return "", errors.New("git not found in PATH")
+}
+
+// shimAhead reports whether dir comes before the real git's directory in
+// PATH, i.e. whether `git` runs the shim.
+func shimAhead(dir, real string) bool {
+    for _, d := range filepath.SplitList(os.Getenv("PATH")) {
+        abs, _ := filepath.Abs(d)
+        switch abs {
+        case dir:
+            return true
+        case filepath.Dir(real):
+            return false
+        }
+    }
+    return false
+}
+
+// shimInstall writes the git wrapper into dir.
+func shimInstall(dir string, force bool) error {
+    if runtime.GOOS == "windows" {
+        return errors.New("the git shim needs a POSIX shell; use `gist guard install` in each repository on Windows")
+    }
+    dir, err := filepath.Abs(expandHome(dir))
+    if err != nil {
+        return err
+    }
+    real, err := realGit(dir)
+    if err != nil {
+        return err
+    }
+    path := filepath.Join(dir, "git")
+    if data, err := os.ReadFile(path); err == nil && !strings.Contains(string(data), "Installed by gist") && !force {
+        return fmt.Errorf("refusing to overwrite %s; use --force", path)
+    }
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return err
+    }
+    script := fmt.Sprintf(shimScript, shellQuote(real), gistExecutable(), gistExecutable())
+    if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+        return err
+    }
+    fmt.Printf("Installed %s, wrapping %s.\n", path, real)
+    if !shimAhead(dir, real) {
+        fmt.Printf("⚠ %s must come before %s in PATH for the shim to run, e.g. in your shell profile:\n", dir, filepath.Dir(real))
+        fmt.Printf("    export PATH=\"%s:$PATH\"\n", dir)
+    }
+    return nil
+}
+
+// shimUninstall removes the git wrapper from dir.
+func shimUninstall(dir string) error {
+    dir, err := filepath.Abs(expandHome(dir))
+    if err != nil {
+        return err
+    }
+    path := filepath.Join(dir, "git")
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return fmt.Errorf("no shim in %s", dir)
+    }
+    if err != nil {
+        return err
+    }
+    if !strings.Contains(string(data), "Installed by gist") {
+        return fmt.Errorf("%s was not installed by gist", path)
+    }
+    if err := os.Remove(path); err != nil {
+        return err
+    }
+    fmt.Printf("Removed %s.\n", path)
+    return nil
+}
+
+// commandShim installs or removes the git wrapper that checks the identity
+// before commits and pushes in every repository, without per-repository
+// hooks.
+func commandShim(args []string) error {
+    usage := errors.New("usage: gist shim install [--dir <dir>] [--force] | gist shim uninstall [--dir <dir>]")
+    if len(args) == 0 {
+        return usage
+    }
+    dir, err := defaultShimDir()
+    if err != nil {
+        return err
+    }
+    force := false
+    for i := 1; i < len(args); i++ {
+        switch {
+        case args[i] == "--dir" && i+1 < len(args):
+            dir = args[i+1]
+            i++
+        case args[i] == "--force":
+            force = true
+        default:
+            return usage
+        }
+    }
+    switch args[0] {
+    case "install":
+        return shimInstall(dir, force)
+    case "uninstall":
+        return shimUninstall(dir)
+    }
+    return usage
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// Signer is a teammate's SSH signing key trusted for verification.
+type Signer struct {
+    Email string `yaml:"email"`
+    Key   string `yaml:"key"`
+}
+
+// loadSignerKey applies a single key of the signers section.
+func loadSignerKey(cfg *Config, trimmed, key, value string) {
+    if strings.HasPrefix(trimmed, "-") {
+        cfg.Signers = append(cfg.Signers, Signer{})
+    }
+    if len(cfg.Signers) == 0 {
+        return
+    }
+    s := &cfg.Signers[len(cfg.Signers)-1]
+    switch key {
+    case "email":
+        s.Email = value
+    case "key":
+        s.Key = value
+    }
+}
+
+// writeSigners serializes the signers section.
+func writeSigners(sb *strings.Builder, signers []Signer) {
+    if len(signers) == 0 {
+        return
+    }
+    sb.WriteString("signers:\n")
+    for _, s := range signers {
+        sb.WriteString("  - email: \"" + s.Email + "\"\n")
+        sb.WriteString("    key: \"" + s.Key + "\"\n")
+    }
+}
+
+// allowedSignersPath returns the gist-managed allowed_signers file.
+func allowedSignersPath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "allowed_signers")
+}
+
+// signingPublicKey returns the "type base64" public key of an SSH signing
+// key setting, or "" for GPG keys.
+func signingPublicKey(key string) (string, error) {
+    if key == "" || !isSSHSigningKey(key) {
+        return "", nil
+    }
+    literal := strings.TrimPrefix(key, "key::")
+    if strings.HasPrefix(literal, "ssh-") || strings.HasPrefix(literal, "ecdsa-") || strings.HasPrefix(literal, "sk-") {
+        fields := strings.Fields(literal)
+        if len(fields) < 2 {
+            return "", fmt.Errorf("%s is not an SSH public key", key)
+        }
+        return fields[0] + " " + fields[1], nil
+    }
+    path := expandHome(key)
+    // A private key file has its public half next to it.
+    if !strings.HasSuffix(path, ".pub") {
+        if _, err := os.Stat(path + ".pub"); err == nil {
+            path += ".pub"
+        }
+    }
+    return sshPublicKey(path)
+}
+
+// allowedSignerEntry is a line of the allowed_signers file and who it is for.
+type allowedSignerEntry struct {
+    Line   string
+    Source string
+}
+
+// allowedSigners lists the entries for every profile's SSH signing key,
+// every teammate signer and the synced team roster.
+func allowedSigners(cfg Config) []allowedSignerEntry {
+    var entries []allowedSignerEntry
+    for _, p := range cfg.Profiles {
+        key, err := signingPublicKey(p.SigningKey)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "warning: profile %s: %v\n", p.Name, err)
+            continue
+        }
+        if key != "" {
+            entries = append(entries, allowedSignerEntry{fmt.Sprintf(`%s namespaces="git" %s`, p.Email, key), "profile " + p.Name})
+        }
+    }
+    for _, s := range cfg.Signers {
+        entries = append(entries, allowedSignerEntry{fmt.Sprintf(`%s namespaces="git" %s`, s.Email, s.Key), "signer"})
+    }
+    roster, _, err := loadRoster()
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+    }
+    for _, e := range roster {
+        if !e.GPG {
+            entries = append(entries, allowedSignerEntry{fmt.Sprintf(`%s namespaces="git" %s`, strings.Join(e.Emails, ","), e.Key), "roster"})
+        }
+    }
+    return entries
+}
+
+// syncAllowedSigners rewrites the gist-managed allowed_signers file.
+func syncAllowedSigners(cfg Config) error {
+    var sb strings.Builder
+    sb.WriteString("# Maintained by gist from the profiles, signers and team roster; edits are lost.\n")
+    for _, e := range allowedSigners(cfg) {
+        sb.WriteString(e.Line + "\n")
+    }
+    path := allowedSignersPath()
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return err
+    }
+    return os.WriteFile(path, []byte(sb.String()), 0o644)
+}
+
+// commandSigners runs the `signers` subcommands. It reports whether the
+// config changed and needs saving.
+func commandSigners(cfg *Config, args []string) (bool, error) {
+    usage := errors.New("usage: gist signers list|add <email> <key or .pub file>|remove <email>")
+    if len(args) == 0 {
+        return false, usage
+    }
+    switch args[0] {
+    case "list":
+        entries := allowedSigners(*cfg)
+        if len(entries) == 0 {
+            fmt.Println("no SSH signing keys")
+            return false, nil
+        }
+        fmt.Printf("allowed signers (%s):\n", allowedSignersPath())
+        for _, e := range entries {
+            fmt.Printf("  %s\t[%s]\n", e.Line, e.Source)
+        }
+        return false, nil
+    case "add":
+        if len(args) < 3 {
+            return false, usage
+        }
+        key, err := signingPublicKey(strings.Join(args[2:], " "))
+        if err != nil {
+            return false, err
+        }
+        if key == "" {
+            return false, fmt.Errorf("%s is not an SSH public key", args[2])
+        }
+        for _, s := range cfg.Signers {
+            if s.Email == args[1] && s.Key == key {
+                return false, fmt.Errorf("%s already trusts this key", args[1])
+            }
+        }
+        cfg.Signers = append(cfg.Signers, Signer{Email: args[1], Key: key})
+        fmt.Printf("Signer %s added.\n", args[1])
+    case "remove":
+        if len(args) < 2 {
+            return false, usage
+        }
+        kept := cfg.Signers[:0]
+        for _, s := range cfg.Signers {
+            if s.Email != args[1] {
+                kept = append(kept, s)
+            }
+        }
+        if len(kept) == len(cfg.Signers) {
+            return false, fmt.Errorf("no signer %s", args[1])
+        }
+        fmt.Printf("Removed %d key(s) of %s.\n", len(cfg.Signers)-len(kept), args[1])
+        cfg.Signers = kept
+    default:
+        return false, usage
+    }
+    return true, syncAllowedSigners(*cfg)
+}
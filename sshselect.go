@@ -0,0 +1,70 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+// sshProfile returns the profile whose SSH key a connection to host should
+// use: inside a repository the profile of its identity, else the one its
+// pin, rules, hosts map or default select; outside one the hosts map entry
+// for the host, else the default profile.
+func sshProfile(cfg Config, host, user string) (*Profile, error) {
+    if inRepo, _ := isGitRepo(); inRepo {
+        if p, err := activeProfile(&cfg); err == nil {
+            return p, nil
+        }
+        if name, err := resolveAutoProfile(cfg, false); err == nil {
+            return findProfile(&cfg, name), nil
+        }
+    }
+    remote := host + ":"
+    if user != "" {
+        remote = user + "@" + remote
+    }
+    name, _ := fallbackProfile(cfg, []remoteInfo{{Name: "origin", URL: remote}})
+    if name == "" {
+        return nil, fmt.Errorf("no profile is selected for %s", host)
+    }
+    return findProfile(&cfg, name), nil
+}
+
+// commandSSHSelect prints the SSH key of the profile selected for a host, so
+// it can feed `ssh -i` or scripts. With --match <profile> it prints nothing
+// and exits zero only when that profile is selected, for ssh_config:
+//
+//   Match host github.com exec "gist ssh-select --match work %h %r"
+//       IdentityFile ~/.ssh/id_work
+//       IdentitiesOnly yes
+func commandSSHSelect(cfg Config, args []string) (bool, error) {
+    usage := errors.New("usage: gist ssh-select [--match <profile>] <host> [<user>]")
+    match := ""
+    if len(args) > 0 && args[0] == "--match" {
+        if len(args) < 2 {
+            return false, usage
+        }
+        match, args = args[1], args[2:]
+    }
+    if len(args) < 1 || len(args) > 2 || args[0] == "" {
+        return false, usage
+    }
+    user := ""
+    if len(args) == 2 {
+        user = args[1]
+    }
+    p, err := sshProfile(cfg, args[0], user)
+    if match != "" {
+        return err == nil && p != nil && p.Name == match, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    if p == nil {
+        return false, fmt.Errorf("no profile is selected for %s", args[0])
+    }
+    if p.SSHKey == "" {
+        return false, fmt.Errorf("%s has no ssh_key", profileLabel(p))
+    }
+    fmt.Println(profilePath(p, p.SSHKey))
+    return true, nil
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+    "bufio"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+)
+
+// gist records what it knows about each repository in its own file under
+// the state directory next to the config: the profile set there, its pin,
+// the last verification and a short history. Writers hold the directory's
+// lock and replace files atomically, so concurrent gist processes (a shell
+// hook, watch, the verify service) never lose each other's updates, and
+// readers never see half a file.
+
+// State is the pins and applied profiles of all repositories, for the
+// commands that work across them.
+type State struct {
+    // Pins maps repository roots to the profile they are pinned to.
+    Pins map[string]string
+    // Repos maps the repositories gist has set a profile in to that
+    // profile, for watch to keep an eye on.
+    Repos map[string]string
+}
+
+// maxRepoHistory is how many events a repository record keeps.
+const maxRepoHistory = 50
+
+// repoRecord is what gist knows about one repository.
+type repoRecord struct {
+    Root string `json:"root"`
+    // Profile is the profile gist last set in the repository, Pin the one
+    // it is pinned to.
+    Profile    string        `json:"profile,omitempty"`
+    Pin        string        `json:"pin,omitempty"`
+    LastVerify *verifyResult `json:"last_verify,omitempty"`
+    // Keys are the local config keys gist last wrote; the next set removes
+    // those its profile doesn't set, and only those.
+    Keys []string `json:"keys,omitempty"`
+    // History lists the changes, oldest first.
+    History []repoEvent `json:"history,omitempty"`
+}
+
+// verifyResult is the outcome of the last check of a repository against
+// its profile; Reason says how it drifted.
+type verifyResult struct {
+    Time   time.Time `json:"time"`
+    OK     bool      `json:"ok"`
+    Reason string    `json:"reason,omitempty"`
+}
+
+// repoEvent is a change gist made to a repository: set, pin or unpin.
+type repoEvent struct {
+    Time     time.Time `json:"time"`
+    Action   string    `json:"action"`
+    Profile  string    `json:"profile,omitempty"`
+    Previous string    `json:"previous,omitempty"`
+}
+
+// record appends an event to the history, dropping the oldest beyond
+// maxRepoHistory.
+func (r *repoRecord) record(action, profile, previous string) {
+    r.History = append(r.History, repoEvent{Time: time.Now().UTC(), Action: action, Profile: profile, Previous: previous})
+    if n := len(r.History) - maxRepoHistory; n > 0 {
+        r.History = r.History[n:]
+    }
+}
+
+// statePath returns the state directory next to the config.
+func statePath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "state")
+}
+
+// legacyStatePath returns the single state file of earlier versions.
+func legacyStatePath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "state.yaml")
+}
+
+// repoRecordPath returns the file of the repository at root, named by a
+// hash of the path.
+func repoRecordPath(root string) string {
+    sum := sha256.Sum256([]byte(filepath.Clean(root)))
+    return filepath.Join(statePath(), "repos", hex.EncodeToString(sum[:8])+".json")
+}
+
+// stateLockTimeout is how long a writer waits for the state lock; a lock
+// older than staleStateLock was left by a process that died.
+const (
+    stateLockTimeout = 10 * time.Second
+    staleStateLock   = 30 * time.Second
+)
+
+// withStateLock runs fn holding the state directory's lock.
+func withStateLock(fn func() error) error {
+    if err := os.MkdirAll(filepath.Join(statePath(), "repos"), 0o755); err != nil {
+        return err
+    }
+    lock := filepath.Join(statePath(), "lock")
+    deadline := time.Now().Add(stateLockTimeout)
+    for {
+        f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+        if err == nil {
+            fmt.Fprintf(f, "%d\n", os.Getpid())
+            f.Close()
+            break
+        }
+        if !os.IsExist(err) {
+            return err
+        }
+        if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > staleStateLock {
+            os.Remove(lock)
+            continue
+        }
+        if time.Now().After(deadline) {
+            return fmt.Errorf("%s is held by another gist; remove it if none is running", lock)
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+    defer os.Remove(lock)
+    return fn()
+}
+
+// readRepoRecord reads a record file; a missing file is an empty record.
+func readRepoRecord(path, root string) (repoRecord, error) {
+    rec := repoRecord{Root: root}
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return rec, nil
+    }
+    if err != nil {
+        return rec, err
+    }
+    if err := json.Unmarshal(data, &rec); err != nil {
+        return rec, fmt.Errorf("%s: %w", path, err)
+    }
+    return rec, nil
+}
+
+// writeRepoRecord replaces a record file atomically.
+func writeRepoRecord(rec repoRecord) error {
+    data, err := json.MarshalIndent(rec, "", "  ")
+    if err != nil {
+        return err
+    }
+    return writeFileAtomic(repoRecordPath(rec.Root), append(data, '\n'))
+}
+
+// loadRepoRecord returns what gist knows about the repository at root.
+func loadRepoRecord(root string) (repoRecord, error) {
+    if err := migrateLegacyState(); err != nil {
+        return repoRecord{}, err
+    }
+    root = filepath.Clean(root)
+    return readRepoRecord(repoRecordPath(root), root)
+}
+
+// updateRepoRecord changes the record of the repository at root under the
+// state lock.
+func updateRepoRecord(root string, change func(*repoRecord)) error {
+    if err := migrateLegacyState(); err != nil {
+        return err
+    }
+    root = filepath.Clean(root)
+    return withStateLock(func() error {
+        rec, err := readRepoRecord(repoRecordPath(root), root)
+        if err != nil {
+            return err
+        }
+        before := rec
+        change(&rec)
+        if reflect.DeepEqual(before, rec) {
+            return nil
+        }
+        if err := writeRepoRecord(rec); err != nil {
+            return err
+        }
+        logStateSave(recordState(before), recordState(rec))
+        return nil
+    })
+}
+
+// recordState returns a record's pin and profile as a State.
+func recordState(rec repoRecord) State {
+    st := State{Pins: map[string]string{}, Repos: map[string]string{}}
+    if rec.Pin != "" {
+        st.Pins[rec.Root] = rec.Pin
+    }
+    if rec.Profile != "" {
+        st.Repos[rec.Root] = rec.Profile
+    }
+    return st
+}
+
+// loadRepoRecords returns the records of all repositories, by root.
+func loadRepoRecords() ([]repoRecord, error) {
+    if err := migrateLegacyState(); err != nil {
+        return nil, err
+    }
+    paths, err := filepath.Glob(filepath.Join(statePath(), "repos", "*.json"))
+    if err != nil {
+        return nil, err
+    }
+    var records []repoRecord
+    for _, path := range paths {
+        rec, err := readRepoRecord(path, "")
+        if err != nil {
+            return nil, err
+        }
+        if rec.Root != "" {
+            records = append(records, rec)
+        }
+    }
+    sort.Slice(records, func(i, j int) bool { return records[i].Root < records[j].Root })
+    return records, nil
+}
+
+// loadState returns the pins and applied profiles of all repositories.
+func loadState() (State, error) {
+    st := State{Pins: map[string]string{}, Repos: map[string]string{}}
+    records, err := loadRepoRecords()
+    if err != nil {
+        return st, err
+    }
+    for _, rec := range records {
+        if rec.Pin != "" {
+            st.Pins[rec.Root] = rec.Pin
+        }
+        if rec.Profile != "" {
+            st.Repos[rec.Root] = rec.Profile
+        }
+    }
+    return st, nil
+}
+
+// migrateLegacyState moves the pins and repositories of an earlier
+// version's state.yaml into repository records, keeping the file as
+// state.yaml.migrated.
+func migrateLegacyState() error {
+    path := legacyStatePath()
+    if _, err := os.Stat(path); err != nil {
+        return nil
+    }
+    return withStateLock(func() error {
+        // Another gist may have migrated it while this one waited.
+        legacy, err := loadLegacyState(path)
+        if os.IsNotExist(err) {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        roots := map[string]bool{}
+        for root := range legacy.Pins {
+            roots[root] = true
+        }
+        for root := range legacy.Repos {
+            roots[root] = true
+        }
+        for root := range roots {
+            rec, err := readRepoRecord(repoRecordPath(root), root)
+            if err != nil {
+                return err
+            }
+            if rec.Pin == "" {
+                rec.Pin = legacy.Pins[root]
+            }
+            if rec.Profile == "" {
+                rec.Profile = legacy.Repos[root]
+            }
+            if err := writeRepoRecord(rec); err != nil {
+                return err
+            }
+        }
+        return os.Rename(path, path+".migrated")
+    })
+}
+
+// loadLegacyState reads an earlier version's state.yaml.
+func loadLegacyState(path string) (State, error) {
+    st := State{Pins: map[string]string{}, Repos: map[string]string{}}
+    f, err := os.Open(path)
+    if err != nil {
+        return st, err
+    }
+    defer f.Close()
+    section, repo := "", ""
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        line := scanner.Text()
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+            continue
+        }
+        key, value, ok := parseKeyValue(line)
+        if !ok {
+            continue
+        }
+        if !strings.HasPrefix(line, " ") {
+            section = key
+            continue
+        }
+        list := st.Pins
+        switch section {
+        case "pins":
+        case "repos":
+            list = st.Repos
+        default:
+            continue
+        }
+        // Paths may contain colons, so repositories are stored as a list.
+        switch key {
+        case "repo":
+            repo = value
+        case "profile":
+            if repo != "" {
+                list[repo] = value
+            }
+        }
+    }
+    return st, scanner.Err()
+}
+
+// pinnedProfile returns the profile the repository at root is pinned to.
+func pinnedProfile(root string) string {
+    rec, err := loadRepoRecord(root)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "warning: cannot read %s: %v\n", statePath(), err)
+        return ""
+    }
+    return rec.Pin
+}
+
+// rememberRepo records the profile gist set in a repository and the local
+// config keys it wrote.
+func rememberRepo(root, profile string, keys []string) error {
+    return updateRepoRecord(root, func(rec *repoRecord) {
+        if rec.Profile != profile {
+            rec.record("set", profile, rec.Profile)
+            rec.Profile = profile
+        }
+        rec.Keys = keys
+    })
+}
+
+// rememberVerify records the outcome of checking a repository; reason is
+// empty when it matches its profile.
+func rememberVerify(root, reason string) error {
+    return updateRepoRecord(root, func(rec *repoRecord) {
+        rec.LastVerify = &verifyResult{Time: time.Now().UTC(), OK: reason == "", Reason: reason}
+    })
+}
+
+// checkPin refuses to give a pinned repository a different profile.
+func checkPin(root, profile string) error {
+    if pin := pinnedProfile(root); pin != "" && pin != profile {
+        return fmt.Errorf("%s is pinned to profile %s; run `gist unpin` first", root, pin)
+    }
+    return nil
+}
+
+// commandPin pins the current repository to a profile and applies it.
+func commandPin(cfg Config, profileName string) error {
+    if findProfile(&cfg, profileName) == nil {
+        return fmt.Errorf("profile %s not found", profileName)
+    }
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    err := updateRepoRecord(root, func(rec *repoRecord) {
+        rec.record("pin", profileName, rec.Pin)
+        rec.Pin = profileName
+    })
+    if err != nil {
+        return err
+    }
+    fmt.Printf("📌 Pinned %s to profile %s\n", root, profileName)
+    return commandSet(cfg, profileName)
+}
+
+// commandUnpin removes the pin of the current repository.
+func commandUnpin() error {
+    inRepo, root := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    root = filepath.Clean(root)
+    if pinnedProfile(root) == "" {
+        return fmt.Errorf("%s is not pinned", root)
+    }
+    err := updateRepoRecord(root, func(rec *repoRecord) {
+        rec.record("unpin", "", rec.Pin)
+        rec.Pin = ""
+    })
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Unpinned %s\n", root)
+    broadcastChange(root, "unpin", "")
+    return nil
+}
+
+// commandPins lists the pinned repositories.
+func commandPins() error {
+    st, err := loadState()
+    if err != nil {
+        return err
+    }
+    if len(st.Pins) == 0 {
+        fmt.Println("no pinned repositories")
+        return nil
+    }
+    for _, repo := range sortedKeys(st.Pins) {
+        fmt.Printf("📌 %s\t%s\n", repo, st.Pins[repo])
+    }
+    return nil
+}
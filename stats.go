@@ -0,0 +1,205 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "regexp"
+    "strconv"
+    "strings"
+    "text/tabwriter"
+    "time"
+)
+
+// defaultExpiryWindow is how many days ahead `stats keys` warns about
+// expiring keys.
+const defaultExpiryWindow = 30
+
+// keyDates describes a signing key's validity; zero times are unknown or,
+// for Expires, never.
+type keyDates struct {
+    Kind     string
+    Created  time.Time
+    Expires  time.Time
+    Profiles []string
+    Err      error
+}
+
+// gpgKeyDates reads a GPG key's creation and expiry from the keyring.
+func gpgKeyDates(key string) (created, expires time.Time, err error) {
+    out, err := exec.Command(getGPGPath(), "--list-keys", "--with-colons", key).Output()
+    if err != nil {
+        return created, expires, errors.New("not found in gpg keyring")
+    }
+    for _, line := range strings.Split(string(out), "\n") {
+        fields := strings.Split(line, ":")
+        if len(fields) < 7 || fields[0] != "pub" {
+            continue
+        }
+        if fields[1] == "r" {
+            return created, expires, errors.New("revoked")
+        }
+        if n, err := strconv.ParseInt(fields[5], 10, 64); err == nil {
+            created = time.Unix(n, 0)
+        }
+        if n, err := strconv.ParseInt(fields[6], 10, 64); err == nil {
+            expires = time.Unix(n, 0)
+        }
+        return created, expires, nil
+    }
+    return created, expires, errors.New("not found in gpg keyring")
+}
+
+// sshValidity matches the validity line of `ssh-keygen -L`.
+var sshValidity = regexp.MustCompile(`Valid: from (\S+) to (\S+)`)
+
+// sshKeyDates reads the validity of an SSH certificate belonging to a
+// signing key file. Plain SSH keys have no dates.
+func sshKeyDates(key string) (created, expires time.Time, err error) {
+    if strings.HasPrefix(key, "key::") || strings.HasPrefix(key, "ssh-") {
+        return created, expires, nil
+    }
+    path := expandHome(key)
+    if _, err := os.Stat(path); err != nil {
+        return created, expires, errors.New("key file does not exist")
+    }
+    cert := strings.TrimSuffix(path, ".pub") + "-cert.pub"
+    if strings.HasSuffix(path, "-cert.pub") {
+        cert = path
+    }
+    if _, err := os.Stat(cert); err != nil {
+        return created, expires, nil
+    }
+    out, err := exec.Command("ssh-keygen", "-L", "-f", cert).Output()
+    if err != nil {
+        return created, expires, fmt.Errorf("cannot read certificate %s", cert)
+    }
+    m := sshValidity.FindStringSubmatch(string(out))
+    if m == nil {
+        // "Valid: forever"
+        return created, expires, nil
+    }
+    created, _ = time.ParseInLocation("2006-01-02T15:04:05", m[1], time.Local)
+    expires, _ = time.ParseInLocation("2006-01-02T15:04:05", m[2], time.Local)
+    return created, expires, nil
+}
+
+// signingKeys collects the signing keys referenced by profiles, in profile
+// order, with the profiles using each.
+func signingKeys(cfg Config) ([]string, map[string]*keyDates) {
+    var order []string
+    keys := map[string]*keyDates{}
+    for _, p := range cfg.Profiles {
+        if p.SigningKey == "" {
+            continue
+        }
+        if k, ok := keys[p.SigningKey]; ok {
+            k.Profiles = append(k.Profiles, p.Name)
+            continue
+        }
+        k := &keyDates{Kind: "gpg", Profiles: []string{p.Name}}
+        if isSSHSigningKey(p.SigningKey) {
+            k.Kind = "ssh"
+            k.Created, k.Expires, k.Err = sshKeyDates(p.SigningKey)
+        } else {
+            k.Created, k.Expires, k.Err = gpgKeyDates(p.SigningKey)
+        }
+        keys[p.SigningKey] = k
+        order = append(order, p.SigningKey)
+    }
+    return order, keys
+}
+
+// commandStatsKeys prints every signing key with its dates and days left,
+// flagging keys that expire within window days. It returns false when a key
+// is missing, expired or expiring.
+func commandStatsKeys(cfg Config, window int) bool {
+    order, keys := signingKeys(cfg)
+    if len(order) == 0 {
+        fmt.Println("no profile has a signing key")
+        return true
+    }
+    date := func(t time.Time, none string) string {
+        if t.IsZero() {
+            return none
+        }
+        return t.Format("2006-01-02")
+    }
+    healthy := true
+    now := time.Now()
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(w, "KEY\tTYPE\tPROFILES\tCREATED\tEXPIRES\tDAYS LEFT")
+    for _, key := range order {
+        k := keys[key]
+        status := "-"
+        switch {
+        case k.Err != nil:
+            status = "✘ " + k.Err.Error()
+            healthy = false
+        case k.Expires.IsZero():
+        case k.Expires.Before(now):
+            status = "✘ expired"
+            healthy = false
+        default:
+            days := int(k.Expires.Sub(now).Hours() / 24)
+            status = strconv.Itoa(days)
+            if days < window {
+                status += " ⚠"
+                healthy = false
+            }
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", key, k.Kind, strings.Join(k.Profiles, ","),
+            date(k.Created, "-"), date(k.Expires, "never"), status)
+    }
+    w.Flush()
+    if !healthy {
+        fmt.Printf("⚠ rotate the keys marked above before commits become unverifiable (window: %d days)\n", window)
+    }
+    return healthy
+}
+
+// commandStats runs the `stats` subcommands.
+func commandStats(cfg Config, args []string) error {
+    usage := errors.New("usage: gist stats keys [--within <days>] [--strict] | stats usage [--json] [--reset]")
+    if len(args) > 0 && args[0] == "usage" {
+        asJSON, reset := false, false
+        for _, a := range args[1:] {
+            switch a {
+            case "--json":
+                asJSON = true
+            case "--reset":
+                reset = true
+            default:
+                return usage
+            }
+        }
+        return commandStatsUsage(cfg, asJSON, reset)
+    }
+    if len(args) == 0 || args[0] != "keys" {
+        return usage
+    }
+    window, strict := defaultExpiryWindow, false
+    for i := 1; i < len(args); i++ {
+        switch args[i] {
+        case "--strict":
+            strict = true
+        case "--within":
+            if i+1 >= len(args) {
+                return errors.New("--within requires a number of days")
+            }
+            n, err := strconv.Atoi(args[i+1])
+            if err != nil || n < 0 {
+                return fmt.Errorf("invalid number of days %q", args[i+1])
+            }
+            window = n
+            i++
+        default:
+            return fmt.Errorf("unknown option %s", args[i])
+        }
+    }
+    if !commandStatsKeys(cfg, window) && strict {
+        return errors.New("signing keys need attention")
+    }
+    return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// defaultCommitTemplate seeds new template files gist creates.
+const defaultCommitTemplate = `
+
+# Commit message template for gist profile %s.
+# Lines starting with '#' are ignored by git.
+Signed-off-by: %s <%s>
+`
+
+// templatePath returns where gist keeps the commit template it owns for a
+// profile.
+func templatePath(configPath, profile string) string {
+    return filepath.Join(filepath.Dir(configPath), "templates", profile+".txt")
+}
+
+// editorCommand returns the user's preferred editor.
+func editorCommand() string {
+    for _, env := range []string{"VISUAL", "EDITOR"} {
+        if e := os.Getenv(env); e != "" {
+            return e
+        }
+    }
+    return "vi"
+}
+
+// commandTemplateEdit opens the profile's commit template in an editor,
+// creating a gist-owned template first if the profile has none. It reports
+// whether the profile was changed and the config needs saving.
+func commandTemplateEdit(cfg *Config, configPath, profileName string, force bool) (bool, error) {
+    p := findProfile(cfg, profileName)
+    if p == nil {
+        return false, fmt.Errorf("profile %s not found", profileName)
+    }
+    if err := checkUnlocked(p, force); err != nil {
+        return false, err
+    }
+    changed := false
+    if p.CommitTemplate == "" {
+        p.CommitTemplate = templatePath(configPath, p.Name)
+        changed = true
+    }
+    path := profilePath(p, p.CommitTemplate)
+    if _, err := os.Stat(path); os.IsNotExist(err) {
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+            return false, err
+        }
+        body := fmt.Sprintf(defaultCommitTemplate, p.Name, p.Username, p.Email)
+        if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+            return false, err
+        }
+    }
+    if err := runEditor(path); err != nil {
+        return changed, err
+    }
+    if changed {
+        fmt.Printf("Profile %s now uses commit template %s.\n", p.Name, p.CommitTemplate)
+    }
+    return changed, nil
+}
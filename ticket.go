@@ -0,0 +1,76 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// ticketHook prefixes commit messages from a prepare-commit-msg hook.
+const ticketHook = `#!/bin/sh
+# Installed by gist: prefix commit messages with the branch's ticket id.
+exec %s hook prepare-commit-msg "$@"
+`
+
+// syncTicketHook installs the prepare-commit-msg hook for profiles with a
+// ticket pattern and removes gist's hook for profiles without one.
+func syncTicketHook(p *Profile) error {
+    hooks, err := hooksDir()
+    if err != nil {
+        return err
+    }
+    if p.TicketPattern != "" {
+        if _, err := regexp.Compile(p.TicketPattern); err != nil {
+            return fmt.Errorf("invalid ticket_pattern: %w", err)
+        }
+        return writeHook(hooks, "prepare-commit-msg", fmt.Sprintf(ticketHook, gistExecutable()))
+    }
+    return removeHook(hooks, "prepare-commit-msg", "# Installed by gist: prefix")
+}
+
+// prefixTicket prepends the ticket id found in the branch name to the commit
+// message in path. Merges, squashes and amends are left alone, as are
+// messages already mentioning the ticket.
+func prefixTicket(cfg Config, path, source string) error {
+    if source != "" && source != "message" && source != "template" {
+        return nil
+    }
+    p, err := activeProfile(&cfg)
+    if err != nil || p.TicketPattern == "" {
+        return nil
+    }
+    re, err := regexp.Compile(p.TicketPattern)
+    if err != nil {
+        return fmt.Errorf("invalid ticket_pattern: %w", err)
+    }
+    branch, err := runGit("symbolic-ref", "--short", "HEAD")
+    if err != nil {
+        // Detached HEAD: no branch to take a ticket from.
+        return nil
+    }
+    ticket := re.FindString(branch)
+    if ticket == "" {
+        return nil
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    if strings.Contains(string(data), ticket) {
+        return nil
+    }
+    return os.WriteFile(path, []byte(ticket+": "+string(data)), 0o644)
+}
+
+// commandHook runs the logic behind hooks gist installs.
+func commandHook(cfg Config, args []string) error {
+    if len(args) >= 2 && args[0] == "prepare-commit-msg" {
+        source := ""
+        if len(args) > 2 {
+            source = args[2]
+        }
+        return prefixTicket(cfg, args[1], source)
+    }
+    return fmt.Errorf("unknown hook invocation: %s", strings.Join(args, " "))
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// tidyKeys are the identity keys tidy considers for removal.
+var tidyKeys = []string{"user.name", "user.email", "user.signingkey"}
+
+// inheritedValue returns the value key would have if the repository's own
+// config did not set it, i.e. the last value from a non-local scope
+// (global, system or anything they include).
+func inheritedValue(key string) (string, bool) {
+    out, err := runGit(append(append([]string{"config"}, configOriginFlags()...), "--get-all", key)...)
+    if err != nil || out == "" {
+        return "", false
+    }
+    value, found := "", false
+    for _, line := range strings.Split(out, "\n") {
+        scope, _, v := parseConfigOrigin(line)
+        if scope == "local" || scope == "worktree" {
+            continue
+        }
+        value, found = v, true
+    }
+    return value, found
+}
+
+// redundantKeys returns the local identity keys whose values merely repeat
+// what the repository would inherit anyway.
+func redundantKeys() []string {
+    var keys []string
+    for _, key := range tidyKeys {
+        local, err := runGit("config", "--local", "--no-includes", "--get", key)
+        if err != nil {
+            continue
+        }
+        if inherited, ok := inheritedValue(key); ok && inherited == local {
+            keys = append(keys, key)
+        }
+    }
+    return keys
+}
+
+// confirm asks a yes/no question on stdin; anything but "y" or "yes" is no,
+// and under a bot profile the answer is always no.
+func confirm(question string) bool {
+    fmt.Printf("%s [y/N] ", question)
+    if nonInteractive() {
+        fmt.Println("n (no prompts under a bot profile)")
+        return false
+    }
+    answer, _ := stdin.ReadString('\n')
+    answer = strings.ToLower(strings.TrimSpace(answer))
+    return answer == "y" || answer == "yes"
+}
+
+// tidyRepo removes redundant local identity entries from the current
+// repository, asking first unless assumeYes is set.
+func tidyRepo(assumeYes bool) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    keys := redundantKeys()
+    if len(keys) == 0 {
+        fmt.Printf("%s: nothing to tidy\n", repoRoot)
+        return nil
+    }
+    fmt.Printf("%s: local %s duplicate inherited config\n", repoRoot, strings.Join(keys, ", "))
+    if !assumeYes && !confirm("Remove the local entries?") {
+        return nil
+    }
+    for _, key := range keys {
+        if _, err := runGit("config", "--local", "--unset", key); err != nil {
+            return fmt.Errorf("failed to unset %s: %w", key, err)
+        }
+    }
+    fmt.Printf("✔️  Removed %d redundant entries from %s\n", len(keys), repoRoot)
+    return nil
+}
+
+// commandTidy tidies each of the given repositories, or the current one when
+// none are given.
+func commandTidy(args []string) error {
+    assumeYes := false
+    var repos []string
+    for _, a := range args {
+        if a == "--yes" || a == "-y" {
+            assumeYes = true
+            continue
+        }
+        repos = append(repos, a)
+    }
+    if len(repos) == 0 {
+        return tidyRepo(assumeYes)
+    }
+    failed := false
+    for _, dir := range repos {
+        repoDir = expandHome(dir)
+        if err := tidyRepo(assumeYes); err != nil {
+            fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+            failed = true
+        }
+    }
+    if failed {
+        return errors.New("some repositories could not be tidied")
+    }
+    return nil
+}
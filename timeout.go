@@ -0,0 +1,114 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/exec"
+    "os/signal"
+    "strings"
+    "time"
+)
+
+// defaultGitTimeout bounds every git command gist runs unless the config's
+// timeouts section or GIST_GIT_TIMEOUT says otherwise, so a hung credential
+// prompt or network command can't freeze gist.
+const defaultGitTimeout = time.Minute
+
+// gitTimeouts holds the configured timeouts by git subcommand, with
+// "default" for the rest; see setGitTimeouts.
+var gitTimeouts = map[string]time.Duration{}
+
+// parseTimeout parses a duration such as "30s" or "5m"; 0 means no timeout.
+func parseTimeout(value string) (time.Duration, error) {
+    d, err := time.ParseDuration(value)
+    if err != nil || d < 0 {
+        return 0, fmt.Errorf("%q is not a duration like 30s or 5m", value)
+    }
+    return d, nil
+}
+
+// parseTimeouts parses the timeouts section: git subcommands (or default)
+// mapped to timeouts.
+func parseTimeouts(raw map[string]string) (map[string]time.Duration, error) {
+    timeouts := map[string]time.Duration{}
+    for _, name := range sortedKeys(raw) {
+        d, err := parseTimeout(raw[name])
+        if err != nil {
+            return nil, fmt.Errorf("timeouts: %s: %w", name, err)
+        }
+        timeouts[name] = d
+    }
+    return timeouts, nil
+}
+
+// setGitTimeouts applies the config's timeouts section. Invalid entries
+// are skipped, leaving them to list --check.
+func setGitTimeouts(raw map[string]string) {
+    for name, value := range raw {
+        if d, err := parseTimeout(value); err == nil {
+            gitTimeouts[name] = d
+        }
+    }
+}
+
+// gitSubcommand returns the git subcommand in args, skipping the global
+// options gist passes before it (-C <dir>, -c <key=value>).
+func gitSubcommand(args []string) string {
+    for i := 0; i < len(args); i++ {
+        switch {
+        case args[i] == "-C" || args[i] == "-c":
+            i++
+        case !strings.HasPrefix(args[i], "-"):
+            return args[i]
+        }
+    }
+    return ""
+}
+
+// gitTimeout returns how long a git command may run: its subcommand's
+// timeout, else GIST_GIT_TIMEOUT, else the configured default, else
+// defaultGitTimeout. Zero means no limit.
+func gitTimeout(args []string) time.Duration {
+    if d, ok := gitTimeouts[gitSubcommand(args)]; ok {
+        return d
+    }
+    if env := os.Getenv("GIST_GIT_TIMEOUT"); env != "" {
+        if d, err := parseTimeout(env); err == nil {
+            return d
+        }
+    }
+    if d, ok := gitTimeouts["default"]; ok {
+        return d
+    }
+    return defaultGitTimeout
+}
+
+// gitCommand returns a git command bounded by its timeout and stopped by
+// Ctrl-C. Call the returned function with the command's error once it has
+// finished: it exits gist when the user interrupted the command, and
+// returns the error to report instead when it timed out.
+func gitCommand(args []string) (*exec.Cmd, func(err error) error) {
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    cancel := context.CancelFunc(func() {})
+    timeout := gitTimeout(args)
+    if timeout > 0 {
+        ctx, cancel = context.WithTimeout(ctx, timeout)
+    }
+    cmd := exec.CommandContext(ctx, getGitPath(), args...)
+    // Don't wait forever for helpers git started that keep its output open.
+    cmd.WaitDelay = 2 * time.Second
+    return cmd, func(err error) error {
+        defer stop()
+        defer cancel()
+        switch {
+        case err == nil:
+        case ctx.Err() == context.DeadlineExceeded:
+            return fmt.Errorf("git %s timed out after %s", gitSubcommand(args), timeout)
+        case ctx.Err() != nil:
+            fmt.Fprintln(os.Stderr, "gist: interrupted")
+            os.Exit(130)
+        }
+        return nil
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+// trashRetention is how long removed profiles are kept before being purged.
+const trashRetention = 30 * 24 * time.Hour
+
+// trashDateFormat is how removal dates are stored in the config.
+const trashDateFormat = "2006-01-02"
+
+// TrashedProfile is a removed profile kept for later restoration.
+type TrashedProfile struct {
+    Profile `yaml:",inline"`
+    Removed string `yaml:"removed"`
+}
+
+// loadTrashKey applies a single trash key and returns the entry subsequent
+// keys belong to.
+func loadTrashKey(cfg *Config, current *TrashedProfile, key, value string) *TrashedProfile {
+    switch key {
+    case "name":
+        cfg.Trash = append(cfg.Trash, TrashedProfile{Profile: Profile{Name: value}})
+        return &cfg.Trash[len(cfg.Trash)-1]
+    case "removed":
+        if current != nil {
+            current.Removed = value
+        }
+    default:
+        if current != nil {
+            setProfileField(&current.Profile, key, value)
+        }
+    }
+    return current
+}
+
+// writeTrash serializes the trash section.
+func writeTrash(sb *strings.Builder, trash []TrashedProfile) {
+    if len(trash) == 0 {
+        return
+    }
+    sb.WriteString("trash:\n")
+    for _, t := range trash {
+        writeProfile(sb, t.Profile)
+        sb.WriteString("    removed: \"" + t.Removed + "\"\n")
+    }
+}
+
+// purgeTrash drops entries removed more than trashRetention before now.
+// Entries with an unreadable date are kept.
+func purgeTrash(trash []TrashedProfile, now time.Time) []TrashedProfile {
+    var kept []TrashedProfile
+    for _, t := range trash {
+        removed, err := time.ParseInLocation(trashDateFormat, t.Removed, time.Local)
+        if err == nil && now.Sub(removed) > trashRetention {
+            continue
+        }
+        kept = append(kept, t)
+    }
+    return kept
+}
+
+// trashProfile moves p into the trash, replacing an older trashed profile
+// of the same name.
+func trashProfile(cfg *Config, p Profile, now time.Time) {
+    for i, t := range cfg.Trash {
+        if t.Name == p.Name {
+            cfg.Trash = append(cfg.Trash[:i], cfg.Trash[i+1:]...)
+            break
+        }
+    }
+    cfg.Trash = append(cfg.Trash, TrashedProfile{Profile: p, Removed: now.Format(trashDateFormat)})
+}
+
+// commandRestore moves a profile from the trash back into the config.
+func commandRestore(cfg *Config, name string) error {
+    for i, t := range cfg.Trash {
+        if t.Name != name {
+            continue
+        }
+        if findProfile(cfg, name) != nil {
+            return fmt.Errorf("profile %s already exists", name)
+        }
+        cfg.Profiles = append(cfg.Profiles, t.Profile)
+        cfg.Trash = append(cfg.Trash[:i], cfg.Trash[i+1:]...)
+        fmt.Printf("Profile %s restored.\n", name)
+        return nil
+    }
+    return fmt.Errorf("profile %s is not in the trash", name)
+}
+
+// commandTrash lists removed profiles.
+func commandTrash(cfg Config) {
+    if len(cfg.Trash) == 0 {
+        fmt.Println("trash is empty")
+        return
+    }
+    fmt.Println("removed profiles (purged after 30 days):")
+    for _, t := range cfg.Trash {
+        fmt.Printf("  • %s\t(%s)\tremoved %s\n", t.Name, t.Email, t.Removed)
+    }
+}
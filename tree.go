@@ -0,0 +1,123 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// treeNode is a line of `list --tree` and the lines nested below it.
+type treeNode struct {
+    label    string
+    children []*treeNode
+}
+
+// child returns the child with the label, adding it if missing.
+func (n *treeNode) child(label string) *treeNode {
+    for _, c := range n.children {
+        if c.label == label {
+            return c
+        }
+    }
+    c := &treeNode{label: label}
+    n.children = append(n.children, c)
+    return c
+}
+
+// print writes the node's children with box-drawing branches.
+func (n *treeNode) print(prefix string) {
+    for i, c := range n.children {
+        branch, indent := "├── ", "│   "
+        if i == len(n.children)-1 {
+            branch, indent = "└── ", "    "
+        }
+        fmt.Println(prefix + branch + c.label)
+        c.print(prefix + indent)
+    }
+}
+
+// ruleLeaf labels a rule under its group: the profile, then the rule's
+// number and the conditions the group doesn't already show.
+func ruleLeaf(i int, r Rule, shown string) string {
+    var extra []string
+    for _, part := range strings.Split(r.describe(), ", ") {
+        if part != "" && part != shown {
+            extra = append(extra, part)
+        }
+    }
+    if r.Priority != 0 {
+        extra = append(extra, "priority "+strconv.Itoa(r.Priority))
+    }
+    note := "rule " + strconv.Itoa(i+1)
+    if len(extra) > 0 {
+        note += ": " + strings.Join(extra, ", ")
+    }
+    if r.Source != "" {
+        note += " [" + r.Source + "]"
+    }
+    return fmt.Sprintf("%s  (%s)", r.Profile, note)
+}
+
+// commandListTree shows which profiles apply where: directory rules, then
+// rules and hosts map entries by remote host, then the remaining rules, the
+// default profile and the profiles nothing selects.
+func commandListTree(cfg Config) {
+    used := map[string]bool{cfg.DefaultProfile: true}
+    dirs, hosts, other := &treeNode{}, &treeNode{}, &treeNode{}
+    for i, r := range cfg.Rules {
+        used[r.Profile] = true
+        switch {
+        case r.Dir != "":
+            dirs.child(r.Dir).children = append(dirs.child(r.Dir).children, &treeNode{label: ruleLeaf(i, r, "dir "+r.Dir)})
+        case r.URL != "":
+            host := hosts.child(remoteHost(r.URL))
+            host.children = append(host.children, &treeNode{label: ruleLeaf(i, r, "")})
+        default:
+            other.children = append(other.children, &treeNode{label: ruleLeaf(i, r, "")})
+        }
+    }
+    for _, key := range sortedKeys(cfg.Hosts) {
+        used[cfg.Hosts[key]] = true
+        host, _, _ := strings.Cut(key, "/")
+        note := "hosts map"
+        if key != host {
+            note += ": " + key
+        }
+        node := hosts.child(host)
+        node.children = append(node.children, &treeNode{label: fmt.Sprintf("%s  (%s)", cfg.Hosts[key], note)})
+    }
+    sort.SliceStable(hosts.children, func(i, j int) bool { return hosts.children[i].label < hosts.children[j].label })
+    def := &treeNode{}
+    if cfg.DefaultProfile != "" {
+        def.child(cfg.DefaultProfile)
+    }
+    unused := &treeNode{}
+    for _, p := range cfg.Profiles {
+        if !used[p.Name] {
+            unused.child(p.Name)
+        }
+    }
+    groups := []struct {
+        title string
+        node  *treeNode
+    }{
+        {"directories", dirs},
+        {"remote hosts", hosts},
+        {"other rules", other},
+        {"default", def},
+        {"profiles nothing selects", unused},
+    }
+    printed := false
+    for _, g := range groups {
+        if len(g.node.children) == 0 {
+            continue
+        }
+        fmt.Println(g.title)
+        g.node.print("")
+        printed = true
+    }
+    if !printed {
+        fmt.Println("no profiles, rules or hosts configured")
+    }
+}
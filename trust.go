@@ -0,0 +1,232 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// RosterEntry maps a teammate's emails to the key they sign commits with:
+// an SSH public key ("type base64") or a GPG fingerprint.
+type RosterEntry struct {
+    Emails []string
+    Key    string
+    GPG    bool
+}
+
+// gpgFingerprint matches a GPG key ID or fingerprint.
+var gpgFingerprint = regexp.MustCompile(`^(0x)?[0-9A-Fa-f]{16,40}$`)
+
+// rosterPath returns where the synced team roster is stored.
+func rosterPath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "roster")
+}
+
+// parseRoster reads a team roster: one "email[,email...] key" line per key,
+// where key is an SSH public key or a GPG fingerprint. allowed_signers files
+// are rosters too; their options are ignored.
+func parseRoster(data []byte) ([]RosterEntry, string, error) {
+    var entries []RosterEntry
+    source := ""
+    for i, line := range strings.Split(string(data), "\n") {
+        trimmed := strings.TrimSpace(line)
+        if value, ok := strings.CutPrefix(trimmed, "# source: "); ok {
+            source = value
+            continue
+        }
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+            continue
+        }
+        fields := strings.Fields(trimmed)
+        e := RosterEntry{Emails: strings.Split(strings.ToLower(fields[0]), ",")}
+        for j := 1; j < len(fields); j++ {
+            f := fields[j]
+            if strings.HasPrefix(f, "ssh-") || strings.HasPrefix(f, "ecdsa-") || strings.HasPrefix(f, "sk-") {
+                if j+1 < len(fields) {
+                    e.Key = f + " " + fields[j+1]
+                }
+                break
+            }
+        }
+        if e.Key == "" && len(fields) == 2 && gpgFingerprint.MatchString(fields[1]) {
+            e.Key, e.GPG = strings.ToUpper(strings.TrimPrefix(fields[1], "0x")), true
+        }
+        if e.Key == "" {
+            return nil, "", fmt.Errorf("line %d: expected an SSH public key or a GPG fingerprint", i+1)
+        }
+        entries = append(entries, e)
+    }
+    return entries, source, nil
+}
+
+// loadRoster reads the synced roster; a missing roster is empty.
+func loadRoster() ([]RosterEntry, string, error) {
+    data, err := os.ReadFile(rosterPath())
+    if err != nil {
+        if os.IsNotExist(err) {
+            return nil, "", nil
+        }
+        return nil, "", err
+    }
+    entries, source, err := parseRoster(data)
+    if err != nil {
+        return nil, "", fmt.Errorf("invalid roster %s: %w", rosterPath(), err)
+    }
+    return entries, source, nil
+}
+
+// principalMatches reports whether an email matches an allowed_signers
+// principal, which may be a pattern such as *@acme.com.
+func principalMatches(principal, email string) bool {
+    ok, err := path.Match(strings.ToLower(principal), strings.ToLower(email))
+    return err == nil && ok
+}
+
+// commandTrustSync fetches the team roster and stores it, then refreshes the
+// allowed_signers file so the roster's SSH keys verify in git log too.
+func commandTrustSync(cfg Config, from string) error {
+    if from == "" {
+        _, source, err := loadRoster()
+        if err != nil {
+            return err
+        }
+        if source == "" {
+            return errors.New("no roster synced yet; pass --from <url>")
+        }
+        from = source
+    }
+    data, err := fetchPolicy(from)
+    if err != nil {
+        return err
+    }
+    entries, _, err := parseRoster(data)
+    if err != nil {
+        return fmt.Errorf("invalid roster %s: %w", from, err)
+    }
+    var sb strings.Builder
+    sb.WriteString("# Synced by gist; edits are lost on the next `gist trust sync`.\n")
+    sb.WriteString("# source: " + from + "\n")
+    for _, e := range entries {
+        sb.WriteString(strings.Join(e.Emails, ",") + " " + e.Key + "\n")
+    }
+    if err := os.MkdirAll(filepath.Dir(rosterPath()), 0o755); err != nil {
+        return err
+    }
+    if err := os.WriteFile(rosterPath(), []byte(sb.String()), 0o644); err != nil {
+        return err
+    }
+    fmt.Printf("✔️  Synced %d key(s) from %s\n", len(entries), from)
+    return syncAllowedSigners(cfg)
+}
+
+// commandTrust runs the `trust` subcommands.
+func commandTrust(cfg Config, args []string) error {
+    usage := errors.New("usage: gist trust sync [--from <url>]")
+    if len(args) == 0 || args[0] != "sync" {
+        return usage
+    }
+    from := ""
+    for i := 1; i < len(args); i++ {
+        switch args[i] {
+        case "--from":
+            if i+1 >= len(args) {
+                return errors.New("--from requires a URL or file")
+            }
+            from = args[i+1]
+            i++
+        default:
+            return usage
+        }
+    }
+    return commandTrustSync(cfg, from)
+}
+
+// commandVerifySignatures checks that every commit in revs is signed by a
+// key the roster lists for its author email.
+func commandVerifySignatures(revs string) error {
+    if inRepo, _ := isGitRepo(); !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    roster, _, err := loadRoster()
+    if err != nil {
+        return err
+    }
+    if len(roster) == 0 {
+        return errors.New("no team roster; run `gist trust sync --from <url>` first")
+    }
+    // git checks SSH signatures against an allowed_signers file made of the
+    // roster alone, so keys trusted only locally don't count.
+    signers, err := os.CreateTemp("", "gist-roster-*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(signers.Name())
+    for _, e := range roster {
+        if !e.GPG {
+            fmt.Fprintf(signers, "%s namespaces=\"git\" %s\n", strings.Join(e.Emails, ","), e.Key)
+        }
+    }
+    signers.Close()
+    out, err := runGit("-c", "gpg.ssh.allowedSignersFile="+signers.Name(), "log",
+        "--format=%h%x00%ae%x00%G?%x00%GS%x00%GF%x00%GP%x1e", revs)
+    if err != nil {
+        return fmt.Errorf("cannot read commits %s: %s", revs, out)
+    }
+    total, bad := 0, 0
+    for _, record := range strings.Split(out, "\x1e") {
+        fields := strings.Split(strings.TrimSpace(record), "\x00")
+        if len(fields) != 6 {
+            continue
+        }
+        total++
+        hash, author, status, signer := fields[0], fields[1], fields[2], fields[3]
+        problem := ""
+        switch status {
+        case "N":
+            problem = "not signed"
+        case "B":
+            problem = "bad signature"
+        case "R":
+            problem = "signed with a revoked key"
+        case "E":
+            problem = "cannot check the signature (is the key in your keyring?)"
+        default:
+            if strings.HasPrefix(fields[4], "SHA256:") {
+                if signer == "" || !principalMatches(signer, author) {
+                    problem = "signed by an SSH key the roster doesn't list for " + author
+                }
+                break
+            }
+            listed := false
+            for _, e := range roster {
+                if !e.GPG {
+                    continue
+                }
+                for _, email := range e.Emails {
+                    if principalMatches(email, author) &&
+                        (strings.HasSuffix(fields[4], e.Key) || (fields[5] != "" && strings.HasSuffix(fields[5], e.Key))) {
+                        listed = true
+                    }
+                }
+            }
+            if !listed {
+                problem = "signed by GPG key " + fields[4] + ", which the roster doesn't list for " + author
+            }
+        }
+        if problem == "" {
+            fmt.Printf("  ✔ %s %s\n", hash, author)
+            continue
+        }
+        bad++
+        fmt.Printf("  ✘ %s %s: %s\n", hash, author, problem)
+    }
+    if bad > 0 {
+        return fmt.Errorf("%d of %d commit(s) not signed by a roster key of their author", bad, total)
+    }
+    fmt.Printf("All %d commit(s) signed by their authors' roster keys.\n", total)
+    return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "text/tabwriter"
+    "time"
+)
+
+// With usage_stats: true in the config gist counts how often each command
+// runs in a file next to the config, for `gist stats usage`. Only command
+// names are counted – never arguments, profiles, paths or identities – and
+// the file never leaves the machine: gist sends nothing anywhere.
+
+// usageStats is the usage file.
+type usageStats struct {
+    Since    time.Time      `json:"since"`
+    Updated  time.Time      `json:"updated"`
+    Commands map[string]int `json:"commands"`
+}
+
+// usagePath returns the usage file next to the config.
+func usagePath() string {
+    return filepath.Join(filepath.Dir(getConfigPath()), "usage.json")
+}
+
+func loadUsage() (usageStats, error) {
+    var u usageStats
+    data, err := os.ReadFile(usagePath())
+    if err != nil {
+        return u, err
+    }
+    if err := json.Unmarshal(data, &u); err != nil {
+        return u, fmt.Errorf("%s: %w", usagePath(), err)
+    }
+    return u, nil
+}
+
+// usageCommand returns what to count for a command line: the command and,
+// for commands with subcommands, the subcommand – or "" for anything that
+// isn't a known command, so nothing the user typed is recorded verbatim.
+func usageCommand(args []string) string {
+    if len(args) == 0 {
+        return ""
+    }
+    known := false
+    for _, name := range commandNames {
+        if name == args[0] {
+            known = true
+            break
+        }
+    }
+    if !known {
+        return ""
+    }
+    if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+        for _, sub := range subcommandNames[args[0]] {
+            if sub == args[1] {
+                return args[0] + " " + sub
+            }
+        }
+    }
+    return args[0]
+}
+
+// recordUsage counts the command. Failures are ignored: counting must never
+// get in the way of the command itself.
+func recordUsage(args []string) {
+    command := usageCommand(args)
+    if command == "" {
+        return
+    }
+    u, err := loadUsage()
+    if err != nil && !os.IsNotExist(err) {
+        return
+    }
+    now := time.Now().UTC()
+    if u.Commands == nil {
+        u.Commands = map[string]int{}
+        u.Since = now
+    }
+    u.Commands[command]++
+    u.Updated = now
+    data, err := json.MarshalIndent(u, "", "  ")
+    if err != nil {
+        return
+    }
+    writeFileAtomic(usagePath(), append(data, '\n'))
+}
+
+// commandStatsUsage prints the counts, most used first, as text or JSON;
+// reset deletes them.
+func commandStatsUsage(cfg Config, asJSON, reset bool) error {
+    if reset {
+        if err := os.Remove(usagePath()); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        fmt.Println("✔ usage statistics deleted")
+        return nil
+    }
+    u, err := loadUsage()
+    if os.IsNotExist(err) {
+        if !cfg.UsageStats {
+            fmt.Println("Usage statistics are off. Add `usage_stats: true` to the config to count the commands you run (locally; nothing is sent anywhere).")
+        } else {
+            fmt.Println("No commands counted yet.")
+        }
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if asJSON {
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        return enc.Encode(u)
+    }
+    commands := make([]string, 0, len(u.Commands))
+    total := 0
+    for c, n := range u.Commands {
+        commands = append(commands, c)
+        total += n
+    }
+    sort.Slice(commands, func(i, j int) bool {
+        if u.Commands[commands[i]] != u.Commands[commands[j]] {
+            return u.Commands[commands[i]] > u.Commands[commands[j]]
+        }
+        return commands[i] < commands[j]
+    })
+    fmt.Printf("%d command(s) since %s\n", total, u.Since.Local().Format("2006-01-02"))
+    w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    for _, c := range commands {
+        fmt.Fprintf(w, "  %s\t%d\t%d%%\n", c, u.Commands[c], u.Commands[c]*100/total)
+    }
+    w.Flush()
+    if !cfg.UsageStats {
+        fmt.Println("(counting is off; usage_stats is not set in the config)")
+    }
+    return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// signOffTrailer is the DCO trailer an identity signs commits off with.
+func signOffTrailer(p *Profile) string {
+    return fmt.Sprintf("%s <%s>", gitName(p), p.Email)
+}
+
+// activeProfile returns the profile matching the identity git uses in the
+// current repository.
+func activeProfile(cfg *Config) (*Profile, error) {
+    name, _ := runGit("config", "user.name")
+    email, _ := runGit("config", "user.email")
+    p := matchProfile(cfg, name, email)
+    if p == nil {
+        return nil, fmt.Errorf("identity %s <%s> does not match any profile", name, email)
+    }
+    return p, nil
+}
+
+// ownsEmail reports whether email is the profile's email or one of its
+// also_emails, ignoring case.
+func ownsEmail(p *Profile, email string) bool {
+    for _, e := range append([]string{p.Email}, p.AlsoEmails...) {
+        if e != "" && strings.EqualFold(e, email) {
+            return true
+        }
+    }
+    return false
+}
+
+// ownsIdentity reports whether a "Name <email>" identity is the profile's,
+// under its email or one of its also_emails, in any normalization form.
+func ownsIdentity(p *Profile, ident string) bool {
+    i := strings.LastIndex(ident, " <")
+    if i < 0 || !strings.HasSuffix(ident, ">") {
+        return false
+    }
+    name := nfc(ident[:i])
+    return (name == nfc(p.Username) || name == gitName(p)) && ownsEmail(p, ident[i+2:len(ident)-1])
+}
+
+// hasSignOff reports whether the trailer values include the profile's
+// sign-off.
+func hasSignOff(values []string, p *Profile) bool {
+    for _, v := range values {
+        if ownsIdentity(p, strings.TrimSpace(v)) {
+            return true
+        }
+    }
+    return false
+}
+
+// verifyMessageFile checks a commit message file for the sign-off the
+// active profile requires; used by the commit-msg hook.
+func verifyMessageFile(cfg Config, path string) error {
+    p, err := activeProfile(&cfg)
+    if err != nil || !p.RequireSignOff {
+        // Nothing to enforce without a profile that asks for it.
+        return nil
+    }
+    out, err := runGit("interpret-trailers", "--parse", path)
+    if err != nil {
+        return fmt.Errorf("cannot read commit message: %s", out)
+    }
+    var values []string
+    for _, line := range strings.Split(out, "\n") {
+        if key, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(key, "Signed-off-by") {
+            values = append(values, value)
+        }
+    }
+    if !hasSignOff(values, p) {
+        return fmt.Errorf("profile %s requires \"Signed-off-by: %s\" (use git commit -s)", p.Name, signOffTrailer(p))
+    }
+    return nil
+}
+
+// identityProblems checks the active profile p of the repository at
+// repoRoot: it should be the one the rules, hosts map or default select, and
+// satisfy the policy.
+func identityProblems(cfg Config, p *Profile, repoRoot string) []string {
+    var problems []string
+    if want, err := resolveAutoProfile(cfg, false); err == nil && want != p.Name {
+        if pinnedProfile(repoRoot) == want {
+            problems = append(problems, fmt.Sprintf("identity is profile %s but this repository is pinned to %s", p.Name, want))
+        } else {
+            problems = append(problems, fmt.Sprintf("identity is profile %s but this repository should use %s", p.Name, want))
+        }
+    }
+    // Another normalization form of the name makes a second shortlog entry.
+    if name, _ := runGit("config", "user.name"); name != gitName(p) {
+        problems = append(problems, fmt.Sprintf("user.name %q is not written as profile %s writes it (%q); run `gist set %s`", name, p.Name, gitName(p), p.Name))
+    }
+    return append(problems, policyViolations(cfg.Policy, p, listRemotes())...)
+}
+
+// verifyQuick checks only the identity, not commits, so it is cheap enough
+// to run before every commit and push: what the git shim runs. Outside a
+// repository, or with an unknown identity nothing selects a profile for,
+// there is nothing to check.
+func verifyQuick(cfg Config) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return nil
+    }
+    p, err := activeProfile(&cfg)
+    if err != nil {
+        if want, rerr := resolveAutoProfile(cfg, false); rerr == nil {
+            return fmt.Errorf("%v; run `gist set %s`", err, want)
+        }
+        return nil
+    }
+    problems := identityProblems(cfg, p, repoRoot)
+    if len(problems) == 0 {
+        return nil
+    }
+    notifyEvent(cfg, eventOnVerifyFail, p, repoRoot)
+    for _, problem := range problems {
+        fmt.Fprintf(os.Stderr, "  ✘ %s\n", problem)
+    }
+    return fmt.Errorf("%d problem(s) found for profile %s", len(problems), p.Name)
+}
+
+// commandVerify checks every commit in revs, by default the last one: its
+// author must be the active identity and, when the profile requires it, it
+// must carry a matching Signed-off-by trailer.
+func commandVerify(cfg Config, revs string) error {
+    inRepo, repoRoot := isGitRepo()
+    if !inRepo {
+        return errors.New("not inside a git repository")
+    }
+    if revs == "" {
+        // HEAD^! names nothing on a root commit, where HEAD is the one commit.
+        revs = "HEAD^!"
+        if _, err := runGit("rev-parse", "--verify", "-q", "HEAD^"); err != nil {
+            revs = "HEAD"
+        }
+    }
+    p, err := activeProfile(&cfg)
+    if err != nil {
+        notifyEvent(cfg, eventOnVerifyFail, nil, repoRoot)
+        return err
+    }
+    bad := 0
+    for _, problem := range identityProblems(cfg, p, repoRoot) {
+        fmt.Printf("  ✘ %s\n", problem)
+        bad++
+    }
+    out, err := runGit("log", "--format=%h%x00%an <%ae>%x00%(trailers:key=Signed-off-by,valueonly,separator=%x1f)%x00%G?%x00%GS%x1e", revs)
+    if err != nil {
+        return fmt.Errorf("cannot read commits %s: %s", revs, out)
+    }
+    for _, record := range strings.Split(out, "\x1e") {
+        fields := strings.Split(strings.TrimSpace(record), "\x00")
+        if len(fields) != 5 {
+            continue
+        }
+        var problems []string
+        if !ownsIdentity(p, fields[1]) {
+            problems = append(problems, "authored by "+fields[1])
+        }
+        if p.RequireSignOff && !hasSignOff(strings.Split(fields[2], "\x1f"), p) {
+            problems = append(problems, "missing Signed-off-by: "+signOffTrailer(p))
+        }
+        if p.SigningFormat == formatGitsign {
+            problems = append(problems, gitsignProblems(p, fields[3], fields[4])...)
+        }
+        if len(problems) == 0 {
+            fmt.Printf("  ✔ %s\n", fields[0])
+            continue
+        }
+        bad++
+        fmt.Printf("  ✘ %s %s\n", fields[0], strings.Join(problems, "; "))
+    }
+    if bad > 0 {
+        notifyEvent(cfg, eventOnVerifyFail, p, repoRoot)
+        return fmt.Errorf("%d problem(s) found for profile %s", bad, p.Name)
+    }
+    return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "runtime"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// defaultWatchInterval is how often watch re-checks the repositories.
+const defaultWatchInterval = 15 * time.Minute
+
+// driftReason describes what is wrong with a scanned repository's identity,
+// or "" when it matches its profile and the policy. Without a rule, host or
+// default to go by, the expected profile is the one gist last set there.
+func driftReason(res scanResult, set string) string {
+    var reasons []string
+    switch {
+    case res.Problem != "" && res.Want != "":
+        reasons = append(reasons, fmt.Sprintf("%s, should be %s", res.Problem, res.Want))
+    case res.Want == "" && set != "" && res.Current != set:
+        problem := "identity is profile " + res.Current
+        if res.Current == "" {
+            problem = "identity " + res.Email + " matches no profile"
+        }
+        reasons = append(reasons, fmt.Sprintf("%s, was set to %s", problem, set))
+    }
+    reasons = append(reasons, res.Violations...)
+    return strings.Join(reasons, "; ")
+}
+
+// notify shows a desktop notification with notify-send or, on macOS,
+// osascript.
+func notify(title, body string) error {
+    var cmd *exec.Cmd
+    switch runtime.GOOS {
+    case "darwin":
+        script := fmt.Sprintf("display notification %s with title %s", strconv.Quote(body), strconv.Quote(title))
+        cmd = exec.Command("osascript", "-e", script)
+    case "windows":
+        return errors.New("desktop notifications are not supported on Windows")
+    default:
+        cmd = exec.Command("notify-send", "--app-name=gist", title, body)
+    }
+    if out, err := cmd.CombinedOutput(); err != nil {
+        if msg := strings.TrimSpace(string(out)); msg != "" {
+            return fmt.Errorf("%s: %v: %s", cmd.Args[0], err, msg)
+        }
+        return fmt.Errorf("%s: %v", cmd.Args[0], err)
+    }
+    return nil
+}
+
+// watchRoots returns what watch checks: the given directories, or else every
+// repository gist has set a profile in or pinned.
+func watchRoots(st State, dirs []string) ([]string, error) {
+    if len(dirs) > 0 {
+        return absRoots(dirs)
+    }
+    var roots []string
+    for _, repo := range sortedKeys(st.Repos) {
+        roots = append(roots, repo)
+    }
+    for _, repo := range sortedKeys(st.Pins) {
+        if _, ok := st.Repos[repo]; !ok {
+            roots = append(roots, repo)
+        }
+    }
+    return roots, nil
+}
+
+// commandWatch re-checks the repositories every interval and reports each
+// one whose identity drifts from its profile or the policy, once when it
+// drifts and once when it is back in line. With notify it also sends a
+// desktop notification. The config is reloaded every round, so edits take
+// effect without a restart. With once it checks a single time and returns
+// an error if anything has drifted.
+func commandWatch(configPath string, args []string) error {
+    notifyDrift, once, interval, jobs := false, false, defaultWatchInterval, 0
+    var dirs []string
+    for i := 0; i < len(args); i++ {
+        switch args[i] {
+        case "--notify":
+            notifyDrift = true
+        case "--once":
+            once = true
+        case "--interval", "--jobs", "-j":
+            if i+1 >= len(args) {
+                return fmt.Errorf("%s requires a value", args[i])
+            }
+            if args[i] == "--interval" {
+                d, err := time.ParseDuration(args[i+1])
+                if err != nil || d <= 0 {
+                    return fmt.Errorf("invalid --interval %q (e.g. 30m)", args[i+1])
+                }
+                interval = d
+            } else {
+                jobs, _ = strconv.Atoi(args[i+1])
+            }
+            i++
+        default:
+            dirs = append(dirs, args[i])
+        }
+    }
+    drifted := map[string]string{}
+    for {
+        cfg, err := loadConfig(configPath)
+        if err != nil {
+            return err
+        }
+        st, err := loadState()
+        if err != nil {
+            return err
+        }
+        roots, err := watchRoots(st, dirs)
+        if err != nil {
+            return err
+        }
+        if len(roots) == 0 {
+            return errors.New("no repositories to watch: set a profile in some, or name directories")
+        }
+        err = scanParallel(cfg, roots, jobs, nil, func(dir string, res scanResult) {
+            reason := driftReason(res, st.Repos[res.Root])
+            if reason == drifted[dir] {
+                return
+            }
+            stamp := time.Now().Format("15:04:05")
+            if reason == "" {
+                fmt.Printf("%s ✔ %s is back in line\n", stamp, dir)
+                delete(drifted, dir)
+                return
+            }
+            fmt.Printf("%s ✘ %s: %s\n", stamp, dir, reason)
+            drifted[dir] = reason
+            if notifyDrift {
+                if err := notify("gist: identity drift", dir+": "+reason); err != nil {
+                    fmt.Fprintf(os.Stderr, "warning: cannot notify: %v\n", err)
+                }
+            }
+        })
+        if err != nil {
+            return err
+        }
+        if once {
+            if len(drifted) > 0 {
+                return fmt.Errorf("%d repositories have drifted", len(drifted))
+            }
+            fmt.Printf("%d repositories, none drifted.\n", len(roots))
+            return nil
+        }
+        time.Sleep(interval)
+    }
+}
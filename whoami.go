@@ -0,0 +1,244 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "os/exec"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// sshProbeTimeout bounds each `ssh -T` probe of whoami.
+const sshProbeTimeout = 15 * time.Second
+
+// sshGreetings match the account name in the greetings forges answer
+// `ssh -T` with: GitHub and Gitea ("Hi jdoe!", "Hi there, jdoe!"), GitLab
+// ("Welcome to GitLab, @jdoe!") and Bitbucket ("logged in as jdoe.").
+var sshGreetings = []*regexp.Regexp{
+    regexp.MustCompile(`Hi (?:there, )?([^!\s]+)!`),
+    regexp.MustCompile(`Welcome to GitLab, @([^!\s]+)!`),
+    regexp.MustCompile(`logged in as (\S+?)\.`),
+}
+
+// sshProbe is what an `ssh -T` probe of a host found out.
+type sshProbe struct {
+    // Key and Fingerprint are the key the host accepted, Account the
+    // account the host greeted it as.
+    Key         string
+    Fingerprint string
+    Account     string
+}
+
+// probeSSH runs `ssh -T` against user@host the way git would, with the
+// repository's core.sshCommand or GIT_SSH_COMMAND, and reads which key the
+// host accepted from ssh's verbose output.
+func probeSSH(user, host string) (sshProbe, error) {
+    command := "ssh"
+    if c, err := runGit("config", "core.sshCommand"); err == nil && c != "" {
+        command = c
+    } else if c := os.Getenv("GIT_SSH_COMMAND"); c != "" {
+        command = c
+    }
+    target := host
+    if h, port, ok := strings.Cut(host, ":"); ok {
+        target = h
+        command += " -p " + port
+    }
+    if user == "" {
+        user = "git"
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), sshProbeTimeout)
+    defer cancel()
+    // Like git, run the command through the shell: it may quote its arguments.
+    cmd := exec.CommandContext(ctx, "sh", "-c", command+` -v -T -o BatchMode=yes -o ConnectTimeout=10 "$1"`, "ssh", user+"@"+target)
+    var out bytes.Buffer
+    cmd.Stdout, cmd.Stderr = &out, &out
+    // Forges close the session with a non-zero status; the output tells.
+    cmd.Run()
+    if ctx.Err() != nil {
+        return sshProbe{}, fmt.Errorf("no answer within %s", sshProbeTimeout)
+    }
+    var probe sshProbe
+    for _, line := range strings.Split(out.String(), "\n") {
+        if _, accepted, ok := strings.Cut(line, "Server accepts key: "); ok {
+            fields := strings.Fields(accepted)
+            if len(fields) > 0 {
+                probe.Key = fields[0]
+            }
+            for _, f := range fields {
+                if strings.HasPrefix(f, "SHA256:") {
+                    probe.Fingerprint = f
+                }
+            }
+        }
+        for _, re := range sshGreetings {
+            if m := re.FindStringSubmatch(line); m != nil && probe.Account == "" {
+                probe.Account = m[1]
+            }
+        }
+    }
+    if probe.Key == "" && probe.Account == "" {
+        if strings.Contains(out.String(), "Permission denied") {
+            return probe, errors.New("the host accepted no key")
+        }
+        return probe, fmt.Errorf("cannot connect to %s", target)
+    }
+    return probe, nil
+}
+
+// sshFingerprint returns the SHA256 fingerprint of an SSH key file or
+// literal public key ("key::ssh-ed25519 ...").
+func sshFingerprint(key string) (string, error) {
+    cmd := exec.Command("ssh-keygen", "-lf", "-")
+    if literal, ok := strings.CutPrefix(key, "key::"); ok || strings.HasPrefix(key, "ssh-") {
+        if !ok {
+            literal = key
+        }
+        cmd.Stdin = strings.NewReader(literal + "\n")
+    } else {
+        cmd = exec.Command("ssh-keygen", "-lf", expandHome(key))
+    }
+    out, err := cmd.Output()
+    fields := strings.Fields(string(out))
+    if err != nil || len(fields) < 2 {
+        return "", fmt.Errorf("cannot read SSH key %s", key)
+    }
+    return fields[1], nil
+}
+
+// gpgFingerprintOf returns the fingerprint of a GPG key's primary key.
+func gpgFingerprintOf(key string) (string, error) {
+    out, err := exec.Command(getGPGPath(), "--list-keys", "--with-colons", key).Output()
+    if err != nil {
+        return "", fmt.Errorf("signing key %s not found in gpg keyring", key)
+    }
+    for _, line := range strings.Split(string(out), "\n") {
+        if fields := strings.Split(line, ":"); len(fields) > 9 && fields[0] == "fpr" {
+            return fields[9], nil
+        }
+    }
+    return "", fmt.Errorf("signing key %s not found in gpg keyring", key)
+}
+
+// whoamiSigning describes the signing setup git uses here.
+func whoamiSigning() string {
+    key, _ := runGit("config", "user.signingkey")
+    sign, _ := runGit("config", "--type=bool", "commit.gpgsign")
+    state := "commits not signed"
+    if sign == "true" {
+        state = "commits signed"
+    }
+    if key == "" {
+        return "no signing key, " + state
+    }
+    format, _ := runGit("config", "gpg.format")
+    if format == "" {
+        format = "openpgp"
+    }
+    var fpr string
+    var err error
+    if format == "ssh" {
+        fpr, err = sshFingerprint(key)
+    } else {
+        fpr, err = gpgFingerprintOf(key)
+        if err == nil {
+            err = checkGPGKey(key)
+        }
+    }
+    if err != nil {
+        return fmt.Sprintf("%s %s ✘ %v, %s", format, key, err, state)
+    }
+    if strings.HasPrefix(key, "key::") || strings.HasPrefix(key, "ssh-") {
+        return fmt.Sprintf("%s %s, %s", format, fpr, state)
+    }
+    return fmt.Sprintf("%s %s (%s), %s", format, fpr, key, state)
+}
+
+// whoamiHost is a host whoami reports on; SSH is set when it is reached
+// over ssh, as SSHUser (git when empty).
+type whoamiHost struct {
+    Host    string
+    SSHUser string
+    SSH     bool
+}
+
+// whoamiHosts returns the hosts of the repository's remotes, or outside a
+// repository those the hosts map assigns to the profile.
+func whoamiHosts(cfg Config, p *Profile) []whoamiHost {
+    var hosts []whoamiHost
+    seen := map[string]bool{}
+    if inRepo, _ := isGitRepo(); inRepo {
+        for _, rm := range listRemotes() {
+            user, host, _, ok := remoteParts(rm.URL)
+            if !ok || seen[host] {
+                continue
+            }
+            seen[host] = true
+            ssh := !strings.Contains(rm.URL, "://") || strings.HasPrefix(rm.URL, "ssh://")
+            hosts = append(hosts, whoamiHost{Host: host, SSHUser: user, SSH: ssh})
+        }
+        return hosts
+    }
+    if p != nil {
+        for _, host := range profileHosts(cfg, p) {
+            hosts = append(hosts, whoamiHost{Host: host, SSH: true})
+        }
+    }
+    return hosts
+}
+
+// commandWhoami shows who you are right now: the git identity and the
+// profile it matches, the signing key, and for every host you push to the
+// forge account the API credentials belong to and the account and key ssh
+// authenticates with.
+func commandWhoami(cfg Config) error {
+    id := currentIdentity(&cfg)
+    if id.Profile != nil {
+        fmt.Printf("Profile %s (%s)\n", id.Profile.Name, id.Scope)
+    } else {
+        fmt.Printf("No profile (%s)\n", id.Scope)
+    }
+    if id.Name.Value == "" && id.Email.Value == "" {
+        fmt.Println("  git:     no identity set")
+    } else {
+        fmt.Printf("  git:     %s <%s>\n", id.Name.Value, id.Email.Value)
+    }
+    fmt.Printf("  signing: %s\n", whoamiSigning())
+    hosts := whoamiHosts(cfg, id.Profile)
+    if len(hosts) == 0 {
+        fmt.Println("  (no remotes or assigned hosts to ask)")
+        return nil
+    }
+    p := id.Profile
+    if p == nil {
+        p = &Profile{}
+    }
+    for _, h := range hosts {
+        host, _, _ := strings.Cut(h.Host, ":")
+        fmt.Printf("  %s:\n", h.Host)
+        if f := forgeFor(cfg, host); f != nil {
+            if account, err := f.Account(p); err != nil {
+                fmt.Printf("    account: ✘ %v\n", err)
+            } else {
+                fmt.Printf("    account: %s\n", account)
+            }
+        }
+        if !h.SSH {
+            continue
+        }
+        probe, err := probeSSH(h.SSHUser, h.Host)
+        switch {
+        case err != nil:
+            fmt.Printf("    ssh:     ✘ %v\n", err)
+        case probe.Account != "":
+            fmt.Printf("    ssh:     %s, key %s (%s)\n", probe.Account, probe.Fingerprint, probe.Key)
+        default:
+            fmt.Printf("    ssh:     key %s (%s)\n", probe.Fingerprint, probe.Key)
+        }
+    }
+    return nil
+}